@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Fusos dos agendamentos; a imagem alpine não traz o zoneinfo
 
 	"github.com/gin-gonic/gin"
 
@@ -83,6 +84,21 @@ func main() {
 	if err != nil {
 		log.Fatalf("Erro ao inicializar manager: %v", err)
 	}
+	// Iniciar workers da fila de entrega de webhooks
+	waMgr.StartWebhookProcessor(whatsapp.WebhookProcessorConfig{
+		Workers:     cfg.WebhookWorkers,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+	})
+	// Iniciar a fila de envio com o ritmo padrão por dispositivo
+	waMgr.StartOutboundProcessor(whatsapp.OutboundProcessorConfig{
+		MessagesPerMinute: cfg.OutboundMessagesPerMinute,
+		DailyCap:          cfg.OutboundDailyCap,
+		MinTypingDelay:    time.Duration(cfg.OutboundMinTypingDelayMs) * time.Millisecond,
+		MaxTypingDelay:    time.Duration(cfg.OutboundMaxTypingDelayMs) * time.Millisecond,
+		MaxAttempts:       cfg.OutboundMaxAttempts,
+	})
+	waMgr.SetMediaMaxDownloadSize(int64(cfg.MediaMaxDownloadMB) << 20)
+
 	// metodo anterior de inicialização
 	// err = waMgr.Connect()
 	// if err != nil {
@@ -131,10 +147,40 @@ func main() {
 		}
 	}()
 
+	// Disparar mensagens agendadas e alimentar a fila com as campanhas em
+	// andamento. A primeira passada recupera os agendamentos que venceram e
+	// retoma as campanhas que estavam rodando quando o serviço parou.
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			waMgr.DispatchScheduledMessages()
+			waMgr.AdvanceCampaigns()
+			<-ticker.C
+		}
+	}()
+
+	// Aplicar a retenção de mensagens dos tenants periodicamente
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			waMgr.PurgeExpiredMessages()
+		}
+	}()
+
 	// Aguardar sinal de encerramento
 	<-quit
 	log.Println("Recebido sinal de encerramento, desconectando clientes...")
 
+	// Parar a fila de envio (mensagens pendentes continuam no banco)
+	waMgr.StopOutboundProcessor()
+
+	// Parar workers de webhook (entregas não concluídas permanecem na fila)
+	waMgr.StopWebhookProcessor()
+
 	// Desconectar todos os clientes
 	devices, err := db.GetAllDevicesByStatus(database.DeviceStatusConnected)
 	if err != nil {
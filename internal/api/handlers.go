@@ -4,10 +4,14 @@ package api
 import (
 	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -18,6 +22,7 @@ import (
 	"whatsapp-service/internal/database"
 	"whatsapp-service/internal/notification"
 	"whatsapp-service/internal/whatsapp"
+	"whatsapp-service/pkg/webhook"
 )
 
 // Handler contém os handlers da API
@@ -275,7 +280,8 @@ func (h *Handler) GetQRCode(c *gin.Context) {
 	}
 }
 
-// SendMessage envia uma mensagem
+// SendMessage envia uma mensagem. Com link_preview, o primeiro link do texto
+// é enviado com título, descrição e miniatura da página.
 func (h *Handler) SendMessage(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -285,8 +291,9 @@ func (h *Handler) SendMessage(c *gin.Context) {
 	}
 
 	var request struct {
-		To      string `json:"to" binding:"required"`
-		Message string `json:"message" binding:"required"`
+		To          string `json:"to" binding:"required"`
+		Message     string `json:"message" binding:"required"`
+		LinkPreview bool   `json:"link_preview"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -294,14 +301,19 @@ func (h *Handler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// Enviar mensagem
-	msgID, err := h.WhatsAppMgr.SendTextMessage(id, request.To, request.Message)
+	// Enfileirar mensagem; o resultado chega pelos webhooks outbound.*
+	var queued *database.OutboundMessage
+	if request.LinkPreview {
+		queued, err = h.WhatsAppMgr.EnqueueLinkPreviewText(id, request.To, request.Message)
+	} else {
+		queued, err = h.WhatsAppMgr.EnqueueTextMessage(id, request.To, request.Message)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
 }
 
 // GetDeviceStatus retorna o status de um dispositivo
@@ -368,7 +380,7 @@ func (h *Handler) GetWhatsAppHealth(c *gin.Context) {
 	})
 }
 
-// WebhookConfig configura um webhook para envio de eventos
+// WebhookConfig cria uma nova assinatura de webhook para um tenant
 func (h *Handler) WebhookConfig(c *gin.Context) {
 	var request struct {
 		URL       string   `json:"url" binding:"required"`
@@ -376,7 +388,7 @@ func (h *Handler) WebhookConfig(c *gin.Context) {
 		Events    []string `json:"events"`
 		TenantID  int64    `json:"tenant_id" binding:"required"`
 		DeviceIDs []int64  `json:"device_ids"`
-		Enabled   bool     `json:"enabled" default:"true"`
+		Enabled   *bool    `json:"enabled"` // Padrão: true
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -385,42 +397,37 @@ func (h *Handler) WebhookConfig(c *gin.Context) {
 	}
 
 	// Validar URL
-	_, err := url.Parse(request.URL)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "URL inválida"})
+	if err := validateWebhookURL(request.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Configurar webhook no EventHandler global
-	h.WhatsAppMgr.ConfigureWebhook(&whatsapp.WebhookConfig{
-		URL:       request.URL,
-		Secret:    request.Secret,
-		Events:    request.Events,
-		TenantID:  request.TenantID,
-		DeviceIDs: request.DeviceIDs,
-		Enabled:   request.Enabled,
-	})
+	enabled := true
+	if request.Enabled != nil {
+		enabled = *request.Enabled
+	}
 
-	// Salvar configuração no banco de dados para persistência
 	webhookConfig := &database.WebhookConfig{
 		TenantID:  request.TenantID,
 		URL:       request.URL,
 		Secret:    request.Secret,
 		Events:    request.Events,
 		DeviceIDs: request.DeviceIDs,
-		Enabled:   request.Enabled,
+		Enabled:   enabled,
 	}
 
-	err = h.DB.SaveWebhookConfig(webhookConfig)
+	// Salvar no banco e ativar a assinatura no EventHandler
+	err := h.WhatsAppMgr.SaveWebhookConfig(webhookConfig)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Erro ao salvar configuração: %v", err)})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusCreated, gin.H{
 		"status":    "success",
 		"message":   "Webhook configurado com sucesso",
 		"config_id": webhookConfig.ID,
+		"webhook":   webhookConfig,
 	})
 }
 
@@ -447,6 +454,90 @@ func (h *Handler) GetWebhookConfigs(c *gin.Context) {
 	c.JSON(http.StatusOK, configs)
 }
 
+// GetWebhookConfig retorna uma assinatura de webhook específica
+func (h *Handler) GetWebhookConfig(c *gin.Context) {
+	configID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	config, err := h.DB.GetWebhookConfigByID(configID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuração não encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// UpdateWebhookConfig atualiza uma assinatura de webhook existente.
+// Apenas os campos enviados são alterados.
+func (h *Handler) UpdateWebhookConfig(c *gin.Context) {
+	configID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		URL       *string   `json:"url"`
+		Secret    *string   `json:"secret"`
+		Events    *[]string `json:"events"`
+		DeviceIDs *[]int64  `json:"device_ids"`
+		Enabled   *bool     `json:"enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := h.DB.GetWebhookConfigByID(configID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuração não encontrada"})
+		return
+	}
+
+	if request.URL != nil {
+		if err := validateWebhookURL(*request.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		config.URL = *request.URL
+	}
+	if request.Secret != nil {
+		config.Secret = *request.Secret
+	}
+	if request.Events != nil {
+		config.Events = *request.Events
+	}
+	if request.DeviceIDs != nil {
+		config.DeviceIDs = *request.DeviceIDs
+	}
+	if request.Enabled != nil {
+		config.Enabled = *request.Enabled
+	}
+
+	err = h.WhatsAppMgr.SaveWebhookConfig(config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
 // Adicionar método para excluir webhook
 func (h *Handler) DeleteWebhookConfig(c *gin.Context) {
 	configIDStr := c.Param("id")
@@ -469,17 +560,8 @@ func (h *Handler) DeleteWebhookConfig(c *gin.Context) {
 		return
 	}
 
-	// Desabilitar webhook no manager (mantendo a mesma URL mas desabilitado)
-	h.WhatsAppMgr.ConfigureWebhook(&whatsapp.WebhookConfig{
-		URL:       config.URL,
-		TenantID:  config.TenantID,
-		Events:    config.Events,
-		DeviceIDs: config.DeviceIDs,
-		Enabled:   false, // Marcar como desabilitado
-	})
-
-	// Excluir do banco de dados
-	err = h.DB.DeleteWebhookConfig(configID)
+	// Excluir do banco de dados e do EventHandler
+	err = h.WhatsAppMgr.DeleteWebhookConfig(configID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -488,6 +570,15 @@ func (h *Handler) DeleteWebhookConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "webhook removido com sucesso"})
 }
 
+// validateWebhookURL garante que a URL do webhook é absoluta e usa HTTP(S)
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("URL inválida: use uma URL http(s) absoluta")
+	}
+	return nil
+}
+
 // Testar webhook
 func (h *Handler) TestWebhook(c *gin.Context) {
 	configIDStr := c.Param("id")
@@ -510,16 +601,14 @@ func (h *Handler) TestWebhook(c *gin.Context) {
 		return
 	}
 
-	// Criar evento de teste
-	testEvent := map[string]interface{}{
-		"event_type": "test_event",
-		"tenant_id":  config.TenantID,
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"message":    "Este é um evento de teste para verificar a configuração do webhook",
-	}
+	// Criar evento de teste no mesmo envelope dos eventos reais
+	testEvent := webhook.NewEvent(webhook.EventWebhookTest, 0, config.TenantID, webhook.TestData{
+		WebhookID: config.ID,
+		Message:   "Este é um evento de teste para verificar a configuração do webhook",
+	})
 
 	// Tentar enviar
-	success, err := h.WhatsAppMgr.SendTestWebhook(config.URL, config.Secret, testEvent)
+	success, err := h.WhatsAppMgr.SendTestWebhook(config.URL, config.SigningSecrets(time.Now()), testEvent)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -543,6 +632,88 @@ func (h *Handler) TestWebhook(c *gin.Context) {
 	})
 }
 
+// RotateWebhookSecret gera (ou define) um novo segredo para a assinatura.
+// O segredo anterior continua válido durante grace_period_hours (padrão 24h),
+// e as entregas nesse período trazem uma assinatura v1 para cada segredo.
+func (h *Handler) RotateWebhookSecret(c *gin.Context) {
+	configID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Secret           string `json:"secret"`
+		GracePeriodHours *int   `json:"grace_period_hours"`
+	}
+
+	// Corpo opcional: sem corpo, gera um segredo novo com o período padrão
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	gracePeriod := 24 * time.Hour
+	if request.GracePeriodHours != nil {
+		if *request.GracePeriodHours < 0 || *request.GracePeriodHours > 24*30 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "grace_period_hours deve estar entre 0 e 720"})
+			return
+		}
+		gracePeriod = time.Duration(*request.GracePeriodHours) * time.Hour
+	}
+
+	if request.Secret == "" {
+		request.Secret, err = webhook.NewSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	config, err := h.DB.GetWebhookConfigByID(configID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuração não encontrada"})
+		return
+	}
+
+	config, err = h.WhatsAppMgr.RotateWebhookSecret(configID, request.Secret, gracePeriod)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// O segredo só é retornado nesta resposta
+	c.JSON(http.StatusOK, gin.H{
+		"status":                     "success",
+		"webhook_id":                 config.ID,
+		"secret":                     request.Secret,
+		"previous_secret_expires_at": config.PreviousSecretExpiresAt,
+	})
+}
+
+// ExpirePreviousWebhookSecret encerra o período de rotação, invalidando o segredo anterior
+func (h *Handler) ExpirePreviousWebhookSecret(c *gin.Context) {
+	configID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	if err := h.DB.ClearPreviousWebhookSecret(configID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "segredo anterior removido"})
+}
+
 // GetPendingDevices retorna dispositivos pendentes de aprovação
 func (h *Handler) GetPendingDevices(c *gin.Context) {
 	devices, err := h.DB.GetAllDevicesByStatus(database.DeviceStatusPending)
@@ -597,7 +768,8 @@ func (h *Handler) MarkDeviceAsReauthenticated(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// GetGroups retorna a lista de grupos
+// GetGroups retorna a lista de grupos da cópia local; com ?refresh=true os
+// grupos são buscados novamente no WhatsApp
 func (h *Handler) GetGroups(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
@@ -614,7 +786,7 @@ func (h *Handler) GetGroups(c *gin.Context) {
 	}
 
 	// Obter grupos
-	groups, err := client.GetGroups()
+	groups, err := client.GetGroups(c.Query("refresh") == "true")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -623,119 +795,180 @@ func (h *Handler) GetGroups(c *gin.Context) {
 	c.JSON(http.StatusOK, groups)
 }
 
-// GetContacts retorna a lista de contatos
-func (h *Handler) GetContacts(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+// GetGroupInfo retorna os dados e participantes de um grupo; com
+// ?refresh=true os dados são buscados novamente no WhatsApp
+func (h *Handler) GetGroupInfo(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
 		return
 	}
 
-	// Obter cliente
-	client, err := h.WhatsAppMgr.GetClient(id)
+	group, err := client.GetGroup(c.Param("group_id"), c.Query("refresh") == "true")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGroupError(c, err)
 		return
 	}
 
-	// Obter contatos
-	contacts, err := client.GetContacts()
+	c.JSON(http.StatusOK, group)
+}
+
+// CreateGroup cria um grupo; participants aceita JIDs ou números de telefone
+func (h *Handler) CreateGroup(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name         string   `json:"name" binding:"required"`
+		Participants []string `json:"participants"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := client.CreateGroup(request.Name, request.Participants)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGroupError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, contacts)
+	c.JSON(http.StatusCreated, group)
 }
 
-// GetGroupMessages retorna mensagens de um grupo específico
-func (h *Handler) GetGroupMessages(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+// UpdateGroup altera nome, descrição e os modos announce (apenas admins
+// enviam mensagens) e locked (apenas admins editam o grupo). Campos omitidos
+// não são alterados.
+func (h *Handler) UpdateGroup(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
 		return
 	}
 
-	groupID := c.Param("group_id")
-	if groupID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do grupo é obrigatório"})
+	var settings whatsapp.GroupSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Filtro (new, day, week, month)
-	filter := c.DefaultQuery("filter", "day")
+	if settings.Name == nil && settings.Description == nil && settings.Announce == nil && settings.Locked == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nenhuma alteração informada"})
+		return
+	}
 
-	// Obter cliente
-	client, err := h.WhatsAppMgr.GetClient(id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := client.UpdateGroupSettings(c.Param("group_id"), settings); err != nil {
+		respondGroupError(c, err)
 		return
 	}
 
-	// Obter mensagens
-	messages, err := client.GetGroupMessages(groupID, filter)
+	group, err := client.GetGroup(c.Param("group_id"), false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGroupError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, group)
 }
 
-// GetContactMessages retorna mensagens de um contato específico
-func (h *Handler) GetContactMessages(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// UpdateGroupParticipants adiciona, remove, promove ou rebaixa participantes.
+// Responde o resultado de cada participante, pois falhas são individuais.
+func (h *Handler) UpdateGroupParticipants(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Action       string   `json:"action" binding:"required"` // add, remove, promote, demote
+		Participants []string `json:"participants" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := client.UpdateGroupParticipants(c.Param("group_id"), request.Participants, request.Action)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		respondGroupError(c, err)
 		return
 	}
 
-	contactID := c.Param("contact_id")
-	if contactID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do contato é obrigatório"})
+	c.JSON(http.StatusOK, gin.H{"action": request.Action, "participants": results})
+}
+
+// SetGroupPhoto troca a foto do grupo pela imagem do campo "file" (multipart)
+func (h *Handler) SetGroupPhoto(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
 		return
 	}
 
-	// Filtro (new, day, week, month)
-	filter := c.DefaultQuery("filter", "day")
+	data, _, ok := readMediaFormFile(c)
+	if !ok {
+		return
+	}
 
-	// Obter cliente
-	client, err := h.WhatsAppMgr.GetClient(id)
+	pictureID, err := client.SetGroupPhoto(c.Param("group_id"), data)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGroupError(c, err)
 		return
 	}
 
-	// Obter mensagens
-	messages, err := client.GetContactMessages(contactID, filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{"picture_id": pictureID})
+}
+
+// DeleteGroupPhoto remove a foto do grupo
+func (h *Handler) DeleteGroupPhoto(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	if _, err := client.SetGroupPhoto(c.Param("group_id"), nil); err != nil {
+		respondGroupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "removed"})
 }
 
-// SendGroupMessage envia uma mensagem para um grupo
-func (h *Handler) SendGroupMessage(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// GetGroupInviteLink retorna o link de convite atual do grupo
+func (h *Handler) GetGroupInviteLink(c *gin.Context) {
+	h.groupInviteLink(c, false)
+}
+
+// RevokeGroupInviteLink revoga o link de convite e retorna o novo link
+func (h *Handler) RevokeGroupInviteLink(c *gin.Context) {
+	h.groupInviteLink(c, true)
+}
+
+func (h *Handler) groupInviteLink(c *gin.Context, reset bool) {
+	client, ok := h.groupClient(c)
+	if !ok {
+		return
+	}
+
+	link, err := client.GetGroupInviteLink(c.Param("group_id"), reset)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		respondGroupError(c, err)
 		return
 	}
 
-	groupID := c.Param("group_id")
-	if groupID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do grupo é obrigatório"})
+	c.JSON(http.StatusOK, gin.H{"invite_link": link})
+}
+
+// JoinGroup entra em um grupo pelo link (https://chat.whatsapp.com/...) ou código de convite
+func (h *Handler) JoinGroup(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
 		return
 	}
 
 	var request struct {
-		Message string `json:"message" binding:"required"`
+		Code string `json:"code" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -743,25 +976,68 @@ func (h *Handler) SendGroupMessage(c *gin.Context) {
 		return
 	}
 
-	// Obter cliente
-	client, err := h.WhatsAppMgr.GetClient(id)
+	group, err := client.JoinGroupWithLink(request.Code)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGroupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// LeaveGroup faz o dispositivo sair do grupo
+func (h *Handler) LeaveGroup(c *gin.Context) {
+	client, ok := h.groupClient(c)
+	if !ok {
+		return
+	}
+
+	if err := client.LeaveGroup(c.Param("group_id")); err != nil {
+		respondGroupError(c, err)
 		return
 	}
 
-	// Enviar mensagem
-	msgID, err := client.SendGroupMessage(groupID, request.Message)
+	c.JSON(http.StatusOK, gin.H{"status": "left"})
+}
+
+// groupClient retorna o cliente do dispositivo da rota. Responde o erro e
+// retorna false se o ID for inválido ou o dispositivo não estiver carregado.
+func (h *Handler) groupClient(c *gin.Context) (*whatsapp.Client, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+	return client, true
 }
 
-// SendMediaMessage envia uma mensagem com mídia
-func (h *Handler) SendMediaMessage(c *gin.Context) {
+// respondGroupError responde o erro de uma operação de grupo com o status
+// correspondente: 403 quando o dispositivo não é admin, 404 para grupo
+// inexistente e 400 para convites inválidos
+func respondGroupError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, whatsapp.ErrNotGroupAdmin):
+		status = http.StatusForbidden
+	case errors.Is(err, whatsapp.ErrGroupNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, whatsapp.ErrInvalidInviteLink):
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// GetContacts retorna o diretório de contatos do dispositivo. ?search procura
+// nos nomes e no número; ?limit e ?offset paginam o resultado.
+func (h *Handler) GetContacts(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -769,78 +1045,2192 @@ func (h *Handler) SendMediaMessage(c *gin.Context) {
 		return
 	}
 
-	// Obter destinatário
-	to := c.PostForm("to")
-	if to == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Destinatário é obrigatório"})
-		return
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 1000 {
+			limit = parsed
+		}
+	}
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if parsed, err := strconv.Atoi(offsetStr); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+
+	// Obter contatos
+	contacts, err := h.DB.SearchContacts(id, c.Query("search"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contacts)
+}
+
+// CheckContacts verifica em lote quais números têm conta no WhatsApp
+func (h *Handler) CheckContacts(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Phones []string `json:"phones" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := client.CheckContacts(request.Phones)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// GetContact retorna um contato do diretório pelo JID ou número
+func (h *Handler) GetContact(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := client.GetContact(c.Param("contact_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if contact == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contato não encontrado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// GetContactPicture baixa a foto de perfil do contato e retorna o contato com
+// o caminho da foto (servida em /media/avatars). ?refresh=true ignora a cópia
+// guardada.
+func (h *Handler) GetContactPicture(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := client.GetContactPicture(c.Param("contact_id"), c.Query("refresh") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// GetContactBusinessProfile consulta o perfil comercial do contato
+func (h *Handler) GetContactBusinessProfile(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := client.GetContactBusinessProfile(c.Param("contact_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+// SetPresence marca o dispositivo como disponível (online) ou indisponível
+func (h *Handler) SetPresence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		State string `json:"state" binding:"required"` // available ou unavailable
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.State != "available" && request.State != "unavailable" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state deve ser available ou unavailable"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := client.SetAvailability(request.State == "available"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// SendChatPresence mostra "digitando..." (composing) ou "gravando áudio..."
+// (recording) em um chat, ou encerra a indicação (paused)
+func (h *Handler) SendChatPresence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To    string `json:"to" binding:"required"`
+		State string `json:"state" binding:"required"` // composing, recording ou paused
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch request.State {
+	case whatsapp.ChatStateComposing, whatsapp.ChatStateRecording, whatsapp.ChatStatePaused:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state deve ser composing, recording ou paused"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := client.SendChatPresence(request.To, request.State); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// SubscribePresence passa a receber a presença (online/offline) de um contato,
+// publicada como presence.update. O dispositivo precisa estar disponível.
+func (h *Handler) SubscribePresence(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Contact string `json:"contact" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := client.SubscribePresence(request.Contact); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetLIDMapping retorna o número de um LID (@lid) ou o LID de um número
+func (h *Handler) GetLIDMapping(c *gin.Context) {
+	mapping, err := h.WhatsAppMgr.LookupLIDMapping(c.Param("jid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if mapping == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapeamento não encontrado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mapping)
+}
+
+// GetGroupMessages retorna mensagens de um grupo específico
+func (h *Handler) GetGroupMessages(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do grupo é obrigatório"})
+		return
+	}
+
+	query, err := parseMessageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Obter cliente
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Obter mensagens
+	page, err := client.GetGroupMessages(groupID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetContactMessages retorna mensagens de um contato específico
+func (h *Handler) GetContactMessages(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	contactID := c.Param("contact_id")
+	if contactID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do contato é obrigatório"})
+		return
+	}
+
+	query, err := parseMessageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Obter cliente
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Obter mensagens
+	page, err := client.GetContactMessages(contactID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetChats lista as conversas do dispositivo com a prévia da última mensagem,
+// não lidas e os estados arquivado, fixado e silenciado. Aceita archived e
+// unread (true/false), sort (recent, name ou unread), limit e offset.
+func (h *Handler) GetChats(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	query := database.ChatQuery{DeviceID: id, Sort: database.ChatSortRecent, Limit: 50}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 200 {
+			query.Limit = parsed
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if parsed, err := strconv.Atoi(offsetStr); err == nil && parsed >= 0 {
+			query.Offset = parsed
+		}
+	}
+
+	if archivedStr := c.Query("archived"); archivedStr != "" {
+		archived, err := strconv.ParseBool(archivedStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archived inválido"})
+			return
+		}
+		query.Archived = &archived
+	}
+
+	if unreadStr := c.Query("unread"); unreadStr != "" {
+		if query.UnreadOnly, err = strconv.ParseBool(unreadStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unread inválido"})
+			return
+		}
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		switch sort {
+		case database.ChatSortRecent, database.ChatSortName, database.ChatSortUnread:
+			query.Sort = sort
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort deve ser recent, name ou unread"})
+			return
+		}
+	}
+
+	chats, err := h.DB.GetChats(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, chats)
+}
+
+// GetChat retorna uma conversa do dispositivo
+func (h *Handler) GetChat(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := client.GetChat(c.Param("chat_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if chat == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat não encontrado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// MarkChatRead envia confirmações de leitura das mensagens não lidas do chat
+// até message_id (ou até a última) e zera a contagem de não lidas até ela
+func (h *Handler) MarkChatRead(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		MessageID string `json:"message_id"` // Vazio = todas as mensagens do chat
+	}
+
+	// Corpo opcional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	marked, err := client.MarkChatRead(c.Param("chat_id"), request.MessageID)
+	if err != nil {
+		if errors.Is(err, whatsapp.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "marked": marked})
+}
+
+// SearchMessages busca no histórico de todos os chats do dispositivo.
+// Aceita os mesmos parâmetros das rotas de mensagens de grupo e contato,
+// mais jid para restringir a um chat.
+func (h *Handler) SearchMessages(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	query, err := parseMessageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.DeviceID = id
+	query.JID = c.Query("jid")
+
+	device, err := h.DB.GetDeviceByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispositivo não encontrado"})
+		return
+	}
+
+	page, err := h.DB.GetMessages(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetMessageStatus retorna o status de entrega de uma mensagem e o histórico de confirmações.
+// Em grupos, o status é o confirmado por todos os participantes e o histórico
+// traz as confirmações de cada um.
+func (h *Handler) GetMessageStatus(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	messageID := c.Param("message_id")
+
+	message, err := h.DB.GetMessage(id, messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := h.DB.GetMessageStatusHistory(id, messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Mensagens não armazenadas (pela política do tenant) ainda têm histórico
+	if message == nil && len(history) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mensagem não encontrada"})
+		return
+	}
+
+	status := ""
+	var updatedAt *time.Time
+	if message != nil {
+		status = message.Status
+		updatedAt = message.StatusUpdatedAt
+	} else {
+		last := history[len(history)-1]
+		status = last.Status
+		updatedAt = &last.Timestamp
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": messageID,
+		"status":     status,
+		"updated_at": updatedAt,
+		"history":    history,
+	})
+}
+
+// SendReply envia uma mensagem de texto respondendo (citando) uma mensagem anterior
+func (h *Handler) SendReply(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To              string `json:"to" binding:"required"`
+		Message         string `json:"message" binding:"required"`
+		QuotedMessageID string `json:"quoted_message_id" binding:"required"`
+		QuotedSender    string `json:"quoted_sender"` // Obrigatório se a mensagem citada não está no histórico
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	msgID, err := client.SendReply(request.To, request.Message, request.QuotedMessageID, request.QuotedSender)
+	if errors.Is(err, whatsapp.ErrMessageSenderRequired) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+}
+
+// ReactToMessage envia (ou remove, com reaction vazio) uma reação a uma mensagem
+func (h *Handler) ReactToMessage(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Chat     string `json:"chat" binding:"required"`
+		Reaction string `json:"reaction"` // Emoji; vazio remove a reação
+		Sender   string `json:"sender"`   // Autor da mensagem; obrigatório se não está no histórico
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	msgID, err := client.SendReaction(request.Chat, c.Param("message_id"), request.Sender, request.Reaction)
+	if errors.Is(err, whatsapp.ErrMessageSenderRequired) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+}
+
+// EditMessage edita o texto de uma mensagem enviada por este número
+func (h *Handler) EditMessage(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Chat    string `json:"chat" binding:"required"`
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	msgID, err := client.EditMessage(request.Chat, c.Param("message_id"), request.Message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+}
+
+// RevokeMessage apaga uma mensagem para todos.
+// Query: chat (obrigatório) e sender (autor; obrigatório se a mensagem não está no histórico).
+func (h *Handler) RevokeMessage(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	chat := c.Query("chat")
+	if chat == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat é obrigatório"})
+		return
+	}
+
+	client, err := h.WhatsAppMgr.GetClient(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	msgID, err := client.RevokeMessage(chat, c.Param("message_id"), c.Query("sender"))
+	if errors.Is(err, whatsapp.ErrMessageSenderRequired) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+}
+
+// SendGroupMessage envia uma mensagem para um grupo
+func (h *Handler) SendGroupMessage(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	groupID := c.Param("group_id")
+	if groupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID do grupo é obrigatório"})
+		return
+	}
+
+	var request struct {
+		Message string `json:"message" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !strings.HasSuffix(groupID, "@g.us") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O JID fornecido não é um grupo"})
+		return
+	}
+
+	// Enfileirar mensagem
+	queued, err := h.WhatsAppMgr.EnqueueTextMessage(id, groupID, request.Message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// SendMediaMessage envia uma mensagem com mídia. Aceita um formulário
+// multipart com "file" ou JSON com "url" (baixada pelo serviço) ou "media_id"
+// (mídia da biblioteca do tenant, enviada sem novo upload). Opcionais:
+// "file_name" (nome exibido em documentos) e "voice_note" (áudio como nota de voz).
+func (h *Handler) SendMediaMessage(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	if c.ContentType() == "application/json" {
+		h.sendMediaReference(c, id)
+		return
+	}
+
+	// Obter destinatário
+	to := c.PostForm("to")
+	if to == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Destinatário é obrigatório"})
+		return
+	}
+
+	// Obter legenda
+	caption := c.PostForm("caption")
+
+	data, mimeType, ok := readMediaFormFile(c)
+	if !ok {
+		return
+	}
+
+	// Enfileirar mídia
+	queued, err := h.WhatsAppMgr.EnqueueMediaMessage(id, to, mimeType, data, caption, mediaOptionsFromForm(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// sendMediaReference trata o envio de mídia em JSON, por URL ou media_id
+func (h *Handler) sendMediaReference(c *gin.Context, deviceID int64) {
+	var request struct {
+		To        string `json:"to" binding:"required"`
+		Caption   string `json:"caption"`
+		URL       string `json:"url"`
+		MediaID   int64  `json:"media_id"`
+		FileName  string `json:"file_name"`
+		VoiceNote bool   `json:"voice_note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (request.URL == "") == (request.MediaID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe url ou media_id"})
+		return
+	}
+
+	options := whatsapp.MediaOptions{FileName: request.FileName, VoiceNote: request.VoiceNote}
+
+	if request.URL != "" {
+		data, mimeType, fileName, err := h.WhatsAppMgr.FetchMediaURL(request.URL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if options.FileName == "" {
+			options.FileName = fileName
+		}
+
+		queued, err := h.WhatsAppMgr.EnqueueMediaMessage(deviceID, request.To, mimeType, data, request.Caption, options)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status, "mimetype": mimeType})
+		return
+	}
+
+	device, err := h.DB.GetDeviceByID(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispositivo não encontrado"})
+		return
+	}
+
+	media, err := h.DB.GetMediaFile(request.MediaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if media == nil || media.TenantID != device.TenantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mídia não encontrada"})
+		return
+	}
+
+	queued, err := h.WhatsAppMgr.EnqueueLibraryMedia(deviceID, request.To, media, request.Caption, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status, "media_id": media.ID})
+}
+
+// SendSticker envia uma figurinha. Aceita um formulário multipart com "file"
+// ou JSON com "url"; imagens PNG e JPEG são convertidas para WebP.
+func (h *Handler) SendSticker(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var to, mimeType string
+	var data []byte
+
+	if c.ContentType() == "application/json" {
+		var request struct {
+			To  string `json:"to" binding:"required"`
+			URL string `json:"url" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		data, mimeType, _, err = h.WhatsAppMgr.FetchMediaURL(request.URL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		to = request.To
+	} else {
+		to = c.PostForm("to")
+		if to == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Destinatário é obrigatório"})
+			return
+		}
+
+		var ok bool
+		data, mimeType, ok = readMediaFormFile(c)
+		if !ok {
+			return
+		}
+	}
+
+	queued, err := h.WhatsAppMgr.EnqueueSticker(id, to, mimeType, data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// SendLocation envia uma localização fixa ou, com live=true, em tempo real
+func (h *Handler) SendLocation(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To             string   `json:"to" binding:"required"`
+		Latitude       *float64 `json:"latitude" binding:"required"`
+		Longitude      *float64 `json:"longitude" binding:"required"`
+		Name           string   `json:"name"`
+		Address        string   `json:"address"`
+		URL            string   `json:"url"`
+		Live           bool     `json:"live"`
+		Caption        string   `json:"caption"`
+		AccuracyMeters uint32   `json:"accuracy_meters"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location := webhook.LocationData{
+		Latitude:       *request.Latitude,
+		Longitude:      *request.Longitude,
+		Name:           request.Name,
+		Address:        request.Address,
+		URL:            request.URL,
+		Live:           request.Live,
+		Caption:        request.Caption,
+		AccuracyMeters: request.AccuracyMeters,
+	}
+
+	queued, err := h.WhatsAppMgr.EnqueueLocation(id, request.To, location)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// SendContacts envia um ou mais cartões de contato (vCard gerado a partir dos
+// campos ou informado pronto em "vcard")
+func (h *Handler) SendContacts(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To       string                      `json:"to" binding:"required"`
+		Contacts []whatsapp.ContactCardInput `json:"contacts" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, contact := range request.Contacts {
+		if _, err := whatsapp.NewContactCard(contact); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	queued, err := h.WhatsAppMgr.EnqueueContacts(id, request.To, request.Contacts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// SendPoll envia uma enquete. Sem "selectable_count", cada participante marca
+// uma única opção; 0 permite marcar qualquer quantidade.
+func (h *Handler) SendPoll(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To              string   `json:"to" binding:"required"`
+		Question        string   `json:"question" binding:"required"`
+		Options         []string `json:"options" binding:"required"`
+		SelectableCount *int     `json:"selectable_count"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	poll := webhook.PollData{
+		Question:        request.Question,
+		Options:         request.Options,
+		SelectableCount: 1,
+	}
+	if request.SelectableCount != nil {
+		poll.SelectableCount = *request.SelectableCount
+	}
+
+	if err := whatsapp.ValidatePoll(poll); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queued, err := h.WhatsAppMgr.EnqueuePoll(id, request.To, poll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"queue_id": queued.ID, "status": queued.Status})
+}
+
+// GetPolls lista as enquetes de um dispositivo com a contagem de votos (?chat=<jid>)
+func (h *Handler) GetPolls(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	polls, err := h.DB.GetPolls(id, c.Query("chat"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, polls)
+}
+
+// GetPoll retorna uma enquete pelo ID da mensagem, com a contagem e o voto de cada participante
+func (h *Handler) GetPoll(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	poll, err := h.DB.GetPoll(id, c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if poll == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Enquete não encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, poll)
+}
+
+// mediaOptionsFromForm lê as opções de envio de mídia de um formulário
+// multipart. Sem "file_name", documentos usam o nome do arquivo enviado.
+func mediaOptionsFromForm(c *gin.Context) whatsapp.MediaOptions {
+	options := whatsapp.MediaOptions{
+		FileName:  c.PostForm("file_name"),
+		VoiceNote: c.PostForm("voice_note") == "true",
+	}
+
+	if options.FileName == "" {
+		if file, err := c.FormFile("file"); err == nil {
+			options.FileName = filepath.Base(file.Filename)
+		}
+	}
+
+	return options
+}
+
+// readMediaFormFile lê o arquivo do campo "file" de um formulário multipart e
+// identifica o tipo MIME. Responde o erro e retorna false se não conseguir.
+func readMediaFormFile(c *gin.Context) ([]byte, string, bool) {
+	// Obter arquivo
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo não fornecido"})
+		return nil, "", false
+	}
+
+	// Obter tipo MIME
+	mimeType := file.Header.Get("Content-Type")
+	if mimeType == "" {
+		// Tentar adivinhar pelo nome do arquivo
+		ext := strings.ToLower(filepath.Ext(file.Filename))
+		switch ext {
+		case ".jpg", ".jpeg":
+			mimeType = "image/jpeg"
+		case ".png":
+			mimeType = "image/png"
+		case ".gif":
+			mimeType = "image/gif"
+		case ".mp4":
+			mimeType = "video/mp4"
+		case ".pdf":
+			mimeType = "application/pdf"
+		case ".ogg":
+			mimeType = "audio/ogg"
+		case ".mp3":
+			mimeType = "audio/mpeg"
+		default:
+			mimeType = "application/octet-stream"
+		}
+	}
+
+	// Abrir arquivo
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao abrir arquivo"})
+		return nil, "", false
+	}
+	defer src.Close()
+
+	// Ler conteúdo do arquivo
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao ler arquivo"})
+		return nil, "", false
+	}
+
+	return data, mimeType, true
+}
+
+// GetOutboundMessages lista a fila de envio de um dispositivo (?status=queued|sending|sent|failed|cancelled)
+func (h *Handler) GetOutboundMessages(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	messages, err := h.DB.GetOutboundMessages(id, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// GetOutboundMessage retorna uma mensagem da fila de envio
+func (h *Handler) GetOutboundMessage(c *gin.Context) {
+	message, ok := h.outboundMessageFromParams(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// CancelOutboundMessage cancela uma mensagem que ainda aguarda na fila de envio
+func (h *Handler) CancelOutboundMessage(c *gin.Context) {
+	message, ok := h.outboundMessageFromParams(c)
+	if !ok {
+		return
+	}
+
+	cancelled, err := h.WhatsAppMgr.CancelOutboundMessage(message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "A mensagem já foi enviada ou está em envio"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queue_id": message.ID, "status": database.OutboundCancelled})
+}
+
+// outboundMessageFromParams busca a mensagem da fila indicada em :queue_id,
+// conferindo se pertence ao dispositivo :id. Responde o erro e retorna false se não encontrar.
+func (h *Handler) outboundMessageFromParams(c *gin.Context) (*database.OutboundMessage, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+
+	queueID, err := strconv.ParseInt(c.Param("queue_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "queue_id inválido"})
+		return nil, false
+	}
+
+	message, err := h.DB.GetOutboundMessage(queueID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if message == nil || message.DeviceID != id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mensagem não encontrada na fila"})
+		return nil, false
+	}
+
+	return message, true
+}
+
+// GetDeviceSendLimits retorna os limites de envio efetivos do dispositivo
+func (h *Handler) GetDeviceSendLimits(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	limits, err := h.WhatsAppMgr.GetDeviceSendLimits(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, limits)
+}
+
+// SetDeviceSendLimits atualiza os limites de envio do dispositivo.
+// Campos omitidos mantêm o valor atual (ou o padrão da fila de envio).
+func (h *Handler) SetDeviceSendLimits(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		MessagesPerMinute *int `json:"messages_per_minute"`
+		DailyCap          *int `json:"daily_cap"`
+		MinTypingDelayMs  *int `json:"min_typing_delay_ms"`
+		MaxTypingDelayMs  *int `json:"max_typing_delay_ms"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limits, err := h.WhatsAppMgr.GetDeviceSendLimits(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.MessagesPerMinute != nil {
+		limits.MessagesPerMinute = *request.MessagesPerMinute
+	}
+	if request.DailyCap != nil {
+		limits.DailyCap = *request.DailyCap
+	}
+	if request.MinTypingDelayMs != nil {
+		limits.MinTypingDelayMs = *request.MinTypingDelayMs
+	}
+	if request.MaxTypingDelayMs != nil {
+		limits.MaxTypingDelayMs = *request.MaxTypingDelayMs
+	}
+
+	switch {
+	case limits.MessagesPerMinute < 1:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "messages_per_minute deve ser maior que zero"})
+		return
+	case limits.DailyCap < 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "daily_cap não pode ser negativo"})
+		return
+	case limits.MinTypingDelayMs < 0 || limits.MaxTypingDelayMs < limits.MinTypingDelayMs:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Os tempos de digitação devem respeitar 0 <= min_typing_delay_ms <= max_typing_delay_ms"})
+		return
+	}
+
+	if err := h.WhatsAppMgr.SetDeviceSendLimits(limits); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, limits)
+}
+
+// Formatos aceitos em send_at sem fuso explícito; são interpretados no timezone informado
+var scheduleTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// parseScheduleTime interpreta send_at no fuso IANA informado (UTC se vazio).
+// Horários com offset (RFC 3339) são absolutos e o fuso serve apenas para exibição.
+func parseScheduleTime(sendAt string, timezone string) (time.Time, string, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("timezone inválido: %s", timezone)
+	}
+
+	if t, err := time.Parse(time.RFC3339, sendAt); err == nil {
+		return t, timezone, nil
+	}
+
+	for _, layout := range scheduleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, sendAt, location); err == nil {
+			return t, timezone, nil
+		}
+	}
+
+	return time.Time{}, "", fmt.Errorf("send_at inválido, use RFC 3339 ou 2006-01-02T15:04:05 com timezone")
+}
+
+// CreateScheduledMessage agenda uma mensagem. Aceita JSON (texto) ou um
+// formulário multipart com "file" (mídia), como /send-media.
+func (h *Handler) CreateScheduledMessage(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To       string `json:"to" form:"to" binding:"required"`
+		Message  string `json:"message" form:"message"`
+		Caption  string `json:"caption" form:"caption"`
+		SendAt   string `json:"send_at" form:"send_at" binding:"required"`
+		Timezone string `json:"timezone" form:"timezone"`
+	}
+
+	isMedia := c.ContentType() == "multipart/form-data"
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sendAt, timezone, err := parseScheduleTime(request.SendAt, request.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sendAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send_at deve estar no futuro"})
+		return
+	}
+
+	var scheduled *database.ScheduledMessage
+	if isMedia {
+		data, mimeType, ok := readMediaFormFile(c)
+		if !ok {
+			return
+		}
+		scheduled, err = h.WhatsAppMgr.ScheduleMediaMessage(id, request.To, mimeType, data, request.Caption, mediaOptionsFromForm(c), sendAt, timezone)
+	} else {
+		if request.Message == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message é obrigatório"})
+			return
+		}
+		scheduled, err = h.WhatsAppMgr.ScheduleTextMessage(id, request.To, request.Message, sendAt, timezone)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, scheduled)
+}
+
+// GetScheduledMessages lista os agendamentos de um dispositivo (?status=scheduled|dispatched|cancelled)
+func (h *Handler) GetScheduledMessages(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	limit := 100
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+
+	messages, err := h.DB.GetScheduledMessages(id, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// GetScheduledMessage retorna um agendamento e, se já disparado, a situação do envio
+func (h *Handler) GetScheduledMessage(c *gin.Context) {
+	message, ok := h.scheduledMessageFromParams(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// UpdateScheduledMessage altera um agendamento pendente. Campos omitidos
+// mantêm o valor atual; o arquivo de uma mídia agendada não pode ser trocado.
+func (h *Handler) UpdateScheduledMessage(c *gin.Context) {
+	message, ok := h.scheduledMessageFromParams(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		To       *string `json:"to"`
+		Message  *string `json:"message"`
+		Caption  *string `json:"caption"`
+		SendAt   *string `json:"send_at"`
+		Timezone *string `json:"timezone"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var payload database.OutboundPayload
+	if err := json.Unmarshal([]byte(message.Payload), &payload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payload do agendamento inválido"})
+		return
+	}
+
+	to := message.Recipient
+	if request.To != nil {
+		to = *request.To
+	}
+	if request.Message != nil {
+		if message.Kind != database.OutboundKindText || *request.Message == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message só pode ser alterado em agendamentos de texto e não pode ser vazio"})
+			return
+		}
+		payload.Text = *request.Message
+	}
+	if request.Caption != nil {
+		if message.Kind != database.OutboundKindMedia {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "caption só pode ser alterado em agendamentos de mídia"})
+			return
+		}
+		payload.Caption = *request.Caption
+	}
+
+	if request.SendAt != nil || request.Timezone != nil {
+		timezone := message.Timezone
+		if request.Timezone != nil {
+			timezone = *request.Timezone
+		}
+
+		// Trocar apenas o fuso mantém o mesmo horário local
+		sendAt := message.SendAt.Format(scheduleTimeLayouts[0])
+		if request.SendAt != nil {
+			sendAt = *request.SendAt
+		}
+
+		parsed, timezone, err := parseScheduleTime(sendAt, timezone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if parsed.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send_at deve estar no futuro"})
+			return
+		}
+
+		message.SendAt = parsed
+		message.Timezone = timezone
+	}
+
+	updated, err := h.WhatsAppMgr.UpdateScheduledMessage(message, to, payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusConflict, gin.H{"error": "O agendamento já foi disparado ou cancelado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// CancelScheduledMessage cancela um agendamento pendente
+func (h *Handler) CancelScheduledMessage(c *gin.Context) {
+	message, ok := h.scheduledMessageFromParams(c)
+	if !ok {
+		return
+	}
+
+	cancelled, err := h.WhatsAppMgr.CancelScheduledMessage(message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "O agendamento já foi disparado ou cancelado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": message.ID, "status": database.ScheduledCancelled})
+}
+
+// scheduledMessageFromParams busca o agendamento indicado em :schedule_id,
+// conferindo se pertence ao dispositivo :id. Responde o erro e retorna false se não encontrar.
+func (h *Handler) scheduledMessageFromParams(c *gin.Context) (*database.ScheduledMessage, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+
+	scheduleID, err := strconv.ParseInt(c.Param("schedule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schedule_id inválido"})
+		return nil, false
+	}
+
+	message, err := h.DB.GetScheduledMessage(scheduleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if message == nil || message.DeviceID != id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agendamento não encontrado"})
+		return nil, false
+	}
+
+	return message, true
+}
+
+// Limite de destinatários por campanha
+const maxCampaignRecipients = 50000
+
+// CreateCampaign cria uma campanha de envio em massa. Aceita JSON (texto) ou um
+// formulário multipart com "file" (mídia), em que "recipients" é o JSON da lista
+// e "caption" o template da legenda.
+func (h *Handler) CreateCampaign(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		Name       string                            `json:"name" binding:"required"`
+		Message    string                            `json:"message"`
+		Recipients []whatsapp.CampaignRecipientInput `json:"recipients"`
+		Start      bool                              `json:"start"`
+	}
+
+	var media []byte
+	var mimeType string
+
+	if c.ContentType() == "multipart/form-data" {
+		request.Name = c.PostForm("name")
+		request.Message = c.PostForm("caption")
+		request.Start = c.PostForm("start") == "true"
+		if err := json.Unmarshal([]byte(c.PostForm("recipients")), &request.Recipients); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "recipients deve ser uma lista JSON"})
+			return
+		}
+
+		var ok bool
+		media, mimeType, ok = readMediaFormFile(c)
+		if !ok {
+			return
+		}
+	} else if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case request.Name == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name é obrigatório"})
+		return
+	case media == nil && request.Message == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message é obrigatório"})
+		return
+	case len(request.Recipients) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "recipients não pode ser vazio"})
+		return
+	case len(request.Recipients) > maxCampaignRecipients:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Máximo de %d destinatários por campanha", maxCampaignRecipients)})
+		return
+	}
+
+	campaign, err := h.WhatsAppMgr.CreateCampaign(id, request.Name, request.Message, mimeType, media, request.Recipients)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Start {
+		if _, err := h.WhatsAppMgr.StartCampaign(campaign.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		campaign, _ = h.DB.GetCampaign(campaign.ID)
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// GetCampaigns lista as campanhas de um dispositivo
+func (h *Handler) GetCampaigns(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	campaigns, err := h.DB.GetCampaigns(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaign retorna uma campanha com a contagem de destinatários por status
+func (h *Handler) GetCampaign(c *gin.Context) {
+	campaign, ok := h.campaignFromParams(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// GetCampaignRecipients lista o resultado por destinatário, paginado por ?after=<id>
+func (h *Handler) GetCampaignRecipients(c *gin.Context) {
+	campaign, ok := h.campaignFromParams(c)
+	if !ok {
+		return
+	}
+
+	limit := 500
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed <= 5000 {
+			limit = parsed
+		}
+	}
+
+	afterID, _ := strconv.ParseInt(c.Query("after"), 10, 64)
+
+	recipients, err := h.DB.GetCampaignRecipients(campaign.ID, c.Query("status"), afterID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"recipients": recipients}
+	if len(recipients) == limit {
+		response["next_after"] = recipients[len(recipients)-1].ID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportCampaignRecipients exporta o resultado por destinatário em CSV
+func (h *Handler) ExportCampaignRecipients(c *gin.Context) {
+	campaign, ok := h.campaignFromParams(c)
+	if !ok {
+		return
+	}
+
+	recipients, err := h.DB.GetCampaignRecipients(campaign.ID, c.Query("status"), 0, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=campaign_%d.csv", campaign.ID))
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"recipient", "status", "message_id", "sent_at", "error", "content"})
+	for _, recipient := range recipients {
+		sentAt := ""
+		if recipient.SentAt != nil {
+			sentAt = recipient.SentAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			recipient.Recipient,
+			recipient.Status,
+			recipient.MessageID,
+			sentAt,
+			recipient.ErrorMessage,
+			recipient.Content,
+		})
+	}
+	writer.Flush()
+}
+
+// StartCampaign inicia uma campanha em draft
+func (h *Handler) StartCampaign(c *gin.Context) {
+	h.changeCampaignStatus(c, h.WhatsAppMgr.StartCampaign, "A campanha só pode ser iniciada a partir de draft")
+}
+
+// PauseCampaign pausa uma campanha em andamento
+func (h *Handler) PauseCampaign(c *gin.Context) {
+	h.changeCampaignStatus(c, h.WhatsAppMgr.PauseCampaign, "A campanha não está em andamento")
+}
+
+// ResumeCampaign retoma uma campanha pausada
+func (h *Handler) ResumeCampaign(c *gin.Context) {
+	h.changeCampaignStatus(c, h.WhatsAppMgr.ResumeCampaign, "A campanha não está pausada")
+}
+
+// StopCampaign interrompe uma campanha definitivamente
+func (h *Handler) StopCampaign(c *gin.Context) {
+	h.changeCampaignStatus(c, h.WhatsAppMgr.StopCampaign, "A campanha já foi concluída ou interrompida")
+}
+
+// changeCampaignStatus aplica uma transição de status e responde a campanha atualizada
+func (h *Handler) changeCampaignStatus(c *gin.Context, change func(id int64) (bool, error), conflictMessage string) {
+	campaign, ok := h.campaignFromParams(c)
+	if !ok {
+		return
+	}
+
+	changed, err := change(campaign.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !changed {
+		c.JSON(http.StatusConflict, gin.H{"error": conflictMessage, "status": campaign.Status})
+		return
+	}
+
+	campaign, err = h.DB.GetCampaign(campaign.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// campaignFromParams busca a campanha indicada em :campaign_id do dispositivo
+// em :id. Responde o erro e retorna false se não encontrar.
+func (h *Handler) campaignFromParams(c *gin.Context) (*database.Campaign, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+
+	campaignID, err := strconv.ParseInt(c.Param("campaign_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "campaign_id inválido"})
+		return nil, false
+	}
+
+	campaign, err := h.DB.GetCampaign(campaignID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if campaign == nil || campaign.DeviceID != id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campanha não encontrada"})
+		return nil, false
+	}
+
+	return campaign, true
+}
+
+// GetMessageTemplates lista os templates do tenant (?name= filtra as variantes de um template)
+func (h *Handler) GetMessageTemplates(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return
+	}
+
+	templates, err := h.DB.GetMessageTemplates(tenantID, c.Query("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range templates {
+		templates[i].Variables = whatsapp.TemplateVariables(templates[i].Content)
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// GetMessageTemplate retorna um template do tenant
+func (h *Handler) GetMessageTemplate(c *gin.Context) {
+	template, ok := h.messageTemplateFromParams(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// CreateMessageTemplate cria um template. Aceita JSON ou um formulário
+// multipart com "file" para anexar uma mídia (content vira a legenda).
+func (h *Handler) CreateMessageTemplate(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return
+	}
+
+	var request struct {
+		Name    string `json:"name" form:"name" binding:"required"`
+		Locale  string `json:"locale" form:"locale"`
+		Content string `json:"content" form:"content"`
+	}
+
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var media []byte
+	var mimeType string
+	if c.ContentType() == "multipart/form-data" {
+		var ok bool
+		media, mimeType, ok = readMediaFormFile(c)
+		if !ok {
+			return
+		}
+	}
+
+	if media == nil && request.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content é obrigatório em templates sem anexo"})
+		return
+	}
+
+	existing, err := h.DB.FindMessageTemplate(tenantID, request.Name, request.Locale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil && strings.EqualFold(existing.Locale, request.Locale) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Já existe um template com esse nome e locale", "id": existing.ID})
+		return
+	}
+
+	template := &database.MessageTemplate{
+		TenantID: tenantID,
+		Name:     request.Name,
+		Locale:   request.Locale,
+		Content:  request.Content,
+	}
+
+	if err := h.WhatsAppMgr.SaveMessageTemplate(template, mimeType, media); err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Já existe um template com esse nome e locale"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	template.Variables = whatsapp.TemplateVariables(template.Content)
+	c.JSON(http.StatusCreated, template)
+}
+
+// UpdateMessageTemplate altera um template. Campos omitidos mantêm o valor
+// atual; um "file" multipart substitui o anexo e remove_media=true o retira.
+func (h *Handler) UpdateMessageTemplate(c *gin.Context) {
+	template, ok := h.messageTemplateFromParams(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        *string `json:"name" form:"name"`
+		Locale      *string `json:"locale" form:"locale"`
+		Content     *string `json:"content" form:"content"`
+		RemoveMedia bool    `json:"remove_media" form:"remove_media"`
+	}
+
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var media []byte
+	var mimeType string
+	if c.ContentType() == "multipart/form-data" {
+		if _, err := c.FormFile("file"); err == nil {
+			media, mimeType, ok = readMediaFormFile(c)
+			if !ok {
+				return
+			}
+		}
+	}
+
+	if request.Name != nil {
+		template.Name = *request.Name
+	}
+	if request.Locale != nil {
+		template.Locale = *request.Locale
+	}
+	if request.Content != nil {
+		template.Content = *request.Content
+	}
+
+	previousMedia := ""
+	if request.RemoveMedia && media == nil {
+		previousMedia = template.MediaPath
+		template.MediaPath = ""
+		template.Mimetype = ""
+	}
+
+	if template.Name == "" || (template.MediaPath == "" && media == nil && template.Content == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name e content (ou um anexo) são obrigatórios"})
+		return
+	}
+
+	if err := h.WhatsAppMgr.SaveMessageTemplate(template, mimeType, media); err != nil {
+		if database.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Já existe um template com esse nome e locale"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if previousMedia != "" {
+		os.Remove(previousMedia)
+	}
+
+	template.Variables = whatsapp.TemplateVariables(template.Content)
+	c.JSON(http.StatusOK, template)
+}
+
+// DeleteMessageTemplate remove um template e o anexo
+func (h *Handler) DeleteMessageTemplate(c *gin.Context) {
+	template, ok := h.messageTemplateFromParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.WhatsAppMgr.DeleteMessageTemplate(template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template excluído com sucesso"})
+}
+
+// messageTemplateFromParams busca o template :template_id do tenant :tenant_id.
+// Responde o erro e retorna false se não encontrar.
+func (h *Handler) messageTemplateFromParams(c *gin.Context) (*database.MessageTemplate, bool) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return nil, false
+	}
+
+	templateID, err := strconv.ParseInt(c.Param("template_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template_id inválido"})
+		return nil, false
+	}
+
+	template, err := h.DB.GetMessageTemplate(templateID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if template == nil || template.TenantID != tenantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template não encontrado"})
+		return nil, false
+	}
+
+	template.Variables = whatsapp.TemplateVariables(template.Content)
+	return template, true
+}
+
+// SendTemplate renderiza um template do tenant do dispositivo e coloca a
+// mensagem na fila de envio, como /send
+func (h *Handler) SendTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var request struct {
+		To        string            `json:"to" binding:"required"`
+		Template  string            `json:"template" binding:"required"`
+		Locale    string            `json:"locale"`
+		Variables map[string]string `json:"variables"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	device, err := h.DB.GetDeviceByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dispositivo não encontrado"})
+		return
+	}
+
+	template, err := h.DB.FindMessageTemplate(device.TenantID, request.Template, request.Locale)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if template == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template não encontrado"})
+		return
+	}
+
+	queued, err := h.WhatsAppMgr.SendTemplate(id, request.To, template, request.Variables)
+	if err != nil {
+		var missing *whatsapp.MissingVariablesError
+		if errors.As(err, &missing) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "missing_variables": missing.Variables})
+			return
+		}
+		if errors.Is(err, whatsapp.ErrInvalidRecipient) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"queue_id": queued.ID,
+		"status":   queued.Status,
+		"template": template.Name,
+		"locale":   template.Locale,
+	})
+}
+
+// GetMediaFiles lista a biblioteca de mídias do tenant
+func (h *Handler) GetMediaFiles(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return
+	}
+
+	files, err := h.DB.GetMediaFiles(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+// GetMediaFile retorna uma mídia da biblioteca do tenant
+func (h *Handler) GetMediaFile(c *gin.Context) {
+	media, ok := h.mediaFileFromParams(c)
+	if !ok {
+		return
 	}
 
-	// Obter legenda
-	caption := c.PostForm("caption")
+	c.JSON(http.StatusOK, media)
+}
 
-	// Obter arquivo
-	file, err := c.FormFile("file")
+// UploadMediaFile guarda uma mídia na biblioteca do tenant. Aceita um
+// formulário multipart com "file" ou JSON com "url" para o serviço baixar.
+// Um arquivo já guardado retorna a mídia existente com status 200.
+func (h *Handler) UploadMediaFile(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Arquivo não fornecido"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
 		return
 	}
 
-	// Obter tipo MIME
-	mimeType := file.Header.Get("Content-Type")
-	if mimeType == "" {
-		// Tentar adivinhar pelo nome do arquivo
-		ext := strings.ToLower(filepath.Ext(file.Filename))
-		switch ext {
-		case ".jpg", ".jpeg":
-			mimeType = "image/jpeg"
-		case ".png":
-			mimeType = "image/png"
-		case ".gif":
-			mimeType = "image/gif"
-		case ".mp4":
-			mimeType = "video/mp4"
-		case ".pdf":
-			mimeType = "application/pdf"
-		case ".ogg":
-			mimeType = "audio/ogg"
-		case ".mp3":
-			mimeType = "audio/mpeg"
-		default:
-			mimeType = "application/octet-stream"
+	var data []byte
+	var mimeType, fileName, sourceURL string
+
+	if c.ContentType() == "application/json" {
+		var request struct {
+			URL      string `json:"url" binding:"required"`
+			FileName string `json:"file_name"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		data, mimeType, fileName, err = h.WhatsAppMgr.FetchMediaURL(request.URL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.FileName != "" {
+			fileName = request.FileName
+		}
+		sourceURL = request.URL
+	} else {
+		var ok bool
+		data, mimeType, ok = readMediaFormFile(c)
+		if !ok {
+			return
+		}
+		if file, err := c.FormFile("file"); err == nil {
+			fileName = filepath.Base(file.Filename)
 		}
 	}
 
-	// Abrir arquivo
-	src, err := file.Open()
+	media, created, err := h.WhatsAppMgr.SaveLibraryMedia(tenantID, fileName, mimeType, sourceURL, data)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao abrir arquivo"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer src.Close()
 
-	// Ler conteúdo do arquivo
-	data, err := ioutil.ReadAll(src)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao ler arquivo"})
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, media)
+}
+
+// DeleteMediaFile remove uma mídia da biblioteca do tenant
+func (h *Handler) DeleteMediaFile(c *gin.Context) {
+	media, ok := h.mediaFileFromParams(c)
+	if !ok {
 		return
 	}
 
-	// Obter cliente
-	client, err := h.WhatsAppMgr.GetClient(id)
-	if err != nil {
+	if err := h.WhatsAppMgr.DeleteLibraryMedia(media); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Enviar mídia
-	msgID, err := client.SendMediaMessage(to, mimeType, data, caption)
+	c.JSON(http.StatusOK, gin.H{"message": "Mídia excluída com sucesso"})
+}
+
+// mediaFileFromParams busca a mídia :media_id do tenant :tenant_id.
+// Responde o erro e retorna false se não encontrar.
+func (h *Handler) mediaFileFromParams(c *gin.Context) (*database.MediaFile, bool) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return nil, false
+	}
+
+	mediaID, err := strconv.ParseInt(c.Param("media_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "media_id inválido"})
+		return nil, false
+	}
+
+	media, err := h.DB.GetMediaFile(mediaID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, false
+	}
+	if media == nil || media.TenantID != tenantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mídia não encontrada"})
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
+	return media, true
 }
 
 // Novo handler para gerenciar tracked entities
@@ -881,6 +3271,75 @@ func (h *Handler) SetTrackedEntity(c *gin.Context) {
 	c.JSON(http.StatusOK, entity)
 }
 
+// GetMessageRetentionPolicy retorna a política de armazenamento de mensagens do tenant
+func (h *Handler) GetMessageRetentionPolicy(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return
+	}
+
+	policy, err := h.DB.GetMessageRetentionPolicy(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetMessageRetentionPolicy atualiza a política de armazenamento de mensagens do tenant.
+// Campos omitidos mantêm o valor atual (ou o padrão, se o tenant ainda não tem política).
+func (h *Handler) SetMessageRetentionPolicy(c *gin.Context) {
+	tenantID, err := strconv.ParseInt(c.Param("tenant_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id inválido"})
+		return
+	}
+
+	var request struct {
+		RetentionDays         *int  `json:"retention_days"`
+		StoreDirectMessages   *bool `json:"store_direct_messages"`
+		StoreOutgoingMessages *bool `json:"store_outgoing_messages"`
+		StoreUntrackedGroups  *bool `json:"store_untracked_groups"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.DB.GetMessageRetentionPolicy(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.RetentionDays != nil {
+		if *request.RetentionDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "retention_days não pode ser negativo"})
+			return
+		}
+		policy.RetentionDays = *request.RetentionDays
+	}
+	if request.StoreDirectMessages != nil {
+		policy.StoreDirectMessages = *request.StoreDirectMessages
+	}
+	if request.StoreOutgoingMessages != nil {
+		policy.StoreOutgoingMessages = *request.StoreOutgoingMessages
+	}
+	if request.StoreUntrackedGroups != nil {
+		policy.StoreUntrackedGroups = *request.StoreUntrackedGroups
+	}
+
+	if err := h.DB.UpsertMessageRetentionPolicy(policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
 // Handler para listar tracked entities
 func (h *Handler) GetTrackedEntities(c *gin.Context) {
 	idStr := c.Param("id")
@@ -946,17 +3405,38 @@ func (h *Handler) GetWebhookLogs(c *gin.Context) {
 	}
 
 	// Obter query params para paginação e filtros
-	limit := 50
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
+	filter, err := parseWebhookLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	status := c.Query("status") // filtro por status
+	filter.WebhookID = &webhookID
 
 	// Buscar logs
-	logs, err := h.DB.GetWebhookLogs(webhookID, status, limit)
+	logs, err := h.DB.GetWebhookLogs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
+
+// GetWebhookDeliveries lista entregas de webhook de um tenant ou de uma assinatura,
+// com filtros por status (ex.: failed) e intervalo de datas
+func (h *Handler) GetWebhookDeliveries(c *gin.Context) {
+	filter, err := parseWebhookLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if filter.TenantID == nil && filter.WebhookID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id ou webhook_id é obrigatório"})
+		return
+	}
+
+	logs, err := h.DB.GetWebhookLogs(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -965,6 +3445,264 @@ func (h *Handler) GetWebhookLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// GetWebhookDelivery retorna uma entrega com payload e resposta completos
+func (h *Handler) GetWebhookDelivery(c *gin.Context) {
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de entrega inválido"})
+		return
+	}
+
+	delivery, err := h.DB.GetWebhookLog(deliveryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entrega não encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// ReplayWebhookDelivery reenvia uma entrega finalizada pela fila de webhooks
+func (h *Handler) ReplayWebhookDelivery(c *gin.Context) {
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de entrega inválido"})
+		return
+	}
+
+	delivery, err := h.DB.GetWebhookLog(deliveryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if delivery == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entrega não encontrada"})
+		return
+	}
+
+	config, err := h.DB.GetWebhookConfigByID(delivery.WebhookID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if config == nil || !config.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Webhook desabilitado; habilite-o antes de reenviar"})
+		return
+	}
+
+	newID, err := h.WhatsAppMgr.ReplayWebhookDelivery(deliveryID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":       "queued",
+		"replay_of":    deliveryID,
+		"delivery_id":  newID,
+		"webhook_id":   delivery.WebhookID,
+		"event_type":   delivery.EventType,
+		"queued_count": 1,
+	})
+}
+
+// ReplayWebhookDeliveries reenvia em lote as entregas que atendem ao filtro.
+// Por padrão reenvia apenas as entregas em dead-letter (status failed).
+func (h *Handler) ReplayWebhookDeliveries(c *gin.Context) {
+	var request struct {
+		TenantID  *int64     `json:"tenant_id"`
+		WebhookID *int64     `json:"webhook_id"`
+		Status    string     `json:"status"`
+		From      *time.Time `json:"from"`
+		To        *time.Time `json:"to"`
+		Limit     int        `json:"limit"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.TenantID == nil && request.WebhookID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant_id ou webhook_id é obrigatório"})
+		return
+	}
+
+	if request.Status == "" {
+		request.Status = database.WebhookDeliveryFailed
+	}
+	if request.Status != database.WebhookDeliveryFailed &&
+		request.Status != database.WebhookDeliveryCancelled &&
+		request.Status != database.WebhookDeliverySuccess {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status deve ser failed, cancelled ou success"})
+		return
+	}
+
+	if request.Limit <= 0 || request.Limit > 1000 {
+		request.Limit = 1000
+	}
+
+	ids, err := h.WhatsAppMgr.ReplayWebhookDeliveries(database.WebhookLogFilter{
+		WebhookID: request.WebhookID,
+		TenantID:  request.TenantID,
+		Status:    request.Status,
+		Since:     request.From,
+		Until:     request.To,
+		Limit:     request.Limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":        "queued",
+		"queued_count":  len(ids),
+		"delivery_ids":  ids,
+		"limit_reached": len(ids) == request.Limit,
+	})
+}
+
+// parseMessageQuery lê os parâmetros de consulta do histórico de mensagens:
+// q (busca textual), sender, media_type, from_me, from e to (RFC3339),
+// before ou after (cursores retornados na página anterior) e limit (máx. 200).
+// O parâmetro legado filter (day, week, month) continua aceito como atalho para
+// from; filter=new retorna apenas as mensagens recebidas ainda não lidas.
+func parseMessageQuery(c *gin.Context) (database.MessageQuery, error) {
+	query := database.MessageQuery{
+		Search:    strings.TrimSpace(c.Query("q")),
+		Sender:    c.Query("sender"),
+		MediaType: c.Query("media_type"),
+		Limit:     50,
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 200 {
+			query.Limit = l
+		}
+	}
+
+	if fromMeStr := c.Query("from_me"); fromMeStr != "" {
+		fromMe, err := strconv.ParseBool(fromMeStr)
+		if err != nil {
+			return query, fmt.Errorf("from_me inválido")
+		}
+		query.IsFromMe = &fromMe
+	}
+
+	now := time.Now()
+	switch c.Query("filter") {
+	case "new":
+		// Mensagens recebidas ainda não lidas (veja MarkChatRead)
+		query.Unread = true
+	case "day":
+		since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		query.Since = &since
+	case "week":
+		since := now.AddDate(0, 0, -int(now.Weekday()))
+		since = time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, now.Location())
+		query.Since = &since
+	case "month":
+		since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		query.Since = &since
+	}
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return query, fmt.Errorf("from inválido, use RFC3339")
+		}
+		query.Since = &from
+	}
+
+	if toStr := c.Query("to"); toStr != "" {
+		to, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return query, fmt.Errorf("to inválido, use RFC3339")
+		}
+		query.Until = &to
+	}
+
+	before, after := c.Query("before"), c.Query("after")
+	if before != "" && after != "" {
+		return query, fmt.Errorf("use before ou after, não ambos")
+	}
+
+	if before != "" {
+		cursor, err := database.ParseMessageCursor(before)
+		if err != nil {
+			return query, err
+		}
+		query.Before = cursor
+	}
+
+	if after != "" {
+		cursor, err := database.ParseMessageCursor(after)
+		if err != nil {
+			return query, err
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
+
+// parseWebhookLogFilter lê os filtros de entrega da query string:
+// tenant_id, webhook_id, status, from e to (RFC3339) e limit (máx. 100)
+func parseWebhookLogFilter(c *gin.Context) (database.WebhookLogFilter, error) {
+	filter := database.WebhookLogFilter{
+		Status: c.Query("status"),
+		Limit:  50,
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			filter.Limit = l
+		}
+	}
+
+	if tenantIDStr := c.Query("tenant_id"); tenantIDStr != "" {
+		tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("tenant_id inválido")
+		}
+		filter.TenantID = &tenantID
+	}
+
+	if webhookIDStr := c.Query("webhook_id"); webhookIDStr != "" {
+		webhookID, err := strconv.ParseInt(webhookIDStr, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("webhook_id inválido")
+		}
+		filter.WebhookID = &webhookID
+	}
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return filter, fmt.Errorf("from inválido, use RFC3339")
+		}
+		filter.Since = &from
+	}
+
+	if toStr := c.Query("to"); toStr != "" {
+		to, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return filter, fmt.Errorf("to inválido, use RFC3339")
+		}
+		filter.Until = &to
+	}
+
+	return filter, nil
+}
+
 // GetSystemStatus retorna status detalhado do sistema
 func (h *Handler) GetSystemStatus(c *gin.Context) {
 	// Status dos clientes em memória
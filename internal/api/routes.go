@@ -31,17 +31,88 @@ func SetupRoutes(router *gin.Engine, handler *Handler) {
 			devices.POST("/:id/reauth-done", handler.MarkDeviceAsReauthenticated)
 
 			devices.GET("/:id/groups", handler.GetGroups)
+			devices.POST("/:id/groups", handler.CreateGroup)
+			devices.POST("/:id/groups/join", handler.JoinGroup)
+			devices.GET("/:id/groups/:group_id", handler.GetGroupInfo)
+			devices.PUT("/:id/groups/:group_id", handler.UpdateGroup)
+			devices.POST("/:id/groups/:group_id/participants", handler.UpdateGroupParticipants)
+			devices.PUT("/:id/groups/:group_id/photo", handler.SetGroupPhoto)
+			devices.DELETE("/:id/groups/:group_id/photo", handler.DeleteGroupPhoto)
+			devices.GET("/:id/groups/:group_id/invite-link", handler.GetGroupInviteLink)
+			devices.DELETE("/:id/groups/:group_id/invite-link", handler.RevokeGroupInviteLink)
+			devices.POST("/:id/groups/:group_id/leave", handler.LeaveGroup)
 			devices.GET("/:id/contacts", handler.GetContacts)
+			devices.POST("/:id/contacts/check", handler.CheckContacts)
+			devices.GET("/:id/contacts/:contact_id", handler.GetContact)
+			devices.GET("/:id/contacts/:contact_id/picture", handler.GetContactPicture)
+			devices.GET("/:id/contacts/:contact_id/business", handler.GetContactBusinessProfile)
+			devices.POST("/:id/presence", handler.SetPresence)
+			devices.POST("/:id/presence/subscribe", handler.SubscribePresence)
+			devices.POST("/:id/chat-presence", handler.SendChatPresence)
+			devices.GET("/:id/chats", handler.GetChats)
+			devices.GET("/:id/chats/:chat_id", handler.GetChat)
+			devices.POST("/:id/chats/:chat_id/read", handler.MarkChatRead)
 			devices.GET("/:id/group/:group_id/messages", handler.GetGroupMessages)
 			devices.GET("/:id/contact/:contact_id/messages", handler.GetContactMessages)
 			devices.POST("/:id/group/:group_id/send", handler.SendGroupMessage)
+			devices.GET("/:id/messages", handler.SearchMessages)
+			devices.GET("/:id/messages/:message_id/status", handler.GetMessageStatus)
+			devices.POST("/:id/messages/reply", handler.SendReply)
+			devices.POST("/:id/messages/:message_id/react", handler.ReactToMessage)
+			devices.PUT("/:id/messages/:message_id", handler.EditMessage)
+			devices.DELETE("/:id/messages/:message_id", handler.RevokeMessage)
 			devices.POST("/:id/send-media", handler.SendMediaMessage)
+			devices.POST("/:id/send-sticker", handler.SendSticker)
+			devices.POST("/:id/send-location", handler.SendLocation)
+			devices.POST("/:id/send-contact", handler.SendContacts)
+			devices.POST("/:id/send-poll", handler.SendPoll)
+			devices.GET("/:id/polls", handler.GetPolls)
+			devices.GET("/:id/polls/:message_id", handler.GetPoll)
+			devices.GET("/:id/outbound", handler.GetOutboundMessages)
+			devices.GET("/:id/outbound/:queue_id", handler.GetOutboundMessage)
+			devices.DELETE("/:id/outbound/:queue_id", handler.CancelOutboundMessage)
+			devices.GET("/:id/send-limits", handler.GetDeviceSendLimits)
+			devices.POST("/:id/send-template", handler.SendTemplate)
+			devices.POST("/:id/campaigns", handler.CreateCampaign)
+			devices.GET("/:id/campaigns", handler.GetCampaigns)
+			devices.GET("/:id/campaigns/:campaign_id", handler.GetCampaign)
+			devices.GET("/:id/campaigns/:campaign_id/recipients", handler.GetCampaignRecipients)
+			devices.GET("/:id/campaigns/:campaign_id/export", handler.ExportCampaignRecipients)
+			devices.POST("/:id/campaigns/:campaign_id/start", handler.StartCampaign)
+			devices.POST("/:id/campaigns/:campaign_id/pause", handler.PauseCampaign)
+			devices.POST("/:id/campaigns/:campaign_id/resume", handler.ResumeCampaign)
+			devices.POST("/:id/campaigns/:campaign_id/stop", handler.StopCampaign)
+			devices.POST("/:id/scheduled", handler.CreateScheduledMessage)
+			devices.GET("/:id/scheduled", handler.GetScheduledMessages)
+			devices.GET("/:id/scheduled/:schedule_id", handler.GetScheduledMessage)
+			devices.PUT("/:id/scheduled/:schedule_id", handler.UpdateScheduledMessage)
+			devices.DELETE("/:id/scheduled/:schedule_id", handler.CancelScheduledMessage)
+			devices.PUT("/:id/send-limits", handler.SetDeviceSendLimits)
 			router.Static("/media", "./storage/media")
 			devices.POST("/:id/tracked", handler.SetTrackedEntity)
 			devices.GET("/:id/tracked", handler.GetTrackedEntities)
 			devices.DELETE("/:id/tracked/:jid", handler.DeleteTrackedEntity)
 		}
 
+		// Configurações por tenant
+		tenants := api.Group("/tenants")
+		{
+			tenants.GET("/:tenant_id/message-retention", handler.GetMessageRetentionPolicy)
+			tenants.PUT("/:tenant_id/message-retention", handler.SetMessageRetentionPolicy)
+			tenants.GET("/:tenant_id/media", handler.GetMediaFiles)
+			tenants.POST("/:tenant_id/media", handler.UploadMediaFile)
+			tenants.GET("/:tenant_id/media/:media_id", handler.GetMediaFile)
+			tenants.DELETE("/:tenant_id/media/:media_id", handler.DeleteMediaFile)
+			tenants.GET("/:tenant_id/templates", handler.GetMessageTemplates)
+			tenants.POST("/:tenant_id/templates", handler.CreateMessageTemplate)
+			tenants.GET("/:tenant_id/templates/:template_id", handler.GetMessageTemplate)
+			tenants.PUT("/:tenant_id/templates/:template_id", handler.UpdateMessageTemplate)
+			tenants.DELETE("/:tenant_id/templates/:template_id", handler.DeleteMessageTemplate)
+		}
+
+		// Mapeamento entre LIDs e números, consultável nos dois sentidos
+		api.GET("/lid-mappings/:jid", handler.GetLIDMapping)
+
 		// Rotas de monitoramento e administração
 		admin := api.Group("/admin")
 		{
@@ -50,15 +121,25 @@ func SetupRoutes(router *gin.Engine, handler *Handler) {
 			admin.POST("/devices/:id/reconnect", handler.ReconnectDevice)
 		}
 
-		// // Webhook
-		// webhook := api.Group("/webhook")
-		// {
-		// 	webhook.POST("", handler.WebhookConfig)
-		// 	webhook.GET("", handler.GetWebhookConfigs)
-		// 	webhook.DELETE("/:id", handler.DeleteWebhookConfig)
-		// 	webhook.POST("/:id/test", handler.TestWebhook)
-		// 	webhook.GET("/:id/logs", handler.GetWebhookLogs)
-		// }
+		// Webhook (várias assinaturas por tenant)
+		webhook := api.Group("/webhook")
+		{
+			webhook.POST("", handler.WebhookConfig)
+			webhook.GET("", handler.GetWebhookConfigs)
+			webhook.GET("/:id", handler.GetWebhookConfig)
+			webhook.PUT("/:id", handler.UpdateWebhookConfig)
+			webhook.DELETE("/:id", handler.DeleteWebhookConfig)
+			webhook.POST("/:id/test", handler.TestWebhook)
+			webhook.POST("/:id/rotate-secret", handler.RotateWebhookSecret)
+			webhook.DELETE("/:id/previous-secret", handler.ExpirePreviousWebhookSecret)
+			webhook.GET("/:id/logs", handler.GetWebhookLogs)
+
+			// Inspeção e reenvio de entregas (dead-letter)
+			webhook.GET("/deliveries", handler.GetWebhookDeliveries)
+			webhook.POST("/deliveries/replay", handler.ReplayWebhookDeliveries)
+			webhook.GET("/deliveries/:delivery_id", handler.GetWebhookDelivery)
+			webhook.POST("/deliveries/:delivery_id/replay", handler.ReplayWebhookDelivery)
+		}
 
 		// Rotas de notificação corrigidas
 		api.GET("/notifications/status", handler.GetNotificationStatus)
@@ -91,4 +172,279 @@ POST /api/admin/devices/2/fix
 {
   "action": "reset_reauth"
 }
+
+# Guardar conversas diretas e enviadas por 180 dias para o tenant 4
+PUT /api/tenants/4/message-retention
+{
+  "retention_days": 180,
+  "store_direct_messages": true,
+  "store_outgoing_messages": true
+}
+
+# Buscar "boleto" nas mensagens recebidas do dispositivo 2 em janeiro
+GET /api/devices/2/messages?q=boleto&from_me=false&from=2025-01-01T00:00:00Z&to=2025-01-31T23:59:59Z
+
+# Próxima página (mais antigas) do histórico de um contato
+GET /api/devices/2/contact/5511999999999@s.whatsapp.net/messages?limit=50&before=<next_cursor>
+
+# Enviar uma mensagem (vai para a fila do dispositivo; responde 202 com queue_id)
+POST /api/devices/2/send
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "message": "Olá!"
+}
+
+# Acompanhar a fila e cancelar uma mensagem que ainda não saiu
+GET /api/devices/2/outbound?status=queued
+DELETE /api/devices/2/outbound/57
+
+# Reduzir o ritmo de envio do dispositivo 2
+PUT /api/devices/2/send-limits
+{
+  "messages_per_minute": 10,
+  "daily_cap": 300,
+  "min_typing_delay_ms": 2000,
+  "max_typing_delay_ms": 6000
+}
+
+# Agendar um lembrete para as 9h no horário de Brasília (ou enviar multipart com "file" para mídia)
+POST /api/devices/2/scheduled
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "message": "Lembrete: sua consulta é amanhã às 14h",
+  "send_at": "2025-03-10T09:00:00",
+  "timezone": "America/Sao_Paulo"
+}
+
+# Adiar e depois cancelar o agendamento
+PUT /api/devices/2/scheduled/12
+{
+  "send_at": "2025-03-10T11:00:00"
+}
+DELETE /api/devices/2/scheduled/12
+
+# Enviar mídia por URL (baixada pelo serviço; apenas https)
+POST /api/devices/2/send-media
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "url": "https://exemplo.com/catalogo.pdf",
+  "caption": "Catálogo atualizado"
+}
+
+# Enviar um áudio como nota de voz (convertido para Ogg/Opus)
+POST /api/devices/2/send-media
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "url": "https://exemplo.com/recado.mp3",
+  "voice_note": true
+}
+
+# Texto com prévia do link (título, descrição e miniatura da página)
+POST /api/devices/2/send
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "message": "Veja a novidade: https://exemplo.com/blog/lancamento",
+  "link_preview": true
+}
+
+# Figurinha a partir de uma imagem PNG/JPEG (convertida para WebP)
+POST /api/devices/2/send-sticker
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "url": "https://exemplo.com/logo.png"
+}
+
+# Localização fixa (use "live": true e "caption" para localização em tempo real)
+POST /api/devices/2/send-location
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "latitude": -23.561414,
+  "longitude": -46.655881,
+  "name": "Loja Paulista",
+  "address": "Av. Paulista, 1000 - São Paulo"
+}
+
+# Cartão de contato (vCard gerado automaticamente)
+POST /api/devices/2/send-contact
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "contacts": [
+    {"name": "Suporte", "phones": ["+55 11 4000-0000"], "organization": "Exemplo Ltda"}
+  ]
+}
+
+# Criar um grupo, promover um participante e restringir o envio aos admins
+# (403 se o dispositivo não for admin do grupo)
+POST /api/devices/2/groups
+{
+  "name": "Clientes VIP",
+  "participants": ["5511999999999", "5511888888888@s.whatsapp.net"]
+}
+POST /api/devices/2/groups/120363025246125888@g.us/participants
+{
+  "action": "promote",
+  "participants": ["5511999999999"]
+}
+PUT /api/devices/2/groups/120363025246125888@g.us
+{
+  "description": "Avisos e ofertas exclusivas",
+  "announce": true
+}
+
+# Link de convite: consultar, revogar (gera um novo) e entrar por convite
+GET /api/devices/2/groups/120363025246125888@g.us/invite-link
+DELETE /api/devices/2/groups/120363025246125888@g.us/invite-link
+POST /api/devices/3/groups/join
+{
+  "code": "https://chat.whatsapp.com/AbCdEfGhIjK"
+}
+
+# Verificar números antes de enviar; use o jid retornado, que pode diferir do número
+POST /api/devices/2/contacts/check
+{
+  "phones": ["+55 11 99999-9999", "5511888888888"]
+}
+
+# Diretório de contatos: busca por nome ou número, foto de perfil e perfil comercial
+GET /api/devices/2/contacts?search=maria&limit=20
+GET /api/devices/2/contacts/5511999999999/picture
+GET /api/devices/2/contacts/5511999999999@s.whatsapp.net/business
+
+# Mostrar "digitando..." enquanto a resposta é gerada; a indicação some ao
+# enviar a mensagem ou com state "paused"
+POST /api/devices/2/chat-presence
+{
+  "to": "5511999999999",
+  "state": "composing"
+}
+
+# Acompanhar se um contato está online (eventos presence.update); o
+# dispositivo precisa estar disponível para receber a presença
+POST /api/devices/2/presence
+{
+  "state": "available"
+}
+POST /api/devices/2/presence/subscribe
+{
+  "contact": "5511999999999"
+}
+
+# Caixa de entrada: conversas com prévia da última mensagem, não lidas e os
+# estados arquivado/fixado/silenciado do celular; marcar como lido envia as
+# confirmações de leitura até message_id (ou até a última mensagem)
+GET /api/devices/2/chats?archived=false&unread=true&sort=recent&limit=20
+GET /api/devices/2/chats/5511999999999
+GET /api/devices/2/contact/5511999999999@s.whatsapp.net/messages?filter=new
+POST /api/devices/2/chats/5511999999999@s.whatsapp.net/read
+{
+  "message_id": "3EB0C767D71D8A0F1A2B"
+}
+
+# Número de um LID, ou LID de um número (JID ou apenas dígitos)
+GET /api/lid-mappings/123456789012345@lid
+GET /api/lid-mappings/5511999999999
+
+# Grupos vêm da cópia local, mantida pelos eventos group.* (participant_added,
+# participant_removed, subject_changed...); refresh=true busca no WhatsApp
+GET /api/devices/2/groups
+GET /api/devices/2/groups?refresh=true
+GET /api/devices/2/groups/120363025246125888@g.us?refresh=true
+
+# Enquete em um grupo e acompanhamento dos votos (também publicados como poll.vote)
+POST /api/devices/2/send-poll
+{
+  "to": "120363025246125888@g.us",
+  "question": "Como foi o atendimento?",
+  "options": ["Ótimo", "Bom", "Ruim"],
+  "selectable_count": 1
+}
+GET /api/devices/2/polls?chat=120363025246125888@g.us
+GET /api/devices/2/polls/3EB0C767D097B7C7C8A1
+
+# Guardar uma mídia na biblioteca do tenant e enviá-la para vários chats sem novo upload
+POST /api/tenants/4/media
+{
+  "url": "https://exemplo.com/banner.jpg"
+}
+
+POST /api/devices/2/send-media
+{
+  "to": "5511988888888@s.whatsapp.net",
+  "media_id": 12,
+  "caption": "Promoção da semana"
+}
+
+# Cadastrar um template com variante em inglês e enviá-lo
+POST /api/tenants/4/templates
+{
+  "name": "confirmacao_consulta",
+  "locale": "pt-BR",
+  "content": "Olá {{nome}}, confirmamos sua consulta em {{data}}."
+}
+
+POST /api/tenants/4/templates
+{
+  "name": "confirmacao_consulta",
+  "locale": "en",
+  "content": "Hi {{nome}}, your appointment on {{data}} is confirmed."
+}
+
+POST /api/devices/2/send-template
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "template": "confirmacao_consulta",
+  "locale": "pt-BR",
+  "variables": {"nome": "Ana", "data": "10/03 às 14h"}
+}
+
+# Criar e iniciar uma campanha; {{variáveis}} vêm de cada destinatário
+POST /api/devices/2/campaigns
+{
+  "name": "Aviso de vencimento março",
+  "message": "Olá {{nome}}, seu boleto de {{valor}} vence amanhã.",
+  "start": true,
+  "recipients": [
+    {"to": "5511999999999", "variables": {"nome": "Ana", "valor": "R$ 120,00"}},
+    {"to": "5511988888888@s.whatsapp.net", "variables": {"nome": "Bruno", "valor": "R$ 80,00"}}
+  ]
+}
+
+# Acompanhar, pausar, retomar e exportar o resultado
+GET /api/devices/2/campaigns/7
+POST /api/devices/2/campaigns/7/pause
+POST /api/devices/2/campaigns/7/resume
+GET /api/devices/2/campaigns/7/export?status=failed
+
+# Responder uma mensagem e depois reagir a ela
+POST /api/devices/2/messages/reply
+{
+  "to": "5511999999999@s.whatsapp.net",
+  "message": "Pode sim!",
+  "quoted_message_id": "3EB0C431C26A1916E07A"
+}
+
+POST /api/devices/2/messages/3EB0C431C26A1916E07A/react
+{
+  "chat": "5511999999999@s.whatsapp.net",
+  "reaction": "👍"
+}
+
+# Apagar para todos uma mensagem enviada
+DELETE /api/devices/2/messages/3EB0C431C26A1916E07B?chat=5511999999999@s.whatsapp.net
+
+# Rotacionar o segredo de um webhook mantendo o anterior válido por 48h
+POST /api/webhook/3/rotate-secret
+{
+  "grace_period_hours": 48
+}
+
+# Listar entregas em dead-letter de um tenant nas últimas horas
+GET /api/webhook/deliveries?tenant_id=4&status=failed&from=2025-01-01T00:00:00Z
+
+# Reenviar todas as entregas com falha de um webhook após uma queda do receptor
+POST /api/webhook/deliveries/replay
+{
+  "webhook_id": 3,
+  "from": "2025-01-01T00:00:00Z"
+}
 */
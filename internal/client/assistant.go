@@ -107,7 +107,7 @@ func (c *AssistantClient) ListActiveTenants() ([]TenantInfo, error) {
 }
 
 // SendWebhookEvent envia um evento de webhook para o Assistant processar
-func (c *AssistantClient) SendWebhookEvent(event map[string]interface{}) error {
+func (c *AssistantClient) SendWebhookEvent(event interface{}) error {
 	// Construir URL
 	url := fmt.Sprintf("%s/internal/webhooks/event", c.BaseURL)
 
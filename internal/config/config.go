@@ -31,6 +31,20 @@ type Config struct {
 	NotificationFromEmail  string
 	NotificationToEmails   []string
 	NotificationsEnabled   bool
+
+	// Fila de entrega de webhooks
+	WebhookWorkers     int
+	WebhookMaxAttempts int
+
+	// Fila de envio (padrões por dispositivo; ajustáveis em /api/devices/:id/send-limits)
+	OutboundMessagesPerMinute int
+	OutboundDailyCap          int
+	OutboundMinTypingDelayMs  int
+	OutboundMaxTypingDelayMs  int
+	OutboundMaxAttempts       int
+
+	// Tamanho máximo das mídias enviadas por URL
+	MediaMaxDownloadMB int
 }
 
 // Load carrega configurações do ambiente
@@ -84,6 +98,20 @@ func Load() Config {
 		NotificationFromEmail:  getEnv("NOTIFICATION_FROM_EMAIL", ""),
 		NotificationToEmails:   toEmails,
 		NotificationsEnabled:   getEnvBool("NOTIFICATIONS_ENABLED", true),
+
+		// Webhooks
+		WebhookWorkers:     getEnvInt("WEBHOOK_WORKERS", 4),
+		WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+
+		// Fila de envio
+		OutboundMessagesPerMinute: getEnvInt("OUTBOUND_MESSAGES_PER_MINUTE", 20),
+		OutboundDailyCap:          getEnvInt("OUTBOUND_DAILY_CAP", 1000),
+		OutboundMinTypingDelayMs:  getEnvInt("OUTBOUND_MIN_TYPING_DELAY_MS", 1000),
+		OutboundMaxTypingDelayMs:  getEnvInt("OUTBOUND_MAX_TYPING_DELAY_MS", 4000),
+		OutboundMaxAttempts:       getEnvInt("OUTBOUND_MAX_ATTEMPTS", 5),
+
+		// Mídias por URL
+		MediaMaxDownloadMB: getEnvInt("MEDIA_MAX_DOWNLOAD_MB", 64),
 	}
 }
 
@@ -3,6 +3,9 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -12,6 +15,7 @@ import (
 	"github.com/lib/pq"
 
 	"whatsapp-service/internal/client"
+	"whatsapp-service/pkg/webhook"
 )
 
 // DB é uma instância de conexão com o banco de dados
@@ -259,14 +263,20 @@ func NullTime(t time.Time) sql.NullTime {
 	}
 }
 
+// IsUniqueViolation indica se o erro é uma violação de restrição UNIQUE do Postgres
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 // SaveMessage salva uma mensagem no banco de dados
 func (db *DB) SaveMessage(message *WhatsAppMessage) error {
 	query := `
         INSERT INTO whatsapp_messages (
             device_id, jid, message_id, sender, is_from_me, is_group,
-            content, media_url, media_type, timestamp
+            content, media_url, media_type, timestamp, status, quoted_message_id, details
         ) VALUES (
-            $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+            $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
         ) ON CONFLICT (device_id, message_id) DO NOTHING
         RETURNING id
     `
@@ -283,8 +293,16 @@ func (db *DB) SaveMessage(message *WhatsAppMessage) error {
 		message.MediaURL,
 		message.MediaType,
 		message.Timestamp,
+		message.Status,
+		message.QuotedMessageID,
+		message.Details,
 	).Scan(&message.ID)
 
+	// Mensagem já armazenada (ex.: reentrega do WhatsApp); não é um erro
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
 	// Após salvar a mensagem, notificar o Assistant API sobre o evento
 	// Este passo é assíncrono e não afeta o retorno da função
 	//go db.notifyAssistantAboutMessage(message)
@@ -292,8 +310,13 @@ func (db *DB) SaveMessage(message *WhatsAppMessage) error {
 	return err
 }
 
-// notifyAssistantAboutMessage envia informações de mensagem para o Assistant API
+// NotifyAssistantAboutMessage envia a mensagem ao Assistant API no esquema público de eventos
 func (db *DB) NotifyAssistantAboutMessage(message *WhatsAppMessage) {
+	db.NotifyAssistantAboutMessageWithAudio(message, "")
+}
+
+// NotifyAssistantAboutMessageWithAudio envia a mensagem ao Assistant API com o áudio convertido, se houver
+func (db *DB) NotifyAssistantAboutMessageWithAudio(message *WhatsAppMessage, audioBase64 string) {
 	// Obter informações do dispositivo para resgatar o tenant_id
 	device, err := db.GetDeviceByID(message.DeviceID)
 	if err != nil || device == nil {
@@ -301,27 +324,18 @@ func (db *DB) NotifyAssistantAboutMessage(message *WhatsAppMessage) {
 		return
 	}
 
-	// Criar evento para enviar ao Assistant
-	event := map[string]interface{}{
-		"device_id":  message.DeviceID,
-		"tenant_id":  device.TenantID,
-		"event_type": "*events.Message",
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"event": map[string]interface{}{
-			"Info": map[string]interface{}{
-				"Chat":     message.JID,
-				"Sender":   message.Sender,
-				"IsFromMe": message.IsFromMe,
-				"IsGroup":  message.IsGroup,
-			},
-			"Message": map[string]interface{}{
-				"Conversation": message.Content,
-				"MediaURL":     message.MediaURL,
-				"MediaType":    message.MediaType,
-			},
-		},
+	data := message.WebhookData()
+
+	// Se há áudio em base64, adicionar ao evento
+	if audioBase64 != "" {
+		data.Audio = &webhook.AudioData{
+			Base64: audioBase64,
+			Format: "mp3",
+		}
 	}
 
+	event := webhook.NewEvent(webhook.MessageEventType(message.IsFromMe), message.DeviceID, device.TenantID, data)
+
 	// Enviar para o Assistant API
 	err = db.AssistantClient.SendWebhookEvent(event)
 	if err != nil {
@@ -330,116 +344,496 @@ func (db *DB) NotifyAssistantAboutMessage(message *WhatsAppMessage) {
 	}
 }
 
-// NotifyAssistantAboutMessageWithAudio envia informações de mensagem para o Assistant API com suporte a áudio
-func (db *DB) NotifyAssistantAboutMessageWithAudio(message *WhatsAppMessage, audioBase64 string) {
-	// Obter informações do dispositivo para resgatar o tenant_id
-	device, err := db.GetDeviceByID(message.DeviceID)
-	if err != nil || device == nil {
-		// Se não conseguir obter o dispositivo, não podemos notificar
-		return
+// messageSearchConfig é a configuração de texto do Postgres usada na busca.
+// "simple" não aplica stemming, o que funciona para conversas em qualquer idioma.
+const messageSearchConfig = "simple"
+
+// messageCursorLayout guarda o horário sem fuso, exatamente como está na coluna TIMESTAMP
+const messageCursorLayout = "2006-01-02T15:04:05.999999"
+
+// MessageCursor identifica a posição de uma mensagem na ordenação (timestamp, id)
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// String codifica o cursor em um token opaco para a API
+func (c MessageCursor) String() string {
+	raw := c.Timestamp.Format(messageCursorLayout) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseMessageCursor decodifica um token gerado por MessageCursor.String
+func ParseMessageCursor(token string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("cursor inválido")
 	}
 
-	// Criar evento base para enviar ao Assistant
-	event := map[string]interface{}{
-		"device_id":  message.DeviceID,
-		"tenant_id":  device.TenantID,
-		"event_type": "*events.Message",
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"event": map[string]interface{}{
-			"Info": map[string]interface{}{
-				"Chat":     message.JID,
-				"Sender":   message.Sender,
-				"IsFromMe": message.IsFromMe,
-				"IsGroup":  message.IsGroup,
-			},
-			"Message": map[string]interface{}{
-				"Conversation": message.Content,
-				"MediaURL":     message.MediaURL,
-				"MediaType":    message.MediaType,
-			},
-		},
+	timestampStr, idStr, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, fmt.Errorf("cursor inválido")
 	}
 
-	// Se há áudio em base64, adicionar ao evento
-	if audioBase64 != "" {
-		// Adicionar o áudio ao evento como um campo especial
-		event["audio_data"] = map[string]interface{}{
-			"base64":     audioBase64,
-			"format":     "mp3",
-			"message_id": message.MessageID,
+	timestamp, err := time.Parse(messageCursorLayout, timestampStr)
+	if err != nil {
+		return nil, fmt.Errorf("cursor inválido")
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cursor inválido")
+	}
+
+	return &MessageCursor{Timestamp: timestamp, ID: id}, nil
+}
+
+// MessageQuery filtra o histórico de mensagens de um dispositivo.
+// Campos nulos/vazios não filtram. Before e After são exclusivos: Before pagina
+// para mensagens mais antigas, After para mais novas.
+type MessageQuery struct {
+	DeviceID  int64
+	JID       string // Vazio = todos os chats do dispositivo
+	Sender    string
+	MediaType string // "text" = mensagens sem mídia
+	IsFromMe  *bool
+	Since     *time.Time
+	Until     *time.Time
+	Search    string // Busca textual no conteúdo (sintaxe websearch do Postgres)
+	Unread    bool   // Apenas mensagens recebidas depois do cursor de leitura do chat
+	Before    *MessageCursor
+	After     *MessageCursor
+	Limit     int
+}
+
+// MessagePage é uma página do histórico, da mensagem mais nova para a mais antiga
+type MessagePage struct {
+	Messages   []WhatsAppMessage `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"` // Página seguinte (mais antigas)
+	PrevCursor string            `json:"prev_cursor,omitempty"` // Página anterior (mais novas)
+}
+
+// conditions monta as cláusulas WHERE da consulta sobre whatsapp_messages,
+// retornando também os argumentos posicionais
+func (q MessageQuery) conditions() ([]string, []interface{}) {
+	args := []interface{}{q.DeviceID}
+	conditions := []string{"device_id = $1"}
+
+	if q.JID != "" {
+		args = append(args, q.JID)
+		conditions = append(conditions, fmt.Sprintf("jid = $%d", len(args)))
+	}
+
+	if q.Sender != "" {
+		args = append(args, q.Sender)
+		conditions = append(conditions, fmt.Sprintf("sender = $%d", len(args)))
+	}
+
+	if q.MediaType == "text" {
+		conditions = append(conditions, "COALESCE(media_type, '') = ''")
+	} else if q.MediaType != "" {
+		args = append(args, q.MediaType)
+		conditions = append(conditions, fmt.Sprintf("media_type = $%d", len(args)))
+	}
+
+	if q.IsFromMe != nil {
+		args = append(args, *q.IsFromMe)
+		conditions = append(conditions, fmt.Sprintf("is_from_me = $%d", len(args)))
+	}
+
+	if q.Since != nil {
+		args = append(args, *q.Since)
+		conditions = append(conditions, fmt.Sprintf("timestamp >= $%d", len(args)))
+	}
+
+	if q.Until != nil {
+		args = append(args, *q.Until)
+		conditions = append(conditions, fmt.Sprintf("timestamp <= $%d", len(args)))
+	}
+
+	if q.Search != "" {
+		args = append(args, q.Search)
+		conditions = append(conditions, fmt.Sprintf(
+			"to_tsvector('%s', COALESCE(content, '')) @@ websearch_to_tsquery('%s', $%d)",
+			messageSearchConfig, messageSearchConfig, len(args)))
+	}
+
+	if q.Unread {
+		conditions = append(conditions, unreadMessageCondition)
+	}
+
+	if q.Before != nil {
+		args = append(args, q.Before.Timestamp.Format(messageCursorLayout), q.Before.ID)
+		conditions = append(conditions, fmt.Sprintf("(timestamp, id) < ($%d::timestamp, $%d)", len(args)-1, len(args)))
+	} else if q.After != nil {
+		args = append(args, q.After.Timestamp.Format(messageCursorLayout), q.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(timestamp, id) > ($%d::timestamp, $%d)", len(args)-1, len(args)))
+	}
+
+	return conditions, args
+}
+
+// GetMessages busca uma página do histórico de mensagens com paginação por
+// cursor (keyset) sobre (timestamp, id)
+func (db *DB) GetMessages(q MessageQuery) (*MessagePage, error) {
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+
+	conditions, args := q.conditions()
+
+	// Paginando para frente, buscar em ordem crescente a partir do cursor e inverter depois
+	forward := q.Before == nil && q.After != nil
+	order := "DESC"
+	if forward {
+		order = "ASC"
+	}
+
+	// Buscar um item a mais para saber se existe outra página
+	args = append(args, q.Limit+1)
+	query := `
+        SELECT * FROM whatsapp_messages
+        WHERE ` + strings.Join(conditions, " AND ") + `
+        ORDER BY timestamp ` + order + `, id ` + order + `
+        LIMIT $` + strconv.Itoa(len(args))
+
+	var messages []WhatsAppMessage
+	if err := db.Select(&messages, query, args...); err != nil {
+		return nil, err
+	}
+
+	hasMore := len(messages) > q.Limit
+	if hasMore {
+		messages = messages[:q.Limit]
+	}
+
+	if forward {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
 		}
+	}
 
-		// Marcar que esta mensagem contém áudio processado
-		eventMessage := event["event"].(map[string]interface{})["Message"].(map[string]interface{})
-		eventMessage["HasProcessedAudio"] = true
-		eventMessage["AudioFormat"] = "mp3"
+	// Garantir que nunca retornamos null mesmo se não houver mensagens
+	page := &MessagePage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = []WhatsAppMessage{}
+		return page, nil
 	}
 
-	// Enviar para o Assistant API
-	err = db.AssistantClient.SendWebhookEvent(event)
+	if err := db.attachReactions(q.DeviceID, page.Messages); err != nil {
+		return nil, err
+	}
+
+	newest := page.Messages[0]
+	oldest := page.Messages[len(page.Messages)-1]
+
+	if forward || hasMore {
+		page.NextCursor = MessageCursor{Timestamp: oldest.Timestamp, ID: oldest.ID}.String()
+	}
+	if (forward && hasMore) || q.Before != nil {
+		page.PrevCursor = MessageCursor{Timestamp: newest.Timestamp, ID: newest.ID}.String()
+	}
+
+	return page, nil
+}
+
+// groupRecipientsCondition seleciona as mensagens (alias m) que não são de
+// grupo ou em que todos os participantes atuais, exceto este número, já
+// confirmaram o status $3 ou um posterior. Participantes gravados pelo LID
+// também são reconhecidos pelo número mapeado.
+const groupRecipientsCondition = `(NOT m.is_group OR NOT EXISTS (
+              SELECT 1 FROM group_participants p
+              JOIN whatsapp_devices d ON d.id = p.device_id
+              LEFT JOIN lid_mappings l ON l.lid = p.jid
+              WHERE p.device_id = m.device_id AND p.group_jid = m.jid
+                AND COALESCE(l.phone_jid, p.jid) <> regexp_replace(COALESCE(d.jid, ''), ':[0-9]+@', '@')
+                AND NOT EXISTS (
+                    SELECT 1 FROM message_status_history h
+                    WHERE h.device_id = m.device_id AND h.message_id = m.message_id
+                      AND h.participant IN (p.jid, l.phone_jid)
+                      AND array_position($5::text[], h.status) >= array_position($5::text[], $3)
+                )
+          ))`
+
+// UpdateMessageStatus registra uma confirmação de entrega no histórico e avança
+// o status das mensagens armazenadas. O status só avança (veja messageStatusOrder),
+// então confirmações atrasadas não regridem uma mensagem já lida. Em grupos,
+// entregue, lida e reproduzida só valem quando todos os participantes
+// confirmaram; o histórico guarda a confirmação de cada um.
+func (db *DB) UpdateMessageStatus(deviceID int64, chat string, participant string, messageIDs []string, status string, timestamp time.Time) error {
+	tx, err := db.Beginx()
 	if err != nil {
-		// Log do erro, mas não afeta o fluxo principal
-		fmt.Printf("Erro ao notificar Assistant sobre mensagem: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, messageID := range messageIDs {
+		_, err = tx.Exec(`
+            INSERT INTO message_status_history (device_id, message_id, chat, participant, status, timestamp)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `, deviceID, messageID, chat, participant, status, timestamp)
+		if err != nil {
+			return fmt.Errorf("erro ao registrar histórico de status: %w", err)
+		}
+	}
+
+	query := `
+        UPDATE whatsapp_messages m
+        SET status = $3, status_updated_at = $4
+        WHERE device_id = $1
+          AND message_id = ANY($2)
+          AND COALESCE(array_position($5::text[], status), 0) < array_position($5::text[], $3)`
+	// Falhas são reportadas pelo servidor para a mensagem toda
+	if status != MessageStatusFailed {
+		query += `
+          AND ` + groupRecipientsCondition
+	}
+
+	_, err = tx.Exec(query, deviceID, pq.Array(messageIDs), status, timestamp, pq.Array(messageStatusOrder))
+	if err != nil {
+		return fmt.Errorf("erro ao atualizar status das mensagens: %w", err)
 	}
+
+	return tx.Commit()
 }
 
-// GetMessages obtém mensagens com base nos filtros
-// GetMessages obtém mensagens com base nos filtros
-func (db *DB) GetMessages(deviceID int64, jid string, filter string) ([]WhatsAppMessage, error) {
-	var messages []WhatsAppMessage
-	var query string
-	var args []interface{}
+// GetMessageStatusHistory retorna as confirmações recebidas para uma mensagem, em ordem cronológica
+func (db *DB) GetMessageStatusHistory(deviceID int64, messageID string) ([]MessageStatusChange, error) {
+	var history []MessageStatusChange
+	err := db.Select(&history, `
+        SELECT id, device_id, message_id, chat, participant, status, timestamp, created_at
+        FROM message_status_history
+        WHERE device_id = $1 AND message_id = $2
+        ORDER BY timestamp, id
+    `, deviceID, messageID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Base da query
-	baseQuery := `
-        SELECT * FROM whatsapp_messages
-        WHERE device_id = $1 AND jid = $2
-    `
+	if history == nil {
+		history = []MessageStatusChange{}
+	}
 
-	args = append(args, deviceID, jid)
-
-	// Aplicar filtro de tempo
-	now := time.Now()
-
-	switch filter {
-	case "new":
-		// Mensagens não lidas (dependerá de uma implementação de status de leitura)
-		query = baseQuery + " ORDER BY timestamp DESC"
-	case "day":
-		// Mensagens do dia atual
-		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-		query = baseQuery + " AND timestamp >= $3 ORDER BY timestamp DESC"
-		args = append(args, startOfDay)
-	case "week":
-		// Mensagens da semana atual
-		startOfWeek := now.AddDate(0, 0, -int(now.Weekday()))
-		startOfWeek = time.Date(startOfWeek.Year(), startOfWeek.Month(), startOfWeek.Day(), 0, 0, 0, 0, now.Location())
-		query = baseQuery + " AND timestamp >= $3 ORDER BY timestamp DESC"
-		args = append(args, startOfWeek)
-	case "month":
-		// Mensagens do mês atual
-		startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-		query = baseQuery + " AND timestamp >= $3 ORDER BY timestamp DESC"
-		args = append(args, startOfMonth)
-	default:
-		// Todas as mensagens, ordenadas por data
-		query = baseQuery + " ORDER BY timestamp DESC"
+	return history, nil
+}
+
+// messageAuthorCondition restringe a atualização às mensagens do autor: as
+// enviadas por este número quando fromMe ($5), ou as do remetente $4
+const messageAuthorCondition = `CASE WHEN $5 THEN is_from_me ELSE NOT is_from_me AND sender = $4 END`
+
+// groupAdminCondition seleciona as mensagens de grupo (alias m) em que o
+// remetente $4 é administrador
+const groupAdminCondition = `(is_group AND EXISTS (
+              SELECT 1 FROM group_participants p
+              WHERE p.device_id = m.device_id AND p.group_jid = m.jid AND p.jid = $4
+                AND (p.is_admin OR p.is_super_admin)
+          ))`
+
+// EditMessage substitui o conteúdo de uma mensagem armazenada após uma edição.
+// Só o autor edita a mensagem, e uma edição mais antiga que chega atrasada não
+// sobrescreve a mais recente. Retorna true se a mensagem foi alterada.
+func (db *DB) EditMessage(deviceID int64, messageID string, sender string, fromMe bool, content string, editedAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE whatsapp_messages
+        SET content = $3, edited_at = $6
+        WHERE device_id = $1 AND message_id = $2
+          AND `+messageAuthorCondition+`
+          AND (edited_at IS NULL OR edited_at < $6)
+    `, deviceID, messageID, content, sender, fromMe, editedAt)
+	if err != nil {
+		return false, err
 	}
 
-	// Adicionar limite para não sobrecarregar a resposta
-	query = query + " LIMIT 100"
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// RevokeMessage marca uma mensagem como apagada para todos. O conteúdo é
+// mantido para auditoria; quem exibe o histórico deve respeitar revoked_at.
+// Apagam a mensagem o autor e, em grupos, os administradores (sender).
+// Retorna true se a mensagem foi alterada.
+func (db *DB) RevokeMessage(deviceID int64, messageID string, sender string, fromMe bool, revokedAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE whatsapp_messages m
+        SET revoked_at = $3
+        WHERE device_id = $1 AND message_id = $2 AND revoked_at IS NULL
+          AND (`+messageAuthorCondition+` OR `+groupAdminCondition+`)
+    `, deviceID, messageID, revokedAt, sender, fromMe)
+	if err != nil {
+		return false, err
+	}
 
-	err := db.Select(&messages, query, args...)
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// MessageUpdateAllowed indica se a mensagem está armazenada e se sender pode
+// editá-la (só o autor) ou, com revoke, apagá-la (o autor ou um admin do grupo)
+func (db *DB) MessageUpdateAllowed(deviceID int64, messageID string, sender string, fromMe bool, revoke bool) (bool, bool, error) {
+	var allowed bool
+	err := db.Get(&allowed, `
+        SELECT `+messageAuthorCondition+` OR ($3 AND `+groupAdminCondition+`)
+        FROM whatsapp_messages m
+        WHERE device_id = $1 AND message_id = $2
+    `, deviceID, messageID, revoke, sender, fromMe)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, allowed, nil
+}
+
+// SaveMessageReaction registra a reação de um participante, substituindo a
+// anterior. Uma reação vazia significa que o participante removeu a reação.
+func (db *DB) SaveMessageReaction(reaction *MessageReaction) error {
+	if reaction.Reaction == "" {
+		_, err := db.Exec(`
+            DELETE FROM message_reactions
+            WHERE device_id = $1 AND message_id = $2 AND sender = $3 AND timestamp <= $4
+        `, reaction.DeviceID, reaction.MessageID, reaction.Sender, reaction.Timestamp)
+		return err
+	}
+
+	// Reações chegam fora de ordem; manter apenas a mais recente
+	_, err := db.Exec(`
+        INSERT INTO message_reactions (device_id, message_id, chat, sender, reaction, timestamp)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (device_id, message_id, sender) DO UPDATE SET
+            reaction = EXCLUDED.reaction,
+            timestamp = EXCLUDED.timestamp
+        WHERE message_reactions.timestamp <= EXCLUDED.timestamp
+    `, reaction.DeviceID, reaction.MessageID, reaction.Chat, reaction.Sender, reaction.Reaction, reaction.Timestamp)
+	return err
+}
+
+// attachReactions preenche as reações atuais das mensagens informadas
+func (db *DB) attachReactions(deviceID int64, messages []WhatsAppMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageIDs := make([]string, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.MessageID
+	}
+
+	var reactions []MessageReaction
+	err := db.Select(&reactions, `
+        SELECT id, device_id, message_id, chat, sender, reaction, timestamp
+        FROM message_reactions
+        WHERE device_id = $1 AND message_id = ANY($2)
+        ORDER BY timestamp
+    `, deviceID, pq.Array(messageIDs))
+	if err != nil {
+		return fmt.Errorf("erro ao buscar reações: %w", err)
+	}
+
+	byMessage := make(map[string][]MessageReaction)
+	for _, reaction := range reactions {
+		byMessage[reaction.MessageID] = append(byMessage[reaction.MessageID], reaction)
+	}
+
+	for i := range messages {
+		messages[i].Reactions = byMessage[messages[i].MessageID]
+	}
+
+	return nil
+}
+
+// GetMessage retorna uma mensagem armazenada pelo ID do WhatsApp, ou nil se não existir
+func (db *DB) GetMessage(deviceID int64, messageID string) (*WhatsAppMessage, error) {
+	var message WhatsAppMessage
+	err := db.Get(&message, "SELECT * FROM whatsapp_messages WHERE device_id = $1 AND message_id = $2", deviceID, messageID)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 
-	// Garantir que nunca retornamos null mesmo se não houver mensagens
-	if messages == nil {
-		messages = []WhatsAppMessage{}
+	return &message, nil
+}
+
+// GetMessageRetentionPolicy retorna a política do tenant ou a padrão, se não houver
+func (db *DB) GetMessageRetentionPolicy(tenantID int64) (*MessageRetentionPolicy, error) {
+	var policy MessageRetentionPolicy
+	err := db.Get(&policy, `
+        SELECT tenant_id, retention_days, store_direct_messages, store_outgoing_messages,
+               store_untracked_groups, created_at, updated_at
+        FROM message_retention_policies
+        WHERE tenant_id = $1
+    `, tenantID)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return DefaultMessageRetentionPolicy(tenantID), nil
+		}
+		return nil, err
 	}
 
-	return messages, nil
+	return &policy, nil
+}
+
+// UpsertMessageRetentionPolicy cria ou atualiza a política de um tenant
+func (db *DB) UpsertMessageRetentionPolicy(policy *MessageRetentionPolicy) error {
+	query := `
+        INSERT INTO message_retention_policies (
+            tenant_id, retention_days, store_direct_messages, store_outgoing_messages, store_untracked_groups
+        ) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (tenant_id) DO UPDATE SET
+            retention_days = EXCLUDED.retention_days,
+            store_direct_messages = EXCLUDED.store_direct_messages,
+            store_outgoing_messages = EXCLUDED.store_outgoing_messages,
+            store_untracked_groups = EXCLUDED.store_untracked_groups,
+            updated_at = CURRENT_TIMESTAMP
+        RETURNING created_at, updated_at
+    `
+	return db.QueryRow(
+		query,
+		policy.TenantID,
+		policy.RetentionDays,
+		policy.StoreDirectMessages,
+		policy.StoreOutgoingMessages,
+		policy.StoreUntrackedGroups,
+	).Scan(&policy.CreatedAt, &policy.UpdatedAt)
+}
+
+// DeleteExpiredMessages remove as mensagens (com reações e histórico de status)
+// que excederam o retention_days do tenant e retorna as referências de mídia das linhas removidas, para limpeza
+// dos arquivos. Tenants sem política ou com retention_days = 0 não expiram.
+func (db *DB) DeleteExpiredMessages() ([]string, error) {
+	var mediaURLs []string
+	err := db.Select(&mediaURLs, `
+        DELETE FROM whatsapp_messages m
+        USING whatsapp_devices d, message_retention_policies p
+        WHERE m.device_id = d.id
+          AND p.tenant_id = d.tenant_id
+          AND p.retention_days > 0
+          AND m.timestamp < NOW() - make_interval(days => p.retention_days)
+        RETURNING COALESCE(m.media_url, '')
+    `)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reações e histórico de status seguem a mesma retenção das mensagens
+	for _, table := range []string{"message_reactions", "message_status_history"} {
+		_, err = db.Exec(`
+            DELETE FROM ` + table + ` t
+            USING whatsapp_devices d, message_retention_policies p
+            WHERE t.device_id = d.id
+              AND p.tenant_id = d.tenant_id
+              AND p.retention_days > 0
+              AND t.timestamp < NOW() - make_interval(days => p.retention_days)
+        `)
+		if err != nil {
+			return mediaURLs, fmt.Errorf("erro ao limpar %s: %w", table, err)
+		}
+	}
+
+	return mediaURLs, nil
 }
 
 // Métodos para gerenciar tracked entities
@@ -568,13 +962,14 @@ func (db *DB) UpdateWebhookConfig(config *WebhookConfig) error {
             enabled = $5,
             updated_at = CURRENT_TIMESTAMP
         WHERE id = $6
+        RETURNING created_at, updated_at
     `
 
 	// Converter slices para arrays de SQL
 	events := pq.Array(config.Events)
 	deviceIDs := pq.Array(config.DeviceIDs)
 
-	_, err := db.Exec(
+	err := db.QueryRow(
 		query,
 		config.URL,
 		config.Secret,
@@ -582,22 +977,26 @@ func (db *DB) UpdateWebhookConfig(config *WebhookConfig) error {
 		deviceIDs,
 		config.Enabled,
 		config.ID,
-	)
+	).Scan(&config.CreatedAt, &config.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("configuração de webhook %d não encontrada", config.ID)
+	}
 
 	return err
 }
 
 // GetWebhookConfigsByTenant busca configurações de webhook por tenant
 func (db *DB) GetWebhookConfigsByTenant(tenantID int64) ([]WebhookConfig, error) {
-	var configs []WebhookConfig
-
 	query := `
         SELECT 
-            id, tenant_id, url, secret, events, device_ids, enabled, created_at, updated_at
+            id, tenant_id, url, secret, events, device_ids, enabled, created_at, updated_at,
+            previous_secret, previous_secret_expires_at
         FROM 
             webhook_configs
         WHERE 
             tenant_id = $1
+        ORDER BY id
     `
 
 	rows, err := db.Query(query, tenantID)
@@ -606,25 +1005,61 @@ func (db *DB) GetWebhookConfigsByTenant(tenantID int64) ([]WebhookConfig, error)
 	}
 	defer rows.Close()
 
+	return scanWebhookConfigs(rows)
+}
+
+// GetEnabledWebhookConfigs busca todas as assinaturas de webhook habilitadas,
+// usado para carregar o cache do EventHandler na inicialização
+func (db *DB) GetEnabledWebhookConfigs() ([]WebhookConfig, error) {
+	query := `
+        SELECT 
+            id, tenant_id, url, secret, events, device_ids, enabled, created_at, updated_at,
+            previous_secret, previous_secret_expires_at
+        FROM 
+            webhook_configs
+        WHERE 
+            enabled = true
+        ORDER BY id
+    `
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookConfigs(rows)
+}
+
+// scanWebhookConfigs converte as linhas de webhook_configs em structs
+func scanWebhookConfigs(rows *sql.Rows) ([]WebhookConfig, error) {
+	configs := []WebhookConfig{}
+
 	for rows.Next() {
 		var config WebhookConfig
 		var events, deviceIDs pq.StringArray
+		var secret, previousSecret sql.NullString
 
 		err := rows.Scan(
 			&config.ID,
 			&config.TenantID,
 			&config.URL,
-			&config.Secret,
+			&secret,
 			&events,
 			&deviceIDs,
 			&config.Enabled,
 			&config.CreatedAt,
 			&config.UpdatedAt,
+			&previousSecret,
+			&config.PreviousSecretExpiresAt,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		config.Secret = secret.String
+		config.PreviousSecret = previousSecret.String
+
 		// Converter arrays de SQL para slices
 		config.Events = []string(events)
 
@@ -641,6 +1076,10 @@ func (db *DB) GetWebhookConfigsByTenant(tenantID int64) ([]WebhookConfig, error)
 		configs = append(configs, config)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return configs, nil
 }
 
@@ -648,10 +1087,12 @@ func (db *DB) GetWebhookConfigsByTenant(tenantID int64) ([]WebhookConfig, error)
 func (db *DB) GetWebhookConfigByID(id int64) (*WebhookConfig, error) {
 	var config WebhookConfig
 	var events, deviceIDs pq.StringArray
+	var secret, previousSecret sql.NullString
 
 	query := `
         SELECT 
-            id, tenant_id, url, secret, events, device_ids, enabled, created_at, updated_at
+            id, tenant_id, url, secret, events, device_ids, enabled, created_at, updated_at,
+            previous_secret, previous_secret_expires_at
         FROM 
             webhook_configs
         WHERE 
@@ -662,12 +1103,14 @@ func (db *DB) GetWebhookConfigByID(id int64) (*WebhookConfig, error) {
 		&config.ID,
 		&config.TenantID,
 		&config.URL,
-		&config.Secret,
+		&secret,
 		&events,
 		&deviceIDs,
 		&config.Enabled,
 		&config.CreatedAt,
 		&config.UpdatedAt,
+		&previousSecret,
+		&config.PreviousSecretExpiresAt,
 	)
 
 	if err != nil {
@@ -677,6 +1120,9 @@ func (db *DB) GetWebhookConfigByID(id int64) (*WebhookConfig, error) {
 		return nil, err
 	}
 
+	config.Secret = secret.String
+	config.PreviousSecret = previousSecret.String
+
 	// Converter arrays de SQL para slices
 	config.Events = []string(events)
 
@@ -699,63 +1145,105 @@ func (db *DB) DeleteWebhookConfig(id int64) error {
 	return err
 }
 
-// LogWebhookDelivery registra uma tentativa de entrega de webhook
-func (db *DB) LogWebhookDelivery(delivery *WebhookDelivery) error {
+// RotateWebhookSecret troca o segredo de uma assinatura mantendo o atual como
+// segredo anterior até expiresAt, para que o receptor possa migrar sem rejeitar entregas
+func (db *DB) RotateWebhookSecret(id int64, newSecret string, expiresAt time.Time) (*WebhookConfig, error) {
+	result, err := db.Exec(`
+        UPDATE webhook_configs SET
+            previous_secret = NULLIF(secret, ''),
+            previous_secret_expires_at = CASE WHEN COALESCE(secret, '') = '' THEN NULL ELSE $2::timestamp END,
+            secret = $1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3
+    `, newSecret, expiresAt, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, fmt.Errorf("configuração de webhook %d não encontrada", id)
+	}
+
+	return db.GetWebhookConfigByID(id)
+}
+
+// ClearPreviousWebhookSecret encerra antecipadamente o período de rotação,
+// deixando apenas o segredo atual ativo
+func (db *DB) ClearPreviousWebhookSecret(id int64) error {
+	_, err := db.Exec(`
+        UPDATE webhook_configs SET
+            previous_secret = NULL,
+            previous_secret_expires_at = NULL,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, id)
+	return err
+}
+
+// EnqueueWebhookDelivery insere uma entrega na fila persistente de webhooks.
+// A entrega fica disponível para os workers imediatamente.
+func (db *DB) EnqueueWebhookDelivery(delivery *WebhookDelivery) error {
 	query := `
         INSERT INTO webhook_deliveries (
-            webhook_id, event_type, payload, response_code, response_body, 
+            webhook_id, device_id, event_type, payload, response_code, response_body,
             error_message, attempt_count, status, next_retry_at,
             created_at, last_updated_at
         ) VALUES (
-            $1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
-        ) RETURNING id, created_at, last_updated_at
+            $1, $2, $3, $4, 0, '', '', 0, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+        ) RETURNING id, status, created_at, last_updated_at
     `
 
-	err := db.QueryRow(
+	return db.QueryRow(
 		query,
 		delivery.WebhookID,
+		delivery.DeviceID,
 		delivery.EventType,
 		delivery.Payload,
-		delivery.ResponseCode,
-		delivery.ResponseBody,
-		delivery.ErrorMessage,
-		delivery.AttemptCount,
-		delivery.Status,
-		delivery.NextRetryAt,
-	).Scan(&delivery.ID, &delivery.CreatedAt, &delivery.LastUpdatedAt)
-
-	return err
+		WebhookDeliveryPending,
+	).Scan(&delivery.ID, &delivery.Status, &delivery.CreatedAt, &delivery.LastUpdatedAt)
 }
 
-// GetPendingWebhookDeliveries busca entregas de webhook pendentes ou com falha para retentar
-func (db *DB) GetPendingWebhookDeliveries() ([]WebhookDelivery, error) {
-	var deliveries []WebhookDelivery
-
+// ClaimWebhookDeliveries reserva até limit entregas prontas para envio.
+// Usa FOR UPDATE SKIP LOCKED para que várias réplicas do serviço possam
+// consumir a mesma fila sem entregar duas vezes o mesmo evento. Entregas
+// em "processing" cujo lease expirou (worker morto) voltam a ser elegíveis.
+func (db *DB) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
 	query := `
-        SELECT 
-            id, webhook_id, event_type, payload, response_code, response_body,
-            error_message, attempt_count, status, next_retry_at, created_at, last_updated_at
-        FROM 
-            webhook_deliveries
-        WHERE 
-            (status = 'pending' OR status = 'retrying')
-            AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
-        ORDER BY
-            created_at ASC
-        LIMIT 100
+        UPDATE webhook_deliveries SET
+            status = 'processing',
+            locked_until = CURRENT_TIMESTAMP + ($2 * INTERVAL '1 second'),
+            last_updated_at = CURRENT_TIMESTAMP
+        WHERE id IN (
+            SELECT id FROM webhook_deliveries
+            WHERE (
+                    status IN ('pending', 'retrying')
+                    AND (next_retry_at IS NULL OR next_retry_at <= CURRENT_TIMESTAMP)
+                ) OR (
+                    status = 'processing' AND locked_until < CURRENT_TIMESTAMP
+                )
+            ORDER BY next_retry_at NULLS FIRST, id
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING
+            id, webhook_id, COALESCE(device_id, 0), event_type, payload,
+            COALESCE(response_code, 0), COALESCE(response_body, ''), COALESCE(error_message, ''),
+            attempt_count, status, next_retry_at, created_at, last_updated_at
     `
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, limit, int(lease.Seconds()))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var deliveries []WebhookDelivery
 	for rows.Next() {
 		var delivery WebhookDelivery
 		err := rows.Scan(
 			&delivery.ID,
 			&delivery.WebhookID,
+			&delivery.DeviceID,
 			&delivery.EventType,
 			&delivery.Payload,
 			&delivery.ResponseCode,
@@ -774,10 +1262,10 @@ func (db *DB) GetPendingWebhookDeliveries() ([]WebhookDelivery, error) {
 		deliveries = append(deliveries, delivery)
 	}
 
-	return deliveries, nil
+	return deliveries, rows.Err()
 }
 
-// UpdateWebhookDeliveryStatus atualiza o status de uma entrega de webhook
+// UpdateWebhookDeliveryStatus atualiza o status de uma entrega de webhook e libera o lease
 func (db *DB) UpdateWebhookDeliveryStatus(id int64, status string, responseCode int, responseBody string, errorMessage string, attemptCount int, nextRetry *time.Time) error {
 	query := `
         UPDATE webhook_deliveries SET
@@ -787,6 +1275,7 @@ func (db *DB) UpdateWebhookDeliveryStatus(id int64, status string, responseCode
             error_message = $4,
             attempt_count = $5,
             next_retry_at = $6,
+            locked_until = NULL,
             last_updated_at = CURRENT_TIMESTAMP
         WHERE id = $7
     `
@@ -814,69 +1303,125 @@ type Tenant struct {
 
 // WebhookLog representa um log de entrega de webhook para a API
 type WebhookLog struct {
-	ID           int64     `json:"id"`
-	WebhookID    int64     `json:"webhook_id"`
-	EventType    string    `json:"event_type"`
-	Status       string    `json:"status"`
-	AttemptCount int       `json:"attempt_count"`
-	ResponseCode int       `json:"response_code"`
-	ResponseBody string    `json:"response_body"`
-	ErrorMessage string    `json:"error_message"`
-	Payload      string    `json:"payload"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"last_updated_at"`
-}
-
-// GetWebhookLogs busca logs de entrega de um webhook específico
-func (db *DB) GetWebhookLogs(webhookID int64, status string, limit int) ([]WebhookLog, error) {
-	var logs []WebhookLog
-
-	// Construir query com filtros opcionais
-	query := `
-        SELECT 
-            id, webhook_id, event_type, status, attempt_count, 
-            response_code, response_body, error_message, payload,
-            created_at, last_updated_at
-        FROM 
-            webhook_deliveries
-        WHERE 
-            webhook_id = $1
-    `
+	ID           int64      `json:"id"`
+	WebhookID    int64      `json:"webhook_id"`
+	DeviceID     int64      `json:"device_id"`
+	EventType    string     `json:"event_type"`
+	Status       string     `json:"status"`
+	AttemptCount int        `json:"attempt_count"`
+	ResponseCode int        `json:"response_code"`
+	ResponseBody string     `json:"response_body"`
+	ErrorMessage string     `json:"error_message"`
+	Payload      string     `json:"payload"`
+	NextRetryAt  *time.Time `json:"next_retry_at,omitempty"`
+	ReplayOf     *int64     `json:"replay_of,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"last_updated_at"`
+}
 
-	args := []interface{}{webhookID}
+// WebhookLogFilter filtra entregas de webhook para consulta e reenvio.
+// Campos nulos/vazios não filtram.
+type WebhookLogFilter struct {
+	WebhookID *int64
+	TenantID  *int64
+	Status    string // "all" ou vazio = qualquer status
+	Since     *time.Time
+	Until     *time.Time
+	Limit     int
+}
 
-	// Adicionar filtro por status se fornecido
-	if status != "" && status != "all" {
-		query += " AND status = $2"
-		args = append(args, status)
+// conditions monta as cláusulas WHERE do filtro sobre webhook_deliveries d
+// e webhook_configs w, retornando também os argumentos posicionais
+func (f WebhookLogFilter) conditions() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.WebhookID != nil {
+		args = append(args, *f.WebhookID)
+		conditions = append(conditions, fmt.Sprintf("d.webhook_id = $%d", len(args)))
 	}
 
-	// Ordenar por data de criação (mais recente primeiro)
-	query += " ORDER BY created_at DESC LIMIT $" + strconv.Itoa(len(args)+1)
-	args = append(args, limit)
+	if f.TenantID != nil {
+		args = append(args, *f.TenantID)
+		conditions = append(conditions, fmt.Sprintf("w.tenant_id = $%d", len(args)))
+	}
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
+	if f.Status != "" && f.Status != "all" {
+		args = append(args, f.Status)
+		conditions = append(conditions, fmt.Sprintf("d.status = $%d", len(args)))
+	}
+
+	if f.Since != nil {
+		args = append(args, *f.Since)
+		conditions = append(conditions, fmt.Sprintf("d.created_at >= $%d", len(args)))
+	}
+
+	if f.Until != nil {
+		args = append(args, *f.Until)
+		conditions = append(conditions, fmt.Sprintf("d.created_at < $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+const webhookLogColumns = `
+            d.id, d.webhook_id, COALESCE(d.device_id, 0), d.event_type, d.status, d.attempt_count,
+            COALESCE(d.response_code, 0), COALESCE(d.response_body, ''), COALESCE(d.error_message, ''),
+            d.payload, d.next_retry_at, d.replay_of, d.created_at, d.last_updated_at`
+
+// scanWebhookLog lê uma linha selecionada com webhookLogColumns
+func scanWebhookLog(scanner interface{ Scan(...interface{}) error }, log *WebhookLog) error {
+	return scanner.Scan(
+		&log.ID,
+		&log.WebhookID,
+		&log.DeviceID,
+		&log.EventType,
+		&log.Status,
+		&log.AttemptCount,
+		&log.ResponseCode,
+		&log.ResponseBody,
+		&log.ErrorMessage,
+		&log.Payload,
+		&log.NextRetryAt,
+		&log.ReplayOf,
+		&log.CreatedAt,
+		&log.UpdatedAt,
+	)
+}
+
+// GetWebhookLogs busca logs de entrega de webhook com filtros por
+// assinatura, tenant, status e intervalo de criação
+func (db *DB) GetWebhookLogs(filter WebhookLogFilter) ([]WebhookLog, error) {
+	logs := []WebhookLog{}
+
+	conditions, args := filter.conditions()
+
+	query := `
+        SELECT ` + webhookLogColumns + `
+        FROM 
+            webhook_deliveries d
+            JOIN webhook_configs w ON w.id = d.webhook_id
+    `
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Ordenar por data de criação (mais recente primeiro)
+	query += " ORDER BY d.created_at DESC, d.id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var log WebhookLog
-		err := rows.Scan(
-			&log.ID,
-			&log.WebhookID,
-			&log.EventType,
-			&log.Status,
-			&log.AttemptCount,
-			&log.ResponseCode,
-			&log.ResponseBody,
-			&log.ErrorMessage,
-			&log.Payload,
-			&log.CreatedAt,
-			&log.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanWebhookLog(rows, &log); err != nil {
 			return nil, err
 		}
 
@@ -890,6 +1435,1966 @@ func (db *DB) GetWebhookLogs(webhookID int64, status string, limit int) ([]Webho
 	return logs, nil
 }
 
+// GetWebhookLog busca uma entrega específica com payload e resposta completos
+func (db *DB) GetWebhookLog(id int64) (*WebhookLog, error) {
+	var log WebhookLog
+
+	row := db.QueryRow(`
+        SELECT `+webhookLogColumns+`
+        FROM webhook_deliveries d
+        WHERE d.id = $1
+    `, id)
+
+	if err := scanWebhookLog(row, &log); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &log, nil
+}
+
+// ReplayWebhookDelivery enfileira uma cópia de uma entrega já finalizada.
+// A linha original é preservada para auditoria e a nova aponta para ela em replay_of.
+func (db *DB) ReplayWebhookDelivery(id int64) (int64, error) {
+	var newID int64
+
+	err := db.QueryRow(`
+        INSERT INTO webhook_deliveries (
+            webhook_id, device_id, event_type, payload, response_code, response_body,
+            error_message, attempt_count, status, next_retry_at, replay_of,
+            created_at, last_updated_at
+        )
+        SELECT
+            d.webhook_id, d.device_id, d.event_type, d.payload, 0, '',
+            '', 0, 'pending', CURRENT_TIMESTAMP, d.id,
+            CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+        FROM webhook_deliveries d
+        WHERE d.id = $1
+        AND d.status IN ('failed', 'cancelled', 'success')
+        RETURNING id
+    `, id).Scan(&newID)
+
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("entrega %d não encontrada ou ainda está na fila", id)
+	}
+
+	return newID, err
+}
+
+// ReplayWebhookDeliveries enfileira cópias de todas as entregas finalizadas
+// que atendem ao filtro. Entregas que já foram reenviadas são ignoradas,
+// assim repetir a mesma chamada não duplica eventos. Só considera
+// assinaturas habilitadas.
+func (db *DB) ReplayWebhookDeliveries(filter WebhookLogFilter) ([]int64, error) {
+	conditions, args := filter.conditions()
+	conditions = append(conditions,
+		"d.status IN ('failed', 'cancelled', 'success')",
+		"w.enabled = true",
+		"NOT EXISTS (SELECT 1 FROM webhook_deliveries r WHERE r.replay_of = d.id)",
+	)
+
+	limitClause := ""
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		limitClause = " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	query := `
+        INSERT INTO webhook_deliveries (
+            webhook_id, device_id, event_type, payload, response_code, response_body,
+            error_message, attempt_count, status, next_retry_at, replay_of,
+            created_at, last_updated_at
+        )
+        SELECT
+            d.webhook_id, d.device_id, d.event_type, d.payload, 0, '',
+            '', 0, 'pending', CURRENT_TIMESTAMP, d.id,
+            CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+        FROM webhook_deliveries d
+        JOIN webhook_configs w ON w.id = d.webhook_id
+        WHERE ` + strings.Join(conditions, " AND ") + `
+        ORDER BY d.created_at ASC, d.id ASC` + limitClause + `
+        RETURNING id
+    `
+
+	ids := []int64{}
+	err := db.Select(&ids, query, args...)
+	return ids, err
+}
+
+// outboundColumns lista as colunas de outbound_messages na ordem de OutboundMessage
+const outboundColumns = `id, device_id, recipient, kind, payload, status, priority, message_id, attempt_count,
+            error_message, next_attempt_at, locked_until, sent_at, created_at, updated_at`
+
+// EnqueueOutboundMessage grava uma mensagem na fila de envio do dispositivo
+func (db *DB) EnqueueOutboundMessage(message *OutboundMessage) error {
+	query := `
+        INSERT INTO outbound_messages (device_id, recipient, kind, payload, status, priority, next_attempt_at)
+        VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+        RETURNING ` + outboundColumns
+
+	return db.Get(message, query,
+		message.DeviceID,
+		message.Recipient,
+		message.Kind,
+		message.Payload,
+		OutboundQueued,
+		message.Priority,
+	)
+}
+
+// GetOutboundMessage retorna uma mensagem da fila, ou nil se não existir
+func (db *DB) GetOutboundMessage(id int64) (*OutboundMessage, error) {
+	var message OutboundMessage
+	err := db.Get(&message, "SELECT "+outboundColumns+" FROM outbound_messages WHERE id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// GetOutboundMessages lista a fila de um dispositivo, das mais recentes para as
+// mais antigas. status vazio ou "all" não filtra.
+func (db *DB) GetOutboundMessages(deviceID int64, status string, limit int) ([]OutboundMessage, error) {
+	messages := []OutboundMessage{}
+
+	args := []interface{}{deviceID, limit}
+	query := "SELECT " + outboundColumns + " FROM outbound_messages WHERE device_id = $1"
+	if status != "" && status != "all" {
+		args = append(args, status)
+		query += " AND status = $3"
+	}
+	query += " ORDER BY id DESC LIMIT $2"
+
+	err := db.Select(&messages, query, args...)
+	return messages, err
+}
+
+// CancelOutboundMessage cancela uma mensagem que ainda não foi reservada para envio.
+// Retorna false se a mensagem não existe ou já saiu da fila.
+func (db *DB) CancelOutboundMessage(id int64) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE outbound_messages
+        SET status = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = $3
+    `, id, OutboundCancelled, OutboundQueued)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetReadyOutboundDevices retorna os dispositivos com mensagens prontas para envio
+func (db *DB) GetReadyOutboundDevices() ([]int64, error) {
+	var deviceIDs []int64
+	err := db.Select(&deviceIDs, `
+        SELECT DISTINCT device_id FROM outbound_messages
+        WHERE (status = 'queued' AND next_attempt_at <= CURRENT_TIMESTAMP)
+           OR (status = 'sending' AND locked_until < CURRENT_TIMESTAMP)
+    `)
+	return deviceIDs, err
+}
+
+// CountSentOutbound conta as mensagens da fila enviadas pelo dispositivo desde since
+func (db *DB) CountSentOutbound(deviceID int64, since time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, `
+        SELECT COUNT(*) FROM outbound_messages
+        WHERE device_id = $1 AND status = 'sent' AND sent_at >= $2
+    `, deviceID, since)
+	return count, err
+}
+
+// ClaimNextOutboundMessage reserva a próxima mensagem pronta do dispositivo,
+// por prioridade e depois por ordem de chegada.
+// Só uma mensagem por dispositivo fica em envio de cada vez, mesmo com várias
+// réplicas do serviço (FOR UPDATE SKIP LOCKED). Mensagens em "sending" com o
+// lease expirado (processo interrompido) voltam a ser elegíveis. Retorna nil
+// quando não há mensagem pronta.
+func (db *DB) ClaimNextOutboundMessage(deviceID int64, lease time.Duration) (*OutboundMessage, error) {
+	var message OutboundMessage
+	err := db.Get(&message, `
+        UPDATE outbound_messages SET
+            status = 'sending',
+            locked_until = CURRENT_TIMESTAMP + ($2 * INTERVAL '1 second'),
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = (
+            SELECT id FROM outbound_messages
+            WHERE device_id = $1
+              AND (
+                    (status = 'queued' AND next_attempt_at <= CURRENT_TIMESTAMP)
+                    OR (status = 'sending' AND locked_until < CURRENT_TIMESTAMP)
+                  )
+            ORDER BY priority DESC, next_attempt_at, id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM outbound_messages
+            WHERE device_id = $1 AND status = 'sending' AND locked_until >= CURRENT_TIMESTAMP
+        )
+        RETURNING `+outboundColumns, deviceID, int(lease.Seconds()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// UpdateOutboundMessageStatus registra o resultado de uma tentativa de envio e libera o lease.
+// nextAttempt é usado quando a mensagem volta para a fila.
+func (db *DB) UpdateOutboundMessageStatus(id int64, status string, messageID string, errorMessage string, attemptCount int, nextAttempt *time.Time) error {
+	_, err := db.Exec(`
+        UPDATE outbound_messages SET
+            status = $2,
+            message_id = $3,
+            error_message = $4,
+            attempt_count = $5,
+            next_attempt_at = COALESCE($6, next_attempt_at),
+            sent_at = CASE WHEN $2 = 'sent' THEN CURRENT_TIMESTAMP ELSE sent_at END,
+            locked_until = NULL,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, id, status, messageID, errorMessage, attemptCount, nextAttempt)
+	return err
+}
+
+// GetDeviceSendLimits retorna os limites configurados do dispositivo, ou nil se usa os padrões
+func (db *DB) GetDeviceSendLimits(deviceID int64) (*DeviceSendLimits, error) {
+	var limits DeviceSendLimits
+	err := db.Get(&limits, `
+        SELECT device_id, messages_per_minute, daily_cap, min_typing_delay_ms, max_typing_delay_ms, updated_at
+        FROM device_send_limits WHERE device_id = $1
+    `, deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &limits, nil
+}
+
+// UpsertDeviceSendLimits cria ou atualiza os limites de envio de um dispositivo
+func (db *DB) UpsertDeviceSendLimits(limits *DeviceSendLimits) error {
+	return db.QueryRow(`
+        INSERT INTO device_send_limits (
+            device_id, messages_per_minute, daily_cap, min_typing_delay_ms, max_typing_delay_ms
+        ) VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (device_id) DO UPDATE SET
+            messages_per_minute = EXCLUDED.messages_per_minute,
+            daily_cap = EXCLUDED.daily_cap,
+            min_typing_delay_ms = EXCLUDED.min_typing_delay_ms,
+            max_typing_delay_ms = EXCLUDED.max_typing_delay_ms,
+            updated_at = CURRENT_TIMESTAMP
+        RETURNING updated_at
+    `,
+		limits.DeviceID,
+		limits.MessagesPerMinute,
+		limits.DailyCap,
+		limits.MinTypingDelayMs,
+		limits.MaxTypingDelayMs,
+	).Scan(&limits.UpdatedAt)
+}
+
+// scheduledSelect consulta mensagens agendadas junto com a situação na fila de envio
+const scheduledSelect = `
+        SELECT s.id, s.device_id, s.recipient, s.kind, s.payload, s.send_at, s.timezone, s.status,
+               s.outbound_message_id, s.dispatched_at, s.created_at, s.updated_at,
+               COALESCE(o.status, '') AS delivery_status,
+               COALESCE(o.message_id, '') AS message_id,
+               COALESCE(o.error_message, '') AS error_message
+        FROM scheduled_messages s
+        LEFT JOIN outbound_messages o ON o.id = s.outbound_message_id`
+
+// CreateScheduledMessage grava uma mensagem agendada
+func (db *DB) CreateScheduledMessage(message *ScheduledMessage) error {
+	err := db.QueryRow(`
+        INSERT INTO scheduled_messages (device_id, recipient, kind, payload, send_at, timezone, status)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, status, created_at, updated_at
+    `,
+		message.DeviceID,
+		message.Recipient,
+		message.Kind,
+		message.Payload,
+		message.SendAt,
+		message.Timezone,
+		ScheduledPending,
+	).Scan(&message.ID, &message.Status, &message.CreatedAt, &message.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	message.prepare()
+	return nil
+}
+
+// GetScheduledMessage retorna uma mensagem agendada, ou nil se não existir
+func (db *DB) GetScheduledMessage(id int64) (*ScheduledMessage, error) {
+	var message ScheduledMessage
+	err := db.Get(&message, scheduledSelect+" WHERE s.id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	message.prepare()
+	return &message, nil
+}
+
+// GetScheduledMessages lista os agendamentos de um dispositivo pelo horário de
+// envio. status vazio ou "all" não filtra.
+func (db *DB) GetScheduledMessages(deviceID int64, status string, limit int) ([]ScheduledMessage, error) {
+	messages := []ScheduledMessage{}
+
+	args := []interface{}{deviceID, limit}
+	query := scheduledSelect + " WHERE s.device_id = $1"
+	if status != "" && status != "all" {
+		args = append(args, status)
+		query += " AND s.status = $3"
+	}
+	query += " ORDER BY s.send_at, s.id LIMIT $2"
+
+	if err := db.Select(&messages, query, args...); err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		messages[i].prepare()
+	}
+	return messages, nil
+}
+
+// UpdateScheduledMessage altera destinatário, conteúdo e horário de um
+// agendamento que ainda não foi disparado. Retorna false caso contrário.
+func (db *DB) UpdateScheduledMessage(message *ScheduledMessage) (bool, error) {
+	err := db.QueryRow(`
+        UPDATE scheduled_messages SET
+            recipient = $2,
+            payload = $3,
+            send_at = $4,
+            timezone = $5,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = $6
+        RETURNING updated_at
+    `,
+		message.ID,
+		message.Recipient,
+		message.Payload,
+		message.SendAt,
+		message.Timezone,
+		ScheduledPending,
+	).Scan(&message.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	message.prepare()
+	return true, nil
+}
+
+// CancelScheduledMessage cancela um agendamento que ainda não foi disparado.
+// Retorna false se ele não existe ou já saiu do estado agendado.
+func (db *DB) CancelScheduledMessage(id int64) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE scheduled_messages
+        SET status = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = $3
+    `, id, ScheduledCancelled, ScheduledPending)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// DispatchDueScheduledMessages move para a fila de envio os agendamentos com
+// horário até now. A mensagem entra na fila e o agendamento é marcado como
+// disparado na mesma transação, então um reinício do serviço não perde nem
+// duplica envios; agendamentos vencidos enquanto o serviço estava parado são
+// disparados na primeira execução. Retorna os agendamentos disparados.
+func (db *DB) DispatchDueScheduledMessages(now time.Time, limit int) ([]ScheduledMessage, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var due []ScheduledMessage
+	err = tx.Select(&due, `
+        SELECT id, device_id, recipient, kind, payload, send_at, timezone, status,
+               outbound_message_id, dispatched_at, created_at, updated_at
+        FROM scheduled_messages
+        WHERE status = $1 AND send_at <= $2
+        ORDER BY send_at, id
+        LIMIT $3
+        FOR UPDATE SKIP LOCKED
+    `, ScheduledPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range due {
+		var outboundID int64
+		err = tx.QueryRow(`
+            INSERT INTO outbound_messages (device_id, recipient, kind, payload, status, next_attempt_at)
+            VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+            RETURNING id
+        `, due[i].DeviceID, due[i].Recipient, due[i].Kind, due[i].Payload, OutboundQueued).Scan(&outboundID)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao enfileirar agendamento %d: %w", due[i].ID, err)
+		}
+
+		err = tx.QueryRow(`
+            UPDATE scheduled_messages SET
+                status = $2,
+                outbound_message_id = $3,
+                dispatched_at = CURRENT_TIMESTAMP,
+                updated_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+            RETURNING status, dispatched_at
+        `, due[i].ID, ScheduledDispatched, outboundID).Scan(&due[i].Status, &due[i].DispatchedAt)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao atualizar agendamento %d: %w", due[i].ID, err)
+		}
+
+		due[i].OutboundMessageID = &outboundID
+		due[i].DeliveryStatus = OutboundQueued
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
+
+// campaignRecipientSelect consulta destinatários com o status efetivo: o da
+// mensagem na fila de envio, quando já foi enfileirada
+const campaignRecipientSelect = `
+        SELECT r.id, r.campaign_id, r.recipient, r.content,
+               COALESCE(o.status, r.status) AS status,
+               COALESCE(NULLIF(r.error_message, ''), o.error_message, '') AS error_message,
+               r.outbound_message_id,
+               COALESCE(o.message_id, '') AS message_id,
+               o.sent_at,
+               GREATEST(r.updated_at, COALESCE(o.updated_at, r.updated_at)) AS updated_at
+        FROM campaign_recipients r
+        LEFT JOIN outbound_messages o ON o.id = r.outbound_message_id`
+
+const campaignColumns = `id, device_id, name, kind, template, payload, status, started_at, completed_at, created_at, updated_at`
+
+// CreateCampaign grava a campanha (como draft) e seus destinatários em uma transação
+func (db *DB) CreateCampaign(campaign *Campaign, recipients []CampaignRecipient) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(campaign, `
+        INSERT INTO campaigns (device_id, name, kind, template, payload, status)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING `+campaignColumns,
+		campaign.DeviceID,
+		campaign.Name,
+		campaign.Kind,
+		campaign.Template,
+		campaign.Payload,
+		CampaignDraft,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao criar campanha: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+        INSERT INTO campaign_recipients (campaign_id, recipient, content, status, error_message)
+        VALUES ($1, $2, $3, $4, $5)
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, recipient := range recipients {
+		_, err = stmt.Exec(campaign.ID, recipient.Recipient, recipient.Content, recipient.Status, recipient.ErrorMessage)
+		if err != nil {
+			return fmt.Errorf("erro ao gravar destinatário %s: %w", recipient.Recipient, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetCampaign retorna a campanha com a contagem de destinatários por status, ou nil se não existir
+func (db *DB) GetCampaign(id int64) (*Campaign, error) {
+	var campaign Campaign
+	err := db.Get(&campaign, "SELECT "+campaignColumns+" FROM campaigns WHERE id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var counts []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	err = db.Select(&counts, `
+        SELECT COALESCE(o.status, r.status) AS status, COUNT(*) AS count
+        FROM campaign_recipients r
+        LEFT JOIN outbound_messages o ON o.id = r.outbound_message_id
+        WHERE r.campaign_id = $1
+        GROUP BY 1
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+
+	campaign.Stats = make(map[string]int)
+	for _, count := range counts {
+		campaign.Stats[count.Status] = count.Count
+	}
+
+	return &campaign, nil
+}
+
+// GetCampaigns lista as campanhas de um dispositivo, das mais recentes para as mais antigas
+func (db *DB) GetCampaigns(deviceID int64) ([]Campaign, error) {
+	campaigns := []Campaign{}
+	err := db.Select(&campaigns, "SELECT "+campaignColumns+" FROM campaigns WHERE device_id = $1 ORDER BY id DESC", deviceID)
+	return campaigns, err
+}
+
+// GetCampaignRecipients lista os destinatários de uma campanha em ordem de
+// inclusão, a partir de afterID. status vazio ou "all" não filtra; limit <= 0
+// retorna todos (exportação).
+func (db *DB) GetCampaignRecipients(campaignID int64, status string, afterID int64, limit int) ([]CampaignRecipient, error) {
+	recipients := []CampaignRecipient{}
+
+	args := []interface{}{campaignID, afterID}
+	query := campaignRecipientSelect + " WHERE r.campaign_id = $1 AND r.id > $2"
+	if status != "" && status != "all" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND COALESCE(o.status, r.status) = $%d", len(args))
+	}
+	query += " ORDER BY r.id"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	err := db.Select(&recipients, query, args...)
+	return recipients, err
+}
+
+// GetOutboundMessageCampaign retorna a campanha da mensagem da fila, ou nil se
+// a mensagem não pertence a uma campanha
+func (db *DB) GetOutboundMessageCampaign(outboundID int64) (*Campaign, error) {
+	var campaign Campaign
+	err := db.Get(&campaign, `
+        SELECT `+campaignColumns+` FROM campaigns
+        WHERE id = (SELECT campaign_id FROM campaign_recipients WHERE outbound_message_id = $1)
+    `, outboundID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// SetCampaignStatus muda o status da campanha se o atual estiver em from.
+// Retorna false se a transição não é permitida.
+func (db *DB) SetCampaignStatus(id int64, status string, from ...string) (bool, error) {
+	result, err := db.Exec(`
+        UPDATE campaigns SET
+            status = $2,
+            started_at = CASE WHEN $2 = 'running' THEN COALESCE(started_at, CURRENT_TIMESTAMP) ELSE started_at END,
+            completed_at = CASE WHEN $2 IN ('completed', 'stopped') THEN CURRENT_TIMESTAMP ELSE completed_at END,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = ANY($3)
+    `, id, status, pq.Array(from))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// WithdrawCampaignMessages retira da fila de envio as mensagens da campanha
+// que ainda não foram reservadas. Os destinatários voltam para
+// recipientStatus (pending ao pausar, cancelled ao interromper); mensagens já
+// em envio terminam normalmente, sem novas tentativas se falharem.
+func (db *DB) WithdrawCampaignMessages(campaignID int64, recipientStatus string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        WITH withdrawn AS (
+            UPDATE outbound_messages o
+            SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+            FROM campaign_recipients r
+            WHERE r.campaign_id = $1 AND r.outbound_message_id = o.id AND o.status = 'queued'
+            RETURNING o.id
+        )
+        UPDATE campaign_recipients
+        SET status = $2, outbound_message_id = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE campaign_id = $1 AND outbound_message_id IN (SELECT id FROM withdrawn)
+    `, campaignID, recipientStatus)
+	if err != nil {
+		return fmt.Errorf("erro ao retirar mensagens da fila: %w", err)
+	}
+
+	if recipientStatus != CampaignRecipientPending {
+		_, err = tx.Exec(`
+            UPDATE campaign_recipients
+            SET status = $2, updated_at = CURRENT_TIMESTAMP
+            WHERE campaign_id = $1 AND status = 'pending'
+        `, campaignID, recipientStatus)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseCampaignMedia retorna as campanhas de mídia concluídas ou
+// interrompidas cujo arquivo compartilhado ainda não foi apagado e que não têm
+// mais mensagens na fila nem em envio, marcando a mídia como removida.
+func (db *DB) ReleaseCampaignMedia() ([]Campaign, error) {
+	var campaigns []Campaign
+	err := db.Select(&campaigns, `
+        UPDATE campaigns c SET media_removed_at = CURRENT_TIMESTAMP
+        WHERE c.kind = $1 AND c.status IN ($2, $3) AND c.media_removed_at IS NULL
+          AND NOT EXISTS (
+              SELECT 1 FROM campaign_recipients r
+              JOIN outbound_messages o ON o.id = r.outbound_message_id
+              WHERE r.campaign_id = c.id AND o.status IN ('queued', 'sending')
+          )
+        RETURNING `+campaignColumns, OutboundKindMedia, CampaignCompleted, CampaignStopped)
+	return campaigns, err
+}
+
+// FeedRunningCampaigns coloca na fila de envio os próximos destinatários das
+// campanhas em andamento, mantendo no máximo window mensagens de cada campanha
+// aguardando na fila; o ritmo de envio fica a cargo da fila. Campanhas sem
+// destinatários pendentes nem mensagens na fila são concluídas. Retorna as
+// campanhas concluídas nesta passada.
+func (db *DB) FeedRunningCampaigns(window int) ([]Campaign, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var campaigns []Campaign
+	err = tx.Select(&campaigns, "SELECT "+campaignColumns+" FROM campaigns WHERE status = $1 ORDER BY id FOR UPDATE SKIP LOCKED", CampaignRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	var completed []Campaign
+	for _, campaign := range campaigns {
+		var inFlight int
+		err = tx.Get(&inFlight, `
+            SELECT COUNT(*) FROM campaign_recipients r
+            JOIN outbound_messages o ON o.id = r.outbound_message_id
+            WHERE r.campaign_id = $1 AND o.status IN ('queued', 'sending')
+        `, campaign.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var pending []CampaignRecipient
+		if inFlight < window {
+			err = tx.Select(&pending, `
+                SELECT id, campaign_id, recipient, content, status, error_message, outbound_message_id, updated_at
+                FROM campaign_recipients
+                WHERE campaign_id = $1 AND status = 'pending'
+                ORDER BY id
+                LIMIT $2
+                FOR UPDATE SKIP LOCKED
+            `, campaign.ID, window-inFlight)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if inFlight == 0 && len(pending) == 0 {
+			_, err = tx.Exec(`
+                UPDATE campaigns SET status = $2, completed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+                WHERE id = $1
+            `, campaign.ID, CampaignCompleted)
+			if err != nil {
+				return nil, err
+			}
+			campaign.Status = CampaignCompleted
+			completed = append(completed, campaign)
+			continue
+		}
+
+		var base OutboundPayload
+		if err := json.Unmarshal([]byte(campaign.Payload), &base); err != nil {
+			return nil, fmt.Errorf("payload inválido na campanha %d: %w", campaign.ID, err)
+		}
+
+		for _, recipient := range pending {
+			payload := base
+			if campaign.Kind == OutboundKindMedia {
+				payload.Caption = recipient.Content
+			} else {
+				payload.Text = recipient.Content
+			}
+			encoded, err := json.Marshal(payload)
+			if err != nil {
+				return nil, err
+			}
+
+			var outboundID int64
+			err = tx.QueryRow(`
+                INSERT INTO outbound_messages (device_id, recipient, kind, payload, status, priority, next_attempt_at)
+                VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+                RETURNING id
+            `, campaign.DeviceID, recipient.Recipient, campaign.Kind, string(encoded), OutboundQueued, OutboundPriorityBulk).Scan(&outboundID)
+			if err != nil {
+				return nil, fmt.Errorf("erro ao enfileirar destinatário %d da campanha %d: %w", recipient.ID, campaign.ID, err)
+			}
+
+			_, err = tx.Exec(`
+                UPDATE campaign_recipients
+                SET status = $2, outbound_message_id = $3, updated_at = CURRENT_TIMESTAMP
+                WHERE id = $1
+            `, recipient.ID, CampaignRecipientQueued, outboundID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return completed, nil
+}
+
+const messageTemplateColumns = `id, tenant_id, name, locale, content, media_path, mimetype, created_at, updated_at`
+
+// CreateMessageTemplate grava um template novo
+func (db *DB) CreateMessageTemplate(template *MessageTemplate) error {
+	return db.QueryRow(`
+        INSERT INTO message_templates (tenant_id, name, locale, content, media_path, mimetype)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at, updated_at
+    `,
+		template.TenantID,
+		template.Name,
+		template.Locale,
+		template.Content,
+		template.MediaPath,
+		template.Mimetype,
+	).Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+}
+
+// UpdateMessageTemplate atualiza um template existente
+func (db *DB) UpdateMessageTemplate(template *MessageTemplate) error {
+	return db.QueryRow(`
+        UPDATE message_templates SET
+            name = $2,
+            locale = $3,
+            content = $4,
+            media_path = $5,
+            mimetype = $6,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING updated_at
+    `,
+		template.ID,
+		template.Name,
+		template.Locale,
+		template.Content,
+		template.MediaPath,
+		template.Mimetype,
+	).Scan(&template.UpdatedAt)
+}
+
+// DeleteMessageTemplate remove um template
+func (db *DB) DeleteMessageTemplate(id int64) error {
+	_, err := db.Exec("DELETE FROM message_templates WHERE id = $1", id)
+	return err
+}
+
+// GetMessageTemplate retorna um template pelo ID, ou nil se não existir
+func (db *DB) GetMessageTemplate(id int64) (*MessageTemplate, error) {
+	var template MessageTemplate
+	err := db.Get(&template, "SELECT "+messageTemplateColumns+" FROM message_templates WHERE id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+// GetMessageTemplates lista os templates de um tenant. name vazio não filtra.
+func (db *DB) GetMessageTemplates(tenantID int64, name string) ([]MessageTemplate, error) {
+	templates := []MessageTemplate{}
+
+	args := []interface{}{tenantID}
+	query := "SELECT " + messageTemplateColumns + " FROM message_templates WHERE tenant_id = $1"
+	if name != "" {
+		args = append(args, name)
+		query += " AND name = $2"
+	}
+	query += " ORDER BY name, locale"
+
+	err := db.Select(&templates, query, args...)
+	return templates, err
+}
+
+// FindMessageTemplate escolhe a variante de um template para o locale pedido:
+// a exata, depois a do idioma base ("pt-BR" usa "pt") e por fim a padrão
+// (locale vazio). Retorna nil se nenhuma existir.
+func (db *DB) FindMessageTemplate(tenantID int64, name string, locale string) (*MessageTemplate, error) {
+	language := locale
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		language = locale[:i]
+	}
+
+	var template MessageTemplate
+	err := db.Get(&template, `
+        SELECT `+messageTemplateColumns+` FROM message_templates
+        WHERE tenant_id = $1 AND name = $2 AND (LOWER(locale) IN (LOWER($3), LOWER($4)) OR locale = '')
+        ORDER BY CASE
+            WHEN LOWER(locale) = LOWER($3) THEN 0
+            WHEN LOWER(locale) = LOWER($4) THEN 1
+            ELSE 2
+        END
+        LIMIT 1
+    `, tenantID, name, locale, language)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+const mediaFileColumns = `id, tenant_id, file_name, mimetype, size, sha256, path, source_url, created_at`
+
+// CreateMediaFile grava uma mídia na biblioteca do tenant
+func (db *DB) CreateMediaFile(media *MediaFile) error {
+	return db.QueryRow(`
+        INSERT INTO media_files (tenant_id, file_name, mimetype, size, sha256, path, source_url)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at
+    `,
+		media.TenantID,
+		media.FileName,
+		media.Mimetype,
+		media.Size,
+		media.SHA256,
+		media.Path,
+		media.SourceURL,
+	).Scan(&media.ID, &media.CreatedAt)
+}
+
+// GetMediaFile busca uma mídia da biblioteca pelo ID. Retorna nil se não existir.
+func (db *DB) GetMediaFile(id int64) (*MediaFile, error) {
+	var media MediaFile
+	err := db.Get(&media, "SELECT "+mediaFileColumns+" FROM media_files WHERE id = $1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+// GetMediaFileBySHA256 busca uma mídia do tenant pelo hash do conteúdo. Retorna nil se não existir.
+func (db *DB) GetMediaFileBySHA256(tenantID int64, sha string) (*MediaFile, error) {
+	var media MediaFile
+	err := db.Get(&media, "SELECT "+mediaFileColumns+" FROM media_files WHERE tenant_id = $1 AND sha256 = $2", tenantID, sha)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &media, nil
+}
+
+// GetMediaFiles lista a biblioteca de mídias de um tenant, das mais recentes para as mais antigas
+func (db *DB) GetMediaFiles(tenantID int64) ([]MediaFile, error) {
+	files := []MediaFile{}
+	err := db.Select(&files, "SELECT "+mediaFileColumns+" FROM media_files WHERE tenant_id = $1 ORDER BY id DESC", tenantID)
+	return files, err
+}
+
+// DeleteMediaFile remove uma mídia da biblioteca e os uploads guardados dela
+func (db *DB) DeleteMediaFile(id int64) error {
+	_, err := db.Exec("DELETE FROM media_files WHERE id = $1", id)
+	return err
+}
+
+// GetMediaUpload retorna o upload de uma mídia feito pelo dispositivo depois de
+// since. Retorna nil se não houver upload recente.
+func (db *DB) GetMediaUpload(mediaID int64, deviceID int64, since time.Time) (*MediaUpload, error) {
+	var upload MediaUpload
+	err := db.Get(&upload, `
+        SELECT media_id, device_id, url, direct_path, media_key, file_sha256, file_enc_sha256, file_length, uploaded_at
+        FROM media_uploads
+        WHERE media_id = $1 AND device_id = $2 AND uploaded_at > $3
+    `, mediaID, deviceID, since)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// SaveMediaUpload grava (ou substitui) o upload de uma mídia pelo dispositivo
+func (db *DB) SaveMediaUpload(upload *MediaUpload) error {
+	_, err := db.Exec(`
+        INSERT INTO media_uploads (media_id, device_id, url, direct_path, media_key, file_sha256, file_enc_sha256, file_length, uploaded_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+        ON CONFLICT (media_id, device_id) DO UPDATE SET
+            url = EXCLUDED.url,
+            direct_path = EXCLUDED.direct_path,
+            media_key = EXCLUDED.media_key,
+            file_sha256 = EXCLUDED.file_sha256,
+            file_enc_sha256 = EXCLUDED.file_enc_sha256,
+            file_length = EXCLUDED.file_length,
+            uploaded_at = CURRENT_TIMESTAMP
+    `,
+		upload.MediaID,
+		upload.DeviceID,
+		upload.URL,
+		upload.DirectPath,
+		upload.MediaKey,
+		upload.FileSHA256,
+		upload.FileEncSHA256,
+		upload.FileLength,
+	)
+	return err
+}
+
+// Colunas de whatsapp_groups na ordem dos campos de WhatsAppGroup
+const groupColumns = `device_id, jid, name, topic, owner, is_announce, is_locked, group_created_at, left_at, synced_at, updated_at`
+
+// SaveGroupSnapshot grava a cópia completa de um grupo obtida do WhatsApp,
+// substituindo os participantes. Um grupo marcado como deixado volta a ser ativo.
+func (db *DB) SaveGroupSnapshot(group *WhatsAppGroup) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO whatsapp_groups (device_id, jid, name, topic, owner, is_announce, is_locked, group_created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (device_id, jid) DO UPDATE SET
+            name = EXCLUDED.name,
+            topic = EXCLUDED.topic,
+            owner = EXCLUDED.owner,
+            is_announce = EXCLUDED.is_announce,
+            is_locked = EXCLUDED.is_locked,
+            group_created_at = EXCLUDED.group_created_at,
+            left_at = NULL,
+            synced_at = CURRENT_TIMESTAMP,
+            updated_at = CURRENT_TIMESTAMP
+    `, group.DeviceID, group.JID, group.Name, group.Topic, group.Owner, group.IsAnnounce, group.IsLocked, group.GroupCreatedAt)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar grupo %s: %w", group.JID, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM group_participants WHERE device_id = $1 AND group_jid = $2`, group.DeviceID, group.JID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+        INSERT INTO group_participants (device_id, group_jid, jid, is_admin, is_super_admin)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (device_id, group_jid, jid) DO NOTHING
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, participant := range group.Participants {
+		_, err = stmt.Exec(group.DeviceID, group.JID, participant.JID, participant.IsAdmin, participant.IsSuperAdmin)
+		if err != nil {
+			return fmt.Errorf("erro ao gravar participante %s: %w", participant.JID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateGroup grava nome, descrição e modos de um grupo alterados por um evento
+func (db *DB) UpdateGroup(group *WhatsAppGroup) error {
+	_, err := db.Exec(`
+        UPDATE whatsapp_groups SET
+            name = $3,
+            topic = $4,
+            is_announce = $5,
+            is_locked = $6,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE device_id = $1 AND jid = $2
+    `, group.DeviceID, group.JID, group.Name, group.Topic, group.IsAnnounce, group.IsLocked)
+	return err
+}
+
+// MarkGroupLeft registra que o dispositivo saiu ou foi removido do grupo
+func (db *DB) MarkGroupLeft(deviceID int64, jid string) error {
+	_, err := db.Exec(`
+        UPDATE whatsapp_groups SET left_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE device_id = $1 AND jid = $2 AND left_at IS NULL
+    `, deviceID, jid)
+	return err
+}
+
+// MarkOtherGroupsLeft marca como deixados os grupos ativos do dispositivo que
+// não estão na lista atual de grupos obtida do WhatsApp
+func (db *DB) MarkOtherGroupsLeft(deviceID int64, currentJIDs []string) error {
+	_, err := db.Exec(`
+        UPDATE whatsapp_groups SET left_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+        WHERE device_id = $1 AND left_at IS NULL AND NOT (jid = ANY($2))
+    `, deviceID, pq.Array(currentJIDs))
+	return err
+}
+
+// SaveGroupsSynced registra que a lista de grupos do dispositivo acabou de ser
+// sincronizada com o WhatsApp
+func (db *DB) SaveGroupsSynced(deviceID int64) error {
+	_, err := db.Exec(`
+        INSERT INTO group_syncs (device_id, synced_at) VALUES ($1, CURRENT_TIMESTAMP)
+        ON CONFLICT (device_id) DO UPDATE SET synced_at = EXCLUDED.synced_at
+    `, deviceID)
+	return err
+}
+
+// GetGroupsSyncedAt retorna quando a lista de grupos do dispositivo foi
+// sincronizada pela última vez, ou nil se nunca foi
+func (db *DB) GetGroupsSyncedAt(deviceID int64) (*time.Time, error) {
+	var syncedAt time.Time
+	err := db.Get(&syncedAt, "SELECT synced_at FROM group_syncs WHERE device_id = $1", deviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &syncedAt, nil
+}
+
+// AddGroupParticipants inclui participantes na cópia local do grupo
+func (db *DB) AddGroupParticipants(deviceID int64, groupJID string, jids []string) error {
+	_, err := db.Exec(`
+        INSERT INTO group_participants (device_id, group_jid, jid)
+        SELECT $1, $2, UNNEST($3::text[])
+        ON CONFLICT (device_id, group_jid, jid) DO NOTHING
+    `, deviceID, groupJID, pq.Array(jids))
+	return err
+}
+
+// RemoveGroupParticipants retira participantes da cópia local do grupo
+func (db *DB) RemoveGroupParticipants(deviceID int64, groupJID string, jids []string) error {
+	_, err := db.Exec(`
+        DELETE FROM group_participants
+        WHERE device_id = $1 AND group_jid = $2 AND jid = ANY($3)
+    `, deviceID, groupJID, pq.Array(jids))
+	return err
+}
+
+// SetGroupParticipantsAdmin promove ou rebaixa participantes na cópia local do grupo
+func (db *DB) SetGroupParticipantsAdmin(deviceID int64, groupJID string, jids []string, isAdmin bool) error {
+	_, err := db.Exec(`
+        UPDATE group_participants SET is_admin = $4
+        WHERE device_id = $1 AND group_jid = $2 AND jid = ANY($3)
+    `, deviceID, groupJID, pq.Array(jids), isAdmin)
+	return err
+}
+
+// GetGroup retorna a cópia local de um grupo com os participantes, ou nil se não existir
+func (db *DB) GetGroup(deviceID int64, jid string) (*WhatsAppGroup, error) {
+	var group WhatsAppGroup
+	err := db.Get(&group, `SELECT `+groupColumns+` FROM whatsapp_groups WHERE device_id = $1 AND jid = $2`, deviceID, jid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	groups := []WhatsAppGroup{group}
+	if err := db.attachGroupParticipants(deviceID, groups); err != nil {
+		return nil, err
+	}
+	return &groups[0], nil
+}
+
+// GetGroups lista a cópia local dos grupos do dispositivo, por nome. Grupos
+// deixados só são incluídos com includeLeft.
+func (db *DB) GetGroups(deviceID int64, includeLeft bool) ([]WhatsAppGroup, error) {
+	groups := []WhatsAppGroup{}
+
+	query := `SELECT ` + groupColumns + ` FROM whatsapp_groups WHERE device_id = $1`
+	if !includeLeft {
+		query += " AND left_at IS NULL"
+	}
+	query += " ORDER BY LOWER(name), jid"
+
+	if err := db.Select(&groups, query, deviceID); err != nil {
+		return nil, err
+	}
+
+	if err := db.attachGroupParticipants(deviceID, groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// attachGroupParticipants preenche os participantes dos grupos informados
+func (db *DB) attachGroupParticipants(deviceID int64, groups []WhatsAppGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	groupJIDs := make([]string, len(groups))
+	for i, group := range groups {
+		groupJIDs[i] = group.JID
+	}
+
+	var participants []GroupParticipant
+	err := db.Select(&participants, `
+        SELECT device_id, group_jid, jid, is_admin, is_super_admin
+        FROM group_participants
+        WHERE device_id = $1 AND group_jid = ANY($2)
+        ORDER BY is_super_admin DESC, is_admin DESC, jid
+    `, deviceID, pq.Array(groupJIDs))
+	if err != nil {
+		return fmt.Errorf("erro ao buscar participantes: %w", err)
+	}
+
+	byGroup := make(map[string][]GroupParticipant)
+	for _, participant := range participants {
+		byGroup[participant.GroupJID] = append(byGroup[participant.GroupJID], participant)
+	}
+
+	for i := range groups {
+		groups[i].Participants = byGroup[groups[i].JID]
+		if groups[i].Participants == nil {
+			groups[i].Participants = []GroupParticipant{}
+		}
+	}
+
+	return nil
+}
+
+// Colunas de contacts na ordem dos campos de Contact
+const contactColumns = `device_id, jid, phone, full_name, first_name, push_name, business_name, is_business,
+    on_whatsapp, checked_at, picture_id, picture_path, picture_checked_at, business_profile, created_at, updated_at`
+
+// upsertContact cria o contato, se necessário, e grava as colunas informadas.
+// As demais colunas de um contato existente não são alteradas.
+func upsertContact(execer sqlx.Execer, deviceID int64, jid string, columns []string, values ...interface{}) error {
+	insertColumns := []string{"device_id", "jid", "phone"}
+	args := []interface{}{deviceID, jid, contactPhone(jid)}
+	placeholders := []string{"$1", "$2", "$3"}
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	for i, column := range columns {
+		insertColumns = append(insertColumns, column)
+		args = append(args, values[i])
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		sets = append(sets, column+" = EXCLUDED."+column)
+	}
+
+	_, err := execer.Exec(fmt.Sprintf(`
+        INSERT INTO contacts (%s)
+        VALUES (%s)
+        ON CONFLICT (device_id, jid) DO UPDATE SET %s
+    `, strings.Join(insertColumns, ", "), strings.Join(placeholders, ", "), strings.Join(sets, ", ")), args...)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar contato %s: %w", jid, err)
+	}
+	return nil
+}
+
+// contactPhone extrai o número de um JID de usuário; LIDs e grupos não têm número
+func contactPhone(jid string) string {
+	if phone, ok := strings.CutSuffix(jid, "@s.whatsapp.net"); ok {
+		return phone
+	}
+	return ""
+}
+
+// SaveContactNames grava os nomes do contato na agenda do celular
+func (db *DB) SaveContactNames(deviceID int64, jid string, fullName string, firstName string) error {
+	return upsertContact(db, deviceID, jid, []string{"full_name", "first_name"}, fullName, firstName)
+}
+
+// SaveContactPushName grava o nome definido pelo próprio contato
+func (db *DB) SaveContactPushName(deviceID int64, jid string, pushName string) error {
+	return upsertContact(db, deviceID, jid, []string{"push_name"}, pushName)
+}
+
+// SaveContactBusinessName grava o nome verificado de uma conta comercial
+func (db *DB) SaveContactBusinessName(deviceID int64, jid string, businessName string) error {
+	return upsertContact(db, deviceID, jid, []string{"business_name", "is_business"}, businessName, businessName != "")
+}
+
+// SaveContactCheck grava o resultado da verificação de um número no WhatsApp
+func (db *DB) SaveContactCheck(deviceID int64, jid string, onWhatsApp bool, businessName string) error {
+	return upsertContact(db, deviceID, jid,
+		[]string{"on_whatsapp", "checked_at", "business_name", "is_business"},
+		onWhatsApp, time.Now(), businessName, businessName != "")
+}
+
+// SaveContactPicture grava a foto de perfil baixada. IDs e caminho vazios
+// indicam que o contato não tem foto ou a esconde.
+func (db *DB) SaveContactPicture(deviceID int64, jid string, pictureID string, picturePath string) error {
+	return upsertContact(db, deviceID, jid,
+		[]string{"picture_id", "picture_path", "picture_checked_at"},
+		pictureID, picturePath, time.Now())
+}
+
+// ExpireContactPicture faz a próxima consulta da foto de perfil buscar a foto
+// no WhatsApp, após o contato trocar ou remover a foto
+func (db *DB) ExpireContactPicture(deviceID int64, jid string) error {
+	return upsertContact(db, deviceID, jid, []string{"picture_checked_at"}, nil)
+}
+
+// SaveContactBusinessProfile grava o perfil de uma conta comercial
+func (db *DB) SaveContactBusinessProfile(deviceID int64, jid string, profile *BusinessProfile) error {
+	return upsertContact(db, deviceID, jid, []string{"business_profile", "is_business"}, profile, true)
+}
+
+// ImportContacts grava em lote os nomes conhecidos pelo WhatsApp. Nomes vazios
+// não apagam os já gravados, pois a origem pode não ter todos os nomes.
+func (db *DB) ImportContacts(deviceID int64, contacts []Contact) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, contact := range contacts {
+		columns, values := []string{}, []interface{}{}
+		names := []struct{ column, value string }{
+			{"full_name", contact.FullName},
+			{"first_name", contact.FirstName},
+			{"push_name", contact.PushName},
+			{"business_name", contact.BusinessName},
+		}
+		for _, name := range names {
+			if name.value != "" {
+				columns, values = append(columns, name.column), append(values, name.value)
+			}
+		}
+		if contact.BusinessName != "" {
+			columns, values = append(columns, "is_business"), append(values, true)
+		}
+
+		if err := upsertContact(tx, deviceID, contact.JID, columns, values...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetContact retorna um contato do diretório, ou nil se não existir
+func (db *DB) GetContact(deviceID int64, jid string) (*Contact, error) {
+	var contact Contact
+	err := db.Get(&contact, `SELECT `+contactColumns+` FROM contacts WHERE device_id = $1 AND jid = $2`, deviceID, jid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// SearchContacts lista os contatos do dispositivo em ordem de nome. A busca,
+// se informada, procura nos nomes e, se tiver dígitos, no número.
+func (db *DB) SearchContacts(deviceID int64, search string, limit int, offset int) ([]Contact, error) {
+	contacts := []Contact{}
+
+	query := `SELECT ` + contactColumns + ` FROM contacts WHERE device_id = $1`
+	args := []interface{}{deviceID}
+
+	if search = strings.TrimSpace(search); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+		args = append(args, "%"+escaped+"%")
+		conditions := fmt.Sprintf(
+			"full_name ILIKE $%[1]d OR first_name ILIKE $%[1]d OR push_name ILIKE $%[1]d OR business_name ILIKE $%[1]d",
+			len(args))
+
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, search)
+		if digits != "" {
+			args = append(args, "%"+digits+"%")
+			conditions += fmt.Sprintf(" OR phone LIKE $%d", len(args))
+		}
+
+		query += " AND (" + conditions + ")"
+	}
+
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(`
+        ORDER BY LOWER(COALESCE(NULLIF(full_name, ''), NULLIF(push_name, ''), NULLIF(business_name, ''), phone)), jid
+        LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	if err := db.Select(&contacts, query, args...); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// SaveLIDMapping grava o número de um LID. Se o mapeamento é novo ou mudou de
+// número, os registros gravados com o LID passam para o número na mesma
+// transação, para que uma falha não deixe o mapeamento gravado sem os
+// registros atualizados. Retorna a quantidade de registros alterados.
+func (db *DB) SaveLIDMapping(lid string, phoneJID string, source string) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        INSERT INTO lid_mappings (lid, phone_jid, source)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (lid) DO UPDATE SET
+            phone_jid = EXCLUDED.phone_jid,
+            source = EXCLUDED.source,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE lid_mappings.phone_jid <> EXCLUDED.phone_jid
+    `, lid, phoneJID, source)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao gravar mapeamento do LID %s: %w", lid, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return 0, err
+	}
+
+	rekeyed, err := rekeyLIDRecords(tx, lid, phoneJID)
+	if err != nil {
+		return 0, err
+	}
+	return rekeyed, tx.Commit()
+}
+
+// GetLIDMapping retorna o mapeamento de um LID, ou nil se não for conhecido
+func (db *DB) GetLIDMapping(lid string) (*LIDMapping, error) {
+	var mapping LIDMapping
+	err := db.Get(&mapping, `SELECT lid, phone_jid, source, created_at, updated_at FROM lid_mappings WHERE lid = $1`, lid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+// GetLIDMappingByPhone retorna o mapeamento mais recente de um número, ou nil
+func (db *DB) GetLIDMappingByPhone(phoneJID string) (*LIDMapping, error) {
+	var mapping LIDMapping
+	err := db.Get(&mapping, `
+        SELECT lid, phone_jid, source, created_at, updated_at
+        FROM lid_mappings
+        WHERE phone_jid = $1
+        ORDER BY updated_at DESC
+        LIMIT 1
+    `, phoneJID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+// GetUnmappedLIDs lista os LIDs sem número conhecido que aparecem nas
+// mensagens, nas entidades rastreadas e nos contatos
+func (db *DB) GetUnmappedLIDs() ([]string, error) {
+	lids := []string{}
+	err := db.Select(&lids, `
+        SELECT DISTINCT jid FROM (
+            SELECT jid FROM whatsapp_messages WHERE jid LIKE '%@lid'
+            UNION SELECT sender FROM whatsapp_messages WHERE sender LIKE '%@lid'
+            UNION SELECT jid FROM tracked_entities WHERE jid LIKE '%@lid'
+            UNION SELECT jid FROM contacts WHERE jid LIKE '%@lid'
+        ) AS found
+        WHERE NOT EXISTS (SELECT 1 FROM lid_mappings m WHERE m.lid = found.jid)
+    `)
+	return lids, err
+}
+
+// rekeyLIDRecords passa para o número os registros gravados com o LID:
+// mensagens, reações, entidades rastreadas, cursores de leitura, chats,
+// contatos, enquetes, votos e participantes de grupos. Quando o número já tem
+// o registro correspondente, os dois são mesclados: prevalece a atividade, o
+// cursor e o voto mais recentes, as marcações (rastreado, arquivado, fixado,
+// silenciado, admin) de qualquer um dos dois e os dados de contato que faltam
+// no número. Filas, agendamentos e campanhas mantêm o destinatário como foi
+// informado. Retorna a quantidade de registros alterados.
+func rekeyLIDRecords(tx *sqlx.Tx, lid string, phoneJID string) (int64, error) {
+	queries := []string{
+		`UPDATE whatsapp_messages SET jid = $2 WHERE jid = $1`,
+		`UPDATE whatsapp_messages SET sender = $2 WHERE sender = $1`,
+		`UPDATE message_reactions SET chat = $2 WHERE chat = $1`,
+		`UPDATE message_reactions r SET sender = $2
+         WHERE sender = $1 AND NOT EXISTS (
+             SELECT 1 FROM message_reactions o
+             WHERE o.device_id = r.device_id AND o.message_id = r.message_id AND o.sender = $2
+         )`,
+		`DELETE FROM message_reactions r
+         WHERE sender = $1 AND EXISTS (
+             SELECT 1 FROM message_reactions o
+             WHERE o.device_id = r.device_id AND o.message_id = r.message_id AND o.sender = $2
+         )`,
+
+		// Entidades rastreadas: lista vazia de tipos de mídia libera todos
+		`UPDATE tracked_entities p SET
+             is_tracked = p.is_tracked OR l.is_tracked,
+             track_media = p.track_media OR l.track_media,
+             allowed_media_types = CASE
+                 WHEN COALESCE(cardinality(p.allowed_media_types), 0) = 0
+                   OR COALESCE(cardinality(l.allowed_media_types), 0) = 0 THEN '{}'
+                 ELSE ARRAY(SELECT DISTINCT UNNEST(p.allowed_media_types || l.allowed_media_types))
+             END,
+             updated_at = CURRENT_TIMESTAMP
+         FROM tracked_entities l
+         WHERE l.device_id = p.device_id AND l.jid = $1 AND p.jid = $2`,
+		`UPDATE tracked_entities t SET jid = $2, updated_at = CURRENT_TIMESTAMP
+         WHERE jid = $1 AND NOT EXISTS (
+             SELECT 1 FROM tracked_entities o WHERE o.device_id = t.device_id AND o.jid = $2
+         )`,
+		`DELETE FROM tracked_entities t
+         WHERE jid = $1 AND EXISTS (
+             SELECT 1 FROM tracked_entities o WHERE o.device_id = t.device_id AND o.jid = $2
+         )`,
+
+		`UPDATE chat_read_cursors p SET
+             last_read_message_id = l.last_read_message_id,
+             last_read_at = l.last_read_at,
+             updated_at = CURRENT_TIMESTAMP
+         FROM chat_read_cursors l
+         WHERE l.device_id = p.device_id AND l.jid = $1 AND p.jid = $2 AND l.last_read_at > p.last_read_at`,
+		`UPDATE chat_read_cursors c SET jid = $2
+         WHERE jid = $1 AND NOT EXISTS (
+             SELECT 1 FROM chat_read_cursors o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+		`DELETE FROM chat_read_cursors c
+         WHERE jid = $1 AND EXISTS (
+             SELECT 1 FROM chat_read_cursors o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+
+		// Chats: a última mensagem vem do mais recente; silenciado sem prazo prevalece
+		`UPDATE chats p SET
+             last_message_id = l.last_message_id,
+             last_message_preview = l.last_message_preview,
+             last_message_from_me = l.last_message_from_me,
+             last_activity_at = l.last_activity_at
+         FROM chats l
+         WHERE l.device_id = p.device_id AND l.jid = $1 AND p.jid = $2
+           AND l.last_activity_at > COALESCE(p.last_activity_at, '-infinity')`,
+		`UPDATE chats p SET
+             archived = p.archived OR l.archived,
+             pinned = p.pinned OR l.pinned,
+             muted = p.muted OR l.muted,
+             muted_until = CASE
+                 WHEN (p.muted AND p.muted_until IS NULL) OR (l.muted AND l.muted_until IS NULL) THEN NULL
+                 ELSE GREATEST(CASE WHEN p.muted THEN p.muted_until END, CASE WHEN l.muted THEN l.muted_until END)
+             END,
+             updated_at = CURRENT_TIMESTAMP
+         FROM chats l
+         WHERE l.device_id = p.device_id AND l.jid = $1 AND p.jid = $2`,
+		`UPDATE chats c SET jid = $2, updated_at = CURRENT_TIMESTAMP
+         WHERE jid = $1 AND NOT EXISTS (
+             SELECT 1 FROM chats o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+		`DELETE FROM chats c
+         WHERE jid = $1 AND EXISTS (
+             SELECT 1 FROM chats o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+
+		// Contatos: dados do número prevalecem, os que faltam vêm do LID
+		`UPDATE contacts p SET
+             full_name = COALESCE(NULLIF(p.full_name, ''), l.full_name),
+             first_name = COALESCE(NULLIF(p.first_name, ''), l.first_name),
+             push_name = COALESCE(NULLIF(p.push_name, ''), l.push_name),
+             business_name = COALESCE(NULLIF(p.business_name, ''), l.business_name),
+             is_business = p.is_business OR l.is_business,
+             business_profile = COALESCE(p.business_profile, l.business_profile),
+             updated_at = CURRENT_TIMESTAMP
+         FROM contacts l
+         WHERE l.device_id = p.device_id AND l.jid = $1 AND p.jid = $2`,
+		`UPDATE contacts c SET jid = $2, phone = split_part($2, '@', 1), updated_at = CURRENT_TIMESTAMP
+         WHERE jid = $1 AND NOT EXISTS (
+             SELECT 1 FROM contacts o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+		`DELETE FROM contacts c
+         WHERE jid = $1 AND EXISTS (
+             SELECT 1 FROM contacts o WHERE o.device_id = c.device_id AND o.jid = $2
+         )`,
+
+		`UPDATE polls SET chat = $2 WHERE chat = $1`,
+		`UPDATE polls SET creator = $2 WHERE creator = $1`,
+		`UPDATE poll_votes p SET options = l.options, voted_at = l.voted_at
+         FROM poll_votes l
+         WHERE l.poll_id = p.poll_id AND l.voter = $1 AND p.voter = $2 AND l.voted_at > p.voted_at`,
+		`UPDATE poll_votes v SET voter = $2
+         WHERE voter = $1 AND NOT EXISTS (
+             SELECT 1 FROM poll_votes o WHERE o.poll_id = v.poll_id AND o.voter = $2
+         )`,
+		`DELETE FROM poll_votes v
+         WHERE voter = $1 AND EXISTS (
+             SELECT 1 FROM poll_votes o WHERE o.poll_id = v.poll_id AND o.voter = $2
+         )`,
+
+		`UPDATE whatsapp_groups SET owner = $2 WHERE owner = $1`,
+		`UPDATE group_participants p SET
+             is_admin = p.is_admin OR l.is_admin,
+             is_super_admin = p.is_super_admin OR l.is_super_admin
+         FROM group_participants l
+         WHERE l.device_id = p.device_id AND l.group_jid = p.group_jid AND l.jid = $1 AND p.jid = $2`,
+		`UPDATE group_participants g SET jid = $2
+         WHERE jid = $1 AND NOT EXISTS (
+             SELECT 1 FROM group_participants o
+             WHERE o.device_id = g.device_id AND o.group_jid = g.group_jid AND o.jid = $2
+         )`,
+		`DELETE FROM group_participants g
+         WHERE jid = $1 AND EXISTS (
+             SELECT 1 FROM group_participants o
+             WHERE o.device_id = g.device_id AND o.group_jid = g.group_jid AND o.jid = $2
+         )`,
+	}
+
+	var total int64
+	for _, query := range queries {
+		result, err := tx.Exec(query, lid, phoneJID)
+		if err != nil {
+			return 0, fmt.Errorf("erro ao atualizar registros do LID %s: %w", lid, err)
+		}
+		rows, _ := result.RowsAffected()
+		total += rows
+	}
+
+	return total, nil
+}
+
+// unreadMessageCondition seleciona em whatsapp_messages as mensagens recebidas
+// posteriores ao cursor de leitura do chat
+const unreadMessageCondition = `is_from_me = FALSE AND revoked_at IS NULL AND NOT EXISTS (
+            SELECT 1 FROM chat_read_cursors r
+            WHERE r.device_id = whatsapp_messages.device_id AND r.jid = whatsapp_messages.jid
+              AND r.last_read_at >= whatsapp_messages.timestamp)`
+
+// chatReadCursorUpsert grava o cursor de leitura sem nunca recuá-lo
+const chatReadCursorUpsert = `
+        ON CONFLICT (device_id, jid) DO UPDATE SET
+            last_read_message_id = EXCLUDED.last_read_message_id,
+            last_read_at = EXCLUDED.last_read_at,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE chat_read_cursors.last_read_at < EXCLUDED.last_read_at`
+
+// SaveChatReadCursor avança o cursor de leitura do chat até a mensagem
+// informada. Um cursor já mais adiantado é mantido.
+func (db *DB) SaveChatReadCursor(deviceID int64, jid string, messageID string, readAt time.Time) error {
+	_, err := db.Exec(`
+        INSERT INTO chat_read_cursors (device_id, jid, last_read_message_id, last_read_at)
+        VALUES ($1, $2, $3, $4)`+chatReadCursorUpsert, deviceID, jid, messageID, readAt)
+	return err
+}
+
+// MarkMessagesRead avança o cursor do chat até a mais recente das mensagens
+// armazenadas informadas. Usado nas leituras feitas pelo próprio número em
+// outro aparelho, que informam apenas os IDs das mensagens.
+func (db *DB) MarkMessagesRead(deviceID int64, messageIDs []string) error {
+	_, err := db.Exec(`
+        INSERT INTO chat_read_cursors (device_id, jid, last_read_message_id, last_read_at)
+        SELECT device_id, jid, message_id, timestamp FROM whatsapp_messages
+        WHERE device_id = $1 AND message_id = ANY($2)
+        ORDER BY timestamp DESC, id DESC
+        LIMIT 1`+chatReadCursorUpsert, deviceID, pq.Array(messageIDs))
+	return err
+}
+
+// GetChatReadCursor retorna o cursor de leitura do chat, ou nil se o chat
+// nunca foi lido
+func (db *DB) GetChatReadCursor(deviceID int64, jid string) (*ChatReadCursor, error) {
+	var cursor ChatReadCursor
+	err := db.Get(&cursor, `
+        SELECT device_id, jid, last_read_message_id, last_read_at, updated_at
+        FROM chat_read_cursors
+        WHERE device_id = $1 AND jid = $2
+    `, deviceID, jid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// GetUnreadMessages lista, da mais antiga para a mais nova, as mensagens não
+// lidas do chat até o horário informado (nil = todas)
+func (db *DB) GetUnreadMessages(deviceID int64, jid string, until *time.Time) ([]WhatsAppMessage, error) {
+	messages := []WhatsAppMessage{}
+
+	query := `SELECT * FROM whatsapp_messages WHERE device_id = $1 AND jid = $2 AND ` + unreadMessageCondition
+	args := []interface{}{deviceID, jid}
+	if until != nil {
+		args = append(args, *until)
+		query += " AND timestamp <= $3"
+	}
+
+	if err := db.Select(&messages, query+" ORDER BY timestamp, id", args...); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// chatColumns seleciona um Chat a partir de chatJoins. O nome vem do grupo ou
+// do contato; muted só vale enquanto o prazo não expirou.
+const chatColumns = `c.device_id, c.jid, c.is_group,
+    COALESCE(NULLIF(g.name, ''), NULLIF(ct.full_name, ''), NULLIF(ct.push_name, ''), NULLIF(ct.business_name, ''), '') AS name,
+    c.last_message_id, c.last_message_preview, c.last_message_from_me, c.last_activity_at,
+    c.archived, c.pinned, c.muted AND (c.muted_until IS NULL OR c.muted_until > NOW()) AS muted, c.muted_until,
+    (SELECT COUNT(*) FROM whatsapp_messages
+     WHERE whatsapp_messages.device_id = c.device_id AND whatsapp_messages.jid = c.jid
+       AND ` + unreadMessageCondition + `) AS unread_count,
+    r.last_read_at, c.created_at, c.updated_at`
+
+const chatJoins = `FROM chats c
+    LEFT JOIN whatsapp_groups g ON g.device_id = c.device_id AND g.jid = c.jid
+    LEFT JOIN contacts ct ON ct.device_id = c.device_id AND ct.jid = c.jid
+    LEFT JOIN chat_read_cursors r ON r.device_id = c.device_id AND r.jid = c.jid`
+
+// upsertChat monta o comando que cria o chat, se necessário, e grava as
+// colunas informadas. As demais colunas de um chat existente não são alteradas.
+func upsertChat(deviceID int64, jid string, columns []string, values ...interface{}) (string, []interface{}) {
+	insertColumns := []string{"device_id", "jid", "is_group"}
+	args := []interface{}{deviceID, jid, strings.HasSuffix(jid, "@g.us")}
+	placeholders := []string{"$1", "$2", "$3"}
+	sets := []string{"updated_at = CURRENT_TIMESTAMP"}
+	for i, column := range columns {
+		insertColumns = append(insertColumns, column)
+		args = append(args, values[i])
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+		sets = append(sets, column+" = EXCLUDED."+column)
+	}
+
+	return fmt.Sprintf(`
+        INSERT INTO chats (%s)
+        VALUES (%s)
+        ON CONFLICT (device_id, jid) DO UPDATE SET %s`,
+		strings.Join(insertColumns, ", "), strings.Join(placeholders, ", "), strings.Join(sets, ", ")), args
+}
+
+// SaveChatActivity registra a última mensagem do chat, criando-o se preciso.
+// Mensagens mais antigas que a última registrada (reenvios, histórico) não
+// alteram o chat.
+func (db *DB) SaveChatActivity(deviceID int64, jid string, messageID string, preview string, fromMe bool, timestamp time.Time) error {
+	query, args := upsertChat(deviceID, jid,
+		[]string{"last_message_id", "last_message_preview", "last_message_from_me", "last_activity_at"},
+		messageID, preview, fromMe, timestamp)
+
+	_, err := db.Exec(query+`
+        WHERE chats.last_activity_at IS NULL OR chats.last_activity_at <= EXCLUDED.last_activity_at`, args...)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar atividade do chat %s: %w", jid, err)
+	}
+	return nil
+}
+
+// UpdateChatPreview atualiza a prévia do chat cuja última mensagem foi editada
+// ou apagada. Prévias vazias (mensagem não armazenada) continuam vazias.
+func (db *DB) UpdateChatPreview(deviceID int64, messageID string, preview string) error {
+	_, err := db.Exec(`
+        UPDATE chats SET last_message_preview = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE device_id = $1 AND last_message_id = $2 AND last_message_preview <> ''
+    `, deviceID, messageID, preview)
+	return err
+}
+
+// SetChatArchived grava se o chat está arquivado
+func (db *DB) SetChatArchived(deviceID int64, jid string, archived bool) error {
+	query, args := upsertChat(deviceID, jid, []string{"archived"}, archived)
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// SetChatPinned grava se o chat está fixado
+func (db *DB) SetChatPinned(deviceID int64, jid string, pinned bool) error {
+	query, args := upsertChat(deviceID, jid, []string{"pinned"}, pinned)
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// SetChatMuted grava se o chat está silenciado e até quando (nil = sem prazo)
+func (db *DB) SetChatMuted(deviceID int64, jid string, muted bool, until *time.Time) error {
+	query, args := upsertChat(deviceID, jid, []string{"muted", "muted_until"}, muted, until)
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// BackfillChats cria os chats que ainda não existem a partir da última
+// mensagem armazenada de cada conversa. Retorna quantos foram criados.
+func (db *DB) BackfillChats() (int64, error) {
+	result, err := db.Exec(`
+        INSERT INTO chats (device_id, jid, is_group, last_message_id, last_message_preview, last_message_from_me, last_activity_at)
+        SELECT DISTINCT ON (m.device_id, m.jid)
+               m.device_id, m.jid, m.is_group, m.message_id,
+               CASE WHEN m.revoked_at IS NOT NULL THEN ''
+                    WHEN COALESCE(m.content, '') = '' AND COALESCE(m.media_type, '') <> '' THEN '[' || m.media_type || ']'
+                    ELSE LEFT(COALESCE(m.content, ''), $1) END,
+               m.is_from_me, m.timestamp
+        FROM whatsapp_messages m
+        WHERE EXISTS (SELECT 1 FROM whatsapp_devices d WHERE d.id = m.device_id)
+          AND NOT EXISTS (SELECT 1 FROM chats c WHERE c.device_id = m.device_id AND c.jid = m.jid)
+        ORDER BY m.device_id, m.jid, m.timestamp DESC, m.id DESC
+        ON CONFLICT (device_id, jid) DO NOTHING
+    `, ChatPreviewMaxLength)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetChat retorna um chat do dispositivo, ou nil se não existir
+func (db *DB) GetChat(deviceID int64, jid string) (*Chat, error) {
+	var chat Chat
+	err := db.Get(&chat, `SELECT `+chatColumns+` `+chatJoins+` WHERE c.device_id = $1 AND c.jid = $2`, deviceID, jid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// GetChats lista os chats do dispositivo conforme os filtros e a ordenação
+func (db *DB) GetChats(q ChatQuery) ([]Chat, error) {
+	chats := []Chat{}
+
+	args := []interface{}{q.DeviceID}
+	conditions := []string{"device_id = $1"}
+	if q.Archived != nil {
+		args = append(args, *q.Archived)
+		conditions = append(conditions, fmt.Sprintf("archived = $%d", len(args)))
+	}
+	if q.UnreadOnly {
+		conditions = append(conditions, "unread_count > 0")
+	}
+
+	var order string
+	switch q.Sort {
+	case ChatSortName:
+		order = "LOWER(COALESCE(NULLIF(name, ''), jid)), jid"
+	case ChatSortUnread:
+		order = "unread_count DESC, last_activity_at DESC NULLS LAST, jid"
+	default:
+		order = "pinned DESC, last_activity_at DESC NULLS LAST, jid"
+	}
+
+	args = append(args, q.Limit, q.Offset)
+	query := fmt.Sprintf(`
+        SELECT * FROM (SELECT `+chatColumns+` `+chatJoins+`) AS chat_list
+        WHERE %s
+        ORDER BY %s
+        LIMIT $%d OFFSET $%d`, strings.Join(conditions, " AND "), order, len(args)-1, len(args))
+
+	if err := db.Select(&chats, query, args...); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+// SavePoll registra uma enquete. Enquetes já registradas são mantidas como estão.
+func (db *DB) SavePoll(poll *Poll) error {
+	_, err := db.Exec(`
+        INSERT INTO polls (device_id, message_id, chat, creator, question, options, selectable_count)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (device_id, message_id) DO NOTHING
+    `, poll.DeviceID, poll.MessageID, poll.Chat, poll.Creator, poll.Question, poll.Options, poll.SelectableCount)
+	return err
+}
+
+// GetPoll retorna uma enquete pelo ID da mensagem, com os votos e a contagem,
+// ou nil se não existir
+func (db *DB) GetPoll(deviceID int64, messageID string) (*Poll, error) {
+	var poll Poll
+	err := db.Get(&poll, `
+        SELECT id, device_id, message_id, chat, creator, question, options, selectable_count, created_at
+        FROM polls
+        WHERE device_id = $1 AND message_id = $2
+    `, deviceID, messageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	polls := []Poll{poll}
+	if err := db.attachPollVotes(polls); err != nil {
+		return nil, err
+	}
+	return &polls[0], nil
+}
+
+// GetPolls lista as enquetes de um dispositivo, mais recentes primeiro,
+// opcionalmente apenas de um chat
+func (db *DB) GetPolls(deviceID int64, chat string, limit int) ([]Poll, error) {
+	polls := []Poll{}
+
+	args := []interface{}{deviceID, limit}
+	query := `
+        SELECT id, device_id, message_id, chat, creator, question, options, selectable_count, created_at
+        FROM polls
+        WHERE device_id = $1`
+	if chat != "" {
+		args = append(args, chat)
+		query += " AND chat = $3"
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT $2"
+
+	if err := db.Select(&polls, query, args...); err != nil {
+		return nil, err
+	}
+
+	if err := db.attachPollVotes(polls); err != nil {
+		return nil, err
+	}
+	return polls, nil
+}
+
+// SavePollVote registra o voto de um participante, substituindo o anterior.
+// Um voto sem opções significa que o participante retirou o voto.
+func (db *DB) SavePollVote(vote *PollVote) error {
+	if len(vote.Options) == 0 {
+		_, err := db.Exec(`
+            DELETE FROM poll_votes
+            WHERE poll_id = $1 AND voter = $2 AND voted_at <= $3
+        `, vote.PollID, vote.Voter, vote.VotedAt)
+		return err
+	}
+
+	// Votos chegam fora de ordem; manter apenas o mais recente
+	_, err := db.Exec(`
+        INSERT INTO poll_votes (poll_id, voter, options, voted_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (poll_id, voter) DO UPDATE SET
+            options = EXCLUDED.options,
+            voted_at = EXCLUDED.voted_at
+        WHERE poll_votes.voted_at <= EXCLUDED.voted_at
+    `, vote.PollID, vote.Voter, vote.Options, vote.VotedAt)
+	return err
+}
+
+// attachPollVotes preenche os votos atuais e a contagem por opção das enquetes informadas
+func (db *DB) attachPollVotes(polls []Poll) error {
+	if len(polls) == 0 {
+		return nil
+	}
+
+	pollIDs := make([]int64, len(polls))
+	for i, poll := range polls {
+		pollIDs[i] = poll.ID
+	}
+
+	var votes []PollVote
+	err := db.Select(&votes, `
+        SELECT poll_id, voter, options, voted_at
+        FROM poll_votes
+        WHERE poll_id = ANY($1)
+        ORDER BY voted_at
+    `, pq.Array(pollIDs))
+	if err != nil {
+		return fmt.Errorf("erro ao buscar votos: %w", err)
+	}
+
+	byPoll := make(map[int64][]PollVote)
+	for _, vote := range votes {
+		byPoll[vote.PollID] = append(byPoll[vote.PollID], vote)
+	}
+
+	for i := range polls {
+		polls[i].Votes = byPoll[polls[i].ID]
+		if polls[i].Votes == nil {
+			polls[i].Votes = []PollVote{}
+		}
+
+		counts := make(map[string]int)
+		for _, vote := range polls[i].Votes {
+			for _, option := range vote.Options {
+				counts[option]++
+			}
+		}
+
+		// Na ordem das opções da enquete, incluindo as que não receberam votos
+		polls[i].Tally = make([]webhook.PollTallyData, len(polls[i].Options))
+		for j, option := range polls[i].Options {
+			polls[i].Tally[j] = webhook.PollTallyData{Option: option, Votes: counts[option]}
+		}
+	}
+
+	return nil
+}
+
 // Método para verificar inconsistências sem corrigir automaticamente
 func (db *DB) CheckDeviceConsistency() ([]map[string]interface{}, error) {
 	rows, err := db.Query(`
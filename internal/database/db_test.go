@@ -0,0 +1,218 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testDB conecta ao Postgres de TEST_DATABASE_URL e cria um dispositivo
+// removido (com seus registros) ao fim do teste
+func testDB(t *testing.T) (*DB, int64) {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL não definido")
+	}
+
+	db, err := New(url, "")
+	if err != nil {
+		t.Fatalf("conectar: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var deviceID int64
+	err = db.QueryRow(`INSERT INTO whatsapp_devices (tenant_id, name) VALUES (0, 'teste') RETURNING id`).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("criar dispositivo: %v", err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM whatsapp_devices WHERE id = $1`, deviceID) })
+
+	return db, deviceID
+}
+
+func TestSaveLIDMappingMergesExistingPhoneRecords(t *testing.T) {
+	db, deviceID := testDB(t)
+
+	suffix := time.Now().UnixNano()
+	lid := fmt.Sprintf("%d@lid", suffix)
+	phone := fmt.Sprintf("55%d@s.whatsapp.net", suffix%1e11)
+
+	older := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	newer := older.Add(30 * time.Minute)
+
+	// Chat do número: mais antigo e arquivado; o do LID: mais recente, fixado e silenciado
+	steps := []error{
+		db.SaveChatActivity(deviceID, phone, "OLD", "antiga", false, older),
+		db.SetChatArchived(deviceID, phone, true),
+		db.SaveChatActivity(deviceID, lid, "NEW", "nova", true, newer),
+		db.SetChatPinned(deviceID, lid, true),
+		db.SetChatMuted(deviceID, lid, true, nil),
+		db.SaveChatReadCursor(deviceID, phone, "OLD", older),
+		db.SaveChatReadCursor(deviceID, lid, "NEW", newer),
+		db.UpsertTrackedEntity(&TrackedEntity{DeviceID: deviceID, JID: phone, TrackMedia: false, AllowedMediaTypes: pq.StringArray{"image"}}),
+		db.UpsertTrackedEntity(&TrackedEntity{DeviceID: deviceID, JID: lid, IsTracked: true, TrackMedia: true, AllowedMediaTypes: pq.StringArray{"video"}}),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("preparar passo %d: %v", i, err)
+		}
+	}
+
+	_, err := db.Exec(`INSERT INTO contacts (device_id, jid, phone, full_name) VALUES ($1, $2, '', '')`, deviceID, phone)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO contacts (device_id, jid, push_name) VALUES ($1, $2, 'Ana')`, deviceID, lid)
+	}
+	if err != nil {
+		t.Fatalf("criar contatos: %v", err)
+	}
+
+	var pollID int64
+	err = db.QueryRow(`
+        INSERT INTO polls (device_id, message_id, chat, creator, question, options)
+        VALUES ($1, $2, $3, $3, 'Quando?', '{a,b}') RETURNING id
+    `, deviceID, fmt.Sprintf("POLL%d", suffix), lid).Scan(&pollID)
+	if err == nil {
+		_, err = db.Exec(`
+            INSERT INTO poll_votes (poll_id, voter, options, voted_at)
+            VALUES ($1, $2, '{a}', $4), ($1, $3, '{b}', $5)
+        `, pollID, phone, lid, older, newer)
+	}
+	if err != nil {
+		t.Fatalf("criar enquete: %v", err)
+	}
+
+	t.Cleanup(func() { db.Exec(`DELETE FROM lid_mappings WHERE lid = $1`, lid) })
+	if _, err := db.SaveLIDMapping(lid, phone, LIDSourceWhatsmeow); err != nil {
+		t.Fatalf("SaveLIDMapping: %v", err)
+	}
+
+	var remaining int
+	err = db.Get(&remaining, `
+        SELECT (SELECT COUNT(*) FROM chats WHERE jid = $1)
+             + (SELECT COUNT(*) FROM chat_read_cursors WHERE jid = $1)
+             + (SELECT COUNT(*) FROM tracked_entities WHERE jid = $1)
+             + (SELECT COUNT(*) FROM contacts WHERE jid = $1)
+             + (SELECT COUNT(*) FROM poll_votes WHERE voter = $1)
+    `, lid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("%d registros continuam com o LID", remaining)
+	}
+
+	chat, err := db.GetChat(deviceID, phone)
+	if err != nil || chat == nil {
+		t.Fatalf("GetChat: %v, %v", chat, err)
+	}
+	if chat.LastMessageID != "NEW" || chat.LastMessagePreview != "nova" || !chat.LastMessageFromMe {
+		t.Errorf("última mensagem = %s %q %v, esperado a do LID", chat.LastMessageID, chat.LastMessagePreview, chat.LastMessageFromMe)
+	}
+	if chat.LastActivityAt == nil || !chat.LastActivityAt.Equal(newer) {
+		t.Errorf("last_activity_at = %v, esperado %v", chat.LastActivityAt, newer)
+	}
+	if !chat.Archived || !chat.Pinned || !chat.Muted || chat.MutedUntil != nil {
+		t.Errorf("marcações = arquivado %v, fixado %v, silenciado %v até %v", chat.Archived, chat.Pinned, chat.Muted, chat.MutedUntil)
+	}
+
+	cursor, err := db.GetChatReadCursor(deviceID, phone)
+	if err != nil || cursor == nil {
+		t.Fatalf("GetChatReadCursor: %v, %v", cursor, err)
+	}
+	if cursor.LastReadMessageID != "NEW" || !cursor.LastReadAt.Equal(newer) {
+		t.Errorf("cursor = %s %v, esperado o do LID", cursor.LastReadMessageID, cursor.LastReadAt)
+	}
+
+	entity, err := db.GetTrackedEntity(deviceID, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entity.IsTracked || !entity.TrackMedia || len(entity.AllowedMediaTypes) != 2 {
+		t.Errorf("entidade = rastreada %v, mídia %v, tipos %v", entity.IsTracked, entity.TrackMedia, entity.AllowedMediaTypes)
+	}
+
+	var pushName string
+	if err := db.Get(&pushName, `SELECT push_name FROM contacts WHERE device_id = $1 AND jid = $2`, deviceID, phone); err != nil {
+		t.Fatal(err)
+	}
+	if pushName != "Ana" {
+		t.Errorf("push_name = %q, esperado o do LID", pushName)
+	}
+
+	var poll struct {
+		Chat    string         `db:"chat"`
+		Creator string         `db:"creator"`
+		Vote    pq.StringArray `db:"vote"`
+	}
+	err = db.Get(&poll, `
+        SELECT p.chat, p.creator, v.options AS vote
+        FROM polls p JOIN poll_votes v ON v.poll_id = p.id
+        WHERE p.id = $1 AND v.voter = $2
+    `, pollID, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poll.Chat != phone || poll.Creator != phone || len(poll.Vote) != 1 || poll.Vote[0] != "b" {
+		t.Errorf("enquete = %+v, esperado chat/criador no número e o voto mais recente", poll)
+	}
+}
+
+func TestUpdateMessageStatusWaitsForAllGroupParticipants(t *testing.T) {
+	db, deviceID := testDB(t)
+
+	suffix := time.Now().UnixNano()
+	group := fmt.Sprintf("%d@g.us", suffix)
+	own := fmt.Sprintf("55%d@s.whatsapp.net", suffix%1e11)
+	ana := fmt.Sprintf("55%d@s.whatsapp.net", suffix%1e11+1)
+	bia := fmt.Sprintf("55%d@s.whatsapp.net", suffix%1e11+2)
+	messageID := fmt.Sprintf("GRP%d", suffix)
+
+	// O próprio número é participante, mas nunca confirma as próprias mensagens
+	_, err := db.Exec(`UPDATE whatsapp_devices SET jid = regexp_replace($2, '@', ':12@') WHERE id = $1`, deviceID, own)
+	if err == nil {
+		err = db.SaveGroupSnapshot(&WhatsAppGroup{
+			DeviceID:     deviceID,
+			JID:          group,
+			Participants: []GroupParticipant{{JID: own}, {JID: ana}, {JID: bia}},
+		})
+	}
+	if err == nil {
+		_, err = db.Exec(`
+            INSERT INTO whatsapp_messages (device_id, jid, message_id, sender, is_from_me, is_group, timestamp)
+            VALUES ($1, $2, $3, $4, TRUE, TRUE, $5)
+        `, deviceID, group, messageID, own, time.Now().UTC())
+	}
+	if err != nil {
+		t.Fatalf("preparar grupo: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	receipts := []struct {
+		participant string
+		status      string
+		expected    string
+	}{
+		{ana, MessageStatusDelivered, ""},
+		{ana, MessageStatusRead, ""},
+		{bia, MessageStatusDelivered, MessageStatusDelivered},
+		{bia, MessageStatusRead, MessageStatusRead},
+	}
+	for i, r := range receipts {
+		if err := db.UpdateMessageStatus(deviceID, group, r.participant, []string{messageID}, r.status, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("UpdateMessageStatus: %v", err)
+		}
+
+		message, err := db.GetMessage(deviceID, messageID)
+		if err != nil || message == nil {
+			t.Fatalf("GetMessage: %v, %v", message, err)
+		}
+		if message.Status != r.expected {
+			t.Errorf("após %s de %s: status = %q, esperado %q", r.status, r.participant, message.Status, r.expected)
+		}
+	}
+}
@@ -3,9 +3,14 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
+
+	"whatsapp-service/pkg/webhook"
 )
 
 // DeviceStatus define o status de um dispositivo WhatsApp
@@ -90,43 +95,357 @@ func CreateTableQueries() []string {
             UNIQUE(device_id, jid)
         )`,
 
-		// Tabela de configurações de webhook
-		// `CREATE TABLE IF NOT EXISTS webhook_configs (
-		// 	id SERIAL PRIMARY KEY,
-		// 	tenant_id INTEGER NOT NULL,
-		// 	url VARCHAR(255) NOT NULL,
-		// 	secret VARCHAR(255),
-		// 	events TEXT[],
-		// 	device_ids INTEGER[],
-		// 	enabled BOOLEAN NOT NULL DEFAULT TRUE,
-		// 	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		// 	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		// )`,
-
-		// // Tabela de entregas de webhook
-		// `CREATE TABLE IF NOT EXISTS webhook_deliveries (
-		// 	id SERIAL PRIMARY KEY,
-		// 	webhook_id INTEGER NOT NULL,
-		// 	event_type VARCHAR(100) NOT NULL,
-		// 	payload TEXT NOT NULL,
-		// 	response_code INTEGER,
-		// 	response_body TEXT,
-		// 	error_message TEXT,
-		// 	attempt_count INTEGER NOT NULL DEFAULT 0,
-		// 	status VARCHAR(20) NOT NULL,
-		// 	next_retry_at TIMESTAMP,
-		// 	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		// 	last_updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		// 	FOREIGN KEY (webhook_id) REFERENCES webhook_configs(id) ON DELETE CASCADE
-		// )`,
+		// Política de armazenamento e retenção de mensagens por tenant
+		`CREATE TABLE IF NOT EXISTS message_retention_policies (
+			tenant_id INTEGER PRIMARY KEY,
+			retention_days INTEGER NOT NULL DEFAULT 0,
+			store_direct_messages BOOLEAN NOT NULL DEFAULT TRUE,
+			store_outgoing_messages BOOLEAN NOT NULL DEFAULT TRUE,
+			store_untracked_groups BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Tabela de configurações de webhook (várias assinaturas por tenant)
+		`CREATE TABLE IF NOT EXISTS webhook_configs (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL,
+			url VARCHAR(255) NOT NULL,
+			secret VARCHAR(255),
+			events TEXT[],
+			device_ids INTEGER[],
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Tabela de entregas de webhook
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id SERIAL PRIMARY KEY,
+			webhook_id INTEGER NOT NULL,
+			event_type VARCHAR(100) NOT NULL,
+			payload TEXT NOT NULL,
+			response_code INTEGER,
+			response_body TEXT,
+			error_message TEXT,
+			attempt_count INTEGER NOT NULL DEFAULT 0,
+			status VARCHAR(20) NOT NULL,
+			next_retry_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (webhook_id) REFERENCES webhook_configs(id) ON DELETE CASCADE
+		)`,
+
+		// Status de entrega das mensagens enviadas e histórico de confirmações
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT ''`,
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS message_status_history (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL,
+			message_id VARCHAR(100) NOT NULL,
+			chat VARCHAR(100) NOT NULL,
+			participant VARCHAR(100) NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Respostas, edições e exclusões para todos
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS quoted_message_id VARCHAR(100) NOT NULL DEFAULT ''`,
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP`,
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP`,
+		// Campos estruturados de localizações, contatos e prévias de link
+		`ALTER TABLE whatsapp_messages ADD COLUMN IF NOT EXISTS details JSONB`,
+
+		// Reações às mensagens (uma por participante; reação vazia remove)
+		`CREATE TABLE IF NOT EXISTS message_reactions (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL,
+			message_id VARCHAR(100) NOT NULL,
+			chat VARCHAR(100) NOT NULL,
+			sender VARCHAR(100) NOT NULL,
+			reaction VARCHAR(50) NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			UNIQUE(device_id, message_id, sender)
+		)`,
+
+		// Fila persistente de envio e limites de envio por dispositivo
+		`CREATE TABLE IF NOT EXISTS outbound_messages (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL,
+			recipient VARCHAR(100) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			payload TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			message_id VARCHAR(100) NOT NULL DEFAULT '',
+			attempt_count INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			locked_until TIMESTAMP,
+			sent_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_send_limits (
+			device_id INTEGER PRIMARY KEY,
+			messages_per_minute INTEGER NOT NULL,
+			daily_cap INTEGER NOT NULL,
+			min_typing_delay_ms INTEGER NOT NULL,
+			max_typing_delay_ms INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Mensagens agendadas; no horário entram na fila de envio (outbound_messages)
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL,
+			recipient VARCHAR(100) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			payload TEXT NOT NULL,
+			send_at TIMESTAMPTZ NOT NULL,
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			status VARCHAR(20) NOT NULL,
+			outbound_message_id INTEGER,
+			dispatched_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Prioridade na fila de envio: mensagens de campanhas ficam atrás dos envios avulsos
+		`ALTER TABLE outbound_messages ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0`,
+
+		// Campanhas de envio em massa e o resultado por destinatário
+		`CREATE TABLE IF NOT EXISTS campaigns (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			template TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			started_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Quando o arquivo compartilhado de uma campanha de mídia foi apagado
+		`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS media_removed_at TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS campaign_recipients (
+			id SERIAL PRIMARY KEY,
+			campaign_id INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+			recipient VARCHAR(100) NOT NULL,
+			content TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL,
+			error_message TEXT NOT NULL DEFAULT '',
+			outbound_message_id INTEGER,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Templates de mensagem por tenant; locale vazio é a variante padrão
+		`CREATE TABLE IF NOT EXISTS message_templates (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			locale VARCHAR(20) NOT NULL DEFAULT '',
+			content TEXT NOT NULL,
+			media_path TEXT NOT NULL DEFAULT '',
+			mimetype VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(tenant_id, name, locale)
+		)`,
+
+		// Biblioteca de mídias do tenant, reutilizáveis em vários envios
+		`CREATE TABLE IF NOT EXISTS media_files (
+			id SERIAL PRIMARY KEY,
+			tenant_id INTEGER NOT NULL,
+			file_name VARCHAR(255) NOT NULL DEFAULT '',
+			mimetype VARCHAR(100) NOT NULL,
+			size BIGINT NOT NULL,
+			sha256 VARCHAR(64) NOT NULL,
+			path TEXT NOT NULL,
+			source_url TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(tenant_id, sha256)
+		)`,
+
+		// Último upload de cada mídia da biblioteca por dispositivo, reaproveitado
+		// enquanto o WhatsApp mantém o arquivo
+		`CREATE TABLE IF NOT EXISTS media_uploads (
+			media_id INTEGER NOT NULL REFERENCES media_files(id) ON DELETE CASCADE,
+			device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			direct_path TEXT NOT NULL,
+			media_key BYTEA NOT NULL,
+			file_sha256 BYTEA NOT NULL,
+			file_enc_sha256 BYTEA NOT NULL,
+			file_length BIGINT NOT NULL,
+			uploaded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (media_id, device_id)
+		)`,
+
+		// Enquetes enviadas ou recebidas e o voto atual de cada participante
+		`CREATE TABLE IF NOT EXISTS polls (
+			id SERIAL PRIMARY KEY,
+			device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			message_id VARCHAR(100) NOT NULL,
+			chat VARCHAR(100) NOT NULL,
+			creator VARCHAR(100) NOT NULL,
+			question TEXT NOT NULL,
+			options TEXT[] NOT NULL,
+			selectable_count INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(device_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id INTEGER NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			voter VARCHAR(100) NOT NULL,
+			options TEXT[] NOT NULL,
+			voted_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (poll_id, voter)
+		)`,
+
+		// Cópia local dos grupos de cada dispositivo e seus participantes,
+		// mantida pelos eventos de grupo e por sincronizações com o WhatsApp
+		`CREATE TABLE IF NOT EXISTS whatsapp_groups (
+			device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			jid VARCHAR(100) NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			topic TEXT NOT NULL DEFAULT '',
+			owner VARCHAR(100) NOT NULL DEFAULT '',
+			is_announce BOOLEAN NOT NULL DEFAULT FALSE,
+			is_locked BOOLEAN NOT NULL DEFAULT FALSE,
+			group_created_at TIMESTAMP,
+			left_at TIMESTAMP,
+			synced_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (device_id, jid)
+		)`,
+		`CREATE TABLE IF NOT EXISTS group_participants (
+			device_id INTEGER NOT NULL,
+			group_jid VARCHAR(100) NOT NULL,
+			jid VARCHAR(100) NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			is_super_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			PRIMARY KEY (device_id, group_jid, jid),
+			FOREIGN KEY (device_id, group_jid) REFERENCES whatsapp_groups(device_id, jid) ON DELETE CASCADE
+		)`,
+		// Última sincronização completa da lista de grupos de cada dispositivo
+		`CREATE TABLE IF NOT EXISTS group_syncs (
+			device_id INTEGER PRIMARY KEY REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			synced_at TIMESTAMP NOT NULL
+		)`,
+
+		// Diretório de contatos de cada dispositivo: nomes vindos da agenda e
+		// das mensagens, verificação no WhatsApp, foto e perfil comercial
+		`CREATE TABLE IF NOT EXISTS contacts (
+			device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			jid VARCHAR(100) NOT NULL,
+			phone VARCHAR(20) NOT NULL DEFAULT '',
+			full_name TEXT NOT NULL DEFAULT '',
+			first_name TEXT NOT NULL DEFAULT '',
+			push_name TEXT NOT NULL DEFAULT '',
+			business_name TEXT NOT NULL DEFAULT '',
+			is_business BOOLEAN NOT NULL DEFAULT FALSE,
+			on_whatsapp BOOLEAN,
+			checked_at TIMESTAMP,
+			picture_id VARCHAR(50) NOT NULL DEFAULT '',
+			picture_path TEXT NOT NULL DEFAULT '',
+			picture_checked_at TIMESTAMP,
+			business_profile JSONB,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (device_id, jid)
+		)`,
+
+		// Mapeamentos LID -> número aprendidos das mensagens, da agenda e do
+		// armazenamento do whatsmeow. O LID identifica o usuário em todos os dispositivos.
+		`CREATE TABLE IF NOT EXISTS lid_mappings (
+			lid VARCHAR(100) PRIMARY KEY,
+			phone_jid VARCHAR(100) NOT NULL,
+			source VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Cursor de leitura de cada chat: mensagens recebidas depois de
+		// last_read_at são não lidas. Na criação da tabela, os chats existentes
+		// recebem um cursor na última mensagem armazenada, para que o histórico
+		// anterior não apareça inteiro como não lido.
+		`DO $$
+		BEGIN
+			IF to_regclass('chat_read_cursors') IS NULL THEN
+				CREATE TABLE chat_read_cursors (
+					device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+					jid VARCHAR(100) NOT NULL,
+					last_read_message_id VARCHAR(100) NOT NULL DEFAULT '',
+					last_read_at TIMESTAMP NOT NULL,
+					updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (device_id, jid)
+				);
+
+				INSERT INTO chat_read_cursors (device_id, jid, last_read_message_id, last_read_at)
+				SELECT DISTINCT ON (m.device_id, m.jid) m.device_id, m.jid, m.message_id, m.timestamp
+				FROM whatsapp_messages m
+				JOIN whatsapp_devices d ON d.id = m.device_id
+				ORDER BY m.device_id, m.jid, m.timestamp DESC, m.id DESC;
+			END IF;
+		END
+		$$`,
+
+		// Conversas de cada dispositivo: última atividade e prévia da última
+		// mensagem, mais arquivado/fixado/silenciado vindos do app state
+		`CREATE TABLE IF NOT EXISTS chats (
+			device_id INTEGER NOT NULL REFERENCES whatsapp_devices(id) ON DELETE CASCADE,
+			jid VARCHAR(100) NOT NULL,
+			is_group BOOLEAN NOT NULL DEFAULT FALSE,
+			last_message_id VARCHAR(100) NOT NULL DEFAULT '',
+			last_message_preview TEXT NOT NULL DEFAULT '',
+			last_message_from_me BOOLEAN NOT NULL DEFAULT FALSE,
+			last_activity_at TIMESTAMP,
+			archived BOOLEAN NOT NULL DEFAULT FALSE,
+			pinned BOOLEAN NOT NULL DEFAULT FALSE,
+			muted BOOLEAN NOT NULL DEFAULT FALSE,
+			muted_until TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (device_id, jid)
+		)`,
+
+		// Segredo anterior da assinatura, aceito até expirar durante uma rotação
+		`ALTER TABLE webhook_configs ADD COLUMN IF NOT EXISTS previous_secret VARCHAR(255)`,
+		`ALTER TABLE webhook_configs ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMP`,
+
+		// Colunas da fila de entregas: dispositivo de origem e lease do worker que reservou a entrega
+		`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS device_id INTEGER`,
+		`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP`,
+		// Entrega original quando a linha foi criada por um reenvio manual
+		`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS replay_of INTEGER`,
 
 		// Índices para buscas rápidas
 		`CREATE INDEX IF NOT EXISTS idx_messages_device_jid ON whatsapp_messages(device_id, jid)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON whatsapp_messages(timestamp)`,
 		`CREATE INDEX IF NOT EXISTS idx_tracked_entities_device ON tracked_entities(device_id)`,
-		// `CREATE INDEX IF NOT EXISTS idx_webhook_configs_tenant ON webhook_configs(tenant_id)`,
-		// `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status)`,
-		// `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_retry ON webhook_deliveries(next_retry_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_device_timestamp_id ON whatsapp_messages(device_id, timestamp DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_content_search ON whatsapp_messages USING GIN (to_tsvector('simple', COALESCE(content, '')))`,
+		`CREATE INDEX IF NOT EXISTS idx_outbound_messages_queue ON outbound_messages(device_id, next_attempt_at, id) WHERE status IN ('queued', 'sending')`,
+		`CREATE INDEX IF NOT EXISTS idx_outbound_messages_sent ON outbound_messages(device_id, sent_at) WHERE status = 'sent'`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(send_at, id) WHERE status = 'scheduled'`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_device ON scheduled_messages(device_id, send_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_campaigns_device ON campaigns(device_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_campaign_recipients_campaign ON campaign_recipients(campaign_id, status, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_status_history_message ON message_status_history(device_id, message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_polls_device_chat ON polls(device_id, chat, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_contacts_device_phone ON contacts(device_id, phone)`,
+		`CREATE INDEX IF NOT EXISTS idx_lid_mappings_phone ON lid_mappings(phone_jid)`,
+		`CREATE INDEX IF NOT EXISTS idx_chats_device_activity ON chats(device_id, last_activity_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_configs_tenant ON webhook_configs(tenant_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_retry ON webhook_deliveries(next_retry_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_created ON webhook_deliveries(webhook_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_replay_of ON webhook_deliveries(replay_of)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_queue ON webhook_deliveries(status, next_retry_at) WHERE status IN ('pending', 'retrying', 'processing')`,
 
 		// NOVA TABELA: notification_logs
 		`CREATE TABLE IF NOT EXISTS notification_logs (
@@ -198,6 +517,313 @@ type WhatsAppMessage struct {
 	MediaType  string    `db:"media_type"`  // Tipo de mídia
 	Timestamp  time.Time `db:"timestamp"`   // Hora da mensagem
 	ReceivedAt time.Time `db:"received_at"` // Hora em que foi recebida pelo nosso sistema
+
+	Status          string     `db:"status"`            // Status de entrega (apenas mensagens enviadas); em grupos, o alcançado por todos os participantes
+	StatusUpdatedAt *time.Time `db:"status_updated_at"` // Hora da última confirmação recebida
+
+	QuotedMessageID string     `db:"quoted_message_id"` // Mensagem respondida (citada), se houver
+	EditedAt        *time.Time `db:"edited_at"`         // Última edição; Content guarda o texto editado
+	RevokedAt       *time.Time `db:"revoked_at"`        // Apagada para todos
+
+	Details *MessageDetails `db:"details"` // Localização, contatos, prévia de link ou enquete, conforme o tipo
+
+	Reactions []MessageReaction `db:"-"` // Preenchido nas consultas de histórico
+}
+
+// MessageDetails guarda os campos estruturados das mensagens que não são só
+// texto ou arquivo. É armazenado como JSONB.
+type MessageDetails struct {
+	Location    *webhook.LocationData    `json:"location,omitempty"`
+	Contacts    []webhook.ContactData    `json:"contacts,omitempty"`
+	LinkPreview *webhook.LinkPreviewData `json:"link_preview,omitempty"`
+	Poll        *webhook.PollData        `json:"poll,omitempty"`
+}
+
+// Value serializa os detalhes para a coluna JSONB
+func (d MessageDetails) Value() (driver.Value, error) {
+	encoded, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// Scan lê os detalhes da coluna JSONB
+func (d *MessageDetails) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("tipo incompatível para MessageDetails: %T", src)
+	}
+}
+
+// MessageReaction é a reação atual de um participante a uma mensagem
+type MessageReaction struct {
+	ID        int64     `db:"id" json:"-"`
+	DeviceID  int64     `db:"device_id" json:"-"`
+	MessageID string    `db:"message_id" json:"message_id"`
+	Chat      string    `db:"chat" json:"chat"`
+	Sender    string    `db:"sender" json:"sender"`
+	Reaction  string    `db:"reaction" json:"reaction"`
+	Timestamp time.Time `db:"timestamp" json:"timestamp"`
+}
+
+// WhatsAppGroup é a cópia local de um grupo do dispositivo. LeftAt indica que
+// o dispositivo saiu ou foi removido; o grupo é mantido para consulta.
+type WhatsAppGroup struct {
+	DeviceID       int64      `db:"device_id" json:"device_id"`
+	JID            string     `db:"jid" json:"jid"`
+	Name           string     `db:"name" json:"name"`
+	Topic          string     `db:"topic" json:"topic"`
+	Owner          string     `db:"owner" json:"owner,omitempty"`
+	IsAnnounce     bool       `db:"is_announce" json:"is_announce"` // Apenas admins enviam mensagens
+	IsLocked       bool       `db:"is_locked" json:"is_locked"`     // Apenas admins editam os dados do grupo
+	GroupCreatedAt *time.Time `db:"group_created_at" json:"group_created_at,omitempty"`
+	LeftAt         *time.Time `db:"left_at" json:"left_at,omitempty"`
+	SyncedAt       time.Time  `db:"synced_at" json:"synced_at"` // Última cópia completa obtida do WhatsApp
+	UpdatedAt      time.Time  `db:"updated_at" json:"updated_at"`
+
+	Participants []GroupParticipant `db:"-" json:"participants"` // Preenchido nas consultas
+}
+
+// GroupParticipant é um participante na cópia local de um grupo
+type GroupParticipant struct {
+	DeviceID     int64  `db:"device_id" json:"-"`
+	GroupJID     string `db:"group_jid" json:"-"`
+	JID          string `db:"jid" json:"jid"`
+	IsAdmin      bool   `db:"is_admin" json:"is_admin"`
+	IsSuperAdmin bool   `db:"is_super_admin" json:"is_super_admin"` // Criador do grupo
+}
+
+// Contact é um contato no diretório do dispositivo. Os nomes vêm de fontes
+// diferentes: FullName e FirstName da agenda do celular, PushName definido
+// pelo próprio contato e BusinessName verificado de contas comerciais.
+type Contact struct {
+	DeviceID         int64            `db:"device_id" json:"device_id"`
+	JID              string           `db:"jid" json:"jid"`
+	Phone            string           `db:"phone" json:"phone,omitempty"`
+	FullName         string           `db:"full_name" json:"full_name,omitempty"`
+	FirstName        string           `db:"first_name" json:"first_name,omitempty"`
+	PushName         string           `db:"push_name" json:"push_name,omitempty"`
+	BusinessName     string           `db:"business_name" json:"business_name,omitempty"`
+	IsBusiness       bool             `db:"is_business" json:"is_business"`
+	OnWhatsApp       *bool            `db:"on_whatsapp" json:"on_whatsapp"` // nil = ainda não verificado
+	CheckedAt        *time.Time       `db:"checked_at" json:"checked_at,omitempty"`
+	PictureID        string           `db:"picture_id" json:"picture_id,omitempty"`
+	PicturePath      string           `db:"picture_path" json:"picture_path,omitempty"` // Servida em /<picture_path>
+	PictureCheckedAt *time.Time       `db:"picture_checked_at" json:"picture_checked_at,omitempty"`
+	BusinessProfile  *BusinessProfile `db:"business_profile" json:"business_profile,omitempty"`
+	CreatedAt        time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time        `db:"updated_at" json:"updated_at"`
+}
+
+// BusinessProfile é o perfil de uma conta comercial. É armazenado como JSONB.
+type BusinessProfile struct {
+	Address       string               `json:"address,omitempty"`
+	Email         string               `json:"email,omitempty"`
+	Categories    []string             `json:"categories,omitempty"`
+	Options       map[string]string    `json:"options,omitempty"` // Site, descrição e outros campos do perfil
+	HoursTimezone string               `json:"hours_timezone,omitempty"`
+	Hours         []BusinessHoursEntry `json:"hours,omitempty"`
+}
+
+// BusinessHoursEntry é o horário de atendimento de um dia da semana
+type BusinessHoursEntry struct {
+	DayOfWeek string `json:"day_of_week"`
+	Mode      string `json:"mode"` // specific_hours, open_24h ou appointment_only
+	OpenTime  string `json:"open_time,omitempty"`
+	CloseTime string `json:"close_time,omitempty"`
+}
+
+// Value serializa o perfil para a coluna JSONB
+func (p BusinessProfile) Value() (driver.Value, error) {
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// Scan lê o perfil da coluna JSONB
+func (p *BusinessProfile) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("tipo incompatível para BusinessProfile: %T", src)
+	}
+}
+
+// LIDMapping associa um LID (identificador oculto do usuário, @lid) ao JID
+// do número de telefone
+type LIDMapping struct {
+	LID       string    `db:"lid" json:"lid"`
+	PhoneJID  string    `db:"phone_jid" json:"phone_jid"`
+	Source    string    `db:"source" json:"source"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Origens de um mapeamento LID -> número
+const (
+	LIDSourceMessage   = "message"   // JID alternativo informado em uma mensagem
+	LIDSourceContact   = "contact"   // Sincronização da agenda do celular
+	LIDSourceWhatsmeow = "whatsmeow" // Armazenamento de LIDs do whatsmeow
+)
+
+// ChatReadCursor marca até onde um chat foi lido, pela API ou pelo próprio
+// número em outro aparelho
+type ChatReadCursor struct {
+	DeviceID          int64     `db:"device_id" json:"device_id"`
+	JID               string    `db:"jid" json:"jid"`
+	LastReadMessageID string    `db:"last_read_message_id" json:"last_read_message_id"`
+	LastReadAt        time.Time `db:"last_read_at" json:"last_read_at"` // Horário da última mensagem lida
+	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Chat é uma conversa do dispositivo. Name, UnreadCount e LastReadAt são
+// calculados na consulta a partir de contatos, grupos e do cursor de leitura.
+type Chat struct {
+	DeviceID           int64      `db:"device_id" json:"device_id"`
+	JID                string     `db:"jid" json:"jid"`
+	IsGroup            bool       `db:"is_group" json:"is_group"`
+	Name               string     `db:"name" json:"name"`
+	LastMessageID      string     `db:"last_message_id" json:"last_message_id,omitempty"`
+	LastMessagePreview string     `db:"last_message_preview" json:"last_message_preview,omitempty"` // Vazia se a mensagem não foi armazenada
+	LastMessageFromMe  bool       `db:"last_message_from_me" json:"last_message_from_me"`
+	LastActivityAt     *time.Time `db:"last_activity_at" json:"last_activity_at,omitempty"`
+	Archived           bool       `db:"archived" json:"archived"`
+	Pinned             bool       `db:"pinned" json:"pinned"`
+	Muted              bool       `db:"muted" json:"muted"`
+	MutedUntil         *time.Time `db:"muted_until" json:"muted_until,omitempty"` // Nulo = silenciado sem prazo
+	UnreadCount        int        `db:"unread_count" json:"unread_count"`
+	LastReadAt         *time.Time `db:"last_read_at" json:"last_read_at,omitempty"`
+	CreatedAt          time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// ChatQuery filtra e ordena a lista de chats de um dispositivo
+type ChatQuery struct {
+	DeviceID   int64
+	Archived   *bool  // Nulo = arquivados e não arquivados
+	UnreadOnly bool   // Apenas chats com mensagens não lidas
+	Sort       string // ChatSortRecent (padrão), ChatSortName ou ChatSortUnread
+	Limit      int
+	Offset     int
+}
+
+// Ordenações da lista de chats
+const (
+	ChatSortRecent = "recent" // Fixados primeiro, depois pela última atividade
+	ChatSortName   = "name"   // Pelo nome exibido
+	ChatSortUnread = "unread" // Mais mensagens não lidas primeiro
+)
+
+// ChatPreviewMaxLength limita o tamanho da prévia da última mensagem
+const ChatPreviewMaxLength = 100
+
+// ChatPreview monta a prévia da última mensagem de um chat: o texto,
+// truncado, ou o tipo da mídia entre colchetes quando não há texto
+func ChatPreview(content string, mediaType string) string {
+	if content == "" && mediaType != "" {
+		return "[" + mediaType + "]"
+	}
+	if runes := []rune(content); len(runes) > ChatPreviewMaxLength {
+		return string(runes[:ChatPreviewMaxLength])
+	}
+	return content
+}
+
+// Poll é uma enquete enviada ou recebida por um dispositivo. As opções são
+// guardadas para identificar os votos, que chegam como hashes das opções.
+type Poll struct {
+	ID              int64          `db:"id" json:"id"`
+	DeviceID        int64          `db:"device_id" json:"device_id"`
+	MessageID       string         `db:"message_id" json:"message_id"`
+	Chat            string         `db:"chat" json:"chat"`
+	Creator         string         `db:"creator" json:"creator"`
+	Question        string         `db:"question" json:"question"`
+	Options         pq.StringArray `db:"options" json:"options"`
+	SelectableCount int            `db:"selectable_count" json:"selectable_count"` // 0 = qualquer quantidade
+	CreatedAt       time.Time      `db:"created_at" json:"created_at"`
+
+	Tally []webhook.PollTallyData `db:"-" json:"tally"` // Preenchido nas consultas
+	Votes []PollVote              `db:"-" json:"votes"` // Preenchido nas consultas
+}
+
+// PollVote é o voto atual de um participante em uma enquete
+type PollVote struct {
+	PollID  int64          `db:"poll_id" json:"-"`
+	Voter   string         `db:"voter" json:"voter"`
+	Options pq.StringArray `db:"options" json:"options"`
+	VotedAt time.Time      `db:"voted_at" json:"voted_at"`
+}
+
+// Status de entrega de uma mensagem enviada, em ordem de progresso
+const (
+	MessageStatusSent      = "sent"       // Enviada pelo celular, ainda sem confirmação do servidor
+	MessageStatusServerAck = "server_ack" // Aceita pelo servidor do WhatsApp
+	MessageStatusFailed    = "failed"     // O servidor reportou erro na entrega
+	MessageStatusDelivered = "delivered"  // Entregue ao aparelho do destinatário
+	MessageStatusRead      = "read"       // Lida pelo destinatário
+	MessageStatusPlayed    = "played"     // Áudio/visualização única reproduzido
+)
+
+// messageStatusOrder define a precedência dos status: uma confirmação só
+// substitui o status atual se estiver mais adiante nesta lista. Confirmações
+// de entrega fora de ordem (ex.: delivered após read) são comuns no WhatsApp.
+var messageStatusOrder = []string{
+	MessageStatusSent,
+	MessageStatusServerAck,
+	MessageStatusFailed,
+	MessageStatusDelivered,
+	MessageStatusRead,
+	MessageStatusPlayed,
+}
+
+// MessageStatusChange é uma confirmação registrada no histórico de uma mensagem
+type MessageStatusChange struct {
+	ID          int64     `db:"id" json:"id"`
+	DeviceID    int64     `db:"device_id" json:"device_id"`
+	MessageID   string    `db:"message_id" json:"message_id"`
+	Chat        string    `db:"chat" json:"chat"`
+	Participant string    `db:"participant" json:"participant,omitempty"` // Quem confirmou, em grupos
+	Status      string    `db:"status" json:"status"`
+	Timestamp   time.Time `db:"timestamp" json:"timestamp"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// WebhookData converte a mensagem armazenada para o payload público de eventos
+func (m *WhatsAppMessage) WebhookData() webhook.MessageData {
+	data := webhook.MessageData{
+		MessageID: m.MessageID,
+		Chat:      m.JID,
+		Sender:    m.Sender,
+		IsFromMe:  m.IsFromMe,
+		IsGroup:   m.IsGroup,
+		Timestamp: m.Timestamp,
+		Type:      m.MediaType,
+		Text:      m.Content,
+	}
+
+	if data.Type == "" {
+		data.Type = "text"
+	}
+	if m.MediaURL != "" {
+		data.Media = &webhook.MediaData{URL: m.MediaURL}
+	}
+	if m.Details != nil {
+		data.Location = m.Details.Location
+		data.Contacts = m.Details.Contacts
+		data.LinkPreview = m.Details.LinkPreview
+	}
+
+	return data
 }
 
 // Modelo TrackedEntity
@@ -212,32 +838,311 @@ type TrackedEntity struct {
 	UpdatedAt         time.Time      `db:"updated_at"`
 }
 
+// MessageRetentionPolicy define quais mensagens de um tenant são armazenadas
+// e por quanto tempo. Tenants sem política usam DefaultMessageRetentionPolicy.
+type MessageRetentionPolicy struct {
+	TenantID              int64     `db:"tenant_id" json:"tenant_id"`
+	RetentionDays         int       `db:"retention_days" json:"retention_days"`                   // 0 = sem expiração
+	StoreDirectMessages   bool      `db:"store_direct_messages" json:"store_direct_messages"`     // Conversas 1:1
+	StoreOutgoingMessages bool      `db:"store_outgoing_messages" json:"store_outgoing_messages"` // Enviadas pela API ou pelo celular
+	StoreUntrackedGroups  bool      `db:"store_untracked_groups" json:"store_untracked_groups"`   // Grupos sem tracking ativo
+	CreatedAt             time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// DefaultMessageRetentionPolicy retorna a política usada quando o tenant não configurou uma
+func DefaultMessageRetentionPolicy(tenantID int64) *MessageRetentionPolicy {
+	return &MessageRetentionPolicy{
+		TenantID:              tenantID,
+		RetentionDays:         0,
+		StoreDirectMessages:   true,
+		StoreOutgoingMessages: true,
+		StoreUntrackedGroups:  false,
+	}
+}
+
+// ShouldStore indica se uma mensagem deve ser persistida segundo a política.
+// tracked informa se o chat está sendo trackeado (relevante apenas para grupos).
+func (p *MessageRetentionPolicy) ShouldStore(isGroup bool, isFromMe bool, tracked bool) bool {
+	if isFromMe && !p.StoreOutgoingMessages {
+		return false
+	}
+	if isGroup {
+		return tracked || p.StoreUntrackedGroups
+	}
+	return p.StoreDirectMessages
+}
+
+// WebhookConfig representa uma assinatura de webhook de um tenant.
+// Um tenant pode ter várias assinaturas, cada uma com seu filtro de eventos,
+// filtro de dispositivos e segredo próprios.
 type WebhookConfig struct {
-	ID        int64     `db:"id"`
-	TenantID  int64     `db:"tenant_id"`
-	URL       string    `db:"url"`
-	Secret    string    `db:"secret"`
-	Events    []string  `db:"events"`
-	DeviceIDs []int64   `db:"device_ids"`
-	Enabled   bool      `db:"enabled"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID        int64     `db:"id" json:"id"`
+	TenantID  int64     `db:"tenant_id" json:"tenant_id"`
+	URL       string    `db:"url" json:"url"`
+	Secret    string    `db:"secret" json:"-"`              // Nunca retornado nas listagens
+	Events    []string  `db:"events" json:"events"`         // Vazio = todos os eventos
+	DeviceIDs []int64   `db:"device_ids" json:"device_ids"` // Vazio = todos os dispositivos do tenant
+	Enabled   bool      `db:"enabled" json:"enabled"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+
+	// Segredo substituído na última rotação, ainda usado para assinar até expirar
+	PreviousSecret          string     `db:"previous_secret" json:"-"`
+	PreviousSecretExpiresAt *time.Time `db:"previous_secret_expires_at" json:"previous_secret_expires_at,omitempty"`
 }
 
+// SigningSecrets retorna os segredos ativos da assinatura: o atual e,
+// durante uma rotação, o anterior ainda não expirado
+func (c *WebhookConfig) SigningSecrets(now time.Time) []string {
+	var secrets []string
+	if c.Secret != "" {
+		secrets = append(secrets, c.Secret)
+	}
+	if c.PreviousSecret != "" && c.PreviousSecretExpiresAt != nil && now.Before(*c.PreviousSecretExpiresAt) {
+		secrets = append(secrets, c.PreviousSecret)
+	}
+	return secrets
+}
+
+// Status de uma entrega na fila de webhooks
+const (
+	WebhookDeliveryPending    = "pending"    // Aguardando a primeira tentativa
+	WebhookDeliveryProcessing = "processing" // Reservada por um worker
+	WebhookDeliveryRetrying   = "retrying"   // Falhou, aguardando next_retry_at
+	WebhookDeliverySuccess    = "success"    // Entregue
+	WebhookDeliveryFailed     = "failed"     // Dead-letter: esgotou tentativas ou erro permanente
+	WebhookDeliveryCancelled  = "cancelled"  // Assinatura desabilitada antes da entrega
+)
+
 // WebhookDelivery representa um evento de entrega de webhook
 type WebhookDelivery struct {
-	ID            int64     `db:"id"`
-	WebhookID     int64     `db:"webhook_id"`
-	EventType     string    `db:"event_type"`
-	Payload       string    `db:"payload"`
-	ResponseCode  int       `db:"response_code"`
-	ResponseBody  string    `db:"response_body"`
-	ErrorMessage  string    `db:"error_message"`
-	AttemptCount  int       `db:"attempt_count"`
-	Status        string    `db:"status"` // success, failed, pending, retrying
-	NextRetryAt   time.Time `db:"next_retry_at"`
-	CreatedAt     time.Time `db:"created_at"`
-	LastUpdatedAt time.Time `db:"last_updated_at"`
+	ID            int64      `db:"id"`
+	WebhookID     int64      `db:"webhook_id"`
+	DeviceID      int64      `db:"device_id"`
+	EventType     string     `db:"event_type"`
+	Payload       string     `db:"payload"`
+	ResponseCode  int        `db:"response_code"`
+	ResponseBody  string     `db:"response_body"`
+	ErrorMessage  string     `db:"error_message"`
+	AttemptCount  int        `db:"attempt_count"`
+	Status        string     `db:"status"` // pending, processing, retrying, success, failed, cancelled
+	NextRetryAt   *time.Time `db:"next_retry_at"`
+	CreatedAt     time.Time  `db:"created_at"`
+	LastUpdatedAt time.Time  `db:"last_updated_at"`
+}
+
+// Status de uma mensagem na fila de envio
+const (
+	OutboundQueued    = "queued"    // Aguardando a vez do dispositivo (ou o dispositivo voltar a conectar)
+	OutboundSending   = "sending"   // Reservada pelo processador
+	OutboundSent      = "sent"      // Enviada; MessageID traz o ID no WhatsApp
+	OutboundFailed    = "failed"    // Esgotou as tentativas
+	OutboundCancelled = "cancelled" // Cancelada antes do envio
+)
+
+// Tipos de conteúdo da fila de envio
+const (
+	OutboundKindText     = "text"
+	OutboundKindMedia    = "media"
+	OutboundKindSticker  = "sticker"
+	OutboundKindLocation = "location"
+	OutboundKindContact  = "contact"
+	OutboundKindPoll     = "poll"
+)
+
+// OutboundMessage é uma mensagem na fila persistente de envio de um dispositivo
+type OutboundMessage struct {
+	ID            int64      `db:"id" json:"id"`
+	DeviceID      int64      `db:"device_id" json:"device_id"`
+	Recipient     string     `db:"recipient" json:"recipient"`
+	Kind          string     `db:"kind" json:"kind"`
+	Payload       string     `db:"payload" json:"-"` // OutboundPayload em JSON
+	Status        string     `db:"status" json:"status"`
+	Priority      int        `db:"priority" json:"priority"`
+	MessageID     string     `db:"message_id" json:"message_id,omitempty"`
+	AttemptCount  int        `db:"attempt_count" json:"attempt_count"`
+	ErrorMessage  string     `db:"error_message" json:"error_message,omitempty"`
+	NextAttemptAt time.Time  `db:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   *time.Time `db:"locked_until" json:"-"`
+	SentAt        *time.Time `db:"sent_at" json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// Prioridades da fila de envio; maior sai primeiro
+const (
+	OutboundPriorityNormal = 0
+	OutboundPriorityBulk   = -10 // Campanhas
+)
+
+// OutboundPayload é o conteúdo de uma mensagem da fila
+type OutboundPayload struct {
+	Text      string `json:"text,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+	Mimetype  string `json:"mimetype,omitempty"`
+	MediaPath string `json:"media_path,omitempty"` // Arquivo guardado até o envio
+	Shared    bool   `json:"shared,omitempty"`     // Arquivo usado por várias mensagens (campanhas); não é removido após o envio
+	MediaID   int64  `json:"media_id,omitempty"`   // Mídia da biblioteca (MediaPath aponta para ela e Shared é true)
+	FileName  string `json:"file_name,omitempty"`  // Nome exibido em documentos
+	VoiceNote bool   `json:"voice_note,omitempty"` // Áudio enviado como nota de voz (já convertido para Ogg/Opus)
+
+	LinkPreview bool                  `json:"link_preview,omitempty"` // Texto com prévia do primeiro link
+	Location    *webhook.LocationData `json:"location,omitempty"`
+	Contacts    []webhook.ContactData `json:"contacts,omitempty"`
+	Poll        *webhook.PollData     `json:"poll,omitempty"`
+}
+
+// Status de uma mensagem agendada
+const (
+	ScheduledPending    = "scheduled"  // Aguardando o horário de envio
+	ScheduledDispatched = "dispatched" // Colocada na fila de envio; o resultado está em DeliveryStatus
+	ScheduledCancelled  = "cancelled"  // Cancelada antes do horário
+)
+
+// ScheduledMessage é uma mensagem agendada. No horário ela é colocada na fila
+// de envio do dispositivo, que respeita os limites de envio e aguarda o
+// dispositivo reconectar caso esteja offline.
+type ScheduledMessage struct {
+	ID                int64      `db:"id" json:"id"`
+	DeviceID          int64      `db:"device_id" json:"device_id"`
+	Recipient         string     `db:"recipient" json:"recipient"`
+	Kind              string     `db:"kind" json:"kind"`
+	Payload           string     `db:"payload" json:"-"` // OutboundPayload em JSON
+	SendAt            time.Time  `db:"send_at" json:"send_at"`
+	Timezone          string     `db:"timezone" json:"timezone"` // Fuso IANA usado para interpretar e exibir send_at
+	Status            string     `db:"status" json:"status"`
+	OutboundMessageID *int64     `db:"outbound_message_id" json:"queue_id,omitempty"`
+	DispatchedAt      *time.Time `db:"dispatched_at" json:"dispatched_at,omitempty"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updated_at"`
+
+	// Situação da mensagem na fila de envio, após o disparo
+	DeliveryStatus string `db:"delivery_status" json:"delivery_status,omitempty"`
+	MessageID      string `db:"message_id" json:"message_id,omitempty"`
+	ErrorMessage   string `db:"error_message" json:"error_message,omitempty"`
+
+	// Conteúdo decodificado de Payload, para exibição
+	Text     string `db:"-" json:"text,omitempty"`
+	Caption  string `db:"-" json:"caption,omitempty"`
+	Mimetype string `db:"-" json:"mimetype,omitempty"`
+}
+
+// prepare preenche o conteúdo de exibição a partir do payload e expressa
+// send_at no fuso do agendamento
+func (s *ScheduledMessage) prepare() {
+	var payload OutboundPayload
+	if json.Unmarshal([]byte(s.Payload), &payload) == nil {
+		s.Text = payload.Text
+		s.Caption = payload.Caption
+		s.Mimetype = payload.Mimetype
+	}
+
+	if location, err := time.LoadLocation(s.Timezone); err == nil {
+		s.SendAt = s.SendAt.In(location)
+	}
+}
+
+// Status de uma campanha
+const (
+	CampaignDraft     = "draft"     // Criada, aguardando start
+	CampaignRunning   = "running"   // Enviando
+	CampaignPaused    = "paused"    // Pausada; mensagens ainda na fila voltam para pending
+	CampaignCompleted = "completed" // Todos os destinatários processados
+	CampaignStopped   = "stopped"   // Interrompida; destinatários restantes ficam cancelled
+)
+
+// Status de um destinatário de campanha. Depois de entrar na fila, o status
+// exibido é o da mensagem na fila de envio (queued, sending, sent, failed, cancelled).
+const (
+	CampaignRecipientPending   = "pending"
+	CampaignRecipientQueued    = "queued"
+	CampaignRecipientSkipped   = "skipped" // Destinatário inválido ou variável do template ausente
+	CampaignRecipientCancelled = "cancelled"
+)
+
+// Campaign é um envio em massa de um template para uma lista de destinatários
+type Campaign struct {
+	ID          int64          `db:"id" json:"id"`
+	DeviceID    int64          `db:"device_id" json:"device_id"`
+	Name        string         `db:"name" json:"name"`
+	Kind        string         `db:"kind" json:"kind"` // text ou media (Template é a legenda)
+	Template    string         `db:"template" json:"template"`
+	Payload     string         `db:"payload" json:"-"` // OutboundPayload base (mídia compartilhada)
+	Status      string         `db:"status" json:"status"`
+	StartedAt   *time.Time     `db:"started_at" json:"started_at,omitempty"`
+	CompletedAt *time.Time     `db:"completed_at" json:"completed_at,omitempty"`
+	CreatedAt   time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at" json:"updated_at"`
+	Stats       map[string]int `db:"-" json:"stats,omitempty"` // Destinatários por status
+}
+
+// CampaignRecipient é o resultado de uma campanha para um destinatário
+type CampaignRecipient struct {
+	ID                int64      `db:"id" json:"id"`
+	CampaignID        int64      `db:"campaign_id" json:"campaign_id"`
+	Recipient         string     `db:"recipient" json:"recipient"`
+	Content           string     `db:"content" json:"content"` // Template renderizado
+	Status            string     `db:"status" json:"status"`
+	ErrorMessage      string     `db:"error_message" json:"error_message,omitempty"`
+	OutboundMessageID *int64     `db:"outbound_message_id" json:"queue_id,omitempty"`
+	MessageID         string     `db:"message_id" json:"message_id,omitempty"`
+	SentAt            *time.Time `db:"sent_at" json:"sent_at,omitempty"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// MessageTemplate é um template de mensagem de um tenant. Um mesmo nome pode
+// ter variantes por locale (ex.: "pt-BR", "en"); locale vazio é a variante padrão.
+type MessageTemplate struct {
+	ID        int64     `db:"id" json:"id"`
+	TenantID  int64     `db:"tenant_id" json:"tenant_id"`
+	Name      string    `db:"name" json:"name"`
+	Locale    string    `db:"locale" json:"locale"`
+	Content   string    `db:"content" json:"content"` // Texto (ou legenda do anexo) com variáveis {{nome}}
+	MediaPath string    `db:"media_path" json:"-"`
+	Mimetype  string    `db:"mimetype" json:"mimetype,omitempty"` // Preenchido quando o template tem anexo
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	Variables []string  `db:"-" json:"variables"` // Variáveis obrigatórias encontradas em Content
+}
+
+// MediaFile é uma mídia guardada na biblioteca do tenant. Arquivos iguais
+// (mesmo SHA-256) são guardados uma única vez.
+type MediaFile struct {
+	ID        int64     `db:"id" json:"id"`
+	TenantID  int64     `db:"tenant_id" json:"tenant_id"`
+	FileName  string    `db:"file_name" json:"file_name,omitempty"`
+	Mimetype  string    `db:"mimetype" json:"mimetype"`
+	Size      int64     `db:"size" json:"size"`
+	SHA256    string    `db:"sha256" json:"sha256"`
+	Path      string    `db:"path" json:"-"`
+	SourceURL string    `db:"source_url" json:"source_url,omitempty"` // URL de origem, quando baixada pelo serviço
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// MediaUpload guarda o resultado do upload de uma mídia da biblioteca para o
+// servidor do WhatsApp, permitindo enviá-la de novo sem outro upload
+type MediaUpload struct {
+	MediaID       int64     `db:"media_id"`
+	DeviceID      int64     `db:"device_id"`
+	URL           string    `db:"url"`
+	DirectPath    string    `db:"direct_path"`
+	MediaKey      []byte    `db:"media_key"`
+	FileSHA256    []byte    `db:"file_sha256"`
+	FileEncSHA256 []byte    `db:"file_enc_sha256"`
+	FileLength    uint64    `db:"file_length"`
+	UploadedAt    time.Time `db:"uploaded_at"`
+}
+
+// DeviceSendLimits define o ritmo de envio de um dispositivo. Dispositivos sem
+// registro usam os limites padrão do processador de envio.
+type DeviceSendLimits struct {
+	DeviceID          int64     `db:"device_id" json:"device_id"`
+	MessagesPerMinute int       `db:"messages_per_minute" json:"messages_per_minute"`
+	DailyCap          int       `db:"daily_cap" json:"daily_cap"` // 0 = sem limite diário
+	MinTypingDelayMs  int       `db:"min_typing_delay_ms" json:"min_typing_delay_ms"`
+	MaxTypingDelayMs  int       `db:"max_typing_delay_ms" json:"max_typing_delay_ms"`
+	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
 }
 
 // NotificationLog representa um log de notificação
@@ -0,0 +1,199 @@
+// internal/whatsapp/campaign.go
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-service/internal/database"
+)
+
+// Mensagens de cada campanha aguardando na fila de envio ao mesmo tempo.
+// Uma janela pequena faz pause/stop valerem quase imediatamente.
+const campaignQueueWindow = 20
+
+// CampaignRecipientInput é um destinatário informado na criação da campanha
+type CampaignRecipientInput struct {
+	To        string            `json:"to"`
+	Variables map[string]string `json:"variables"`
+}
+
+// normalizeRecipient aceita um JID ou apenas o número de telefone
+func normalizeRecipient(to string) (string, error) {
+	to = strings.TrimSpace(to)
+	if !strings.Contains(to, "@") {
+		to = strings.TrimPrefix(to, "+")
+		to = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(to)
+		to += "@" + types.DefaultUserServer
+	}
+
+	jid, err := types.ParseJID(to)
+	if err != nil || jid.User == "" {
+		return "", fmt.Errorf("destinatário inválido: %s", to)
+	}
+	return jid.String(), nil
+}
+
+// CreateCampaign cria uma campanha em draft. O template é renderizado para
+// cada destinatário na criação; destinatários inválidos ou sem alguma variável
+// ficam como skipped com o motivo, sem impedir o restante da campanha. Para
+// campanhas de mídia, template é a legenda e o arquivo é compartilhado por
+// todas as mensagens.
+func (m *Manager) CreateCampaign(deviceID int64, name string, template string, mimetype string, media []byte, inputs []CampaignRecipientInput) (*database.Campaign, error) {
+	device, err := m.db.GetDeviceByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("dispositivo não encontrado")
+	}
+
+	campaign := &database.Campaign{
+		DeviceID: deviceID,
+		Name:     name,
+		Kind:     database.OutboundKindText,
+		Template: template,
+	}
+
+	var payload database.OutboundPayload
+	if media != nil {
+		mediaPath, err := saveOutboundMedia(deviceID, media)
+		if err != nil {
+			return nil, err
+		}
+		campaign.Kind = database.OutboundKindMedia
+		payload = database.OutboundPayload{Mimetype: mimetype, MediaPath: mediaPath, Shared: true}
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar campanha: %w", err)
+	}
+	campaign.Payload = string(encoded)
+
+	seen := make(map[string]bool)
+	recipients := make([]database.CampaignRecipient, 0, len(inputs))
+	for _, input := range inputs {
+		recipient := database.CampaignRecipient{Recipient: input.To, Status: database.CampaignRecipientPending}
+
+		jid, err := normalizeRecipient(input.To)
+		if err == nil {
+			recipient.Recipient = jid
+			if seen[jid] {
+				err = fmt.Errorf("destinatário duplicado")
+			}
+			seen[jid] = true
+		}
+		if err == nil {
+			recipient.Content, err = RenderTemplate(template, input.Variables)
+		}
+		if err != nil {
+			recipient.Status = database.CampaignRecipientSkipped
+			recipient.ErrorMessage = err.Error()
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	if err := m.db.CreateCampaign(campaign, recipients); err != nil {
+		removeCampaignMedia(campaign)
+		return nil, err
+	}
+
+	return m.db.GetCampaign(campaign.ID)
+}
+
+// StartCampaign inicia uma campanha em draft
+func (m *Manager) StartCampaign(id int64) (bool, error) {
+	started, err := m.db.SetCampaignStatus(id, database.CampaignRunning, database.CampaignDraft)
+	if started {
+		m.AdvanceCampaigns()
+	}
+	return started, err
+}
+
+// PauseCampaign pausa uma campanha em andamento. Mensagens que ainda não
+// começaram a ser enviadas saem da fila e voltam a ficar pendentes.
+func (m *Manager) PauseCampaign(id int64) (bool, error) {
+	paused, err := m.db.SetCampaignStatus(id, database.CampaignPaused, database.CampaignRunning)
+	if err != nil || !paused {
+		return paused, err
+	}
+
+	return true, m.db.WithdrawCampaignMessages(id, database.CampaignRecipientPending)
+}
+
+// ResumeCampaign retoma uma campanha pausada
+func (m *Manager) ResumeCampaign(id int64) (bool, error) {
+	resumed, err := m.db.SetCampaignStatus(id, database.CampaignRunning, database.CampaignPaused)
+	if resumed {
+		m.AdvanceCampaigns()
+	}
+	return resumed, err
+}
+
+// StopCampaign interrompe a campanha de vez: destinatários pendentes e
+// mensagens ainda na fila ficam cancelled. A mídia só é apagada depois que as
+// mensagens já em envio terminarem.
+func (m *Manager) StopCampaign(id int64) (bool, error) {
+	stopped, err := m.db.SetCampaignStatus(id, database.CampaignStopped,
+		database.CampaignDraft, database.CampaignRunning, database.CampaignPaused)
+	if err != nil || !stopped {
+		return stopped, err
+	}
+
+	if err := m.db.WithdrawCampaignMessages(id, database.CampaignRecipientCancelled); err != nil {
+		return true, err
+	}
+
+	m.releaseCampaignMedia()
+	return true, nil
+}
+
+// AdvanceCampaigns coloca na fila de envio os próximos destinatários das
+// campanhas em andamento, conclui as que terminaram e apaga a mídia das
+// campanhas encerradas
+func (m *Manager) AdvanceCampaigns() {
+	completed, err := m.db.FeedRunningCampaigns(campaignQueueWindow)
+	if err != nil {
+		fmt.Printf("Erro ao processar campanhas: %v\n", err)
+		return
+	}
+
+	for i := range completed {
+		fmt.Printf("Campanha %d (%s) concluída\n", completed[i].ID, completed[i].Name)
+	}
+
+	m.releaseCampaignMedia()
+	m.wakeOutboundProcessor()
+}
+
+// releaseCampaignMedia apaga a mídia das campanhas concluídas ou interrompidas
+// que não têm mais mensagens na fila nem em envio
+func (m *Manager) releaseCampaignMedia() {
+	campaigns, err := m.db.ReleaseCampaignMedia()
+	if err != nil {
+		fmt.Printf("Erro ao liberar mídia das campanhas: %v\n", err)
+		return
+	}
+
+	for i := range campaigns {
+		removeCampaignMedia(&campaigns[i])
+	}
+}
+
+// removeCampaignMedia apaga o arquivo compartilhado de uma campanha de mídia
+func removeCampaignMedia(campaign *database.Campaign) {
+	var payload database.OutboundPayload
+	if json.Unmarshal([]byte(campaign.Payload), &payload) != nil || payload.MediaPath == "" {
+		return
+	}
+
+	if err := os.Remove(payload.MediaPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Aviso: erro ao remover mídia da campanha %d: %v\n", campaign.ID, err)
+	}
+}
@@ -0,0 +1,168 @@
+// internal/whatsapp/chats.go
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-service/internal/database"
+)
+
+// Erros de referência a mensagens, para a API escolher o status HTTP
+var (
+	ErrMessageNotFound       = errors.New("mensagem não encontrada no chat")
+	ErrMessageSenderRequired = errors.New("mensagem não armazenada: informe o autor (sender)")
+)
+
+// GetChat retorna a conversa com o contato ou grupo, ou nil se não existir
+func (c *Client) GetChat(chat string) (*database.Chat, error) {
+	jid, err := normalizeRecipient(chat)
+	if err != nil {
+		return nil, err
+	}
+	return c.DB.GetChat(c.DeviceID, jid)
+}
+
+// MarkChatRead envia confirmações de leitura das mensagens recebidas e não
+// lidas do chat até a mensagem informada (vazia = até a última) e avança o
+// cursor de leitura. Só as mensagens armazenadas são conhecidas; retorna
+// quantas foram marcadas.
+func (c *Client) MarkChatRead(chat string, upToMessageID string) (int, error) {
+	if !c.IsConnected() {
+		return 0, fmt.Errorf("cliente não está conectado")
+	}
+
+	normalized, err := normalizeRecipient(chat)
+	if err != nil {
+		return 0, err
+	}
+	chatJID, _ := types.ParseJID(normalized)
+
+	var until *time.Time
+	if upToMessageID != "" {
+		message, err := c.DB.GetMessage(c.DeviceID, upToMessageID)
+		if err != nil {
+			return 0, err
+		}
+		if message == nil || message.JID != normalized {
+			return 0, fmt.Errorf("%w: %s", ErrMessageNotFound, upToMessageID)
+		}
+		until = &message.Timestamp
+	}
+
+	messages, err := c.DB.GetUnreadMessages(c.DeviceID, normalized, until)
+	if err != nil {
+		return 0, err
+	}
+
+	// Em grupos, cada confirmação só pode cobrir mensagens de um mesmo remetente
+	var senders []string
+	bySender := make(map[string][]types.MessageID)
+	for _, message := range messages {
+		sender := ""
+		if message.IsGroup {
+			sender = message.Sender
+		}
+		if _, exists := bySender[sender]; !exists {
+			senders = append(senders, sender)
+		}
+		bySender[sender] = append(bySender[sender], message.MessageID)
+	}
+
+	now := time.Now()
+	for _, sender := range senders {
+		senderJID := types.EmptyJID
+		if sender != "" {
+			if senderJID, err = types.ParseJID(sender); err != nil {
+				return 0, fmt.Errorf("remetente inválido %s: %w", sender, err)
+			}
+		}
+		if err := c.Client.MarkRead(bySender[sender], now, chatJID, senderJID); err != nil {
+			return 0, fmt.Errorf("falha ao enviar confirmação de leitura: %w", err)
+		}
+	}
+
+	// O cursor avança até a mensagem pedida, mesmo que já estivesse lida
+	switch {
+	case upToMessageID != "":
+		err = c.DB.SaveChatReadCursor(c.DeviceID, normalized, upToMessageID, *until)
+	case len(messages) > 0:
+		last := messages[len(messages)-1]
+		err = c.DB.SaveChatReadCursor(c.DeviceID, normalized, last.MessageID, last.Timestamp)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("erro ao gravar cursor de leitura: %w", err)
+	}
+
+	return len(messages), nil
+}
+
+// handleSelfRead avança o cursor de leitura quando o próprio número lê
+// mensagens em outro aparelho
+func (h *EventHandler) handleSelfRead(deviceID int64, receipt *events.Receipt) {
+	if err := h.DB.MarkMessagesRead(deviceID, receipt.MessageIDs); err != nil {
+		fmt.Printf("Erro ao atualizar leitura do chat %s: %v\n", receipt.Chat, err)
+	}
+}
+
+// updateChatActivity registra a mensagem como a última do chat. A prévia só é
+// gravada para mensagens armazenadas conforme a política do tenant.
+func updateChatActivity(db *database.DB, message *database.WhatsAppMessage, stored bool) {
+	preview := ""
+	if stored {
+		preview = database.ChatPreview(message.Content, message.MediaType)
+	}
+
+	if err := db.SaveChatActivity(message.DeviceID, message.JID, message.MessageID, preview, message.IsFromMe, message.Timestamp); err != nil {
+		fmt.Printf("Erro ao atualizar chat %s: %v\n", message.JID, err)
+	}
+}
+
+// handleArchive grava o chat como arquivado ou desarquivado no celular
+func (h *EventHandler) handleArchive(deviceID int64, evt *events.Archive) {
+	jid := h.resolveContactID(evt.JID, types.EmptyJID)
+	if err := h.DB.SetChatArchived(deviceID, jid, evt.Action.GetArchived()); err != nil {
+		fmt.Printf("Erro ao arquivar chat %s: %v\n", jid, err)
+	}
+}
+
+// handlePin grava o chat como fixado ou desafixado no celular
+func (h *EventHandler) handlePin(deviceID int64, evt *events.Pin) {
+	jid := h.resolveContactID(evt.JID, types.EmptyJID)
+	if err := h.DB.SetChatPinned(deviceID, jid, evt.Action.GetPinned()); err != nil {
+		fmt.Printf("Erro ao fixar chat %s: %v\n", jid, err)
+	}
+}
+
+// handleMute grava o chat como silenciado no celular. Sem prazo informado, o
+// chat fica silenciado até ser reativado.
+func (h *EventHandler) handleMute(deviceID int64, evt *events.Mute) {
+	jid := h.resolveContactID(evt.JID, types.EmptyJID)
+
+	var until *time.Time
+	if end := evt.Action.GetMuteEndTimestamp(); evt.Action.GetMuted() && end > 0 {
+		muteEnd := time.UnixMilli(end)
+		until = &muteEnd
+	}
+
+	if err := h.DB.SetChatMuted(deviceID, jid, evt.Action.GetMuted(), until); err != nil {
+		fmt.Printf("Erro ao silenciar chat %s: %v\n", jid, err)
+	}
+}
+
+// BackfillChats cria a partir do histórico armazenado as conversas anteriores
+// à lista de chats
+func (m *Manager) BackfillChats() {
+	created, err := m.db.BackfillChats()
+	if err != nil {
+		fmt.Printf("Erro ao criar chats a partir do histórico: %v\n", err)
+		return
+	}
+	if created > 0 {
+		fmt.Printf("%d chats criados a partir do histórico de mensagens\n", created)
+	}
+}
@@ -37,6 +37,9 @@ func NewClient(deviceID int64, tenantID int64, deviceStore *store.Device, db *da
 	//TODO func NewClient(deviceID int64, tenantID int64, deviceStore *store.Device, db *database.DB, logger waLog.Logger, deviceName string) *Client {
 
 	waClient := whatsmeow.NewClient(deviceStore, logger)
+	// Emitir os eventos de app state também na sincronização completa após o
+	// pareamento, para que os chats recebam os estados arquivado, fixado e silenciado
+	waClient.EmitAppStateEventsOnFullSync = true
 	// Configurar propriedades do dispositivo
 	//waClient.Store.CompanionProps.Os = proto.String(deviceName)
 	//arquivo interno que seta o nome do dispositivo (linha 127)
@@ -154,6 +157,8 @@ func (c *Client) SendTextMessage(to string, text string) (string, error) {
 		return "", fmt.Errorf("falha ao enviar mensagem: %w", err)
 	}
 
+	c.saveSentMessage(recipient, resp, &database.WhatsAppMessage{Content: text}, nil)
+
 	return resp.ID, nil
 }
 
@@ -290,30 +295,8 @@ func (c *Client) AddEventHandler(handler func(evt interface{})) {
 	c.EventHandlers = append(c.EventHandlers, handler)
 }
 
-// GetGroups obtém a lista de grupos do cliente
-func (c *Client) GetGroups() ([]*types.GroupInfo, error) {
-	if !c.IsConnected() {
-		return nil, fmt.Errorf("cliente não está conectado")
-	}
-
-	return c.Client.GetJoinedGroups()
-}
-
-// GetContacts obtém a lista de contatos do cliente
-func (c *Client) GetContacts() (map[types.JID]types.ContactInfo, error) {
-	if !c.IsConnected() {
-		return nil, fmt.Errorf("cliente não está conectado")
-	}
-
-	// Adicionar context com timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	return c.Client.Store.Contacts.GetAllContacts(ctx)
-}
-
-// GetGroupMessages obtém mensagens de um grupo específico
-func (c *Client) GetGroupMessages(groupID string, filter string) ([]database.WhatsAppMessage, error) {
+// GetGroupMessages obtém uma página do histórico de um grupo específico
+func (c *Client) GetGroupMessages(groupID string, query database.MessageQuery) (*database.MessagePage, error) {
 	if !c.IsConnected() {
 		return nil, fmt.Errorf("cliente não está conectado")
 	}
@@ -330,11 +313,13 @@ func (c *Client) GetGroupMessages(groupID string, filter string) ([]database.Wha
 	}
 
 	// Buscar mensagens do banco de dados
-	return c.DB.GetMessages(c.DeviceID, groupID, filter)
+	query.DeviceID = c.DeviceID
+	query.JID = groupID
+	return c.DB.GetMessages(query)
 }
 
-// GetContactMessages obtém mensagens de um contato específico
-func (c *Client) GetContactMessages(contactID string, filter string) ([]database.WhatsAppMessage, error) {
+// GetContactMessages obtém uma página do histórico de um contato específico
+func (c *Client) GetContactMessages(contactID string, query database.MessageQuery) (*database.MessagePage, error) {
 	if !c.IsConnected() {
 		return nil, fmt.Errorf("cliente não está conectado")
 	}
@@ -351,7 +336,9 @@ func (c *Client) GetContactMessages(contactID string, filter string) ([]database
 	}
 
 	// Buscar mensagens do banco de dados
-	return c.DB.GetMessages(c.DeviceID, contactID, filter)
+	query.DeviceID = c.DeviceID
+	query.JID = contactID
+	return c.DB.GetMessages(query)
 }
 
 // SendGroupMessage envia uma mensagem para um grupo
@@ -381,38 +368,60 @@ func (c *Client) SendGroupMessage(groupID string, text string) (string, error) {
 		return "", fmt.Errorf("falha ao enviar mensagem: %w", err)
 	}
 
+	c.saveSentMessage(jid, resp, &database.WhatsAppMessage{Content: text}, nil)
+
 	return resp.ID, nil
 }
 
 // SendMediaMessage envia uma mensagem com mídia para um contato ou grupo
-func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, caption string) (string, error) {
+func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, caption string, options MediaOptions) (string, error) {
 	if !c.IsConnected() {
 		return "", fmt.Errorf("cliente não está conectado")
 	}
 
-	recipient, err := types.ParseJID(to)
-	if err != nil {
+	if _, err := types.ParseJID(to); err != nil {
 		return "", fmt.Errorf("JID inválido: %w", err)
 	}
 
-	// Converter a string mediaType para o tipo adequado
-	var mediaTypeEnum whatsmeow.MediaType
-	switch mediaType {
-	case "image/jpeg", "image/png", "image/gif":
-		mediaTypeEnum = whatsmeow.MediaImage
-	case "video/mp4":
-		mediaTypeEnum = whatsmeow.MediaVideo
-	case "audio/ogg", "audio/mpeg", "audio/mp4":
-		mediaTypeEnum = whatsmeow.MediaAudio
-	default:
-		mediaTypeEnum = whatsmeow.MediaDocument
+	uploaded, err := c.UploadMedia(mediaType, data)
+	if err != nil {
+		return "", err
 	}
 
-	uploaded, err := c.Client.Upload(context.Background(), data, mediaTypeEnum)
+	return c.SendUploadedMedia(to, mediaType, uploaded, caption, data, options)
+}
+
+// UploadMedia criptografa e envia a mídia para o servidor do WhatsApp. O
+// resultado pode ser usado em várias mensagens com SendUploadedMedia.
+func (c *Client) UploadMedia(mediaType string, data []byte) (whatsmeow.UploadResponse, error) {
+	if !c.IsConnected() {
+		return whatsmeow.UploadResponse{}, fmt.Errorf("cliente não está conectado")
+	}
+
+	uploaded, err := c.Client.Upload(context.Background(), data, whatsmeowMediaType(mediaType))
 	if err != nil {
-		return "", fmt.Errorf("falha ao fazer upload da mídia: %w", err)
+		return uploaded, fmt.Errorf("falha ao fazer upload da mídia: %w", err)
+	}
+
+	return uploaded, nil
+}
+
+// SendUploadedMedia envia uma mídia que já está no servidor do WhatsApp, sem
+// novo upload. data é usado para extrair dimensões, duração e miniatura e
+// para a cópia guardada no histórico; se nil, a mensagem vai sem esses dados.
+func (c *Client) SendUploadedMedia(to string, mediaType string, uploaded whatsmeow.UploadResponse, caption string, data []byte, options MediaOptions) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
 	}
 
+	recipient, err := types.ParseJID(to)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	mediaTypeEnum := whatsmeowMediaType(mediaType)
+	meta := describeMedia(mediaTypeEnum, data)
+
 	var msg *waProto.Message
 
 	switch mediaTypeEnum {
@@ -427,6 +436,9 @@ func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, capt
 				FileEncSHA256: uploaded.FileEncSHA256,
 				MediaKey:      uploaded.MediaKey,
 				DirectPath:    proto.String(uploaded.DirectPath),
+				Width:         optionalUint32(meta.Width),
+				Height:        optionalUint32(meta.Height),
+				JPEGThumbnail: meta.Thumbnail,
 			},
 		}
 	case whatsmeow.MediaVideo:
@@ -440,6 +452,10 @@ func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, capt
 				FileEncSHA256: uploaded.FileEncSHA256,
 				MediaKey:      uploaded.MediaKey,
 				DirectPath:    proto.String(uploaded.DirectPath),
+				Width:         optionalUint32(meta.Width),
+				Height:        optionalUint32(meta.Height),
+				Seconds:       optionalUint32(meta.Seconds),
+				JPEGThumbnail: meta.Thumbnail,
 			},
 		}
 	case whatsmeow.MediaAudio:
@@ -452,15 +468,19 @@ func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, capt
 				FileEncSHA256: uploaded.FileEncSHA256,
 				MediaKey:      uploaded.MediaKey,
 				DirectPath:    proto.String(uploaded.DirectPath),
+				Seconds:       optionalUint32(meta.Seconds),
+				PTT:           proto.Bool(options.VoiceNote),
 			},
 		}
 	default:
 		// Para outros tipos de arquivos, usar DocumentMessage
+		fileName := documentFileName(options.FileName, mediaType)
 		msg = &waProto.Message{
 			DocumentMessage: &waProto.DocumentMessage{
 				URL:           proto.String(uploaded.URL),
 				Mimetype:      proto.String(mediaType),
-				Title:         proto.String(caption),
+				Title:         proto.String(fileName),
+				FileName:      proto.String(fileName),
 				FileLength:    proto.Uint64(uploaded.FileLength),
 				FileSHA256:    uploaded.FileSHA256,
 				FileEncSHA256: uploaded.FileEncSHA256,
@@ -468,6 +488,9 @@ func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, capt
 				DirectPath:    proto.String(uploaded.DirectPath),
 			},
 		}
+		if caption != "" {
+			msg.DocumentMessage.Caption = proto.String(caption)
+		}
 	}
 
 	resp, err := c.Client.SendMessage(context.Background(), recipient, msg)
@@ -475,5 +498,309 @@ func (c *Client) SendMediaMessage(to string, mediaType string, data []byte, capt
 		return "", fmt.Errorf("falha ao enviar mensagem: %w", err)
 	}
 
+	c.saveSentMessage(recipient, resp, &database.WhatsAppMessage{Content: caption, MediaType: storedMediaType(mediaTypeEnum)}, data)
+
+	return resp.ID, nil
+}
+
+// optionalUint32 omite do protobuf valores desconhecidos (zero)
+func optionalUint32(value uint32) *uint32 {
+	if value == 0 {
+		return nil
+	}
+	return proto.Uint32(value)
+}
+
+// SendReply envia uma mensagem de texto citando (respondendo) uma mensagem anterior.
+// quotedSender é o autor da mensagem citada; se vazio, é obtido do histórico
+// armazenado e é obrigatório se a mensagem não está armazenada.
+func (c *Client) SendReply(to string, text string, quotedMessageID string, quotedSender string) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	recipient, err := types.ParseJID(to)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	contextInfo := &waProto.ContextInfo{
+		StanzaID: proto.String(quotedMessageID),
+	}
+
+	// O WhatsApp exibe a citação a partir do conteúdo enviado junto; usar o histórico quando disponível
+	quoted, err := c.DB.GetMessage(c.DeviceID, quotedMessageID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar mensagem citada %s: %v\n", quotedMessageID, err)
+	}
+	if quoted != nil {
+		contextInfo.QuotedMessage = quotedMessageContent(quoted)
+	}
+
+	participant, err := c.messageAuthor(quoted, quotedSender)
+	if err != nil {
+		return "", err
+	}
+	contextInfo.Participant = proto.String(participant.String())
+
+	msg := &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: contextInfo,
+		},
+	}
+
+	resp, err := c.Client.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		return "", fmt.Errorf("falha ao enviar mensagem: %w", err)
+	}
+
+	c.saveSentMessage(recipient, resp, &database.WhatsAppMessage{Content: text, QuotedMessageID: quotedMessageID}, nil)
+
+	return resp.ID, nil
+}
+
+// SendReaction reage a uma mensagem. Uma reação vazia remove a reação anterior.
+// sender é o autor da mensagem; se vazio, é obtido do histórico armazenado e é
+// obrigatório se a mensagem não está armazenada.
+func (c *Client) SendReaction(chat string, messageID string, sender string, reaction string) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	chatJID, err := types.ParseJID(chat)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	senderJID, err := c.storedMessageAuthor(messageID, sender)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Client.SendMessage(context.Background(), chatJID, c.Client.BuildReaction(chatJID, senderJID, messageID, reaction))
+	if err != nil {
+		return "", fmt.Errorf("falha ao enviar reação: %w", err)
+	}
+
+	if c.Client.Store.ID != nil {
+		err = c.DB.SaveMessageReaction(&database.MessageReaction{
+			DeviceID:  c.DeviceID,
+			MessageID: messageID,
+			Chat:      chatJID.String(),
+			Sender:    c.Client.Store.ID.ToNonAD().String(),
+			Reaction:  reaction,
+			Timestamp: resp.Timestamp,
+		})
+		if err != nil {
+			fmt.Printf("Erro ao registrar reação à mensagem %s: %v\n", messageID, err)
+		}
+	}
+
+	return resp.ID, nil
+}
+
+// EditMessage edita o texto de uma mensagem enviada por este número.
+// O WhatsApp só aceita edições até whatsmeow.EditWindow após o envio.
+func (c *Client) EditMessage(chat string, messageID string, text string) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	chatJID, err := types.ParseJID(chat)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	newContent := &waProto.Message{Conversation: proto.String(text)}
+
+	resp, err := c.Client.SendMessage(context.Background(), chatJID, c.Client.BuildEdit(chatJID, messageID, newContent))
+	if err != nil {
+		return "", fmt.Errorf("falha ao editar mensagem: %w", err)
+	}
+
+	if _, err := c.DB.EditMessage(c.DeviceID, messageID, c.ownJID(), true, text, resp.Timestamp); err != nil {
+		fmt.Printf("Erro ao registrar edição da mensagem %s: %v\n", messageID, err)
+	}
+
 	return resp.ID, nil
 }
+
+// RevokeMessage apaga uma mensagem para todos. Mensagens de outros participantes
+// só podem ser apagadas em grupos onde este número é administrador.
+// sender é o autor da mensagem; se vazio, é obtido do histórico armazenado e é
+// obrigatório (o próprio número, para as mensagens dele) se a mensagem não está armazenada.
+func (c *Client) RevokeMessage(chat string, messageID string, sender string) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	chatJID, err := types.ParseJID(chat)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	senderJID, err := c.storedMessageAuthor(messageID, sender)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Client.SendMessage(context.Background(), chatJID, c.Client.BuildRevoke(chatJID, senderJID, messageID))
+	if err != nil {
+		return "", fmt.Errorf("falha ao apagar mensagem: %w", err)
+	}
+
+	if _, err := c.DB.RevokeMessage(c.DeviceID, messageID, c.ownJID(), true, resp.Timestamp); err != nil {
+		fmt.Printf("Erro ao registrar exclusão da mensagem %s: %v\n", messageID, err)
+	}
+
+	return resp.ID, nil
+}
+
+// ownJID retorna o JID do número deste dispositivo, ou vazio se não pareado
+func (c *Client) ownJID() string {
+	if c.Client.Store.ID == nil {
+		return ""
+	}
+	return c.Client.Store.ID.ToNonAD().String()
+}
+
+// storedMessageAuthor resolve o autor de uma mensagem: o informado ou o
+// registrado no histórico. Sem nenhum dos dois retorna ErrMessageSenderRequired.
+func (c *Client) storedMessageAuthor(messageID string, sender string) (types.JID, error) {
+	var stored *database.WhatsAppMessage
+	if sender == "" {
+		var err error
+		if stored, err = c.DB.GetMessage(c.DeviceID, messageID); err != nil {
+			return types.EmptyJID, fmt.Errorf("erro ao buscar mensagem %s: %w", messageID, err)
+		}
+	}
+
+	return c.messageAuthor(stored, sender)
+}
+
+// messageAuthor converte o autor informado em JID. Vazio usa o autor da
+// mensagem armazenada (stored), que pode ser este próprio número.
+func (c *Client) messageAuthor(stored *database.WhatsAppMessage, sender string) (types.JID, error) {
+	if sender == "" {
+		switch {
+		case stored == nil:
+			return types.EmptyJID, ErrMessageSenderRequired
+		case stored.IsFromMe:
+			if c.Client.Store.ID == nil {
+				return types.EmptyJID, nil
+			}
+			return c.Client.Store.ID.ToNonAD(), nil
+		default:
+			sender = stored.Sender
+		}
+	}
+
+	jid, err := types.ParseJID(sender)
+	if err != nil {
+		return types.EmptyJID, fmt.Errorf("JID do autor inválido: %w", err)
+	}
+
+	return jid, nil
+}
+
+// quotedMessageContent monta o conteúdo exibido na citação a partir da
+// mensagem armazenada. Mídias são citadas pelo tipo e pela legenda, sem o
+// arquivo; tipos sem equivalente simples retornam nil (citação sem prévia).
+func quotedMessageContent(quoted *database.WhatsAppMessage) *waProto.Message {
+	caption := proto.String(quoted.Content)
+	switch quoted.MediaType {
+	case "":
+		return &waProto.Message{Conversation: caption}
+	case "image":
+		return &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: caption}}
+	case "video":
+		return &waProto.Message{VideoMessage: &waProto.VideoMessage{Caption: caption}}
+	case "document":
+		return &waProto.Message{DocumentMessage: &waProto.DocumentMessage{Caption: caption}}
+	case "audio":
+		return &waProto.Message{AudioMessage: &waProto.AudioMessage{}}
+	case "sticker":
+		return &waProto.Message{StickerMessage: &waProto.StickerMessage{}}
+	default:
+		return nil
+	}
+}
+
+// whatsmeowMediaType escolhe o tipo de mídia do WhatsApp pelo mimetype;
+// formatos não reconhecidos são enviados como documento
+func whatsmeowMediaType(mimetype string) whatsmeow.MediaType {
+	switch baseMimetype(mimetype) {
+	case "image/jpeg", "image/png", "image/gif":
+		return whatsmeow.MediaImage
+	case "video/mp4":
+		return whatsmeow.MediaVideo
+	case "audio/ogg", "audio/mpeg", "audio/mp4":
+		return whatsmeow.MediaAudio
+	default:
+		return whatsmeow.MediaDocument
+	}
+}
+
+// storedMediaType converte o tipo de upload do whatsmeow para o media_type armazenado
+func storedMediaType(mediaType whatsmeow.MediaType) string {
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return "image"
+	case whatsmeow.MediaVideo:
+		return "video"
+	case whatsmeow.MediaAudio:
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+// saveSentMessage registra no histórico uma mensagem enviada pela API, com o ID
+// retornado pelo WhatsApp, conforme a política de armazenamento do tenant, e
+// atualiza a última atividade do chat.
+// message traz o conteúdo (texto, tipo de mídia, mensagem citada); os demais
+// campos são preenchidos aqui. Falhas são apenas registradas em log: o envio já foi concluído.
+func (c *Client) saveSentMessage(chat types.JID, resp whatsmeow.SendResponse, message *database.WhatsAppMessage, media []byte) {
+	policy, err := c.DB.GetMessageRetentionPolicy(c.TenantID)
+	if err != nil {
+		fmt.Printf("Erro ao obter política de retenção do tenant %d: %v\n", c.TenantID, err)
+		policy = database.DefaultMessageRetentionPolicy(c.TenantID)
+	}
+
+	isGroup := chat.Server == types.GroupServer
+	isTracked := false
+	if isGroup {
+		tracked, err := c.DB.GetTrackedEntity(c.DeviceID, chat.String())
+		isTracked = err == nil && tracked.IsTracked
+	}
+
+	message.DeviceID = c.DeviceID
+	message.JID = chat.String()
+	message.MessageID = resp.ID
+	message.Sender = c.ownJID()
+	message.IsFromMe = true
+	message.IsGroup = isGroup
+	message.Timestamp = resp.Timestamp
+	message.Status = database.MessageStatusServerAck // SendMessage só retorna após o ack do servidor
+
+	stored := policy.ShouldStore(isGroup, true, isTracked)
+	updateChatActivity(c.DB, message, stored)
+	if !stored {
+		return
+	}
+
+	// Guardar uma cópia da mídia enviada como referência da mensagem
+	if len(media) > 0 && c.manager != nil && c.manager.eventHandler != nil {
+		mediaURL, err := c.manager.eventHandler.storeMedia(c.DeviceID, resp.ID, message.MediaType, media, "")
+		if err != nil {
+			fmt.Printf("Erro ao armazenar mídia enviada %s: %v\n", resp.ID, err)
+		} else {
+			message.MediaURL = mediaURL
+		}
+	}
+
+	if err := c.DB.SaveMessage(message); err != nil {
+		fmt.Printf("Erro ao salvar mensagem enviada %s: %v\n", resp.ID, err)
+	}
+}
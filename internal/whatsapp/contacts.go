@@ -0,0 +1,369 @@
+// internal/whatsapp/contacts.go
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-service/internal/database"
+)
+
+// Quantidade máxima de números por verificação
+const contactCheckMaxPhones = 500
+
+// Tamanho máximo de uma foto de perfil baixada
+const profilePictureMaxBytes = 5 << 20
+
+// Por quanto tempo a foto de perfil guardada é usada sem consultar o WhatsApp.
+// Trocas de foto avisadas pelo WhatsApp invalidam a cópia antes disso.
+const profilePictureTTL = 24 * time.Hour
+
+// Diretório das fotos de perfil, dentro de ./storage (servido em /media)
+const profilePictureDir = "media/avatars"
+
+// ContactCheckResult é o resultado da verificação de um número no WhatsApp
+type ContactCheckResult struct {
+	Phone        string `json:"phone"`         // Número como informado
+	JID          string `json:"jid,omitempty"` // JID a usar no envio; pode diferir do número informado
+	OnWhatsApp   bool   `json:"on_whatsapp"`
+	IsBusiness   bool   `json:"is_business"`
+	BusinessName string `json:"business_name,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CheckContacts verifica quais números têm conta no WhatsApp. Os resultados
+// seguem a ordem dos números informados e são gravados no diretório.
+func (c *Client) CheckContacts(phones []string) ([]ContactCheckResult, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("cliente não está conectado")
+	}
+	if len(phones) == 0 {
+		return nil, fmt.Errorf("informe ao menos um número")
+	}
+	if len(phones) > contactCheckMaxPhones {
+		return nil, fmt.Errorf("no máximo %d números por verificação", contactCheckMaxPhones)
+	}
+
+	results := make([]ContactCheckResult, len(phones))
+	positions := make(map[string][]int) // Número normalizado -> posições em phones
+	queries := []string{}
+	for i, phone := range phones {
+		results[i].Phone = phone
+
+		digits := phoneDigits(phone)
+		if len(digits) < 8 || len(digits) > 15 {
+			results[i].Error = "número inválido"
+			continue
+		}
+		if _, ok := positions[digits]; !ok {
+			queries = append(queries, "+"+digits)
+		}
+		positions[digits] = append(positions[digits], i)
+	}
+
+	if len(queries) == 0 {
+		return results, nil
+	}
+
+	responses, err := c.Client.IsOnWhatsApp(queries)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao verificar números: %w", err)
+	}
+
+	for _, response := range responses {
+		result := ContactCheckResult{
+			JID:        response.JID.String(),
+			OnWhatsApp: response.IsIn,
+		}
+		if response.VerifiedName != nil && response.VerifiedName.Details != nil {
+			result.BusinessName = response.VerifiedName.Details.GetVerifiedName()
+			result.IsBusiness = result.BusinessName != ""
+		}
+
+		if err := c.DB.SaveContactCheck(c.DeviceID, result.JID, result.OnWhatsApp, result.BusinessName); err != nil {
+			fmt.Printf("Erro ao gravar verificação de %s: %v\n", result.JID, err)
+		}
+
+		for _, i := range positions[phoneDigits(response.Query)] {
+			result.Phone = results[i].Phone
+			results[i] = result
+		}
+	}
+
+	// Números sem resposta do WhatsApp
+	for i := range results {
+		if results[i].JID == "" && results[i].Error == "" {
+			results[i].Error = "sem resposta do WhatsApp para este número"
+		}
+	}
+
+	return results, nil
+}
+
+// GetContact retorna um contato do diretório pelo JID ou número, ou nil se
+// o contato não estiver no diretório
+func (c *Client) GetContact(contactID string) (*database.Contact, error) {
+	jid, err := normalizeRecipient(contactID)
+	if err != nil {
+		return nil, err
+	}
+	return c.DB.GetContact(c.DeviceID, jid)
+}
+
+// GetContactPicture baixa a foto de perfil do contato para ./storage/media/avatars
+// e retorna o contato atualizado. A foto guardada é reaproveitada por
+// profilePictureTTL; com refresh, o WhatsApp é sempre consultado.
+func (c *Client) GetContactPicture(contactID string, refresh bool) (*database.Contact, error) {
+	normalized, err := normalizeRecipient(contactID)
+	if err != nil {
+		return nil, err
+	}
+	jid, _ := types.ParseJID(normalized)
+
+	contact, err := c.DB.GetContact(c.DeviceID, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if !refresh && contact != nil && contact.PictureCheckedAt != nil && time.Since(*contact.PictureCheckedAt) < profilePictureTTL {
+		return contact, nil
+	}
+
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("cliente não está conectado")
+	}
+
+	// Com o ID da foto guardada, o WhatsApp só responde a foto se ela mudou
+	params := &whatsmeow.GetProfilePictureParams{}
+	if contact != nil && contact.PictureID != "" && storedFileExists(contact.PicturePath) {
+		params.ExistingID = contact.PictureID
+	}
+
+	info, err := c.Client.GetProfilePictureInfo(jid, params)
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet), errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized):
+		// Sem foto, ou escondida deste dispositivo
+		if contact != nil {
+			removeProfilePicture(contact.PicturePath)
+		}
+		err = c.DB.SaveContactPicture(c.DeviceID, normalized, "", "")
+	case err != nil:
+		return nil, fmt.Errorf("falha ao obter foto de perfil: %w", err)
+	case info == nil:
+		// Foto inalterada
+		err = c.DB.SaveContactPicture(c.DeviceID, normalized, contact.PictureID, contact.PicturePath)
+	default:
+		var picturePath string
+		picturePath, err = saveProfilePicture(c.DeviceID, jid, info)
+		if err != nil {
+			return nil, err
+		}
+		if contact != nil && contact.PicturePath != picturePath {
+			removeProfilePicture(contact.PicturePath)
+		}
+		err = c.DB.SaveContactPicture(c.DeviceID, normalized, info.ID, picturePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.DB.GetContact(c.DeviceID, normalized)
+}
+
+// GetContactBusinessProfile consulta o perfil comercial do contato e o grava
+// no diretório
+func (c *Client) GetContactBusinessProfile(contactID string) (*database.Contact, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("cliente não está conectado")
+	}
+
+	normalized, err := normalizeRecipient(contactID)
+	if err != nil {
+		return nil, err
+	}
+	jid, _ := types.ParseJID(normalized)
+
+	profile, err := c.Client.GetBusinessProfile(jid)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao obter perfil comercial: %w", err)
+	}
+
+	if err := c.DB.SaveContactBusinessProfile(c.DeviceID, normalized, businessProfile(profile)); err != nil {
+		return nil, err
+	}
+	return c.DB.GetContact(c.DeviceID, normalized)
+}
+
+// ImportStoreContacts grava no diretório os nomes que o whatsmeow já conhece
+func (c *Client) ImportStoreContacts() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stored, err := c.Client.Store.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		return fmt.Errorf("falha ao ler contatos: %w", err)
+	}
+
+	contacts := make([]database.Contact, 0, len(stored))
+	for jid, info := range stored {
+		contacts = append(contacts, database.Contact{
+			JID:          jid.ToNonAD().String(),
+			FullName:     info.FullName,
+			FirstName:    info.FirstName,
+			PushName:     info.PushName,
+			BusinessName: info.BusinessName,
+		})
+	}
+
+	return c.DB.ImportContacts(c.DeviceID, contacts)
+}
+
+// businessProfile converte o perfil comercial do WhatsApp
+func businessProfile(profile *types.BusinessProfile) *database.BusinessProfile {
+	converted := &database.BusinessProfile{
+		Address:       profile.Address,
+		Email:         profile.Email,
+		Options:       profile.ProfileOptions,
+		HoursTimezone: profile.BusinessHoursTimeZone,
+	}
+	for _, category := range profile.Categories {
+		converted.Categories = append(converted.Categories, category.Name)
+	}
+	for _, hours := range profile.BusinessHours {
+		converted.Hours = append(converted.Hours, database.BusinessHoursEntry{
+			DayOfWeek: hours.DayOfWeek,
+			Mode:      hours.Mode,
+			OpenTime:  hours.OpenTime,
+			CloseTime: hours.CloseTime,
+		})
+	}
+	return converted
+}
+
+// saveProfilePicture baixa a foto de perfil e retorna o caminho relativo a
+// ./storage. O nome inclui o ID da foto, então trocas geram um arquivo novo.
+func saveProfilePicture(deviceID int64, jid types.JID, info *types.ProfilePictureInfo) (string, error) {
+	data, _, _, err := fetchHTTPS(info.URL, profilePictureMaxBytes)
+	if err != nil {
+		return "", fmt.Errorf("erro ao baixar foto de perfil: %w", err)
+	}
+
+	picturePath := filepath.Join(profilePictureDir, fmt.Sprintf("%d_%s_%s.jpg", deviceID, jid.User, info.ID))
+	fullPath := filepath.Join("./storage", picturePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("erro ao criar diretório: %w", err)
+	}
+	if err := os.WriteFile(fullPath, data, 0644); err != nil {
+		return "", fmt.Errorf("erro ao salvar foto de perfil: %w", err)
+	}
+
+	return picturePath, nil
+}
+
+// storedFileExists indica se um arquivo relativo a ./storage existe
+func storedFileExists(relativePath string) bool {
+	if relativePath == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("./storage", relativePath))
+	return err == nil
+}
+
+// removeProfilePicture remove uma foto de perfil guardada em ./storage
+func removeProfilePicture(relativePath string) {
+	cleaned := filepath.Clean(relativePath)
+	if relativePath == "" || !strings.HasPrefix(cleaned, profilePictureDir+string(filepath.Separator)) {
+		return
+	}
+	if err := os.Remove(filepath.Join("./storage", cleaned)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Aviso: erro ao remover foto %s: %v\n", relativePath, err)
+	}
+}
+
+// phoneDigits mantém apenas os dígitos de um número de telefone
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// handlePushName grava o nome que o contato definiu para si
+func (h *EventHandler) handlePushName(deviceID int64, evt *events.PushName) {
+	altJID := types.EmptyJID
+	if evt.Message != nil {
+		altJID = evt.Message.SenderAlt
+	}
+
+	jid := h.resolveContactID(evt.JID, altJID)
+	if err := h.DB.SaveContactPushName(deviceID, jid, evt.NewPushName); err != nil {
+		fmt.Printf("Erro ao gravar nome de %s: %v\n", jid, err)
+	}
+}
+
+// handleBusinessName grava o nome verificado de uma conta comercial
+func (h *EventHandler) handleBusinessName(deviceID int64, evt *events.BusinessName) {
+	altJID := types.EmptyJID
+	if evt.Message != nil {
+		altJID = evt.Message.SenderAlt
+	}
+
+	jid := h.resolveContactID(evt.JID, altJID)
+	if err := h.DB.SaveContactBusinessName(deviceID, jid, evt.NewBusinessName); err != nil {
+		fmt.Printf("Erro ao gravar nome comercial de %s: %v\n", jid, err)
+	}
+}
+
+// handleContact grava os nomes da agenda do celular, sincronizados pelo WhatsApp
+func (h *EventHandler) handleContact(deviceID int64, evt *events.Contact) {
+	if evt.Action == nil {
+		return
+	}
+
+	jid := evt.JID.ToNonAD().String()
+	if err := h.DB.SaveContactNames(deviceID, jid, evt.Action.GetFullName(), evt.Action.GetFirstName()); err != nil {
+		fmt.Printf("Erro ao gravar contato %s: %v\n", jid, err)
+	}
+
+	// A agenda informa o LID dos contatos com número
+	if evt.JID.Server == types.DefaultUserServer && evt.Action.GetLidJID() != "" {
+		if lid, err := types.ParseJID(evt.Action.GetLidJID()); err == nil && lid.Server == types.HiddenUserServer {
+			h.learnLIDMapping(lid, evt.JID, database.LIDSourceContact)
+		}
+	}
+}
+
+// handlePicture invalida a foto de perfil guardada de um contato que trocou
+// ou removeu a foto; a nova é baixada na próxima consulta
+func (h *EventHandler) handlePicture(deviceID int64, evt *events.Picture) {
+	if evt.JID.Server == types.GroupServer {
+		return
+	}
+
+	jid := h.resolveContactID(evt.JID, types.EmptyJID)
+	if err := h.DB.ExpireContactPicture(deviceID, jid); err != nil {
+		fmt.Printf("Erro ao invalidar foto de %s: %v\n", jid, err)
+	}
+}
+
+// importContactsOnConnect grava no diretório os contatos conhecidos após conectar
+func (h *EventHandler) importContactsOnConnect(deviceID int64) {
+	client, err := h.Manager.GetClient(deviceID)
+	if err != nil {
+		return
+	}
+
+	if err := client.ImportStoreContacts(); err != nil {
+		fmt.Printf("Erro ao importar contatos do dispositivo %d: %v\n", deviceID, err)
+	}
+}
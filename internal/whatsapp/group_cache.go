@@ -0,0 +1,294 @@
+// internal/whatsapp/group_cache.go
+package whatsapp
+
+import (
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-service/internal/database"
+	"whatsapp-service/pkg/webhook"
+)
+
+// GetGroups lista os grupos do dispositivo a partir da cópia local. Com
+// refresh, ou se a lista nunca foi sincronizada, os grupos são buscados no
+// WhatsApp.
+func (c *Client) GetGroups(refresh bool) ([]database.WhatsAppGroup, error) {
+	if !refresh {
+		syncedAt, err := c.DB.GetGroupsSyncedAt(c.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		if syncedAt != nil || !c.IsConnected() {
+			return c.DB.GetGroups(c.DeviceID, false)
+		}
+	}
+
+	if err := c.RefreshGroups(); err != nil {
+		return nil, err
+	}
+	return c.DB.GetGroups(c.DeviceID, false)
+}
+
+// RefreshGroups substitui a cópia local pelos grupos atuais do dispositivo.
+// Grupos que não estão mais na lista são marcados como deixados.
+func (c *Client) RefreshGroups() error {
+	if !c.IsConnected() {
+		return fmt.Errorf("cliente não está conectado")
+	}
+
+	joined, err := c.Client.GetJoinedGroups()
+	if err != nil {
+		return fmt.Errorf("falha ao obter grupos: %w", err)
+	}
+
+	current := make([]string, 0, len(joined))
+	for _, info := range joined {
+		if err := c.DB.SaveGroupSnapshot(groupSnapshot(c.DeviceID, info)); err != nil {
+			return err
+		}
+		current = append(current, info.JID.String())
+	}
+
+	if err := c.DB.MarkOtherGroupsLeft(c.DeviceID, current); err != nil {
+		return err
+	}
+	return c.DB.SaveGroupsSynced(c.DeviceID)
+}
+
+// refreshGroup busca os dados atuais de um grupo e atualiza a cópia local
+func (c *Client) refreshGroup(jid types.JID) (*database.WhatsAppGroup, error) {
+	info, err := c.Client.GetGroupInfo(jid)
+	if err != nil {
+		return nil, groupError("obter dados do grupo", err)
+	}
+
+	if err := c.DB.SaveGroupSnapshot(groupSnapshot(c.DeviceID, info)); err != nil {
+		return nil, err
+	}
+	return c.DB.GetGroup(c.DeviceID, jid.String())
+}
+
+// isOwnJID indica se o JID (número ou LID) é do próprio dispositivo
+func (c *Client) isOwnJID(jid types.JID) bool {
+	store := c.Client.Store
+	switch jid.Server {
+	case types.DefaultUserServer:
+		return store.ID != nil && store.ID.User == jid.User
+	case types.HiddenUserServer:
+		return store.LID.User == jid.User
+	default:
+		return false
+	}
+}
+
+// groupSnapshot converte os dados de um grupo do WhatsApp para a cópia local
+func groupSnapshot(deviceID int64, info *types.GroupInfo) *database.WhatsAppGroup {
+	group := &database.WhatsAppGroup{
+		DeviceID:   deviceID,
+		JID:        info.JID.String(),
+		Name:       info.Name,
+		Topic:      info.Topic,
+		IsAnnounce: info.IsAnnounce,
+		IsLocked:   info.IsLocked,
+	}
+
+	if !info.OwnerPN.IsEmpty() {
+		group.Owner = info.OwnerPN.String()
+	} else if !info.OwnerJID.IsEmpty() {
+		group.Owner = info.OwnerJID.String()
+	}
+	if !info.GroupCreated.IsZero() {
+		created := info.GroupCreated
+		group.GroupCreatedAt = &created
+	}
+
+	group.Participants = make([]database.GroupParticipant, len(info.Participants))
+	for i, participant := range info.Participants {
+		group.Participants[i] = database.GroupParticipant{
+			JID:          participantID(participant).String(),
+			IsAdmin:      participant.IsAdmin,
+			IsSuperAdmin: participant.IsSuperAdmin,
+		}
+	}
+
+	return group
+}
+
+// participantID retorna o número do participante, ou o LID quando o número
+// não é informado
+func participantID(participant types.GroupParticipant) types.JID {
+	if !participant.PhoneNumber.IsEmpty() {
+		return participant.PhoneNumber
+	}
+	return participant.JID
+}
+
+// handleJoinedGroup grava a cópia do grupo em que o dispositivo entrou
+func (h *EventHandler) handleJoinedGroup(deviceID int64, evt *events.JoinedGroup) {
+	group := groupSnapshot(deviceID, &evt.GroupInfo)
+	if err := h.DB.SaveGroupSnapshot(group); err != nil {
+		fmt.Printf("Erro ao gravar grupo %s: %v\n", group.JID, err)
+	}
+
+	// O evento não traz o horário da entrada
+	data := webhook.GroupEventData{
+		Group:     group.JID,
+		Reason:    evt.Reason,
+		Subject:   group.Name,
+		Timestamp: time.Now(),
+	}
+	if evt.Sender != nil {
+		data.Actor = h.resolveContactID(*evt.Sender, optionalJID(evt.SenderPN))
+	}
+
+	h.publishWebhookEvent(deviceID, webhook.EventGroupJoined, data)
+	fmt.Printf("Dispositivo %d entrou no grupo %s (%s)\n", deviceID, group.JID, group.Name)
+}
+
+// handleGroupInfo aplica à cópia local as alterações de um grupo e publica
+// um evento normalizado para cada tipo de alteração
+func (h *EventHandler) handleGroupInfo(deviceID int64, evt *events.GroupInfo) {
+	groupJID := evt.JID.String()
+
+	base := webhook.GroupEventData{Group: groupJID, Timestamp: evt.Timestamp}
+	if evt.Sender != nil {
+		base.Actor = h.resolveContactID(*evt.Sender, optionalJID(evt.SenderPN))
+	}
+
+	client, _ := h.Manager.GetClient(deviceID)
+
+	group, err := h.DB.GetGroup(deviceID, groupJID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar grupo %s: %v\n", groupJID, err)
+	}
+
+	// Alterações de nome, descrição e modos
+	changed := false
+	if evt.Name != nil {
+		data := base
+		data.Subject = evt.Name.Name
+		h.publishWebhookEvent(deviceID, webhook.EventGroupSubjectChanged, data)
+		if group != nil {
+			group.Name, changed = evt.Name.Name, true
+		}
+	}
+	if evt.Topic != nil {
+		data := base
+		topic := evt.Topic.Topic
+		if evt.Topic.TopicDeleted {
+			topic = ""
+		}
+		data.Description = &topic
+		h.publishWebhookEvent(deviceID, webhook.EventGroupDescriptionChanged, data)
+		if group != nil {
+			group.Topic, changed = topic, true
+		}
+	}
+	if evt.Announce != nil || evt.Locked != nil {
+		data := base
+		if evt.Announce != nil {
+			data.Announce = &evt.Announce.IsAnnounce
+			if group != nil {
+				group.IsAnnounce, changed = evt.Announce.IsAnnounce, true
+			}
+		}
+		if evt.Locked != nil {
+			data.Locked = &evt.Locked.IsLocked
+			if group != nil {
+				group.IsLocked, changed = evt.Locked.IsLocked, true
+			}
+		}
+		h.publishWebhookEvent(deviceID, webhook.EventGroupSettingsChanged, data)
+	}
+	if changed {
+		if err := h.DB.UpdateGroup(group); err != nil {
+			fmt.Printf("Erro ao atualizar grupo %s: %v\n", groupJID, err)
+		}
+	}
+
+	// Alterações de participantes
+	selfJoined, selfLeft := false, false
+	participantChanges := []struct {
+		jids      []types.JID
+		eventType string
+		apply     func(jids []string) error
+	}{
+		{evt.Join, webhook.EventGroupParticipantAdded, func(jids []string) error {
+			return h.DB.AddGroupParticipants(deviceID, groupJID, jids)
+		}},
+		{evt.Leave, webhook.EventGroupParticipantRemoved, func(jids []string) error {
+			return h.DB.RemoveGroupParticipants(deviceID, groupJID, jids)
+		}},
+		{evt.Promote, webhook.EventGroupParticipantPromoted, func(jids []string) error {
+			return h.DB.SetGroupParticipantsAdmin(deviceID, groupJID, jids, true)
+		}},
+		{evt.Demote, webhook.EventGroupParticipantDemoted, func(jids []string) error {
+			return h.DB.SetGroupParticipantsAdmin(deviceID, groupJID, jids, false)
+		}},
+	}
+
+	for _, change := range participantChanges {
+		if len(change.jids) == 0 {
+			continue
+		}
+
+		participants := make([]string, len(change.jids))
+		for i, jid := range change.jids {
+			participants[i] = h.resolveContactID(jid, types.EmptyJID)
+			if client != nil && client.isOwnJID(jid) {
+				selfJoined = selfJoined || change.eventType == webhook.EventGroupParticipantAdded
+				selfLeft = selfLeft || change.eventType == webhook.EventGroupParticipantRemoved
+			}
+		}
+
+		if group != nil {
+			if err := change.apply(participants); err != nil {
+				fmt.Printf("Erro ao atualizar participantes do grupo %s: %v\n", groupJID, err)
+			}
+		}
+
+		data := base
+		data.Participants = participants
+		if change.eventType == webhook.EventGroupParticipantAdded {
+			data.Reason = evt.JoinReason
+		}
+		h.publishWebhookEvent(deviceID, change.eventType, data)
+	}
+
+	switch {
+	case selfLeft || evt.Delete != nil:
+		if err := h.DB.MarkGroupLeft(deviceID, groupJID); err != nil {
+			fmt.Printf("Erro ao marcar saída do grupo %s: %v\n", groupJID, err)
+		}
+	case (group == nil || selfJoined) && client != nil && client.IsConnected():
+		// Sem cópia local (ou o dispositivo acabou de entrar): buscar a cópia
+		// completa, que já inclui as alterações deste evento
+		if _, err := client.refreshGroup(evt.JID); err != nil {
+			fmt.Printf("Erro ao sincronizar grupo %s: %v\n", groupJID, err)
+		}
+	}
+}
+
+// refreshGroupsOnConnect atualiza a cópia local dos grupos após conectar,
+// cobrindo as alterações ocorridas enquanto o dispositivo estava offline
+func (h *EventHandler) refreshGroupsOnConnect(deviceID int64) {
+	client, err := h.Manager.GetClient(deviceID)
+	if err != nil {
+		return
+	}
+
+	if err := client.RefreshGroups(); err != nil {
+		fmt.Printf("Erro ao sincronizar grupos do dispositivo %d: %v\n", deviceID, err)
+	}
+}
+
+// optionalJID retorna o JID apontado, ou um JID vazio
+func optionalJID(jid *types.JID) types.JID {
+	if jid == nil {
+		return types.EmptyJID
+	}
+	return *jid
+}
@@ -0,0 +1,329 @@
+// internal/whatsapp/groups.go
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-service/internal/database"
+)
+
+// Erros de administração de grupos, para a API escolher o status HTTP
+var (
+	ErrNotGroupAdmin     = errors.New("o dispositivo não é administrador do grupo")
+	ErrGroupNotFound     = errors.New("grupo não encontrado ou o dispositivo não participa dele")
+	ErrInvalidInviteLink = errors.New("link de convite inválido ou revogado")
+)
+
+// Ações sobre participantes aceitas por UpdateGroupParticipants
+var groupParticipantActions = map[string]whatsmeow.ParticipantChange{
+	"add":     whatsmeow.ParticipantChangeAdd,
+	"remove":  whatsmeow.ParticipantChangeRemove,
+	"promote": whatsmeow.ParticipantChangePromote,
+	"demote":  whatsmeow.ParticipantChangeDemote,
+}
+
+// GroupParticipantResult é o resultado da alteração de um participante.
+// O WhatsApp aplica a alteração a cada participante de forma independente.
+type GroupParticipantResult struct {
+	JID     string `json:"jid"`
+	Success bool   `json:"success"`
+	Code    int    `json:"code,omitempty"` // Código de erro do WhatsApp
+	Error   string `json:"error,omitempty"`
+}
+
+// GroupSettings são as configurações alteráveis de um grupo. Campos nil não
+// são alterados; uma descrição vazia remove a descrição.
+type GroupSettings struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Announce    *bool   `json:"announce"` // Apenas admins enviam mensagens
+	Locked      *bool   `json:"locked"`   // Apenas admins alteram os dados do grupo
+}
+
+// GetGroup retorna os dados e participantes de um grupo a partir da cópia
+// local. Com refresh, ou se o grupo não estiver na cópia, os dados são
+// buscados no WhatsApp.
+func (c *Client) GetGroup(groupID string, refresh bool) (*database.WhatsAppGroup, error) {
+	if !refresh {
+		group, err := c.DB.GetGroup(c.DeviceID, groupID)
+		if err != nil {
+			return nil, err
+		}
+		if group != nil && group.LeftAt == nil {
+			return group, nil
+		}
+	}
+
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return c.refreshGroup(jid)
+}
+
+// CreateGroup cria um grupo com os participantes informados (JIDs ou números).
+// O próprio dispositivo entra como administrador.
+func (c *Client) CreateGroup(name string, participants []string) (*database.WhatsAppGroup, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("cliente não está conectado")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("o nome do grupo é obrigatório")
+	}
+
+	jids, err := participantJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.Client.CreateGroup(whatsmeow.ReqCreateGroup{Name: name, Participants: jids})
+	if err != nil {
+		return nil, groupError("criar grupo", err)
+	}
+
+	group := groupSnapshot(c.DeviceID, info)
+	if err := c.DB.SaveGroupSnapshot(group); err != nil {
+		fmt.Printf("Erro ao gravar grupo %s: %v\n", group.JID, err)
+	}
+	return group, nil
+}
+
+// UpdateGroupParticipants adiciona, remove, promove ou rebaixa participantes
+// (action: add, remove, promote, demote)
+func (c *Client) UpdateGroupParticipants(groupID string, participants []string, action string) ([]GroupParticipantResult, error) {
+	change, ok := groupParticipantActions[action]
+	if !ok {
+		return nil, fmt.Errorf("ação inválida: %s (use add, remove, promote ou demote)", action)
+	}
+
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	jids, err := participantJIDs(participants)
+	if err != nil {
+		return nil, err
+	}
+	if len(jids) == 0 {
+		return nil, fmt.Errorf("informe ao menos um participante")
+	}
+
+	updated, err := c.Client.UpdateGroupParticipants(jid, jids, change)
+	if err != nil {
+		return nil, groupError("alterar participantes", err)
+	}
+
+	results := make([]GroupParticipantResult, len(updated))
+	for i, participant := range updated {
+		results[i] = GroupParticipantResult{
+			JID:     participantID(participant).String(),
+			Success: participant.Error == 0,
+			Code:    participant.Error,
+		}
+		if participant.Error != 0 {
+			results[i].Error = participantErrorMessage(action, participant.Error)
+		}
+	}
+
+	c.refreshGroupAfterChange(jid)
+	return results, nil
+}
+
+// UpdateGroupSettings altera nome, descrição e modos do grupo. As alterações
+// são aplicadas em sequência e a primeira falha interrompe as seguintes.
+func (c *Client) UpdateGroupSettings(groupID string, settings GroupSettings) error {
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return err
+	}
+
+	if settings.Name != nil {
+		name := strings.TrimSpace(*settings.Name)
+		if name == "" {
+			return fmt.Errorf("o nome do grupo não pode ser vazio")
+		}
+		if err := c.Client.SetGroupName(jid, name); err != nil {
+			return groupError("alterar o nome do grupo", err)
+		}
+	}
+	if settings.Description != nil {
+		// IDs vazios: o whatsmeow busca a descrição atual e gera o novo ID
+		if err := c.Client.SetGroupTopic(jid, "", "", *settings.Description); err != nil {
+			return groupError("alterar a descrição do grupo", err)
+		}
+	}
+	if settings.Announce != nil {
+		if err := c.Client.SetGroupAnnounce(jid, *settings.Announce); err != nil {
+			return groupError("alterar quem envia mensagens no grupo", err)
+		}
+	}
+	if settings.Locked != nil {
+		if err := c.Client.SetGroupLocked(jid, *settings.Locked); err != nil {
+			return groupError("alterar quem edita os dados do grupo", err)
+		}
+	}
+
+	c.refreshGroupAfterChange(jid)
+	return nil
+}
+
+// SetGroupPhoto troca a foto do grupo. A imagem é recortada no quadrado
+// central e convertida para JPEG. Com data nil, a foto é removida.
+func (c *Client) SetGroupPhoto(groupID string, data []byte) (string, error) {
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return "", err
+	}
+
+	var photo []byte
+	if data != nil {
+		if photo, err = groupPhotoJPEG(data); err != nil {
+			return "", err
+		}
+	}
+
+	pictureID, err := c.Client.SetGroupPhoto(jid, photo)
+	if err != nil {
+		return "", groupError("alterar a foto do grupo", err)
+	}
+	return pictureID, nil
+}
+
+// GetGroupInviteLink retorna o link de convite do grupo. Com reset, o link
+// atual é revogado e um novo é gerado.
+func (c *Client) GetGroupInviteLink(groupID string, reset bool) (string, error) {
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := c.Client.GetGroupInviteLink(jid, reset)
+	if err != nil {
+		return "", groupError("obter o link de convite", err)
+	}
+	return link, nil
+}
+
+// JoinGroupWithLink entra em um grupo pelo link ou código de convite
+func (c *Client) JoinGroupWithLink(code string) (*database.WhatsAppGroup, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("cliente não está conectado")
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("o código de convite é obrigatório")
+	}
+
+	jid, err := c.Client.JoinGroupWithLink(code)
+	if err != nil {
+		return nil, groupError("entrar no grupo", err)
+	}
+
+	// Grupos com aprovação de entrada só ficam acessíveis após a aprovação
+	group, err := c.refreshGroup(jid)
+	if err != nil {
+		return &database.WhatsAppGroup{DeviceID: c.DeviceID, JID: jid.String()}, nil
+	}
+	return group, nil
+}
+
+// LeaveGroup sai do grupo
+func (c *Client) LeaveGroup(groupID string) error {
+	jid, err := c.groupJID(groupID)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Client.LeaveGroup(jid); err != nil {
+		return groupError("sair do grupo", err)
+	}
+
+	if err := c.DB.MarkGroupLeft(c.DeviceID, jid.String()); err != nil {
+		fmt.Printf("Erro ao marcar saída do grupo %s: %v\n", jid, err)
+	}
+	return nil
+}
+
+// refreshGroupAfterChange atualiza a cópia local após uma alteração feita
+// pela API. Falhas são apenas registradas: a alteração já foi aplicada.
+func (c *Client) refreshGroupAfterChange(jid types.JID) {
+	if _, err := c.refreshGroup(jid); err != nil {
+		fmt.Printf("Erro ao sincronizar grupo %s: %v\n", jid, err)
+	}
+}
+
+// groupJID confere a conexão e converte o ID de um grupo
+func (c *Client) groupJID(groupID string) (types.JID, error) {
+	if !c.IsConnected() {
+		return types.EmptyJID, fmt.Errorf("cliente não está conectado")
+	}
+
+	jid, err := types.ParseJID(groupID)
+	if err != nil {
+		return types.EmptyJID, fmt.Errorf("JID de grupo inválido: %w", err)
+	}
+	if jid.Server != types.GroupServer {
+		return types.EmptyJID, fmt.Errorf("o JID fornecido não é um grupo")
+	}
+	return jid, nil
+}
+
+// participantJIDs converte os participantes informados como JID ou número
+func participantJIDs(participants []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(participants))
+	for _, participant := range participants {
+		normalized, err := normalizeRecipient(participant)
+		if err != nil {
+			return nil, err
+		}
+		jid, _ := types.ParseJID(normalized)
+		jids = append(jids, jid)
+	}
+	return jids, nil
+}
+
+// groupError traduz os erros do WhatsApp para os erros de grupo do pacote
+func groupError(action string, err error) error {
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotInGroup), errors.Is(err, whatsmeow.ErrGroupNotFound),
+		errors.Is(err, whatsmeow.ErrIQNotFound):
+		return fmt.Errorf("falha ao %s: %w", action, ErrGroupNotFound)
+	case errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized),
+		errors.Is(err, whatsmeow.ErrIQForbidden), errors.Is(err, whatsmeow.ErrIQNotAuthorized):
+		return fmt.Errorf("falha ao %s: %w", action, ErrNotGroupAdmin)
+	case errors.Is(err, whatsmeow.ErrInviteLinkInvalid), errors.Is(err, whatsmeow.ErrInviteLinkRevoked):
+		return fmt.Errorf("falha ao %s: %w", action, ErrInvalidInviteLink)
+	default:
+		return fmt.Errorf("falha ao %s: %w", action, err)
+	}
+}
+
+// participantErrorMessage descreve o código de erro retornado para um participante
+func participantErrorMessage(action string, code int) string {
+	switch code {
+	case 403:
+		if action == "add" {
+			return "a privacidade do contato não permite adicioná-lo; envie o link de convite"
+		}
+		return "sem permissão para alterar este participante"
+	case 404:
+		return "número não encontrado no WhatsApp ou não participa do grupo"
+	case 408:
+		return "o contato saiu do grupo recentemente e não pode ser adicionado agora"
+	case 409:
+		return "o contato já participa do grupo"
+	case 401:
+		return ErrNotGroupAdmin.Error()
+	default:
+		return fmt.Sprintf("erro %d do WhatsApp", code)
+	}
+}
@@ -4,24 +4,24 @@ package whatsapp
 import (
 	"bytes"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
+	waProto "go.mau.fi/whatsmeow/binary/proto"
 	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/reflect/protoreflect"
 
 	"whatsapp-service/internal/database"
 	"whatsapp-service/internal/notification"
+	"whatsapp-service/pkg/webhook"
 
 	"regexp"
 
@@ -32,6 +32,7 @@ import (
 
 // WebhookConfig contém a configuração para enviar eventos para um webhook
 type WebhookConfig struct {
+	ID        int64    `json:"id"` // ID da assinatura em webhook_configs
 	URL       string   `json:"url"`
 	Secret    string   `json:"secret,omitempty"`
 	Events    []string `json:"events,omitempty"` // Tipos de eventos a enviar, vazio = todos
@@ -42,12 +43,15 @@ type WebhookConfig struct {
 
 // EventHandler gerencia o processamento de eventos do WhatsApp
 type EventHandler struct {
-	DB            *database.DB
-	WebhookConfig *WebhookConfig
-	httpClient    *http.Client
-	Manager       *Manager          // JÁ EXISTIA - perfeito!
-	lidCache      map[string]string // Cache LID -> PhoneNumber
-	lidMutex      sync.RWMutex
+	DB         *database.DB
+	httpClient *http.Client
+	Manager    *Manager          // JÁ EXISTIA - perfeito!
+	lidCache   map[string]string // Cache LID -> PhoneNumber, gravado em lid_mappings
+	lidMutex   sync.RWMutex
+
+	// Assinaturas de webhook ativas, indexadas pelo ID em webhook_configs
+	webhooks      map[int64]*WebhookConfig
+	webhooksMutex sync.RWMutex
 
 	// NOVO: Adicionar apenas este campo para tracking de falhas
 	consecutiveFailures map[string]int // Track failures by webhook URL
@@ -89,15 +93,125 @@ func NewEventHandler(db *database.DB, manager *Manager) *EventHandler {
 		},
 		Manager:  manager,                 // JÁ EXISTIA
 		lidCache: make(map[string]string), // Inicializar se não estava
+		webhooks: make(map[int64]*WebhookConfig),
 
 		// NOVO: Inicializar apenas o campo de failures
 		consecutiveFailures: make(map[string]int),
 	}
 }
 
-// SetWebhookConfig configura o webhook para envio de eventos
+// SetWebhookConfig adiciona ou atualiza uma assinatura de webhook no cache.
+// Assinaturas desabilitadas são removidas do cache.
 func (h *EventHandler) SetWebhookConfig(config *WebhookConfig) {
-	h.WebhookConfig = config
+	h.webhooksMutex.Lock()
+	defer h.webhooksMutex.Unlock()
+
+	if !config.Enabled {
+		delete(h.webhooks, config.ID)
+		return
+	}
+	h.webhooks[config.ID] = config
+}
+
+// RemoveWebhookConfig remove uma assinatura de webhook do cache
+func (h *EventHandler) RemoveWebhookConfig(id int64) {
+	h.webhooksMutex.Lock()
+	defer h.webhooksMutex.Unlock()
+
+	delete(h.webhooks, id)
+}
+
+// LoadWebhookConfigs recarrega do banco todas as assinaturas habilitadas
+func (h *EventHandler) LoadWebhookConfigs() error {
+	configs, err := h.DB.GetEnabledWebhookConfigs()
+	if err != nil {
+		return fmt.Errorf("erro ao carregar configurações de webhook: %w", err)
+	}
+
+	webhooks := make(map[int64]*WebhookConfig, len(configs))
+	for _, config := range configs {
+		webhooks[config.ID] = newWebhookConfig(config)
+	}
+
+	h.webhooksMutex.Lock()
+	h.webhooks = webhooks
+	h.webhooksMutex.Unlock()
+
+	fmt.Printf("Carregadas %d assinaturas de webhook\n", len(webhooks))
+	return nil
+}
+
+// newWebhookConfig converte uma configuração do banco para o formato do cache
+func newWebhookConfig(config database.WebhookConfig) *WebhookConfig {
+	return &WebhookConfig{
+		ID:        config.ID,
+		URL:       config.URL,
+		Secret:    config.Secret,
+		Events:    config.Events,
+		TenantID:  config.TenantID,
+		DeviceIDs: config.DeviceIDs,
+		Enabled:   config.Enabled,
+	}
+}
+
+// hasWebhooks indica se existe alguma assinatura ativa
+func (h *EventHandler) hasWebhooks() bool {
+	h.webhooksMutex.RLock()
+	defer h.webhooksMutex.RUnlock()
+
+	return len(h.webhooks) > 0
+}
+
+// matchingWebhooks retorna as assinaturas que devem receber um evento
+func (h *EventHandler) matchingWebhooks(deviceID int64, tenantID int64, eventType string) []*WebhookConfig {
+	h.webhooksMutex.RLock()
+	defer h.webhooksMutex.RUnlock()
+
+	var matches []*WebhookConfig
+	for _, webhook := range h.webhooks {
+		if webhook.matches(deviceID, tenantID, eventType) {
+			matches = append(matches, webhook)
+		}
+	}
+	return matches
+}
+
+// matches verifica os filtros de tenant, dispositivo e tipo de evento
+func (w *WebhookConfig) matches(deviceID int64, tenantID int64, eventType string) bool {
+	if !w.Enabled || w.URL == "" {
+		return false
+	}
+
+	// Verificar se o tenant corresponde ao configurado (0 = todos)
+	if w.TenantID != 0 && tenantID != w.TenantID {
+		return false
+	}
+
+	// Verificar se o dispositivo está na lista de dispositivos ou se está vazia (todos)
+	if len(w.DeviceIDs) > 0 {
+		deviceFound := false
+		for _, id := range w.DeviceIDs {
+			if id == deviceID {
+				deviceFound = true
+				break
+			}
+		}
+		if !deviceFound {
+			return false
+		}
+	}
+
+	// Verificar se este tipo de evento deve ser enviado
+	if len(w.Events) > 0 {
+		for _, allowedType := range w.Events {
+			if eventTypeMatches(allowedType, eventType) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
 }
 
 // Método auxiliar para acessar notification service
@@ -119,7 +233,37 @@ func (h *EventHandler) HandleEvent(deviceID int64, evt interface{}) {
 	case *events.LoggedOut:
 		h.handleLoggedOut(deviceID)
 	case *events.Message:
-		h.handleMessage(deviceID, v)
+		// Edições e exclusões atualizam a mensagem original em vez de gerar
+		// uma nova. As recusadas (de quem não é o autor) não são publicadas.
+		if update, allowed := h.applyMessageUpdate(deviceID, v); !update {
+			h.handleMessage(deviceID, v)
+		} else if !allowed {
+			return
+		}
+	case *events.Receipt:
+		h.handleReceipt(deviceID, v)
+	case *events.JoinedGroup:
+		h.handleJoinedGroup(deviceID, v)
+	case *events.GroupInfo:
+		h.handleGroupInfo(deviceID, v)
+	case *events.PushName:
+		h.handlePushName(deviceID, v)
+	case *events.BusinessName:
+		h.handleBusinessName(deviceID, v)
+	case *events.Contact:
+		h.handleContact(deviceID, v)
+	case *events.Picture:
+		h.handlePicture(deviceID, v)
+	case *events.Presence:
+		h.handlePresence(deviceID, v)
+	case *events.ChatPresence:
+		h.handleChatPresence(deviceID, v)
+	case *events.Archive:
+		h.handleArchive(deviceID, v)
+	case *events.Pin:
+		h.handlePin(deviceID, v)
+	case *events.Mute:
+		h.handleMute(deviceID, v)
 	}
 
 	// Enviar evento para o webhook, se configurado
@@ -143,6 +287,9 @@ func (h *EventHandler) handleConnected(deviceID int64) {
 	if err != nil {
 		fmt.Printf("Erro ao atualizar dispositivo %d: %v\n", deviceID, err)
 	}
+
+	go h.refreshGroupsOnConnect(deviceID)
+	go h.importContactsOnConnect(deviceID)
 }
 
 // handleDisconnected atualiza o status de desconexão no banco de dados
@@ -191,6 +338,23 @@ func (h *EventHandler) handleMessage(deviceID int64, msg *events.Message) {
 		return
 	}
 
+	// Reações são guardadas à parte, associadas à mensagem que as recebeu
+	if msg.Message.GetReactionMessage() != nil {
+		h.saveReaction(deviceID, msg)
+		return
+	}
+
+	// Votos em enquetes atualizam a contagem da enquete em vez de entrar no histórico
+	if msg.Message.GetPollUpdateMessage() != nil {
+		h.savePollVote(deviceID, msg, client)
+		return
+	}
+
+	// Demais mensagens de protocolo e mensagens sem conteúdo próprio não entram no histórico
+	if !hasMessageContent(msg.Message) {
+		return
+	}
+
 	resolvedSender := h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt)
 	resolvedChat := h.resolveContactID(msg.Info.Chat, msg.Info.RecipientAlt)
 
@@ -202,13 +366,30 @@ func (h *EventHandler) handleMessage(deviceID int64, msg *events.Message) {
 		fmt.Printf("Chat LID resolvido: %s -> %s\n", msg.Info.Chat.String(), resolvedChat)
 	}
 
+	// Enquetes são registradas independentemente da política de armazenamento,
+	// para que os votos possam ser contados
+	if creation := getPollCreation(msg.Message); creation != nil {
+		h.savePoll(deviceID, msg.Info.ID, resolvedChat, resolvedSender, pollData(creation))
+	}
+
+	// Política de armazenamento do tenant (direto, enviadas, grupos não trackeados)
+	policy, err := h.DB.GetMessageRetentionPolicy(client.TenantID)
+	if err != nil {
+		fmt.Printf("Erro ao obter política de retenção do tenant %d: %v\n", client.TenantID, err)
+		policy = database.DefaultMessageRetentionPolicy(client.TenantID)
+	}
+
 	// Verificar se o contato/grupo está sendo trackado (usar IDs resolvidos)
 	tracked, err := h.DB.GetTrackedEntity(deviceID, resolvedChat)
-	if err != nil || !tracked.IsTracked {
-		fmt.Printf("Não salvar mensagens não trackeadas para contato/grupo %s: %v\n", resolvedChat, err)
-		if msg.Info.IsGroup {
+	isTracked := err == nil && tracked.IsTracked
+	if !isTracked {
+		if msg.Info.IsGroup && !policy.StoreUntrackedGroups {
+			fmt.Printf("Não salvar mensagens não trackeadas para grupo %s: %v\n", resolvedChat, err)
 			return
 		}
+		if tracked == nil {
+			tracked = &database.TrackedEntity{DeviceID: deviceID, JID: resolvedChat, TrackMedia: true}
+		}
 	}
 
 	// Registrar mensagem no banco de dados (usar IDs resolvidos)
@@ -221,28 +402,45 @@ func (h *EventHandler) handleMessage(deviceID int64, msg *events.Message) {
 		IsGroup:   msg.Info.IsGroup,
 		Timestamp: msg.Info.Timestamp,
 		Content:   getMessageTextContent(msg),
+		Details:   getMessageDetails(msg.Message),
+
+		QuotedMessageID: getQuotedMessageID(msg.Message),
+	}
+
+	// Mensagens enviadas pelo celular chegam aqui antes de qualquer confirmação
+	if msg.Info.IsFromMe {
+		message.Status = database.MessageStatusSent
 	}
 
-	// Resto do método permanece igual...
 	mediaType := getMessageMediaType(msg)
 	var audioBase64 string
 
-	if mediaType != "text" && tracked.TrackMedia {
+	if mediaType != "text" {
+		message.MediaType = mediaType
+	}
+
+	// A política é verificada antes do download: mensagens que não serão
+	// armazenadas não deixam arquivos no diretório de mídia
+	stored := policy.ShouldStore(msg.Info.IsGroup, msg.Info.IsFromMe, isTracked)
+
+	if isDownloadableMedia(mediaType) && tracked.TrackMedia {
 		if !isAllowedMediaType(mediaType, tracked.AllowedMediaTypes) && mediaType != "audio" {
 			return
 		}
 
 		if mediaType == "audio" {
-			mp3Base64, err := h.processAudioMessage(deviceID, msg, client)
+			// O áudio é sempre convertido para o assistente; o original só é
+			// guardado se a mensagem for armazenada
+			mp3Base64, mediaURL, err := h.processAudioMessage(deviceID, msg, client, stored)
 			if err != nil {
 				fmt.Printf("Erro ao processar áudio: %v\n", err)
 			} else {
 				audioBase64 = mp3Base64
+				message.MediaURL = mediaURL
 				fmt.Printf("Áudio processado com sucesso para mensagem %s\n", msg.Info.ID)
 			}
-		} else {
+		} else if stored {
 			if url, content, err := h.downloadAndSaveMedia(deviceID, msg, client); err == nil {
-				message.MediaType = mediaType
 				message.MediaURL = url
 				if content != "" {
 					message.Content = content
@@ -253,13 +451,15 @@ func (h *EventHandler) handleMessage(deviceID int64, msg *events.Message) {
 		}
 	}
 
-	// Salvar mensagem no banco (exceto áudios)
-	if mediaType != "audio" {
-		if msg.Info.IsGroup {
-			if err := h.DB.SaveMessage(message); err != nil {
-				fmt.Printf("Erro ao salvar mensagem: %v\n", err)
-			}
+	// Salvar mensagem no banco conforme a política do tenant
+	if stored {
+		if err := h.DB.SaveMessage(message); err != nil {
+			fmt.Printf("Erro ao salvar mensagem: %v\n", err)
 		}
+	}
+	updateChatActivity(h.DB, message, stored)
+
+	if mediaType != "audio" {
 		go h.DB.NotifyAssistantAboutMessage(message)
 	} else {
 		go h.DB.NotifyAssistantAboutMessageWithAudio(message, audioBase64)
@@ -268,6 +468,224 @@ func (h *EventHandler) handleMessage(deviceID int64, msg *events.Message) {
 	fmt.Printf("Dispositivo %d recebeu mensagem de %s: %s\n", deviceID, resolvedSender, message.Content)
 }
 
+// applyMessageUpdate registra no histórico as edições e exclusões para todos,
+// que chegam como mensagens de protocolo referentes à mensagem original.
+// Retorna se o evento era uma delas e se o remetente pode alterar a mensagem
+// (mensagens não armazenadas não têm como ser conferidas e são aceitas).
+func (h *EventHandler) applyMessageUpdate(deviceID int64, msg *events.Message) (bool, bool) {
+	protocol := msg.Message.GetProtocolMessage()
+	if protocol == nil {
+		return false, false
+	}
+
+	targetID := protocol.GetKey().GetID()
+	revoke := false
+	var changed bool
+	var err error
+	preview := ""
+
+	// Só o autor (ou um admin do grupo, na exclusão) altera a mensagem. As
+	// alterações feitas pelo próprio número valem para as mensagens dele.
+	sender := h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt)
+	if msg.Info.IsFromMe {
+		sender = h.resolveContactID(msg.Info.Sender.ToNonAD(), types.EmptyJID)
+	}
+
+	switch protocol.GetType() {
+	case waProto.ProtocolMessage_REVOKE:
+		revoke = true
+		changed, err = h.DB.RevokeMessage(deviceID, targetID, sender, msg.Info.IsFromMe, msg.Info.Timestamp)
+	case waProto.ProtocolMessage_MESSAGE_EDIT:
+		text := getProtoMessageText(protocol.GetEditedMessage())
+		changed, err = h.DB.EditMessage(deviceID, targetID, sender, msg.Info.IsFromMe, text, msg.Info.Timestamp)
+		preview = database.ChatPreview(text, "")
+	default:
+		return false, false
+	}
+
+	if err != nil {
+		fmt.Printf("Erro ao atualizar mensagem %s (%s): %v\n", targetID, protocol.GetType(), err)
+		return true, true
+	}
+
+	if !changed {
+		// Mensagem não armazenada, já apagada, edição atrasada ou de outro autor
+		stored, allowed, err := h.DB.MessageUpdateAllowed(deviceID, targetID, sender, msg.Info.IsFromMe, revoke)
+		if err != nil {
+			fmt.Printf("Erro ao conferir autor da mensagem %s: %v\n", targetID, err)
+			return true, false
+		}
+		if stored && !allowed {
+			fmt.Printf("%s de %s recusada: %s não é o autor da mensagem\n", protocol.GetType(), targetID, sender)
+		}
+		return true, !stored || allowed
+	}
+
+	// A prévia do chat acompanha a edição ou exclusão da sua última mensagem
+	if preview != "" || revoke {
+		if err := h.DB.UpdateChatPreview(deviceID, targetID, preview); err != nil {
+			fmt.Printf("Erro ao atualizar prévia do chat da mensagem %s: %v\n", targetID, err)
+		}
+	}
+
+	return true, true
+}
+
+// saveReaction registra a reação de um participante a uma mensagem
+func (h *EventHandler) saveReaction(deviceID int64, msg *events.Message) {
+	data := h.newReactionData(msg)
+
+	err := h.DB.SaveMessageReaction(&database.MessageReaction{
+		DeviceID:  deviceID,
+		MessageID: data.MessageID,
+		Chat:      data.Chat,
+		Sender:    data.Sender,
+		Reaction:  data.Reaction,
+		Timestamp: data.Timestamp,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao salvar reação à mensagem %s: %v\n", data.MessageID, err)
+	}
+}
+
+// savePoll registra uma enquete recebida ou criada pelo celular
+func (h *EventHandler) savePoll(deviceID int64, messageID, chat, creator string, poll *webhook.PollData) {
+	err := h.DB.SavePoll(&database.Poll{
+		DeviceID:        deviceID,
+		MessageID:       messageID,
+		Chat:            chat,
+		Creator:         creator,
+		Question:        poll.Question,
+		Options:         poll.Options,
+		SelectableCount: poll.SelectableCount,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao registrar enquete %s: %v\n", messageID, err)
+	}
+}
+
+// savePollVote decifra o voto de um participante, registra as opções marcadas
+// e publica poll.vote com a contagem atualizada. Votos em enquetes não
+// registradas (anteriores ao dispositivo) são ignorados, pois as opções votadas
+// chegam apenas como hashes.
+func (h *EventHandler) savePollVote(deviceID int64, msg *events.Message, client *Client) {
+	update := msg.Message.GetPollUpdateMessage()
+	pollID := update.GetPollCreationMessageKey().GetID()
+
+	poll, err := h.DB.GetPoll(deviceID, pollID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar enquete %s: %v\n", pollID, err)
+		return
+	}
+	if poll == nil {
+		fmt.Printf("Voto ignorado: enquete %s não registrada\n", pollID)
+		return
+	}
+
+	vote, err := client.Client.DecryptPollVote(context.Background(), msg)
+	if err != nil {
+		fmt.Printf("Erro ao decifrar voto na enquete %s: %v\n", pollID, err)
+		return
+	}
+
+	voter := h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt)
+	options := pollOptionsFromHashes(poll.Options, vote.GetSelectedOptions())
+
+	votedAt := msg.Info.Timestamp
+	if update.GetSenderTimestampMS() > 0 {
+		votedAt = time.UnixMilli(update.GetSenderTimestampMS())
+	}
+
+	err = h.DB.SavePollVote(&database.PollVote{
+		PollID:  poll.ID,
+		Voter:   voter,
+		Options: options,
+		VotedAt: votedAt,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao salvar voto na enquete %s: %v\n", pollID, err)
+		return
+	}
+
+	// Recarregar para publicar a contagem com o voto aplicado
+	if updated, err := h.DB.GetPoll(deviceID, pollID); err == nil && updated != nil {
+		poll = updated
+	}
+
+	h.publishWebhookEvent(deviceID, webhook.EventPollVote, webhook.PollVoteData{
+		PollID:    pollID,
+		Chat:      poll.Chat,
+		Voter:     voter,
+		IsFromMe:  msg.Info.IsFromMe,
+		Options:   options,
+		Tally:     poll.Tally,
+		Timestamp: votedAt,
+	})
+
+	fmt.Printf("Dispositivo %d registrou voto de %s na enquete %s: %v\n", deviceID, voter, pollID, options)
+}
+
+// hasMessageContent indica se a mensagem tem conteúdo para o histórico, em
+// oposição a mensagens de protocolo, reações, votos e distribuição de chaves,
+// que chegam sem conteúdo próprio. Tipos sem tratamento específico (respostas
+// de botões, listas, templates etc.) contam como conteúdo.
+func hasMessageContent(message *waProto.Message) bool {
+	if message == nil ||
+		message.GetProtocolMessage() != nil ||
+		message.GetReactionMessage() != nil ||
+		message.GetPollUpdateMessage() != nil {
+		return false
+	}
+
+	// Campos que acompanham as mensagens, mas não são conteúdo
+	hasContent := false
+	message.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		switch field.Name() {
+		case "senderKeyDistributionMessage", "messageContextInfo":
+			return true
+		}
+		hasContent = true
+		return false
+	})
+	return hasContent
+}
+
+// receiptStatus converte o tipo de confirmação do WhatsApp para o status de entrega.
+// Confirmações sobre mensagens recebidas (leitura em outro aparelho, retry etc.) são ignoradas.
+func receiptStatus(receiptType types.ReceiptType) (string, bool) {
+	switch receiptType {
+	case types.ReceiptTypeDelivered:
+		return database.MessageStatusDelivered, true
+	case types.ReceiptTypeRead:
+		return database.MessageStatusRead, true
+	case types.ReceiptTypePlayed:
+		return database.MessageStatusPlayed, true
+	case types.ReceiptTypeServerError:
+		return database.MessageStatusFailed, true
+	default:
+		return "", false
+	}
+}
+
+// handleReceipt atualiza o status de entrega das mensagens enviadas e, nas
+// leituras feitas pelo próprio número, o cursor de leitura do chat
+func (h *EventHandler) handleReceipt(deviceID int64, receipt *events.Receipt) {
+	if receipt.IsFromMe && (receipt.Type == types.ReceiptTypeRead || receipt.Type == types.ReceiptTypeReadSelf) {
+		h.handleSelfRead(deviceID, receipt)
+		return
+	}
+
+	status, ok := receiptStatus(receipt.Type)
+	if !ok || receipt.IsFromMe {
+		return
+	}
+
+	data := h.newStatusData(receipt, status)
+	if err := h.DB.UpdateMessageStatus(deviceID, data.Chat, data.Recipient, data.MessageIDs, status, data.Timestamp); err != nil {
+		fmt.Printf("Erro ao atualizar status de mensagens do dispositivo %d: %v\n", deviceID, err)
+	}
+}
+
 func (h *EventHandler) resolveContactID(primaryJID, altJID types.JID) string {
 	// Se o JID principal não é LID, usar ele mesmo
 	if primaryJID.Server != types.HiddenUserServer {
@@ -279,15 +697,15 @@ func (h *EventHandler) resolveContactID(primaryJID, altJID types.JID) string {
 		fmt.Printf("LID %s resolvido via Alt para %s\n", primaryJID.String(), altJID.String())
 
 		// Salvar mapeamento para futuro uso
-		h.cacheLIDMapping(primaryJID.String(), altJID.String())
+		h.learnLIDMapping(primaryJID, altJID, database.LIDSourceMessage)
 
 		return altJID.String()
 	}
 
-	// Verificar cache de mapeamentos conhecidos
-	if cached, exists := h.getCachedLIDMapping(primaryJID.String()); exists {
-		fmt.Printf("LID %s resolvido via cache para %s\n", primaryJID.String(), cached)
-		return cached
+	// Verificar mapeamentos conhecidos (cache, banco e whatsmeow)
+	if phone, exists := h.lookupLIDMapping(primaryJID); exists {
+		fmt.Printf("LID %s resolvido via mapeamento para %s\n", primaryJID.String(), phone.String())
+		return phone.String()
 	}
 
 	// Se não conseguir resolver, manter o LID original
@@ -302,12 +720,14 @@ func (h *EventHandler) isValidPhoneNumber(number string) bool {
 	return matched
 }
 
-// processAudioMessage processa uma mensagem de áudio: download, conversão para MP3 e codificação em base64
-func (h *EventHandler) processAudioMessage(deviceID int64, msg *events.Message, client *Client) (string, error) {
+// processAudioMessage processa uma mensagem de áudio: download, armazenamento do
+// original (se store), conversão para MP3 e codificação em base64. Retorna o MP3
+// em base64 e a referência do áudio original armazenado.
+func (h *EventHandler) processAudioMessage(deviceID int64, msg *events.Message, client *Client, store bool) (string, string, error) {
 	// Baixar o arquivo de áudio
 	audio := msg.Message.GetAudioMessage()
 	if audio == nil {
-		return "", fmt.Errorf("mensagem de áudio não encontrada")
+		return "", "", fmt.Errorf("mensagem de áudio não encontrada")
 	}
 
 	// Adicionar context com timeout para download
@@ -316,23 +736,31 @@ func (h *EventHandler) processAudioMessage(deviceID int64, msg *events.Message,
 
 	data, err := client.Client.Download(ctx, audio)
 	if err != nil {
-		return "", fmt.Errorf("erro ao baixar áudio: %w", err)
+		return "", "", fmt.Errorf("erro ao baixar áudio: %w", err)
 	}
 
 	if len(data) == 0 {
-		return "", fmt.Errorf("nenhum dado de áudio recebido")
+		return "", "", fmt.Errorf("nenhum dado de áudio recebido")
+	}
+
+	// Armazenar o áudio original como referência de mídia da mensagem
+	mediaURL := ""
+	if store {
+		if mediaURL, err = h.storeMedia(deviceID, msg.Info.ID, "audio", data, ""); err != nil {
+			return "", "", fmt.Errorf("erro ao armazenar áudio: %w", err)
+		}
 	}
 
 	// Criar arquivo temporário para o áudio original
 	tempDir := "./temp"
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return "", fmt.Errorf("erro ao criar diretório temporário: %w", err)
+		return "", "", fmt.Errorf("erro ao criar diretório temporário: %w", err)
 	}
 
 	// Arquivo de entrada (formato original do WhatsApp, geralmente OGG)
 	inputFile := filepath.Join(tempDir, fmt.Sprintf("audio_%d_%s.ogg", deviceID, msg.Info.ID))
 	if err := ioutil.WriteFile(inputFile, data, 0644); err != nil {
-		return "", fmt.Errorf("erro ao salvar arquivo de áudio temporário: %w", err)
+		return "", "", fmt.Errorf("erro ao salvar arquivo de áudio temporário: %w", err)
 	}
 
 	// Limpar arquivo temporário no final
@@ -352,19 +780,19 @@ func (h *EventHandler) processAudioMessage(deviceID int64, msg *events.Message,
 
 	// Converter para MP3 usando ffmpeg
 	if err := h.convertToMP3(inputFile, outputFile); err != nil {
-		return "", fmt.Errorf("erro ao converter áudio para MP3: %w", err)
+		return "", "", fmt.Errorf("erro ao converter áudio para MP3: %w", err)
 	}
 
 	// Ler o arquivo MP3 convertido
 	mp3Data, err := ioutil.ReadFile(outputFile)
 	if err != nil {
-		return "", fmt.Errorf("erro ao ler arquivo MP3 convertido: %w", err)
+		return "", "", fmt.Errorf("erro ao ler arquivo MP3 convertido: %w", err)
 	}
 
 	// Codificar em base64
 	base64String := base64.StdEncoding.EncodeToString(mp3Data)
 
-	return base64String, nil
+	return base64String, mediaURL, nil
 }
 
 // convertToMP3 converte um arquivo de áudio para MP3 usando ffmpeg
@@ -406,15 +834,54 @@ func (h *EventHandler) convertToMP3(inputFile, outputFile string) error {
 }
 
 func getMessageTextContent(msg *events.Message) string {
-	if msg.Message.GetConversation() != "" {
-		return msg.Message.GetConversation()
+	return getProtoMessageText(msg.Message)
+}
+
+// getProtoMessageText extrai o texto de uma mensagem (ou o texto novo de uma edição)
+func getProtoMessageText(message *waProto.Message) string {
+	if message.GetConversation() != "" {
+		return message.GetConversation()
 	}
-	if ext := msg.Message.GetExtendedTextMessage(); ext != nil {
+	if ext := message.GetExtendedTextMessage(); ext != nil {
 		return ext.GetText()
 	}
+	if img := message.GetImageMessage(); img != nil {
+		return img.GetCaption()
+	}
+	if vid := message.GetVideoMessage(); vid != nil {
+		return vid.GetCaption()
+	}
+	if details := getMessageDetails(message); details != nil {
+		switch {
+		case details.Location != nil:
+			return locationText(details.Location)
+		case len(details.Contacts) > 0:
+			return contactsText(details.Contacts)
+		case details.Poll != nil:
+			return details.Poll.Question
+		}
+	}
 	return ""
 }
 
+// getQuotedMessageID retorna o ID da mensagem citada, quando a mensagem é uma resposta
+func getQuotedMessageID(message *waProto.Message) string {
+	switch {
+	case message.GetExtendedTextMessage() != nil:
+		return message.GetExtendedTextMessage().GetContextInfo().GetStanzaID()
+	case message.GetImageMessage() != nil:
+		return message.GetImageMessage().GetContextInfo().GetStanzaID()
+	case message.GetVideoMessage() != nil:
+		return message.GetVideoMessage().GetContextInfo().GetStanzaID()
+	case message.GetAudioMessage() != nil:
+		return message.GetAudioMessage().GetContextInfo().GetStanzaID()
+	case message.GetDocumentMessage() != nil:
+		return message.GetDocumentMessage().GetContextInfo().GetStanzaID()
+	default:
+		return ""
+	}
+}
+
 func getMessageMediaType(msg *events.Message) string {
 	switch {
 	case msg.Message.GetImageMessage() != nil:
@@ -425,11 +892,85 @@ func getMessageMediaType(msg *events.Message) string {
 		return "audio"
 	case msg.Message.GetDocumentMessage() != nil:
 		return "document"
+	case msg.Message.GetStickerMessage() != nil:
+		return "sticker"
+	case msg.Message.GetLocationMessage() != nil, msg.Message.GetLiveLocationMessage() != nil:
+		return "location"
+	case msg.Message.GetContactMessage() != nil, msg.Message.GetContactsArrayMessage() != nil:
+		return "contact"
+	case getPollCreation(msg.Message) != nil:
+		return "poll"
 	default:
 		return "text"
 	}
 }
 
+// isDownloadableMedia indica se o tipo de mensagem tem arquivo para baixar
+// (localizações e contatos não têm)
+func isDownloadableMedia(mediaType string) bool {
+	switch mediaType {
+	case "image", "video", "audio", "document", "sticker":
+		return true
+	default:
+		return false
+	}
+}
+
+// getMessageDetails extrai os campos estruturados de localizações, cartões
+// de contato, enquetes e textos com prévia de link. Retorna nil para as demais mensagens.
+func getMessageDetails(message *waProto.Message) *database.MessageDetails {
+	switch {
+	case message.GetLocationMessage() != nil:
+		loc := message.GetLocationMessage()
+		return &database.MessageDetails{Location: &webhook.LocationData{
+			Latitude:       loc.GetDegreesLatitude(),
+			Longitude:      loc.GetDegreesLongitude(),
+			Name:           loc.GetName(),
+			Address:        loc.GetAddress(),
+			URL:            loc.GetURL(),
+			Live:           loc.GetIsLive(),
+			AccuracyMeters: loc.GetAccuracyInMeters(),
+		}}
+	case message.GetLiveLocationMessage() != nil:
+		loc := message.GetLiveLocationMessage()
+		return &database.MessageDetails{Location: &webhook.LocationData{
+			Latitude:       loc.GetDegreesLatitude(),
+			Longitude:      loc.GetDegreesLongitude(),
+			Live:           true,
+			Caption:        loc.GetCaption(),
+			AccuracyMeters: loc.GetAccuracyInMeters(),
+		}}
+	case message.GetContactMessage() != nil:
+		return &database.MessageDetails{Contacts: []webhook.ContactData{contactCard(message.GetContactMessage())}}
+	case message.GetContactsArrayMessage() != nil:
+		contacts := []webhook.ContactData{}
+		for _, contact := range message.GetContactsArrayMessage().GetContacts() {
+			contacts = append(contacts, contactCard(contact))
+		}
+		return &database.MessageDetails{Contacts: contacts}
+	case getPollCreation(message) != nil:
+		return &database.MessageDetails{Poll: pollData(getPollCreation(message))}
+	case message.GetExtendedTextMessage().GetMatchedText() != "" && message.GetExtendedTextMessage().GetTitle() != "":
+		ext := message.GetExtendedTextMessage()
+		return &database.MessageDetails{LinkPreview: &webhook.LinkPreviewData{
+			URL:         ext.GetMatchedText(),
+			Title:       ext.GetTitle(),
+			Description: ext.GetDescription(),
+		}}
+	default:
+		return nil
+	}
+}
+
+// contactCard converte um cartão recebido, usando o nome exibido quando o vCard não tem FN
+func contactCard(contact *waProto.ContactMessage) webhook.ContactData {
+	card := parseVCard(contact.GetVcard())
+	if contact.GetDisplayName() != "" {
+		card.Name = contact.GetDisplayName()
+	}
+	return card
+}
+
 func isAllowedMediaType(mediaType string, allowedTypes []string) bool {
 	if len(allowedTypes) == 0 {
 		return true
@@ -756,6 +1297,24 @@ func (h *EventHandler) storeMediaLocal(deviceID int64, messageID string, mediaTy
 	return filename, nil
 }
 
+// removeStoredMedia remove um arquivo salvo por storeMediaLocal. Referências
+// externas ou fora do diretório de mídia são ignoradas.
+func (h *EventHandler) removeStoredMedia(mediaURL string) bool {
+	cleaned := filepath.Clean(mediaURL)
+	if !strings.HasPrefix(cleaned, "media"+string(filepath.Separator)) {
+		return false
+	}
+
+	if err := os.Remove(filepath.Join("./storage", cleaned)); err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Aviso: erro ao remover mídia %s: %v\n", mediaURL, err)
+		}
+		return false
+	}
+
+	return true
+}
+
 // Armazenamento externo (implementação fictícia por enquanto)
 func (h *EventHandler) storeMediaExternal(deviceID int64, messageID string, mediaType string, data []byte, originalFilename string) (string, error) {
 	// Aqui você implementaria a chamada para um serviço externo como Dropbox, S3, etc.
@@ -823,6 +1382,8 @@ func getExtensionFromMediaType(mediaType string) string {
 		return "ogg"
 	case "document":
 		return "pdf"
+	case "sticker":
+		return "webp"
 	default:
 		return "bin"
 	}
@@ -850,180 +1411,74 @@ func getExtensionFromMediaType(mediaType string) string {
 // 	}
 // }
 
-// sendToWebhook envia um evento para o webhook configurado
+// sendToWebhook converte um evento do whatsmeow para o esquema público e o
+// publica para as assinaturas de webhook que o aceitam
 func (h *EventHandler) sendToWebhook(deviceID int64, evt interface{}) {
-	// Verificar se webhook está configurado e habilitado
-	if h.WebhookConfig == nil || h.WebhookConfig.URL == "" || !h.WebhookConfig.Enabled {
-		return // Sem webhook configurado ou desabilitado
+	// Evitar conversões e consultas ao banco quando não há nenhuma assinatura
+	if !h.hasWebhooks() {
+		return
 	}
 
-	// Verificar se o dispositivo está na lista de dispositivos ou se está vazia (todos)
-	if len(h.WebhookConfig.DeviceIDs) > 0 {
-		deviceFound := false
-		for _, id := range h.WebhookConfig.DeviceIDs {
-			if id == deviceID {
-				deviceFound = true
-				break
-			}
-		}
-		if !deviceFound {
-			return // Este dispositivo não está configurado para este webhook
-		}
+	// Eventos internos do whatsmeow não fazem parte do esquema público
+	eventType, data, ok := h.webhookEventData(evt)
+	if !ok {
+		return
 	}
 
-	// Verificar se este tipo de evento deve ser enviado
-	if len(h.WebhookConfig.Events) > 0 {
-		eventType := fmt.Sprintf("%T", evt)
-		shouldSend := false
-
-		for _, allowedType := range h.WebhookConfig.Events {
-			if eventType == allowedType || allowedType == "*" {
-				shouldSend = true
-				break
-			}
-		}
+	h.publishWebhookEvent(deviceID, eventType, data)
+}
 
-		if !shouldSend {
-			return // Este tipo de evento não deve ser enviado
-		}
+// publishWebhookEvent monta o envelope versionado e grava uma entrega por
+// assinatura na fila persistente
+func (h *EventHandler) publishWebhookEvent(deviceID int64, eventType string, data interface{}) {
+	if !h.hasWebhooks() {
+		return
 	}
 
-	// Preparar dados do evento
 	device, _ := h.DB.GetDeviceByID(deviceID)
 	tenantID := int64(0)
 	if device != nil {
 		tenantID = device.TenantID
 	}
 
-	// Verificar se o tenant corresponde ao configurado
-	if h.WebhookConfig.TenantID != 0 && tenantID != h.WebhookConfig.TenantID {
-		return // Este evento não é para o tenant configurado
-	}
-
-	webhookData := map[string]interface{}{
-		"device_id":  deviceID,
-		"tenant_id":  tenantID,
-		"event_type": fmt.Sprintf("%T", evt),
-		"timestamp":  time.Now().Format(time.RFC3339),
-		"event":      evt,
+	webhooks := h.matchingWebhooks(deviceID, tenantID, eventType)
+	if len(webhooks) == 0 {
+		return // Nenhuma assinatura para este tenant/dispositivo/evento
 	}
 
 	// Converter para JSON
-	jsonData, err := json.Marshal(webhookData)
+	jsonData, err := json.Marshal(webhook.NewEvent(eventType, deviceID, tenantID, data))
 	if err != nil {
-		fmt.Printf("Erro ao serializar evento para webhook: %v\n", err)
-		// Registrar falha no banco de dados
-		h.logWebhookDeliveryFailure(deviceID, fmt.Sprintf("%T", evt), jsonData, 0, "", fmt.Sprintf("Erro ao serializar: %v", err))
+		fmt.Printf("Erro ao serializar evento %s para webhook: %v\n", eventType, err)
 		return
 	}
 
-	// Criar assinatura se um segredo for fornecido
-	var signature string
-	if h.WebhookConfig.Secret != "" {
-		signature = generateSignature(jsonData, h.WebhookConfig.Secret)
-	}
-
-	// Enviar para o webhook
-	req, err := http.NewRequest("POST", h.WebhookConfig.URL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Erro ao criar requisição para webhook: %v\n", err)
-		h.logWebhookDeliveryFailure(deviceID, fmt.Sprintf("%T", evt), jsonData, 0, "", fmt.Sprintf("Erro ao criar requisição: %v", err))
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "WhatsApp-Service-Webhook/1.0")
-
-	if signature != "" {
-		req.Header.Set("X-Webhook-Signature", signature)
-	}
-
-	if h.WebhookConfig.Secret != "" {
-		req.Header.Set("X-Webhook-Secret", h.WebhookConfig.Secret)
-	}
-
-	// Enviar a requisição com timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	req = req.WithContext(ctx)
-
-	resp, err := h.httpClient.Do(req)
-
-	// Processar resposta ou erro
-	if err != nil {
-		fmt.Printf("Erro ao enviar evento para webhook: %v\n", err)
-		h.logWebhookDeliveryFailure(deviceID, fmt.Sprintf("%T", evt), jsonData, 0, "", fmt.Sprintf("Erro ao enviar: %v", err))
-
-		// ADICIONAR APENAS ESTA PARTE - Tracking de falhas consecutivas
-		h.failuresMutex.Lock()
-		h.consecutiveFailures[h.WebhookConfig.URL]++
-		failures := h.consecutiveFailures[h.WebhookConfig.URL]
-		h.failuresMutex.Unlock()
-
-		// Notificar após muitas falhas consecutivas
-		if failures >= 5 && h.getNotificationService() != nil {
-			h.getNotificationService().NotifyWebhookDeliveryFailure(deviceID, h.WebhookConfig.URL, failures)
+	// Gravar uma entrega por assinatura na fila persistente; o envio HTTP,
+	// as retentativas e o dead-letter ficam a cargo do WebhookProcessor
+	for _, config := range webhooks {
+		delivery := &database.WebhookDelivery{
+			WebhookID: config.ID,
+			DeviceID:  deviceID,
+			EventType: eventType,
+			Payload:   string(jsonData),
 		}
-
-		// Agendar reenvio em background - MANTER CÓDIGO EXISTENTE
-		go h.scheduleWebhookRetry(deviceID, fmt.Sprintf("%T", evt), jsonData)
-		return
-	}
-
-	defer resp.Body.Close()
-
-	// Ler corpo da resposta para logging
-	responseBody, _ := ioutil.ReadAll(resp.Body)
-	responseStr := string(responseBody)
-
-	if resp.StatusCode >= 400 {
-		fmt.Printf("Webhook retornou status de erro: %d\n", resp.StatusCode)
-		h.logWebhookDeliveryFailure(deviceID, fmt.Sprintf("%T", evt), jsonData, resp.StatusCode, responseStr, fmt.Sprintf("Status de erro: %d", resp.StatusCode))
-
-		// ADICIONAR APENAS ESTA PARTE - Tracking para erros HTTP
-		h.failuresMutex.Lock()
-		h.consecutiveFailures[h.WebhookConfig.URL]++
-		failures := h.consecutiveFailures[h.WebhookConfig.URL]
-		h.failuresMutex.Unlock()
-
-		// Notificar após falhas consecutivas (menos tolerância para erros HTTP)
-		if failures >= 3 && h.getNotificationService() != nil {
-			h.getNotificationService().NotifyWebhookDeliveryFailure(deviceID, h.WebhookConfig.URL, failures)
+		if err := h.DB.EnqueueWebhookDelivery(delivery); err != nil {
+			fmt.Printf("Erro ao enfileirar entrega para webhook %d: %v\n", config.ID, err)
 		}
-
-		// Agendar reenvio se for um erro temporário - MANTER CÓDIGO EXISTENTE
-		if resp.StatusCode >= 500 {
-			go h.scheduleWebhookRetry(deviceID, fmt.Sprintf("%T", evt), jsonData)
-		}
-		return
 	}
 
-	// ADICIONAR APENAS ESTA PARTE - Reset contador em caso de sucesso
-	h.failuresMutex.Lock()
-	if h.consecutiveFailures[h.WebhookConfig.URL] > 0 {
-		fmt.Printf("Webhook URL %s voltou ao normal após %d falhas\n", h.WebhookConfig.URL, h.consecutiveFailures[h.WebhookConfig.URL])
-		h.consecutiveFailures[h.WebhookConfig.URL] = 0
+	if h.Manager != nil {
+		h.Manager.wakeWebhookProcessor()
 	}
-	h.failuresMutex.Unlock()
-
-	// Log de sucesso - MANTER CÓDIGO EXISTENTE SE JÁ EXISTIR
-	// ou adicionar apenas este printf se não existir:
-	fmt.Printf("Webhook entregue com sucesso para dispositivo %d\n", deviceID)
 }
 
-func (h *EventHandler) SendTestWebhook(url string, secret string, payload interface{}) (bool, error) {
+// SendTestWebhook envia um evento de teste assinado com os segredos ativos da assinatura
+func (h *EventHandler) SendTestWebhook(url string, secrets []string, payload interface{}) (bool, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return false, fmt.Errorf("erro ao serializar payload: %v", err)
 	}
 
-	// Criar assinatura se um segredo for fornecido
-	var signature string
-	if secret != "" {
-		signature = generateSignature(jsonData, secret)
-	}
-
 	// Enviar para o webhook
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -1032,13 +1487,11 @@ func (h *EventHandler) SendTestWebhook(url string, secret string, payload interf
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "WhatsApp-Service-Webhook-Test/1.0")
+	req.Header.Set("X-Webhook-Event", webhook.EventWebhookTest)
 
-	if signature != "" {
-		req.Header.Set("X-Webhook-Signature", signature)
-	}
-
-	if secret != "" {
-		req.Header.Set("X-Webhook-Secret", secret)
+	// Assinar da mesma forma que as entregas reais; o segredo nunca é enviado
+	if len(secrets) > 0 {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(jsonData, time.Now(), secrets...))
 	}
 
 	// Enviar a requisição com timeout
@@ -1057,283 +1510,27 @@ func (h *EventHandler) SendTestWebhook(url string, secret string, payload interf
 	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
 }
 
-// Método para gerar assinatura para autenticação de webhook
-func generateSignature(payload []byte, secret string) string {
-	// Criar HMAC SHA256
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write(payload)
-
-	// Retornar assinatura em formato hexadecimal
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-// Método para registrar falha de entrega de webhook no banco de dados
-func (h *EventHandler) logWebhookDeliveryFailure(deviceID int64, eventType string, payload []byte, statusCode int, responseBody string, errorMessage string) {
-	// Identificar o webhook configurado (assumindo um único webhook global por enquanto)
-	var webhookID int64 = 0
-
-	// Idealmente, você buscaria o ID do webhook no banco com base no URL configurado
-	// Para simplificar, vamos assumir que temos apenas um webhook configurado
-
-	// Criar registro de entrega
-	delivery := &database.WebhookDelivery{
-		WebhookID:    webhookID,
-		EventType:    eventType,
-		Payload:      string(payload),
-		ResponseCode: statusCode,
-		ResponseBody: responseBody,
-		ErrorMessage: errorMessage,
-		AttemptCount: 1,
-		Status:       "failed",
-		NextRetryAt:  time.Time{}, // Sem próxima tentativa
-	}
-
-	// Salvar no banco de dados
-	err := h.DB.LogWebhookDelivery(delivery)
-	if err != nil {
-		fmt.Printf("Erro ao registrar falha de entrega de webhook: %v\n", err)
-	}
-}
-
-// Método para registrar sucesso de entrega de webhook
-func (h *EventHandler) logWebhookDeliverySuccess(deviceID int64, eventType string, payload []byte, statusCode int, responseBody string) {
-	// Identificar o webhook configurado (assumindo um único webhook global por enquanto)
-	var webhookID int64 = 0
-
-	// Criar registro de entrega
-	delivery := &database.WebhookDelivery{
-		WebhookID:    webhookID,
-		EventType:    eventType,
-		Payload:      string(payload),
-		ResponseCode: statusCode,
-		ResponseBody: responseBody,
-		ErrorMessage: "",
-		AttemptCount: 1,
-		Status:       "success",
-		NextRetryAt:  time.Time{}, // Sem próxima tentativa
-	}
-
-	// Salvar no banco de dados
-	err := h.DB.LogWebhookDelivery(delivery)
-	if err != nil {
-		fmt.Printf("Erro ao registrar sucesso de entrega de webhook: %v\n", err)
-	}
-}
-
-// Método para agendar reenvio de webhook em caso de falha
-func (h *EventHandler) scheduleWebhookRetry(deviceID int64, eventType string, payload []byte) {
-	// Identificar o webhook configurado
-	var webhookID int64 = 0
-
-	// Idealmente, buscar a entrega anterior para incrementar attempt_count
-	// Para simplificar, vamos criar uma nova entrada
-
-	// Calcular próxima tentativa com backoff exponencial
-	attemptCount := 1
-	// Backoff exponencial: 5s, 25s, 125s, etc. (5 * 5^n segundos)
-	nextRetryDelay := time.Duration(5*math.Pow(5, float64(attemptCount-1))) * time.Second
-	nextRetryTime := time.Now().Add(nextRetryDelay)
-
-	// Limitar número máximo de tentativas (exemplo: 5)
-	maxAttempts := 5
-	if attemptCount > maxAttempts {
-		fmt.Printf("Número máximo de tentativas alcançado para webhook. Desistindo.\n")
-		return
-	}
-
-	// Criar registro de entrega para reenvio
-	delivery := &database.WebhookDelivery{
-		WebhookID:    webhookID,
-		EventType:    eventType,
-		Payload:      string(payload),
-		ResponseCode: 0,
-		ResponseBody: "",
-		ErrorMessage: "Agendado para reenvio",
-		AttemptCount: attemptCount,
-		Status:       "pending",
-		NextRetryAt:  nextRetryTime,
-	}
+// trackWebhookFailure incrementa as falhas consecutivas de uma URL e notifica
+// quando o limite é atingido
+func (h *EventHandler) trackWebhookFailure(deviceID int64, webhookURL string, threshold int) {
+	h.failuresMutex.Lock()
+	h.consecutiveFailures[webhookURL]++
+	failures := h.consecutiveFailures[webhookURL]
+	h.failuresMutex.Unlock()
 
-	// Salvar no banco de dados
-	err := h.DB.LogWebhookDelivery(delivery)
-	if err != nil {
-		fmt.Printf("Erro ao agendar reenvio de webhook: %v\n", err)
+	if failures >= threshold && h.getNotificationService() != nil {
+		h.getNotificationService().NotifyWebhookDeliveryFailure(deviceID, webhookURL, failures)
 	}
 }
 
-// Método para processar reenvios pendentes
-// Este método seria chamado periodicamente por uma goroutine separada
-func (h *EventHandler) ProcessPendingWebhooks() {
-	// Buscar webhooks pendentes
-	pendingDeliveries, err := h.DB.GetPendingWebhookDeliveries()
-	if err != nil {
-		fmt.Printf("Erro ao buscar webhooks pendentes: %v\n", err)
-		return
-	}
-
-	for _, delivery := range pendingDeliveries {
-		// Obter configuração do webhook
-		webhookConfig, err := h.DB.GetWebhookConfigByID(delivery.WebhookID)
-		if err != nil || webhookConfig == nil {
-			fmt.Printf("Configuração de webhook não encontrada para ID %d: %v\n", delivery.WebhookID, err)
-			continue
-		}
-
-		// Verificar se webhook ainda está habilitado
-		if !webhookConfig.Enabled {
-			// Marcar como falha permanente
-			h.DB.UpdateWebhookDeliveryStatus(
-				delivery.ID,
-				"cancelled",
-				0,
-				"",
-				"Webhook desabilitado",
-				delivery.AttemptCount,
-				nil,
-			)
-			continue
-		}
-
-		// Tentar reenviar
-		payloadBytes := []byte(delivery.Payload)
-
-		// Criar assinatura se necessário
-		var signature string
-		if webhookConfig.Secret != "" {
-			signature = generateSignature(payloadBytes, webhookConfig.Secret)
-		}
-
-		// Criar e enviar requisição
-		req, err := http.NewRequest("POST", webhookConfig.URL, bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			fmt.Printf("Erro ao criar requisição para reenvio: %v\n", err)
-
-			// Atualizar status
-			h.DB.UpdateWebhookDeliveryStatus(
-				delivery.ID,
-				"failed",
-				0,
-				"",
-				fmt.Sprintf("Erro ao criar requisição: %v", err),
-				delivery.AttemptCount+1,
-				nil,
-			)
-			continue
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		if signature != "" {
-			req.Header.Set("X-Webhook-Signature", signature)
-		}
-
-		// Timeout para reenvio
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		req = req.WithContext(ctx)
-
-		// Enviar requisição
-		resp, err := h.httpClient.Do(req)
-		cancel() // Cancelar o contexto independente do resultado
-
-		if err != nil {
-			fmt.Printf("Erro ao reenviar webhook: %v\n", err)
-
-			// Calcular próxima tentativa com backoff exponencial
-			nextAttemptCount := delivery.AttemptCount + 1
-			maxAttempts := 5
-
-			if nextAttemptCount > maxAttempts {
-				// Desistir após número máximo de tentativas
-				h.DB.UpdateWebhookDeliveryStatus(
-					delivery.ID,
-					"failed",
-					0,
-					"",
-					fmt.Sprintf("Número máximo de tentativas alcançado: %v", err),
-					nextAttemptCount,
-					nil,
-				)
-			} else {
-				// Agendar próxima tentativa
-				nextRetryDelay := time.Duration(5*math.Pow(5, float64(nextAttemptCount-1))) * time.Second
-				nextRetryTime := time.Now().Add(nextRetryDelay)
-
-				h.DB.UpdateWebhookDeliveryStatus(
-					delivery.ID,
-					"retrying",
-					0,
-					"",
-					fmt.Sprintf("Erro ao enviar: %v", err),
-					nextAttemptCount,
-					&nextRetryTime,
-				)
-			}
-
-			continue
-		}
+// resetWebhookFailures zera o contador de falhas após uma entrega bem-sucedida
+func (h *EventHandler) resetWebhookFailures(webhookURL string) {
+	h.failuresMutex.Lock()
+	defer h.failuresMutex.Unlock()
 
-		// Processar resposta
-		defer resp.Body.Close()
-		responseBody, _ := ioutil.ReadAll(resp.Body)
-		responseStr := string(responseBody)
-
-		if resp.StatusCode >= 400 {
-			fmt.Printf("Reenvio de webhook retornou status de erro: %d\n", resp.StatusCode)
-
-			// Para erros 5xx, tentar novamente se não excedeu o limite
-			if resp.StatusCode >= 500 {
-				nextAttemptCount := delivery.AttemptCount + 1
-				maxAttempts := 5
-
-				if nextAttemptCount > maxAttempts {
-					// Desistir após número máximo de tentativas
-					h.DB.UpdateWebhookDeliveryStatus(
-						delivery.ID,
-						"failed",
-						resp.StatusCode,
-						responseStr,
-						fmt.Sprintf("Número máximo de tentativas alcançado. Último status: %d", resp.StatusCode),
-						nextAttemptCount,
-						nil,
-					)
-				} else {
-					// Agendar próxima tentativa
-					nextRetryDelay := time.Duration(5*math.Pow(5, float64(nextAttemptCount-1))) * time.Second
-					nextRetryTime := time.Now().Add(nextRetryDelay)
-
-					h.DB.UpdateWebhookDeliveryStatus(
-						delivery.ID,
-						"retrying",
-						resp.StatusCode,
-						responseStr,
-						fmt.Sprintf("Erro de servidor: %d", resp.StatusCode),
-						nextAttemptCount,
-						&nextRetryTime,
-					)
-				}
-			} else {
-				// Para erros 4xx, não tentar novamente (problema no cliente)
-				h.DB.UpdateWebhookDeliveryStatus(
-					delivery.ID,
-					"failed",
-					resp.StatusCode,
-					responseStr,
-					fmt.Sprintf("Erro no cliente: %d", resp.StatusCode),
-					delivery.AttemptCount+1,
-					nil,
-				)
-			}
-		} else {
-			// Sucesso!
-			h.DB.UpdateWebhookDeliveryStatus(
-				delivery.ID,
-				"success",
-				resp.StatusCode,
-				responseStr,
-				"",
-				delivery.AttemptCount+1,
-				nil,
-			)
-		}
+	if h.consecutiveFailures[webhookURL] > 0 {
+		fmt.Printf("Webhook URL %s voltou ao normal após %d falhas\n", webhookURL, h.consecutiveFailures[webhookURL])
+		h.consecutiveFailures[webhookURL] = 0
 	}
 }
 
@@ -0,0 +1,48 @@
+package whatsapp
+
+import (
+	"testing"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHasMessageContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *waProto.Message
+		want    bool
+	}{
+		{"nil", nil, false},
+		{"vazia", &waProto.Message{}, false},
+		{"texto", &waProto.Message{Conversation: proto.String("oi")}, true},
+		{"resposta de botão", &waProto.Message{
+			ButtonsResponseMessage: &waProto.ButtonsResponseMessage{
+				SelectedButtonID: proto.String("sim"),
+				Response:         &waProto.ButtonsResponseMessage_SelectedDisplayText{SelectedDisplayText: "Sim"},
+			},
+		}, true},
+		{"resposta de lista", &waProto.Message{
+			ListResponseMessage: &waProto.ListResponseMessage{
+				Title: proto.String("Plano básico"),
+				SingleSelectReply: &waProto.ListResponseMessage_SingleSelectReply{
+					SelectedRowID: proto.String("basico"),
+				},
+			},
+		}, true},
+		{"protocolo", &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{}}, false},
+		{"reação", &waProto.Message{ReactionMessage: &waProto.ReactionMessage{Text: proto.String("👍")}}, false},
+		{"distribuição de chaves", &waProto.Message{
+			SenderKeyDistributionMessage: &waProto.SenderKeyDistributionMessage{GroupID: proto.String("grupo")},
+			MessageContextInfo:           &waProto.MessageContextInfo{},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasMessageContent(tt.message); got != tt.want {
+				t.Errorf("hasMessageContent() = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// internal/whatsapp/lid_mappings.go
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-service/internal/database"
+)
+
+// learnLIDMapping registra que o LID pertence ao número. Um mapeamento novo ou
+// alterado é gravado e os registros guardados com o LID passam para o número.
+func (h *EventHandler) learnLIDMapping(lid types.JID, phone types.JID, source string) {
+	lidID, phoneID := lid.ToNonAD().String(), phone.ToNonAD().String()
+	if cached, exists := h.getCachedLIDMapping(lidID); exists && cached == phoneID {
+		return
+	}
+
+	// Só vai para o cache depois de gravado: uma falha é tentada de novo na
+	// próxima vez que o LID aparecer
+	rekeyed, err := h.DB.SaveLIDMapping(lidID, phoneID, source)
+	if err != nil {
+		fmt.Printf("Erro ao gravar mapeamento LID: %v\n", err)
+		return
+	}
+	h.cacheLIDMapping(lidID, phoneID)
+
+	if rekeyed > 0 {
+		fmt.Printf("%d registros do LID %s passaram para %s\n", rekeyed, lidID, phoneID)
+	}
+}
+
+// lookupLIDMapping procura o número de um LID no cache, no banco e, por fim,
+// no armazenamento de LIDs do whatsmeow
+func (h *EventHandler) lookupLIDMapping(lid types.JID) (types.JID, bool) {
+	lidID := lid.ToNonAD().String()
+
+	if cached, exists := h.getCachedLIDMapping(lidID); exists {
+		if phone, err := types.ParseJID(cached); err == nil {
+			return phone, true
+		}
+	}
+
+	mapping, err := h.DB.GetLIDMapping(lidID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar mapeamento do LID %s: %v\n", lidID, err)
+	} else if mapping != nil {
+		if phone, err := types.ParseJID(mapping.PhoneJID); err == nil {
+			h.cacheLIDMapping(lidID, mapping.PhoneJID)
+			return phone, true
+		}
+	}
+
+	if h.Manager == nil || h.Manager.container == nil {
+		return types.EmptyJID, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	phone, err := h.Manager.container.LIDMap.GetPNForLID(ctx, lid.ToNonAD())
+	if err != nil || phone.IsEmpty() {
+		return types.EmptyJID, false
+	}
+
+	h.learnLIDMapping(lid, phone, database.LIDSourceWhatsmeow)
+	return phone, true
+}
+
+// BackfillLIDMappings resolve pelo armazenamento do whatsmeow os LIDs sem
+// número que aparecem nas mensagens, entidades rastreadas e contatos
+func (m *Manager) BackfillLIDMappings() {
+	lids, err := m.db.GetUnmappedLIDs()
+	if err != nil {
+		fmt.Printf("Erro ao listar LIDs sem número: %v\n", err)
+		return
+	}
+
+	resolved := 0
+	for _, lidID := range lids {
+		lid, err := types.ParseJID(lidID)
+		if err != nil {
+			continue
+		}
+		if _, ok := m.eventHandler.lookupLIDMapping(lid); ok {
+			resolved++
+		}
+	}
+
+	if len(lids) > 0 {
+		fmt.Printf("Mapeamentos LID: %d de %d LIDs resolvidos\n", resolved, len(lids))
+	}
+}
+
+// LookupLIDMapping retorna o mapeamento de um LID ou de um número (JID ou
+// apenas dígitos), ou nil se não for conhecido
+func (m *Manager) LookupLIDMapping(id string) (*database.LIDMapping, error) {
+	if strings.HasSuffix(id, "@"+types.HiddenUserServer) {
+		lid, err := types.ParseJID(id)
+		if err != nil {
+			return nil, fmt.Errorf("LID inválido: %w", err)
+		}
+		if _, ok := m.eventHandler.lookupLIDMapping(lid); !ok {
+			return nil, nil
+		}
+		return m.db.GetLIDMapping(lid.ToNonAD().String())
+	}
+
+	normalized, err := normalizeRecipient(id)
+	if err != nil {
+		return nil, err
+	}
+	phone, _ := types.ParseJID(normalized)
+	phone = phone.ToNonAD()
+
+	mapping, err := m.db.GetLIDMappingByPhone(phone.String())
+	if err != nil || mapping != nil {
+		return mapping, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lid, err := m.container.LIDMap.GetLIDForPN(ctx, phone)
+	if err != nil || lid.IsEmpty() {
+		return nil, nil
+	}
+
+	m.eventHandler.learnLIDMapping(lid, phone, database.LIDSourceWhatsmeow)
+	return m.db.GetLIDMapping(lid.ToNonAD().String())
+}
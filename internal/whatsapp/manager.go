@@ -4,7 +4,11 @@ package whatsapp
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -20,6 +24,10 @@ import (
 	"whatsapp-service/internal/notification"
 )
 
+// ErrInvalidRecipient indica que o destinatário de uma mensagem enfileirada ou
+// agendada não é um JID válido
+var ErrInvalidRecipient = errors.New("JID inválido")
+
 // Manager gerencia múltiplos clientes WhatsApp
 type Manager struct {
 	clients             map[int64]*Client // Mapeado por deviceID
@@ -30,6 +38,10 @@ type Manager struct {
 	eventHandlers       []func(deviceID int64, evt interface{})
 	eventHandler        *EventHandler
 	notificationService *notification.NotificationService
+	webhookProcessor    *WebhookProcessor
+	outboundProcessor   *OutboundProcessor
+
+	mediaMaxDownloadBytes int64 // Limite das mídias baixadas por URL
 }
 
 // método para configurar notificações:
@@ -102,6 +114,8 @@ func NewManager(dbString string, postgresDB *database.DB) (*Manager, error) {
 		db:            postgresDB,
 		logger:        logger,
 		eventHandlers: make([]func(deviceID int64, evt interface{}), 0),
+
+		mediaMaxDownloadBytes: defaultMediaMaxDownloadBytes,
 	}
 
 	// Agora criar o eventHandler passando o manager
@@ -261,6 +275,310 @@ func (m *Manager) SendTextMessage(deviceID int64, to string, text string) (strin
 	return client.SendTextMessage(to, text)
 }
 
+// connectedClient retorna o cliente do dispositivo se estiver em memória e
+// conectado, sem criar um novo cliente
+func (m *Manager) connectedClient(deviceID int64) (*Client, bool) {
+	m.mutex.Lock()
+	client, exists := m.clients[deviceID]
+	m.mutex.Unlock()
+
+	if !exists || client == nil || !client.IsConnected() {
+		return nil, false
+	}
+	return client, true
+}
+
+// EnqueueTextMessage coloca uma mensagem de texto na fila de envio do dispositivo
+func (m *Manager) EnqueueTextMessage(deviceID int64, to string, text string) (*database.OutboundMessage, error) {
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindText, database.OutboundPayload{Text: text})
+}
+
+// EnqueueMediaMessage coloca uma mensagem de mídia na fila de envio do dispositivo.
+// O arquivo fica em ./storage/outbound até ser enviado, descartado ou cancelado.
+func (m *Manager) EnqueueMediaMessage(deviceID int64, to string, mimetype string, data []byte, caption string, options MediaOptions) (*database.OutboundMessage, error) {
+	if _, err := types.ParseJID(to); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	payload, err := prepareMediaPayload(deviceID, mimetype, data, caption, options)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := m.enqueueOutbound(deviceID, to, database.OutboundKindMedia, payload)
+	if err != nil {
+		os.Remove(payload.MediaPath)
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// prepareMediaPayload converte o áudio para nota de voz, se pedido, e guarda
+// o arquivo em ./storage/outbound
+func prepareMediaPayload(deviceID int64, mimetype string, data []byte, caption string, options MediaOptions) (database.OutboundPayload, error) {
+	if options.VoiceNote {
+		if !strings.HasPrefix(baseMimetype(mimetype), "audio/") {
+			return database.OutboundPayload{}, fmt.Errorf("apenas áudios podem ser enviados como nota de voz")
+		}
+
+		converted, err := convertToVoiceNote(data)
+		if err != nil {
+			return database.OutboundPayload{}, err
+		}
+		data, mimetype = converted, voiceNoteMimetype
+	}
+
+	mediaPath, err := saveOutboundMedia(deviceID, data)
+	if err != nil {
+		return database.OutboundPayload{}, err
+	}
+
+	return database.OutboundPayload{
+		Caption:   caption,
+		Mimetype:  mimetype,
+		MediaPath: mediaPath,
+		FileName:  options.FileName,
+		VoiceNote: options.VoiceNote,
+	}, nil
+}
+
+// enqueueOutbound grava a mensagem na fila e acorda o processador
+func (m *Manager) enqueueOutbound(deviceID int64, to string, kind string, payload database.OutboundPayload) (*database.OutboundMessage, error) {
+	message, err := m.newOutboundMessage(deviceID, to, kind, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.db.EnqueueOutboundMessage(message); err != nil {
+		return nil, fmt.Errorf("erro ao enfileirar mensagem: %w", err)
+	}
+
+	m.wakeOutboundProcessor()
+	return message, nil
+}
+
+// newOutboundMessage valida o dispositivo e o destinatário e monta a mensagem
+// com o payload serializado
+func (m *Manager) newOutboundMessage(deviceID int64, to string, kind string, payload database.OutboundPayload) (*database.OutboundMessage, error) {
+	recipient, err := types.ParseJID(to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	device, err := m.db.GetDeviceByID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("dispositivo não encontrado")
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao serializar mensagem: %w", err)
+	}
+
+	return &database.OutboundMessage{
+		DeviceID:  deviceID,
+		Recipient: recipient.String(),
+		Kind:      kind,
+		Payload:   string(encoded),
+	}, nil
+}
+
+// saveOutboundMedia guarda o arquivo de uma mensagem de mídia em ./storage/outbound
+// até o envio e retorna o caminho salvo
+func saveOutboundMedia(deviceID int64, data []byte) (string, error) {
+	return saveStorageFile("outbound", deviceID, data)
+}
+
+// saveStorageFile grava data em um arquivo novo em ./storage/<subdir>, com o
+// nome prefixado pelo ID do dono, e retorna o caminho salvo
+func saveStorageFile(subdir string, ownerID int64, data []byte) (string, error) {
+	dir := filepath.Join("./storage", subdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("erro ao criar diretório: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, fmt.Sprintf("%d_*", ownerID))
+	if err != nil {
+		return "", fmt.Errorf("erro ao salvar mídia: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("erro ao salvar mídia: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// CancelOutboundMessage cancela uma mensagem que ainda aguarda na fila.
+// Retorna false se a mensagem já foi reservada para envio ou finalizada.
+func (m *Manager) CancelOutboundMessage(message *database.OutboundMessage) (bool, error) {
+	cancelled, err := m.db.CancelOutboundMessage(message.ID)
+	if err != nil || !cancelled {
+		return cancelled, err
+	}
+
+	var payload database.OutboundPayload
+	if json.Unmarshal([]byte(message.Payload), &payload) == nil {
+		removeOutboundMedia(payload)
+	}
+
+	return true, nil
+}
+
+// ScheduleTextMessage agenda uma mensagem de texto para sendAt
+func (m *Manager) ScheduleTextMessage(deviceID int64, to string, text string, sendAt time.Time, timezone string) (*database.ScheduledMessage, error) {
+	return m.scheduleOutbound(deviceID, to, database.OutboundKindText, database.OutboundPayload{Text: text}, sendAt, timezone)
+}
+
+// ScheduleMediaMessage agenda uma mensagem de mídia para sendAt. O arquivo
+// fica em ./storage/outbound até o envio ou o cancelamento.
+func (m *Manager) ScheduleMediaMessage(deviceID int64, to string, mimetype string, data []byte, caption string, options MediaOptions, sendAt time.Time, timezone string) (*database.ScheduledMessage, error) {
+	if _, err := types.ParseJID(to); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	payload, err := prepareMediaPayload(deviceID, mimetype, data, caption, options)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := m.scheduleOutbound(deviceID, to, database.OutboundKindMedia, payload, sendAt, timezone)
+	if err != nil {
+		os.Remove(payload.MediaPath)
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// scheduleOutbound valida e grava um agendamento
+func (m *Manager) scheduleOutbound(deviceID int64, to string, kind string, payload database.OutboundPayload, sendAt time.Time, timezone string) (*database.ScheduledMessage, error) {
+	outbound, err := m.newOutboundMessage(deviceID, to, kind, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &database.ScheduledMessage{
+		DeviceID:  deviceID,
+		Recipient: outbound.Recipient,
+		Kind:      kind,
+		Payload:   outbound.Payload,
+		SendAt:    sendAt,
+		Timezone:  timezone,
+	}
+	if err := m.db.CreateScheduledMessage(message); err != nil {
+		return nil, fmt.Errorf("erro ao agendar mensagem: %w", err)
+	}
+
+	return message, nil
+}
+
+// UpdateScheduledMessage altera destinatário, conteúdo e horário de um
+// agendamento pendente. Retorna false se ele já foi disparado ou cancelado.
+func (m *Manager) UpdateScheduledMessage(message *database.ScheduledMessage, to string, payload database.OutboundPayload) (bool, error) {
+	outbound, err := m.newOutboundMessage(message.DeviceID, to, message.Kind, payload)
+	if err != nil {
+		return false, err
+	}
+
+	message.Recipient = outbound.Recipient
+	message.Payload = outbound.Payload
+
+	return m.db.UpdateScheduledMessage(message)
+}
+
+// CancelScheduledMessage cancela um agendamento pendente e descarta a mídia guardada.
+// Retorna false se ele já foi disparado ou cancelado.
+func (m *Manager) CancelScheduledMessage(message *database.ScheduledMessage) (bool, error) {
+	cancelled, err := m.db.CancelScheduledMessage(message.ID)
+	if err != nil || !cancelled {
+		return cancelled, err
+	}
+
+	var payload database.OutboundPayload
+	if json.Unmarshal([]byte(message.Payload), &payload) == nil {
+		removeOutboundMedia(payload)
+	}
+
+	return true, nil
+}
+
+// DispatchScheduledMessages coloca na fila de envio os agendamentos vencidos.
+// A fila aplica os limites de envio do dispositivo e, se ele estiver offline,
+// segura a mensagem até a reconexão.
+func (m *Manager) DispatchScheduledMessages() {
+	for {
+		dispatched, err := m.db.DispatchDueScheduledMessages(time.Now(), 100)
+		if err != nil {
+			fmt.Printf("Erro ao disparar mensagens agendadas: %v\n", err)
+			return
+		}
+
+		if len(dispatched) == 0 {
+			return
+		}
+
+		fmt.Printf("%d mensagens agendadas colocadas na fila de envio\n", len(dispatched))
+		m.wakeOutboundProcessor()
+
+		if len(dispatched) < 100 {
+			return
+		}
+	}
+}
+
+// GetDeviceSendLimits retorna os limites de envio efetivos do dispositivo:
+// os configurados para ele ou, se não houver, os padrões da fila de envio
+func (m *Manager) GetDeviceSendLimits(deviceID int64) (*database.DeviceSendLimits, error) {
+	limits, err := m.db.GetDeviceSendLimits(deviceID)
+	if err != nil || limits != nil {
+		return limits, err
+	}
+
+	m.mutex.Lock()
+	processor := m.outboundProcessor
+	m.mutex.Unlock()
+
+	// Sem processador em execução, valem os padrões do construtor
+	config := NewOutboundProcessor(m, OutboundProcessorConfig{}).config
+	if processor != nil {
+		config = processor.config
+	}
+
+	return &database.DeviceSendLimits{
+		DeviceID:          deviceID,
+		MessagesPerMinute: config.MessagesPerMinute,
+		DailyCap:          config.DailyCap,
+		MinTypingDelayMs:  int(config.MinTypingDelay / time.Millisecond),
+		MaxTypingDelayMs:  int(config.MaxTypingDelay / time.Millisecond),
+	}, nil
+}
+
+// SetDeviceSendLimits grava os limites de envio do dispositivo e os aplica
+// imediatamente, liberando uma fila que aguardava o limite anterior
+func (m *Manager) SetDeviceSendLimits(limits *database.DeviceSendLimits) error {
+	if err := m.db.UpsertDeviceSendLimits(limits); err != nil {
+		return fmt.Errorf("erro ao salvar limites de envio: %w", err)
+	}
+
+	m.mutex.Lock()
+	processor := m.outboundProcessor
+	m.mutex.Unlock()
+
+	if processor != nil {
+		processor.ResetDevice(limits.DeviceID)
+	}
+	return nil
+}
+
 // AddEventHandler adiciona um handler global de eventos
 func (m *Manager) AddEventHandler(handler func(deviceID int64, evt interface{})) {
 	m.mutex.Lock()
@@ -626,6 +944,32 @@ func (m *Manager) CleanCorruptedSessions() error {
 	return nil
 }
 
+// PurgeExpiredMessages aplica a retenção de mensagens dos tenants, removendo as
+// mensagens expiradas e os arquivos de mídia armazenados localmente
+func (m *Manager) PurgeExpiredMessages() {
+	// As mensagens já apagadas voltam mesmo quando a limpeza seguinte falha,
+	// e seus arquivos precisam ser removidos de qualquer forma
+	mediaURLs, err := m.db.DeleteExpiredMessages()
+	if err != nil {
+		fmt.Printf("Erro ao remover mensagens expiradas: %v\n", err)
+	}
+
+	if len(mediaURLs) == 0 {
+		return
+	}
+
+	removedFiles := 0
+	if m.eventHandler != nil {
+		for _, mediaURL := range mediaURLs {
+			if mediaURL != "" && m.eventHandler.removeStoredMedia(mediaURL) {
+				removedFiles++
+			}
+		}
+	}
+
+	fmt.Printf("Retenção de mensagens: %d mensagens e %d arquivos de mídia removidos\n", len(mediaURLs), removedFiles)
+}
+
 // Método para verificar saúde dos clientes conectados
 func (m *Manager) HealthCheckClients() {
 	log.Printf("HealthCheckClients INIT: Verificando saúde dos clientes conectados...")
@@ -684,6 +1028,17 @@ func (m *Manager) InitializeWithCleanup() error {
 	// Verificar saúde dos clientes
 	m.HealthCheckClients()
 
+	// Carregar assinaturas de webhook antes de receber eventos
+	if err := m.LoadWebhookConfigs(); err != nil {
+		fmt.Printf("Aviso: %v\n", err)
+	}
+
+	// Recuperar em segundo plano os números dos LIDs já gravados
+	go m.BackfillLIDMappings()
+
+	// Criar os chats das conversas que já têm mensagens armazenadas
+	go m.BackfillChats()
+
 	// Aguardar um pouco antes de tentar reconectar
 	time.Sleep(2 * time.Second)
 
@@ -712,72 +1067,165 @@ func (m *Manager) InitializeWithCleanup() error {
 // 	}
 // }
 
-func (m *Manager) ConfigureWebhook(config *WebhookConfig) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// LoadWebhookConfigs carrega as assinaturas de webhook do banco para o EventHandler
+func (m *Manager) LoadWebhookConfigs() error {
+	if m.eventHandler == nil {
+		return fmt.Errorf("event handler não está inicializado")
+	}
+	return m.eventHandler.LoadWebhookConfigs()
+}
+
+// SaveWebhookConfig cria ou atualiza uma assinatura de webhook no banco
+// e aplica a alteração imediatamente no EventHandler
+func (m *Manager) SaveWebhookConfig(config *database.WebhookConfig) error {
+	var err error
+	if config.ID == 0 {
+		err = m.db.SaveWebhookConfig(config)
+	} else {
+		err = m.db.UpdateWebhookConfig(config)
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao salvar configuração de webhook: %w", err)
+	}
 
-	// Configurar webhook no EventHandler
 	if m.eventHandler != nil {
-		m.eventHandler.SetWebhookConfig(config)
-	}
-
-	// Armazenar configuração no banco de dados para persistência
-	if m.db != nil {
-		dbConfig := &database.WebhookConfig{
-			TenantID:  config.TenantID,
-			URL:       config.URL,
-			Secret:    config.Secret,
-			Events:    config.Events,
-			DeviceIDs: config.DeviceIDs,
-			Enabled:   config.Enabled,
-		}
+		m.eventHandler.SetWebhookConfig(newWebhookConfig(*config))
+	}
 
-		// Verificar se já existe uma configuração para este tenant
-		existingConfigs, err := m.db.GetWebhookConfigsByTenant(config.TenantID)
-		if err != nil {
-			fmt.Printf("Erro ao buscar configurações existentes: %v\n", err)
-		} else if len(existingConfigs) > 0 {
-			// Atualizar configuração existente
-			dbConfig.ID = existingConfigs[0].ID
-			err = m.db.UpdateWebhookConfig(dbConfig)
-			if err != nil {
-				fmt.Printf("Erro ao atualizar configuração de webhook: %v\n", err)
-			}
-		} else {
-			// Criar nova configuração
-			err = m.db.SaveWebhookConfig(dbConfig)
-			if err != nil {
-				fmt.Printf("Erro ao salvar configuração de webhook: %v\n", err)
-			}
-		}
+	return nil
+}
+
+// DeleteWebhookConfig remove uma assinatura de webhook do banco e do EventHandler
+func (m *Manager) DeleteWebhookConfig(id int64) error {
+	if err := m.db.DeleteWebhookConfig(id); err != nil {
+		return fmt.Errorf("erro ao excluir configuração de webhook: %w", err)
+	}
+
+	if m.eventHandler != nil {
+		m.eventHandler.RemoveWebhookConfig(id)
 	}
+
+	return nil
+}
+
+// ReplayWebhookDelivery reenfileira uma entrega finalizada (ex.: dead-letter)
+func (m *Manager) ReplayWebhookDelivery(id int64) (int64, error) {
+	newID, err := m.db.ReplayWebhookDelivery(id)
+	if err != nil {
+		return 0, err
+	}
+
+	m.wakeWebhookProcessor()
+	return newID, nil
+}
+
+// ReplayWebhookDeliveries reenfileira em lote as entregas que atendem ao filtro
+func (m *Manager) ReplayWebhookDeliveries(filter database.WebhookLogFilter) ([]int64, error) {
+	ids, err := m.db.ReplayWebhookDeliveries(filter)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao reenfileirar entregas: %w", err)
+	}
+
+	if len(ids) > 0 {
+		m.wakeWebhookProcessor()
+	}
+	return ids, nil
+}
+
+// RotateWebhookSecret troca o segredo de uma assinatura. O segredo anterior
+// continua assinando as entregas durante gracePeriod para o receptor migrar.
+func (m *Manager) RotateWebhookSecret(id int64, newSecret string, gracePeriod time.Duration) (*database.WebhookConfig, error) {
+	config, err := m.db.RotateWebhookSecret(id, newSecret, time.Now().Add(gracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao rotacionar segredo do webhook: %w", err)
+	}
+
+	if m.eventHandler != nil && config != nil {
+		m.eventHandler.SetWebhookConfig(newWebhookConfig(*config))
+	}
+
+	return config, nil
 }
 
 // Adicionar método para enviar evento de teste
-func (m *Manager) SendTestWebhook(url string, secret string, payload interface{}) (bool, error) {
+func (m *Manager) SendTestWebhook(url string, secrets []string, payload interface{}) (bool, error) {
 	if m.eventHandler != nil {
-		return m.eventHandler.SendTestWebhook(url, secret, payload)
+		return m.eventHandler.SendTestWebhook(url, secrets, payload)
 	}
 	return false, fmt.Errorf("event handler não está inicializado")
 }
 
-// Iniciar worker de processamento de reenvio de webhooks
-// func (m *Manager) StartWebhookProcessor() {
-// 	go func() {
-// 		// Processar a cada 30 segundos
-// 		ticker := time.NewTicker(30 * time.Second)
-// 		defer ticker.Stop()
+// StartWebhookProcessor inicia os workers que consomem a fila de entregas de webhook
+func (m *Manager) StartWebhookProcessor(config WebhookProcessorConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-// 		for {
-// 			select {
-// 			case <-ticker.C:
-// 				if m.eventHandler != nil {
-// 					m.eventHandler.ProcessPendingWebhooks()
-// 				}
-// 			}
-// 		}
-// 	}()
-// }
+	if m.webhookProcessor != nil || m.eventHandler == nil {
+		return
+	}
+
+	m.webhookProcessor = NewWebhookProcessor(m.eventHandler, config)
+	m.webhookProcessor.Start()
+}
+
+// StopWebhookProcessor encerra os workers aguardando as entregas em andamento
+func (m *Manager) StopWebhookProcessor() {
+	m.mutex.Lock()
+	processor := m.webhookProcessor
+	m.webhookProcessor = nil
+	m.mutex.Unlock()
+
+	if processor != nil {
+		processor.Stop()
+	}
+}
+
+// wakeWebhookProcessor avisa o processador de que há novas entregas na fila
+func (m *Manager) wakeWebhookProcessor() {
+	m.mutex.Lock()
+	processor := m.webhookProcessor
+	m.mutex.Unlock()
+
+	if processor != nil {
+		processor.Wake()
+	}
+}
+
+// StartOutboundProcessor inicia o processamento da fila de envio
+func (m *Manager) StartOutboundProcessor(config OutboundProcessorConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.outboundProcessor != nil {
+		return
+	}
+
+	m.outboundProcessor = NewOutboundProcessor(m, config)
+	m.outboundProcessor.Start()
+}
+
+// StopOutboundProcessor encerra a fila de envio aguardando os envios em andamento
+func (m *Manager) StopOutboundProcessor() {
+	m.mutex.Lock()
+	processor := m.outboundProcessor
+	m.outboundProcessor = nil
+	m.mutex.Unlock()
+
+	if processor != nil {
+		processor.Stop()
+	}
+}
+
+// wakeOutboundProcessor avisa o processador de que há novas mensagens na fila
+func (m *Manager) wakeOutboundProcessor() {
+	m.mutex.Lock()
+	processor := m.outboundProcessor
+	m.mutex.Unlock()
+
+	if processor != nil {
+		processor.Wake()
+	}
+}
 
 func (m *Manager) Connect() error {
 	//IGNORANDO, POIS OS WEBHOOKS SÃO PROCESSADOS NA API
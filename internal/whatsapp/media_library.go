@@ -0,0 +1,278 @@
+// internal/whatsapp/media_library.go
+package whatsapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+
+	"whatsapp-service/internal/database"
+)
+
+// Tamanho máximo padrão de uma mídia baixada por URL
+const defaultMediaMaxDownloadBytes = 64 << 20
+
+// Por quanto tempo o upload de uma mídia da biblioteca é reaproveitado. O
+// WhatsApp mantém o arquivo por mais tempo, mas uma margem curta evita enviar
+// referências que o destinatário já não consiga baixar.
+const mediaUploadReuseTTL = 24 * time.Hour
+
+// mediaHTTPClient baixa mídias e prévias de links recusando conexões com endereços
+// internos (loopback, rede privada, link-local, CGNAT)
+var mediaHTTPClient = &http.Client{
+	Timeout: 2 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: denyInternalAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("redirecionamentos demais")
+		}
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("redirecionamento para URL não HTTPS")
+		}
+		return nil
+	},
+}
+
+// Faixa de endereços compartilhados (CGNAT, RFC 6598), usada por provedores e
+// redes de nuvem internas
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// denyInternalAddress impede o download de endereços da rede interna. É
+// chamado com o IP já resolvido, então vale também para nomes de DNS.
+func denyInternalAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("endereço não permitido: %s", host)
+	}
+	return nil
+}
+
+// SetMediaMaxDownloadSize define o tamanho máximo, em bytes, das mídias baixadas por URL
+func (m *Manager) SetMediaMaxDownloadSize(maxBytes int64) {
+	if maxBytes > 0 {
+		m.mediaMaxDownloadBytes = maxBytes
+	}
+}
+
+// FetchMediaURL baixa uma mídia de uma URL HTTPS, respeitando o tamanho máximo,
+// e identifica o mimetype pelo conteúdo. Retorna os dados, o mimetype e o nome
+// do arquivo extraído da URL.
+func (m *Manager) FetchMediaURL(rawURL string) ([]byte, string, string, error) {
+	maxBytes := m.mediaMaxDownloadBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMediaMaxDownloadBytes
+	}
+
+	data, contentType, finalURL, err := fetchHTTPS(rawURL, maxBytes)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("erro ao baixar mídia: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, "", "", fmt.Errorf("mídia vazia")
+	}
+
+	fileName := path.Base(finalURL.Path)
+	if fileName == "/" || fileName == "." {
+		fileName = ""
+	}
+
+	return data, detectMimetype(data, contentType, fileName), fileName, nil
+}
+
+// fetchHTTPS baixa o conteúdo de uma URL HTTPS com até maxBytes. Retorna os
+// dados, o Content-Type informado pelo servidor e a URL final (após redirecionamentos).
+func fetchHTTPS(rawURL string, maxBytes int64) ([]byte, string, *url.URL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, "", nil, fmt.Errorf("URL inválida: apenas URLs https são aceitas")
+	}
+
+	resp, err := mediaHTTPClient.Get(parsed.String())
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, "", nil, fmt.Errorf("conteúdo maior que o limite de %d bytes", maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", nil, fmt.Errorf("conteúdo maior que o limite de %d bytes", maxBytes)
+	}
+
+	return data, resp.Header.Get("Content-Type"), resp.Request.URL, nil
+}
+
+// detectMimetype identifica o tipo pelo conteúdo. Quando o conteúdo não tem
+// assinatura conhecida, usa o tipo declarado pelo servidor ou a extensão.
+func detectMimetype(data []byte, declared string, fileName string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+
+	switch sniffed {
+	case "application/ogg":
+		// Áudios de voz do WhatsApp são Ogg/Opus
+		return "audio/ogg"
+	case "application/octet-stream", "text/plain":
+		if declaredType, _, err := mime.ParseMediaType(declared); err == nil && declaredType != "application/octet-stream" {
+			return declaredType
+		}
+		if byExtension, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(fileName))); err == nil {
+			return byExtension
+		}
+	}
+
+	return sniffed
+}
+
+// SaveLibraryMedia guarda uma mídia na biblioteca do tenant. Se o mesmo
+// conteúdo já estiver guardado, retorna a mídia existente e created = false.
+func (m *Manager) SaveLibraryMedia(tenantID int64, fileName string, mimetype string, sourceURL string, data []byte) (*database.MediaFile, bool, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	existing, err := m.db.GetMediaFileBySHA256(tenantID, hash)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+
+	mediaPath, err := saveStorageFile("library", tenantID, data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	media := &database.MediaFile{
+		TenantID:  tenantID,
+		FileName:  fileName,
+		Mimetype:  mimetype,
+		Size:      int64(len(data)),
+		SHA256:    hash,
+		Path:      mediaPath,
+		SourceURL: sourceURL,
+	}
+	if err := m.db.CreateMediaFile(media); err != nil {
+		os.Remove(mediaPath)
+		return nil, false, err
+	}
+
+	return media, true, nil
+}
+
+// DeleteLibraryMedia remove uma mídia da biblioteca. Mensagens com essa mídia
+// que ainda aguardam na fila falharão ao enviar.
+func (m *Manager) DeleteLibraryMedia(media *database.MediaFile) error {
+	if err := m.db.DeleteMediaFile(media.ID); err != nil {
+		return err
+	}
+
+	if err := os.Remove(media.Path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Aviso: erro ao remover mídia %d da biblioteca: %v\n", media.ID, err)
+	}
+	return nil
+}
+
+// EnqueueLibraryMedia coloca na fila de envio uma mídia da biblioteca. O
+// arquivo não é copiado: a mensagem referencia a mídia guardada. Como o
+// arquivo não é convertido, notas de voz exigem um áudio Ogg/Opus na biblioteca.
+func (m *Manager) EnqueueLibraryMedia(deviceID int64, to string, media *database.MediaFile, caption string, options MediaOptions) (*database.OutboundMessage, error) {
+	if options.VoiceNote && baseMimetype(media.Mimetype) != "audio/ogg" {
+		return nil, fmt.Errorf("a mídia precisa ser um áudio Ogg/Opus para ser enviada como nota de voz")
+	}
+
+	fileName := options.FileName
+	if fileName == "" {
+		fileName = media.FileName
+	}
+
+	payload := database.OutboundPayload{
+		Caption:   caption,
+		Mimetype:  media.Mimetype,
+		MediaPath: media.Path,
+		Shared:    true,
+		MediaID:   media.ID,
+		FileName:  fileName,
+		VoiceNote: options.VoiceNote,
+	}
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindMedia, payload)
+}
+
+// sendLibraryMedia envia uma mídia da biblioteca reaproveitando o último
+// upload feito pelo dispositivo, quando ainda recente
+func (m *Manager) sendLibraryMedia(client *Client, to string, payload database.OutboundPayload) (string, error) {
+	data, err := os.ReadFile(payload.MediaPath)
+	if err != nil {
+		return "", fmt.Errorf("erro ao ler mídia %d da biblioteca: %w", payload.MediaID, err)
+	}
+
+	cached, err := m.db.GetMediaUpload(payload.MediaID, client.DeviceID, time.Now().Add(-mediaUploadReuseTTL))
+	if err != nil {
+		fmt.Printf("Erro ao buscar upload da mídia %d: %v\n", payload.MediaID, err)
+	}
+	if cached != nil {
+		uploaded := whatsmeow.UploadResponse{
+			URL:           cached.URL,
+			DirectPath:    cached.DirectPath,
+			MediaKey:      cached.MediaKey,
+			FileEncSHA256: cached.FileEncSHA256,
+			FileSHA256:    cached.FileSHA256,
+			FileLength:    cached.FileLength,
+		}
+		return client.SendUploadedMedia(to, payload.Mimetype, uploaded, payload.Caption, data, payloadMediaOptions(payload))
+	}
+
+	uploaded, err := client.UploadMedia(payload.Mimetype, data)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.db.SaveMediaUpload(&database.MediaUpload{
+		MediaID:       payload.MediaID,
+		DeviceID:      client.DeviceID,
+		URL:           uploaded.URL,
+		DirectPath:    uploaded.DirectPath,
+		MediaKey:      uploaded.MediaKey,
+		FileSHA256:    uploaded.FileSHA256,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileLength:    uploaded.FileLength,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao guardar upload da mídia %d: %v\n", payload.MediaID, err)
+	}
+
+	return client.SendUploadedMedia(to, payload.Mimetype, uploaded, payload.Caption, data, payloadMediaOptions(payload))
+}
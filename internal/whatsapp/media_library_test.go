@@ -0,0 +1,27 @@
+package whatsapp
+
+import "testing"
+
+func TestDenyInternalAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		denied  bool
+	}{
+		{"127.0.0.1:443", true},
+		{"10.1.2.3:443", true},
+		{"192.168.0.10:443", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:443", true},
+		{"100.127.255.254:443", true},
+		{"[::1]:443", true},
+		{"100.128.0.1:443", false},
+		{"8.8.8.8:443", false},
+	}
+
+	for _, tt := range tests {
+		err := denyInternalAddress("tcp", tt.address, nil)
+		if denied := err != nil; denied != tt.denied {
+			t.Errorf("%s: bloqueado = %v, esperado %v", tt.address, denied, tt.denied)
+		}
+	}
+}
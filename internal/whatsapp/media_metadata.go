@@ -0,0 +1,396 @@
+// internal/whatsapp/media_metadata.go
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"mime"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Maior lado, em pixels, das miniaturas enviadas junto com imagens e vídeos
+const thumbnailMaxSize = 72
+
+// Lado, em pixels, das figurinhas
+const stickerSize = 512
+
+// Maior lado, em pixels, das fotos de grupo
+const groupPhotoMaxSize = 640
+
+// Maior número de pixels de uma imagem decodificada. Imagens muito comprimidas
+// podem declarar dimensões enormes e esgotar a memória na decodificação.
+const imageMaxPixels = 25_000_000
+
+// Tempo máximo de execução do ffprobe e do ffmpeg sobre uma mídia. Arquivos
+// malformados podem prender as ferramentas indefinidamente.
+const mediaToolTimeout = 2 * time.Minute
+
+// Mimetype das notas de voz do WhatsApp
+const voiceNoteMimetype = "audio/ogg; codecs=opus"
+
+// MediaOptions são opções de envio de uma mídia
+type MediaOptions struct {
+	FileName  string // Nome exibido em documentos
+	VoiceNote bool   // Enviar áudio como nota de voz (convertido para Ogg/Opus)
+}
+
+// mediaMetadata são as propriedades que o WhatsApp exibe antes do download
+type mediaMetadata struct {
+	Width     uint32
+	Height    uint32
+	Seconds   uint32
+	Thumbnail []byte // JPEG
+}
+
+// describeMedia extrai dimensões, duração e miniatura da mídia. Falhas são
+// registradas em log e resultam em metadados parciais: o envio continua.
+func describeMedia(mediaType whatsmeow.MediaType, data []byte) mediaMetadata {
+	var meta mediaMetadata
+	if len(data) == 0 {
+		return meta
+	}
+
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		img, err := decodeImage(data)
+		if err != nil {
+			fmt.Printf("Aviso: não foi possível decodificar a imagem enviada: %v\n", err)
+			return meta
+		}
+		meta.Width = uint32(img.Bounds().Dx())
+		meta.Height = uint32(img.Bounds().Dy())
+		if meta.Thumbnail, err = thumbnailJPEG(img); err != nil {
+			fmt.Printf("Aviso: erro ao gerar miniatura da imagem: %v\n", err)
+		}
+	case whatsmeow.MediaVideo, whatsmeow.MediaAudio:
+		inputFile, err := writeTempMedia("probe", data)
+		if err != nil {
+			fmt.Printf("Aviso: %v\n", err)
+			return meta
+		}
+		defer os.Remove(inputFile)
+
+		if err := probeMedia(inputFile, &meta); err != nil {
+			fmt.Printf("Aviso: erro ao analisar mídia com ffprobe: %v\n", err)
+		}
+		if mediaType == whatsmeow.MediaVideo {
+			if meta.Thumbnail, err = videoThumbnail(inputFile); err != nil {
+				fmt.Printf("Aviso: erro ao gerar miniatura do vídeo: %v\n", err)
+			}
+		}
+	}
+
+	return meta
+}
+
+// decodeImage decodifica a imagem depois de conferir pelo cabeçalho que as
+// dimensões cabem em imageMaxPixels
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > imageMaxPixels {
+		return nil, fmt.Errorf("imagem muito grande (%dx%d)", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// thumbnailJPEG reduz a imagem para caber em thumbnailMaxSize e a codifica
+// em JPEG. Áreas transparentes ficam brancas.
+func thumbnailJPEG(img image.Image) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("imagem vazia")
+	}
+	return encodeJPEG(scaleImage(img, img.Bounds(), thumbnailMaxSize), 60)
+}
+
+// groupPhotoJPEG recorta a imagem no quadrado central e a reduz para caber em
+// groupPhotoMaxSize, no formato JPEG exigido para fotos de grupo
+func groupPhotoJPEG(data []byte) ([]byte, error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("imagem inválida (use PNG, JPEG ou GIF): %w", err)
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, fmt.Errorf("imagem vazia")
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	return encodeJPEG(scaleImage(img, image.Rect(x0, y0, x0+side, y0+side), groupPhotoMaxSize), 85)
+}
+
+// scaleImage reduz a área bounds da imagem para caber em maxSize, calculando
+// a média de cada bloco de pixels, sobre fundo branco
+func scaleImage(img image.Image, bounds image.Rectangle, maxSize int) *image.RGBA {
+	width, height := bounds.Dx(), bounds.Dy()
+
+	scale := math.Min(1, float64(maxSize)/float64(max(width, height)))
+	scaledWidth := max(1, int(float64(width)*scale))
+	scaledHeight := max(1, int(float64(height)*scale))
+
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	for ty := 0; ty < scaledHeight; ty++ {
+		y0 := bounds.Min.Y + ty*height/scaledHeight
+		y1 := max(y0+1, bounds.Min.Y+(ty+1)*height/scaledHeight)
+
+		for tx := 0; tx < scaledWidth; tx++ {
+			x0 := bounds.Min.X + tx*width/scaledWidth
+			x1 := max(x0+1, bounds.Min.X+(tx+1)*width/scaledWidth)
+
+			var r, g, b, a, count uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, pa := img.At(x, y).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			// Cores pré-multiplicadas: somar o fundo branco na proporção transparente
+			background := 0xffff - a/count
+			scaled.Set(tx, ty, color.RGBA64{
+				R: uint16(r/count + background),
+				G: uint16(g/count + background),
+				B: uint16(b/count + background),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return scaled
+}
+
+// encodeJPEG codifica a imagem em JPEG com a qualidade informada
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// probeMedia preenche dimensões e duração usando o ffprobe
+func probeMedia(inputFile string, meta *mediaMetadata) error {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return fmt.Errorf("ffprobe não encontrado no sistema: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaToolTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,width,height:format=duration",
+		"-of", "json",
+		inputFile)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("ffprobe excedeu o tempo limite de %s", mediaToolTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%w, stderr: %s", err, stderr.String())
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     uint32 `json:"width"`
+			Height    uint32 `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return fmt.Errorf("saída inválida do ffprobe: %w", err)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && stream.Width > 0 {
+			meta.Width = stream.Width
+			meta.Height = stream.Height
+			break
+		}
+	}
+
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil && duration > 0 {
+		meta.Seconds = uint32(math.Ceil(duration))
+	}
+
+	return nil
+}
+
+// videoThumbnail extrai o primeiro quadro do vídeo como miniatura JPEG
+func videoThumbnail(inputFile string) ([]byte, error) {
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", thumbnailMaxSize, thumbnailMaxSize)
+	return runFFmpeg(
+		"-i", inputFile,
+		"-frames:v", "1",
+		"-vf", scale,
+		"-c:v", "mjpeg",
+		"-q:v", "5",
+		"-f", "image2pipe",
+		"pipe:1")
+}
+
+// convertToVoiceNote converte um áudio para Ogg/Opus mono, o formato das notas
+// de voz do WhatsApp
+func convertToVoiceNote(data []byte) ([]byte, error) {
+	inputFile, err := writeTempMedia("voice", data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inputFile)
+
+	// -vn: descartar capa ou vídeo; -application voip: perfil do Opus para voz
+	output, err := runFFmpeg(
+		"-i", inputFile,
+		"-vn",
+		"-ac", "1",
+		"-ar", "48000",
+		"-c:a", "libopus",
+		"-b:a", "32k",
+		"-application", "voip",
+		"-f", "ogg",
+		"pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao converter áudio para nota de voz: %w", err)
+	}
+
+	return output, nil
+}
+
+// convertToSticker converte uma imagem para WebP 512x512, o formato das
+// figurinhas do WhatsApp, mantendo a proporção com fundo transparente
+func convertToSticker(data []byte) ([]byte, error) {
+	inputFile, err := writeTempMedia("sticker", data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inputFile)
+
+	// Saída em arquivo para o ffmpeg poder finalizar o cabeçalho RIFF do WebP
+	outputFile := inputFile + ".webp"
+	defer os.Remove(outputFile)
+
+	filter := fmt.Sprintf("scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,format=rgba,"+
+		"pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=black@0", stickerSize)
+	if _, err := execFFmpeg(
+		"-i", inputFile,
+		"-vf", filter,
+		"-frames:v", "1",
+		"-c:v", "libwebp",
+		"-quality", "80",
+		outputFile); err != nil {
+		return nil, fmt.Errorf("erro ao converter imagem para figurinha: %w", err)
+	}
+
+	output, err := os.ReadFile(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler figurinha convertida: %w", err)
+	}
+	return output, nil
+}
+
+// runFFmpeg executa o ffmpeg e retorna o que foi escrito na saída padrão.
+// Uma saída vazia é tratada como erro.
+func runFFmpeg(args ...string) ([]byte, error) {
+	output, err := execFFmpeg(args...)
+	if err == nil && len(output) == 0 {
+		err = fmt.Errorf("ffmpeg não gerou saída")
+	}
+	return output, err
+}
+
+// execFFmpeg executa o ffmpeg com as opções comuns e retorna a saída padrão
+func execFFmpeg(args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("ffmpeg não encontrado no sistema: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaToolTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-v", "error", "-y"}, args...)...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("ffmpeg excedeu o tempo limite de %s", mediaToolTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao executar ffmpeg: %w, stderr: %s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// writeTempMedia grava data em um arquivo temporário em ./temp para as
+// ferramentas externas, que precisam de acesso aleatório ao arquivo (MP4)
+func writeTempMedia(prefix string, data []byte) (string, error) {
+	tempDir := "./temp"
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", fmt.Errorf("erro ao criar diretório temporário: %w", err)
+	}
+
+	file, err := os.CreateTemp(tempDir, prefix+"_*")
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar arquivo temporário: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("erro ao gravar arquivo temporário: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+// documentFileName retorna o nome exibido de um documento, gerando um a partir
+// do mimetype quando não informado
+func documentFileName(fileName string, mimetype string) string {
+	if fileName != "" {
+		return fileName
+	}
+
+	if extensions, err := mime.ExtensionsByType(baseMimetype(mimetype)); err == nil && len(extensions) > 0 {
+		return "documento" + extensions[0]
+	}
+	return "documento"
+}
+
+// baseMimetype remove os parâmetros de um mimetype ("audio/ogg; codecs=opus" -> "audio/ogg")
+func baseMimetype(mimetype string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(mimetype, ";", 2)[0]))
+}
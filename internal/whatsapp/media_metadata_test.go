@@ -0,0 +1,37 @@
+package whatsapp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngWithSize gera um PNG de 1x1 cujo cabeçalho declara as dimensões informadas
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Assinatura (8 bytes), tamanho e tipo do chunk IHDR (8 bytes), dados (13 bytes), CRC
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodeImage(t *testing.T) {
+	if _, err := decodeImage(pngWithSize(t, 1, 1)); err != nil {
+		t.Fatalf("imagem pequena recusada: %v", err)
+	}
+
+	if _, err := decodeImage(pngWithSize(t, 100000, 100000)); err == nil {
+		t.Fatal("imagem de 10 gigapixels aceita")
+	}
+}
@@ -0,0 +1,461 @@
+// internal/whatsapp/outbound_processor.go
+package whatsapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-service/internal/database"
+	"whatsapp-service/pkg/webhook"
+)
+
+// Intervalo até verificar de novo um dispositivo desconectado com mensagens na fila
+const outboundOfflineRetry = 30 * time.Second
+
+// OutboundProcessorConfig define o ritmo padrão da fila de envio. Cada
+// dispositivo pode ter limites próprios em device_send_limits.
+type OutboundProcessorConfig struct {
+	PollInterval      time.Duration // Intervalo de verificação da fila
+	MessagesPerMinute int           // Ritmo máximo de envio por dispositivo
+	DailyCap          int           // Envios por dispositivo por dia (0 = sem limite)
+	MinTypingDelay    time.Duration // Tempo mínimo de "digitando..." antes de cada envio
+	MaxTypingDelay    time.Duration // Tempo máximo de "digitando..." antes de cada envio
+	MaxAttempts       int           // Tentativas antes de marcar a mensagem como failed
+	BaseDelay         time.Duration // Atraso da primeira retentativa
+	MaxDelay          time.Duration // Teto do backoff exponencial
+	Lease             time.Duration // Tempo que uma mensagem reservada fica bloqueada para outras réplicas
+}
+
+// sendLimits são os limites efetivos de um dispositivo
+type sendLimits struct {
+	perMinute int
+	dailyCap  int
+	minTyping time.Duration
+	maxTyping time.Duration
+}
+
+// OutboundProcessor consome a fila persistente outbound_messages. Cada
+// dispositivo envia uma mensagem por vez, espaçadas conforme o limite por
+// minuto e com um intervalo aleatório de "digitando..." antes de cada envio,
+// para que o padrão de envio se pareça com o de uma pessoa.
+type OutboundProcessor struct {
+	manager *Manager
+	db      *database.DB
+	config  OutboundProcessorConfig
+
+	mutex      sync.Mutex
+	busy       map[int64]bool      // Dispositivos com envio em andamento
+	nextSendAt map[int64]time.Time // Próximo envio permitido por dispositivo
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewOutboundProcessor cria um processador aplicando valores padrão às opções não informadas
+func NewOutboundProcessor(manager *Manager, config OutboundProcessorConfig) *OutboundProcessor {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.MessagesPerMinute <= 0 {
+		config.MessagesPerMinute = 20
+	}
+	if config.DailyCap < 0 {
+		config.DailyCap = 0
+	}
+	if config.MinTypingDelay <= 0 {
+		config.MinTypingDelay = time.Second
+	}
+	if config.MaxTypingDelay < config.MinTypingDelay {
+		config.MaxTypingDelay = 4 * config.MinTypingDelay
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = 30 * time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = 30 * time.Minute
+	}
+	if config.Lease <= 0 {
+		// Cobre o "digitando...", o upload da mídia e o envio
+		config.Lease = 2*config.MaxTypingDelay + 2*time.Minute
+	}
+
+	return &OutboundProcessor{
+		manager:    manager,
+		db:         manager.db,
+		config:     config,
+		busy:       make(map[int64]bool),
+		nextSendAt: make(map[int64]time.Time),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start inicia o dispatcher da fila
+func (p *OutboundProcessor) Start() {
+	p.wg.Add(1)
+	go p.dispatch()
+
+	fmt.Printf("Processador de envio iniciado (%d msgs/min, limite diário %d por dispositivo)\n",
+		p.config.MessagesPerMinute, p.config.DailyCap)
+}
+
+// Stop encerra o processador e aguarda os envios em andamento.
+// Mensagens interrompidas durante o "digitando..." voltam para a fila.
+func (p *OutboundProcessor) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+	fmt.Println("Processador de envio encerrado")
+}
+
+// Wake antecipa a próxima leitura da fila (chamado após enfileirar mensagens)
+func (p *OutboundProcessor) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ResetDevice libera o dispositivo do intervalo entre envios e do limite
+// diário em espera (chamado quando os limites do dispositivo mudam)
+func (p *OutboundProcessor) ResetDevice(deviceID int64) {
+	p.mutex.Lock()
+	delete(p.nextSendAt, deviceID)
+	p.mutex.Unlock()
+
+	p.Wake()
+}
+
+// dispatch inicia um envio para cada dispositivo com mensagens prontas que
+// não esteja ocupado nem aguardando o intervalo entre envios
+func (p *OutboundProcessor) dispatch() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		deviceIDs, err := p.db.GetReadyOutboundDevices()
+		if err != nil {
+			fmt.Printf("Erro ao buscar fila de envio: %v\n", err)
+		}
+
+		now := time.Now()
+		p.mutex.Lock()
+		for _, deviceID := range deviceIDs {
+			if p.busy[deviceID] || now.Before(p.nextSendAt[deviceID]) {
+				continue
+			}
+			p.busy[deviceID] = true
+			p.wg.Add(1)
+			go p.processDevice(deviceID)
+		}
+		p.mutex.Unlock()
+
+		select {
+		case <-p.stop:
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processDevice envia a próxima mensagem da fila de um dispositivo
+func (p *OutboundProcessor) processDevice(deviceID int64) {
+	defer p.wg.Done()
+
+	// Por padrão o dispositivo volta a ser elegível na próxima leitura da fila
+	var nextSendAt time.Time
+	defer func() {
+		p.mutex.Lock()
+		delete(p.busy, deviceID)
+		if !nextSendAt.IsZero() {
+			p.nextSendAt[deviceID] = nextSendAt
+		}
+		p.mutex.Unlock()
+	}()
+
+	limits := p.limitsFor(deviceID)
+
+	if limits.dailyCap > 0 {
+		midnight := startOfDay(time.Now())
+		sent, err := p.db.CountSentOutbound(deviceID, midnight)
+		if err != nil {
+			fmt.Printf("Erro ao contar envios do dispositivo %d: %v\n", deviceID, err)
+			return
+		}
+		if sent >= limits.dailyCap {
+			nextSendAt = midnight.AddDate(0, 0, 1)
+			fmt.Printf("Dispositivo %d atingiu o limite diário de %d envios; fila retomada em %s\n",
+				deviceID, limits.dailyCap, nextSendAt.Format("2006-01-02 15:04"))
+			return
+		}
+	}
+
+	// Dispositivo desconectado: a mensagem continua na fila sem consumir tentativas
+	client, ok := p.manager.connectedClient(deviceID)
+	if !ok {
+		nextSendAt = time.Now().Add(outboundOfflineRetry)
+		return
+	}
+
+	message, err := p.db.ClaimNextOutboundMessage(deviceID, p.config.Lease)
+	if err != nil {
+		fmt.Printf("Erro ao reservar mensagem da fila do dispositivo %d: %v\n", deviceID, err)
+		return
+	}
+	if message == nil {
+		return
+	}
+
+	var payload database.OutboundPayload
+	if err := json.Unmarshal([]byte(message.Payload), &payload); err != nil {
+		p.fail(message, message.AttemptCount+1, fmt.Sprintf("Payload inválido: %v", err))
+		return
+	}
+
+	if !p.simulateTyping(client, message, limits) {
+		// Encerrando: devolver a mensagem para a fila sem consumir tentativa
+		p.db.UpdateOutboundMessageStatus(message.ID, database.OutboundQueued, "", message.ErrorMessage, message.AttemptCount, nil)
+		return
+	}
+
+	attempt := message.AttemptCount + 1
+	messageID, err := p.send(client, message, payload)
+	nextSendAt = time.Now().Add(p.pacingInterval(limits))
+
+	if err != nil {
+		fmt.Printf("Erro ao enviar mensagem %d da fila do dispositivo %d (tentativa %d): %v\n",
+			message.ID, deviceID, attempt, err)
+		p.reschedule(message, attempt, err.Error())
+		return
+	}
+
+	if err := p.db.UpdateOutboundMessageStatus(message.ID, database.OutboundSent, messageID, "", attempt, nil); err != nil {
+		fmt.Printf("Erro ao atualizar mensagem %d da fila: %v\n", message.ID, err)
+	}
+	removeOutboundMedia(payload)
+
+	p.publish(message, webhook.EventOutboundSent, database.OutboundSent, messageID, "", attempt)
+}
+
+// simulateTyping mostra "digitando..." no chat por um tempo aleatório.
+// Retorna false se o processador foi encerrado durante a espera.
+func (p *OutboundProcessor) simulateTyping(client *Client, message *database.OutboundMessage, limits sendLimits) bool {
+	delay := limits.minTyping
+	if limits.maxTyping > limits.minTyping {
+		delay += time.Duration(rand.Int63n(int64(limits.maxTyping - limits.minTyping)))
+	}
+
+	jid, err := types.ParseJID(message.Recipient)
+	typing := err == nil
+	if typing {
+		client.Client.SendChatPresence(jid, types.ChatPresenceComposing, types.ChatPresenceMediaText)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	stopped := false
+	select {
+	case <-timer.C:
+	case <-p.stop:
+		stopped = true
+	}
+
+	if typing {
+		client.Client.SendChatPresence(jid, types.ChatPresencePaused, types.ChatPresenceMediaText)
+	}
+
+	return !stopped
+}
+
+// send envia a mensagem pelo cliente do dispositivo
+func (p *OutboundProcessor) send(client *Client, message *database.OutboundMessage, payload database.OutboundPayload) (string, error) {
+	switch message.Kind {
+	case database.OutboundKindText:
+		if payload.LinkPreview {
+			return client.SendTextWithLinkPreview(message.Recipient, payload.Text)
+		}
+		return client.SendTextMessage(message.Recipient, payload.Text)
+	case database.OutboundKindMedia:
+		if payload.MediaID != 0 {
+			return p.manager.sendLibraryMedia(client, message.Recipient, payload)
+		}
+		data, err := os.ReadFile(payload.MediaPath)
+		if err != nil {
+			return "", fmt.Errorf("erro ao ler mídia da fila: %w", err)
+		}
+		return client.SendMediaMessage(message.Recipient, payload.Mimetype, data, payload.Caption, payloadMediaOptions(payload))
+	case database.OutboundKindSticker:
+		data, err := os.ReadFile(payload.MediaPath)
+		if err != nil {
+			return "", fmt.Errorf("erro ao ler figurinha da fila: %w", err)
+		}
+		return client.SendSticker(message.Recipient, data)
+	case database.OutboundKindLocation:
+		if payload.Location == nil {
+			return "", fmt.Errorf("localização ausente no payload")
+		}
+		return client.SendLocation(message.Recipient, *payload.Location)
+	case database.OutboundKindContact:
+		return client.SendContacts(message.Recipient, payload.Contacts)
+	case database.OutboundKindPoll:
+		if payload.Poll == nil {
+			return "", fmt.Errorf("enquete ausente no payload")
+		}
+		return client.SendPoll(message.Recipient, *payload.Poll)
+	default:
+		return "", fmt.Errorf("tipo de mensagem desconhecido: %s", message.Kind)
+	}
+}
+
+// reschedule agenda uma nova tentativa ou marca a mensagem como failed. Mensagens
+// de campanhas pausadas ou interrompidas não voltam para a fila.
+func (p *OutboundProcessor) reschedule(message *database.OutboundMessage, attempt int, errorMessage string) {
+	if attempt >= p.config.MaxAttempts {
+		p.fail(message, attempt, fmt.Sprintf("Número máximo de tentativas alcançado (%d): %s", attempt, errorMessage))
+		return
+	}
+
+	nextAttempt := time.Now().Add(p.backoff(attempt))
+	if err := p.db.UpdateOutboundMessageStatus(message.ID, database.OutboundQueued, "", errorMessage, attempt, &nextAttempt); err != nil {
+		fmt.Printf("Erro ao atualizar mensagem %d da fila: %v\n", message.ID, err)
+		return
+	}
+
+	// Se a campanha foi pausada ou interrompida durante o envio, a mensagem
+	// sai da fila como as demais que ainda aguardavam
+	campaign, err := p.db.GetOutboundMessageCampaign(message.ID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar campanha da mensagem %d da fila: %v\n", message.ID, err)
+		return
+	}
+	if campaign == nil {
+		return
+	}
+
+	switch campaign.Status {
+	case database.CampaignPaused:
+		err = p.db.WithdrawCampaignMessages(campaign.ID, database.CampaignRecipientPending)
+	case database.CampaignStopped:
+		err = p.db.WithdrawCampaignMessages(campaign.ID, database.CampaignRecipientCancelled)
+	}
+	if err != nil {
+		fmt.Printf("Erro ao retirar da fila as mensagens da campanha %d: %v\n", campaign.ID, err)
+	}
+}
+
+// fail marca a mensagem como failed e avisa os webhooks
+func (p *OutboundProcessor) fail(message *database.OutboundMessage, attempt int, errorMessage string) {
+	if err := p.db.UpdateOutboundMessageStatus(message.ID, database.OutboundFailed, "", errorMessage, attempt, nil); err != nil {
+		fmt.Printf("Erro ao atualizar mensagem %d da fila: %v\n", message.ID, err)
+	}
+
+	var payload database.OutboundPayload
+	if json.Unmarshal([]byte(message.Payload), &payload) == nil {
+		removeOutboundMedia(payload)
+	}
+
+	p.publish(message, webhook.EventOutboundFailed, database.OutboundFailed, "", errorMessage, attempt)
+}
+
+// publish publica o resultado final de uma mensagem da fila
+func (p *OutboundProcessor) publish(message *database.OutboundMessage, eventType string, status string, messageID string, errorMessage string, attempt int) {
+	if p.manager.eventHandler == nil {
+		return
+	}
+
+	p.manager.eventHandler.publishWebhookEvent(message.DeviceID, eventType, webhook.OutboundData{
+		QueueID:   message.ID,
+		MessageID: messageID,
+		Recipient: message.Recipient,
+		Status:    status,
+		Error:     errorMessage,
+		Attempts:  attempt,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// limitsFor retorna os limites do dispositivo, ou os padrões do processador
+func (p *OutboundProcessor) limitsFor(deviceID int64) sendLimits {
+	limits := sendLimits{
+		perMinute: p.config.MessagesPerMinute,
+		dailyCap:  p.config.DailyCap,
+		minTyping: p.config.MinTypingDelay,
+		maxTyping: p.config.MaxTypingDelay,
+	}
+
+	custom, err := p.db.GetDeviceSendLimits(deviceID)
+	if err != nil {
+		fmt.Printf("Erro ao buscar limites de envio do dispositivo %d: %v\n", deviceID, err)
+		return limits
+	}
+	if custom == nil {
+		return limits
+	}
+
+	if custom.MessagesPerMinute > 0 {
+		limits.perMinute = custom.MessagesPerMinute
+	}
+	limits.dailyCap = custom.DailyCap
+	limits.minTyping = time.Duration(custom.MinTypingDelayMs) * time.Millisecond
+	limits.maxTyping = time.Duration(custom.MaxTypingDelayMs) * time.Millisecond
+
+	return limits
+}
+
+// pacingInterval calcula o intervalo até o próximo envio do dispositivo,
+// com variação aleatória de ±25% para evitar um ritmo constante
+func (p *OutboundProcessor) pacingInterval(limits sendLimits) time.Duration {
+	interval := time.Minute / time.Duration(limits.perMinute)
+	quarter := interval / 4
+	return interval - quarter + time.Duration(rand.Int63n(int64(2*quarter)+1))
+}
+
+// backoff calcula o atraso exponencial com jitter para a tentativa informada:
+// metade fixa e metade aleatória, limitado a MaxDelay
+func (p *OutboundProcessor) backoff(attempt int) time.Duration {
+	delay := p.config.BaseDelay
+	for i := 1; i < attempt && delay < p.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.config.MaxDelay {
+		delay = p.config.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// payloadMediaOptions retorna as opções de envio guardadas no payload da fila
+func payloadMediaOptions(payload database.OutboundPayload) MediaOptions {
+	return MediaOptions{FileName: payload.FileName, VoiceNote: payload.VoiceNote}
+}
+
+// removeOutboundMedia apaga o arquivo guardado para uma mensagem de mídia da
+// fila. Arquivos compartilhados são removidos por quem os criou (campanhas).
+func removeOutboundMedia(payload database.OutboundPayload) {
+	if payload.MediaPath == "" || payload.Shared {
+		return
+	}
+	if err := os.Remove(payload.MediaPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Aviso: erro ao remover mídia da fila %s: %v\n", payload.MediaPath, err)
+	}
+}
+
+// startOfDay retorna a meia-noite (horário local) do dia de t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
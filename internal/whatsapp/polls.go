@@ -0,0 +1,140 @@
+// internal/whatsapp/polls.go
+package whatsapp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+
+	"whatsapp-service/internal/database"
+	"whatsapp-service/pkg/webhook"
+)
+
+// Quantidade de opções aceita pelo WhatsApp em uma enquete
+const (
+	pollMinOptions = 2
+	pollMaxOptions = 12
+)
+
+// ValidatePoll confere a pergunta e as opções de uma enquete. As opções
+// precisam ser distintas: os votos identificam cada opção pelo hash do texto.
+func ValidatePoll(poll webhook.PollData) error {
+	if strings.TrimSpace(poll.Question) == "" {
+		return fmt.Errorf("a pergunta da enquete é obrigatória")
+	}
+	if len(poll.Options) < pollMinOptions || len(poll.Options) > pollMaxOptions {
+		return fmt.Errorf("a enquete deve ter de %d a %d opções", pollMinOptions, pollMaxOptions)
+	}
+
+	seen := make(map[string]bool)
+	for _, option := range poll.Options {
+		if strings.TrimSpace(option) == "" {
+			return fmt.Errorf("as opções da enquete não podem ser vazias")
+		}
+		if seen[option] {
+			return fmt.Errorf("opção repetida na enquete: %s", option)
+		}
+		seen[option] = true
+	}
+
+	if poll.SelectableCount < 0 || poll.SelectableCount > len(poll.Options) {
+		return fmt.Errorf("selectable_count deve estar entre 0 e %d", len(poll.Options))
+	}
+	return nil
+}
+
+// EnqueuePoll coloca uma enquete na fila de envio
+func (m *Manager) EnqueuePoll(deviceID int64, to string, poll webhook.PollData) (*database.OutboundMessage, error) {
+	if err := ValidatePoll(poll); err != nil {
+		return nil, err
+	}
+
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindPoll, database.OutboundPayload{Poll: &poll})
+}
+
+// SendPoll envia uma enquete e a registra para a contagem dos votos
+func (c *Client) SendPoll(to string, poll webhook.PollData) (string, error) {
+	// O segredo gerado aqui é guardado pelo whatsmeow ao enviar e usado para decifrar os votos
+	msg := c.Client.BuildPollCreation(poll.Question, poll.Options, poll.SelectableCount)
+
+	stored := &database.WhatsAppMessage{
+		Content:   poll.Question,
+		MediaType: "poll",
+		Details:   &database.MessageDetails{Poll: &poll},
+	}
+	messageID, err := c.sendContent(to, msg, stored, nil)
+	if err != nil {
+		return "", err
+	}
+
+	chat, _ := types.ParseJID(to) // Já validado por sendContent
+	creator := ""
+	if c.Client.Store.ID != nil {
+		creator = c.Client.Store.ID.ToNonAD().String()
+	}
+
+	err = c.DB.SavePoll(&database.Poll{
+		DeviceID:        c.DeviceID,
+		MessageID:       messageID,
+		Chat:            chat.String(),
+		Creator:         creator,
+		Question:        poll.Question,
+		Options:         poll.Options,
+		SelectableCount: poll.SelectableCount,
+	})
+	if err != nil {
+		fmt.Printf("Erro ao registrar enquete %s: %v\n", messageID, err)
+	}
+
+	return messageID, nil
+}
+
+// getPollCreation retorna a enquete contida na mensagem, em qualquer uma das
+// versões da mensagem de criação, ou nil
+func getPollCreation(message *waProto.Message) *waProto.PollCreationMessage {
+	switch {
+	case message.GetPollCreationMessage() != nil:
+		return message.GetPollCreationMessage()
+	case message.GetPollCreationMessageV2() != nil:
+		return message.GetPollCreationMessageV2()
+	case message.GetPollCreationMessageV3() != nil:
+		return message.GetPollCreationMessageV3()
+	default:
+		return nil
+	}
+}
+
+// pollData converte a mensagem de criação de uma enquete
+func pollData(creation *waProto.PollCreationMessage) *webhook.PollData {
+	options := make([]string, 0, len(creation.GetOptions()))
+	for _, option := range creation.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+
+	return &webhook.PollData{
+		Question:        creation.GetName(),
+		Options:         options,
+		SelectableCount: int(creation.GetSelectableOptionsCount()),
+	}
+}
+
+// pollOptionsFromHashes identifica as opções votadas a partir dos hashes
+// SHA-256 enviados no voto. Hashes sem opção correspondente são ignorados.
+func pollOptionsFromHashes(options []string, selected [][]byte) []string {
+	hashes := whatsmeow.HashPollOptions(options)
+
+	names := []string{}
+	for _, hash := range selected {
+		for i, optionHash := range hashes {
+			if bytes.Equal(hash, optionHash) {
+				names = append(names, options[i])
+				break
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,138 @@
+// internal/whatsapp/presence.go
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-service/pkg/webhook"
+)
+
+// Estados de presença em um chat aceitos por SendChatPresence
+const (
+	ChatStateComposing = "composing" // Digitando
+	ChatStateRecording = "recording" // Gravando áudio
+	ChatStatePaused    = "paused"    // Parou de digitar
+)
+
+// SendChatPresence mostra no chat que o dispositivo está digitando ou gravando
+// um áudio, ou encerra a indicação (paused). O WhatsApp encerra a indicação
+// sozinho após alguns segundos sem atualização ou quando a mensagem chega.
+func (c *Client) SendChatPresence(to string, state string) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("cliente não está conectado")
+	}
+
+	normalized, err := normalizeRecipient(to)
+	if err != nil {
+		return err
+	}
+	jid, _ := types.ParseJID(normalized)
+
+	presence, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+	switch state {
+	case ChatStateComposing:
+	case ChatStateRecording:
+		media = types.ChatPresenceMediaAudio
+	case ChatStatePaused:
+		presence = types.ChatPresencePaused
+	default:
+		return fmt.Errorf("estado inválido: %s (use composing, recording ou paused)", state)
+	}
+
+	if err := c.Client.SendChatPresence(jid, presence, media); err != nil {
+		return fmt.Errorf("falha ao enviar presença no chat: %w", err)
+	}
+	return nil
+}
+
+// SetAvailability marca o dispositivo como disponível (online) ou indisponível.
+// Enquanto indisponível, o WhatsApp não envia a presença dos contatos.
+func (c *Client) SetAvailability(available bool) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("cliente não está conectado")
+	}
+
+	presence := types.PresenceUnavailable
+	if available {
+		presence = types.PresenceAvailable
+	}
+
+	if err := c.Client.SendPresence(presence); err != nil {
+		if errors.Is(err, whatsmeow.ErrNoPushName) {
+			return fmt.Errorf("o dispositivo ainda não recebeu o próprio nome do WhatsApp; tente novamente em instantes")
+		}
+		return fmt.Errorf("falha ao enviar disponibilidade: %w", err)
+	}
+	return nil
+}
+
+// SubscribePresence pede ao WhatsApp a presença do contato, publicada depois
+// como presence.update. O dispositivo precisa estar disponível (SetAvailability).
+func (c *Client) SubscribePresence(contact string) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("cliente não está conectado")
+	}
+
+	normalized, err := normalizeRecipient(contact)
+	if err != nil {
+		return err
+	}
+	jid, _ := types.ParseJID(normalized)
+	if jid.Server == types.GroupServer {
+		return fmt.Errorf("a presença só pode ser acompanhada para contatos, não grupos")
+	}
+
+	if err := c.Client.SubscribePresence(jid); err != nil {
+		return fmt.Errorf("falha ao se inscrever na presença: %w", err)
+	}
+	return nil
+}
+
+// handlePresence publica a mudança de presença de um contato
+func (h *EventHandler) handlePresence(deviceID int64, evt *events.Presence) {
+	data := webhook.PresenceData{
+		Contact: h.resolveContactID(evt.From, types.EmptyJID),
+		Online:  !evt.Unavailable,
+	}
+	if !evt.LastSeen.IsZero() {
+		lastSeen := evt.LastSeen
+		data.LastSeen = &lastSeen
+	}
+
+	h.publishWebhookEvent(deviceID, webhook.EventPresenceUpdate, data)
+}
+
+// handleChatPresence publica a indicação de digitação ou gravação de um
+// contato. As indicações do próprio número (em outros aparelhos) são ignoradas.
+func (h *EventHandler) handleChatPresence(deviceID int64, evt *events.ChatPresence) {
+	if evt.IsFromMe {
+		return
+	}
+
+	state := ChatStatePaused
+	if evt.State == types.ChatPresenceComposing {
+		state = ChatStateComposing
+		if evt.Media == types.ChatPresenceMediaAudio {
+			state = ChatStateRecording
+		}
+	}
+
+	// Em conversas individuais o chat é o próprio contato
+	sender := h.resolveContactID(evt.Sender, evt.SenderAlt)
+	chat := sender
+	if evt.IsGroup {
+		chat = evt.Chat.String()
+	}
+
+	h.publishWebhookEvent(deviceID, webhook.EventChatPresence, webhook.ChatPresenceData{
+		Chat:    chat,
+		Sender:  sender,
+		IsGroup: evt.IsGroup,
+		State:   state,
+	})
+}
@@ -0,0 +1,493 @@
+// internal/whatsapp/rich_messages.go
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+
+	"whatsapp-service/internal/database"
+	"whatsapp-service/pkg/webhook"
+)
+
+// Limites da busca de prévias de link
+const (
+	linkPreviewMaxHTMLBytes  = 512 << 10 // As tags OpenGraph ficam no <head>
+	linkPreviewMaxImageBytes = 5 << 20
+)
+
+// linkPattern encontra o primeiro link HTTPS de um texto. Links HTTP não
+// ganham prévia: o servidor só busca páginas por HTTPS.
+var linkPattern = regexp.MustCompile(`https://[^\s<>"']+`)
+
+// ContactCardInput é um contato a enviar como cartão. Se VCard for
+// informado, é enviado como está; senão o vCard é gerado com os demais campos.
+type ContactCardInput struct {
+	Name         string   `json:"name"`
+	Phones       []string `json:"phones"`
+	Organization string   `json:"organization"`
+	Email        string   `json:"email"`
+	VCard        string   `json:"vcard"`
+}
+
+// NewContactCard monta um cartão de contato a partir dos dados informados
+func NewContactCard(input ContactCardInput) (webhook.ContactData, error) {
+	if input.VCard != "" {
+		card := parseVCard(input.VCard)
+		if input.Name != "" {
+			card.Name = input.Name
+		}
+		if card.Name == "" {
+			return card, fmt.Errorf("vCard sem nome (FN)")
+		}
+		return card, nil
+	}
+
+	if strings.TrimSpace(input.Name) == "" {
+		return webhook.ContactData{}, fmt.Errorf("nome do contato é obrigatório")
+	}
+	if len(input.Phones) == 0 {
+		return webhook.ContactData{}, fmt.Errorf("informe ao menos um telefone para %s", input.Name)
+	}
+
+	return webhook.ContactData{
+		Name:   input.Name,
+		Phones: input.Phones,
+		VCard:  buildVCard(input),
+	}, nil
+}
+
+// buildVCard gera um vCard 3.0. O parâmetro waid faz o WhatsApp oferecer
+// "Conversar" e "Adicionar" para o número.
+func buildVCard(input ContactCardInput) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&b, "N:;%s;;;\n", vcardEscape(input.Name))
+	fmt.Fprintf(&b, "FN:%s\n", vcardEscape(input.Name))
+	if input.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s;\n", vcardEscape(input.Organization))
+	}
+	for _, phone := range input.Phones {
+		waid := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, phone)
+		fmt.Fprintf(&b, "TEL;type=CELL;type=VOICE;waid=%s:%s\n", waid, vcardEscape(phone))
+	}
+	if input.Email != "" {
+		fmt.Fprintf(&b, "EMAIL:%s\n", vcardEscape(input.Email))
+	}
+	b.WriteString("END:VCARD")
+	return b.String()
+}
+
+// vcardEscape escapa os caracteres especiais de um valor do vCard
+func vcardEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// parseVCard extrai o nome (FN) e os telefones (TEL) de um vCard
+func parseVCard(vcard string) webhook.ContactData {
+	card := webhook.ContactData{VCard: vcard}
+
+	// Linhas continuadas começam com espaço ou tab
+	unfolded := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(vcard)
+	for _, line := range strings.Split(unfolded, "\n") {
+		line = strings.TrimRight(line, "\r")
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		property := strings.ToUpper(strings.SplitN(name, ";", 2)[0])
+		switch property {
+		case "FN":
+			card.Name = strings.NewReplacer(`\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+		case "TEL":
+			card.Phones = append(card.Phones, value)
+		}
+	}
+
+	return card
+}
+
+// EnqueueLinkPreviewText coloca um texto na fila de envio com a prévia do
+// primeiro link, buscada no momento do envio
+func (m *Manager) EnqueueLinkPreviewText(deviceID int64, to string, text string) (*database.OutboundMessage, error) {
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindText, database.OutboundPayload{Text: text, LinkPreview: true})
+}
+
+// EnqueueSticker coloca uma figurinha na fila de envio. Imagens PNG/JPEG são
+// convertidas para WebP antes de entrar na fila.
+func (m *Manager) EnqueueSticker(deviceID int64, to string, mimetype string, data []byte) (*database.OutboundMessage, error) {
+	if _, err := types.ParseJID(to); err != nil {
+		return nil, fmt.Errorf("JID inválido: %w", err)
+	}
+
+	if baseMimetype(mimetype) != "image/webp" {
+		if !strings.HasPrefix(baseMimetype(mimetype), "image/") {
+			return nil, fmt.Errorf("figurinhas precisam ser imagens (PNG, JPEG ou WebP)")
+		}
+
+		converted, err := convertToSticker(data)
+		if err != nil {
+			return nil, err
+		}
+		data = converted
+	}
+
+	mediaPath, err := saveOutboundMedia(deviceID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := database.OutboundPayload{Mimetype: "image/webp", MediaPath: mediaPath}
+	message, err := m.enqueueOutbound(deviceID, to, database.OutboundKindSticker, payload)
+	if err != nil {
+		os.Remove(mediaPath)
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// EnqueueLocation coloca uma localização (fixa ou em tempo real) na fila de envio
+func (m *Manager) EnqueueLocation(deviceID int64, to string, location webhook.LocationData) (*database.OutboundMessage, error) {
+	if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+		return nil, fmt.Errorf("coordenadas inválidas")
+	}
+
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindLocation, database.OutboundPayload{Location: &location})
+}
+
+// EnqueueContacts coloca um ou mais cartões de contato na fila de envio
+func (m *Manager) EnqueueContacts(deviceID int64, to string, inputs []ContactCardInput) (*database.OutboundMessage, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("informe ao menos um contato")
+	}
+
+	contacts := make([]webhook.ContactData, 0, len(inputs))
+	for _, input := range inputs {
+		card, err := NewContactCard(input)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, card)
+	}
+
+	return m.enqueueOutbound(deviceID, to, database.OutboundKindContact, database.OutboundPayload{Contacts: contacts})
+}
+
+// SendTextWithLinkPreview envia um texto com a prévia (título, descrição e
+// miniatura) do primeiro link. Se a prévia não puder ser obtida, o texto é
+// enviado sem ela.
+func (c *Client) SendTextWithLinkPreview(to string, text string) (string, error) {
+	preview, err := fetchLinkPreview(text)
+	if err != nil {
+		fmt.Printf("Aviso: prévia de link indisponível: %v\n", err)
+	}
+	if preview == nil {
+		return c.SendTextMessage(to, text)
+	}
+
+	msg := &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:          proto.String(text),
+			MatchedText:   proto.String(preview.URL),
+			Title:         proto.String(preview.Title),
+			Description:   proto.String(preview.Description),
+			JPEGThumbnail: preview.Thumbnail,
+			PreviewType:   waProto.ExtendedTextMessage_NONE.Enum(),
+		},
+	}
+
+	stored := &database.WhatsAppMessage{
+		Content: text,
+		Details: &database.MessageDetails{LinkPreview: &preview.LinkPreviewData},
+	}
+	return c.sendContent(to, msg, stored, nil)
+}
+
+// SendSticker envia uma figurinha. data deve estar em WebP.
+func (c *Client) SendSticker(to string, data []byte) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	// Figurinhas usam a mesma criptografia de upload das imagens
+	uploaded, err := c.Client.Upload(context.Background(), data, whatsmeow.MediaImage)
+	if err != nil {
+		return "", fmt.Errorf("falha ao fazer upload da figurinha: %w", err)
+	}
+
+	msg := &waProto.Message{
+		StickerMessage: &waProto.StickerMessage{
+			URL:           proto.String(uploaded.URL),
+			Mimetype:      proto.String("image/webp"),
+			FileLength:    proto.Uint64(uploaded.FileLength),
+			FileSHA256:    uploaded.FileSHA256,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			MediaKey:      uploaded.MediaKey,
+			DirectPath:    proto.String(uploaded.DirectPath),
+		},
+	}
+
+	return c.sendContent(to, msg, &database.WhatsAppMessage{MediaType: "sticker"}, data)
+}
+
+// SendLocation envia uma localização fixa ou, com Live, em tempo real
+func (c *Client) SendLocation(to string, location webhook.LocationData) (string, error) {
+	var msg *waProto.Message
+	if location.Live {
+		msg = &waProto.Message{
+			LiveLocationMessage: &waProto.LiveLocationMessage{
+				DegreesLatitude:  proto.Float64(location.Latitude),
+				DegreesLongitude: proto.Float64(location.Longitude),
+				AccuracyInMeters: optionalUint32(location.AccuracyMeters),
+				Caption:          proto.String(location.Caption),
+				SequenceNumber:   proto.Int64(time.Now().UnixMilli()),
+			},
+		}
+	} else {
+		msg = &waProto.Message{
+			LocationMessage: &waProto.LocationMessage{
+				DegreesLatitude:  proto.Float64(location.Latitude),
+				DegreesLongitude: proto.Float64(location.Longitude),
+				Name:             proto.String(location.Name),
+				Address:          proto.String(location.Address),
+				URL:              proto.String(location.URL),
+				AccuracyInMeters: optionalUint32(location.AccuracyMeters),
+			},
+		}
+	}
+
+	stored := &database.WhatsAppMessage{
+		Content:   locationText(&location),
+		MediaType: "location",
+		Details:   &database.MessageDetails{Location: &location},
+	}
+	return c.sendContent(to, msg, stored, nil)
+}
+
+// SendContacts envia cartões de contato. Vários contatos vão em uma única mensagem.
+func (c *Client) SendContacts(to string, contacts []webhook.ContactData) (string, error) {
+	if len(contacts) == 0 {
+		return "", fmt.Errorf("nenhum contato informado")
+	}
+
+	cards := make([]*waProto.ContactMessage, len(contacts))
+	for i, contact := range contacts {
+		cards[i] = &waProto.ContactMessage{
+			DisplayName: proto.String(contact.Name),
+			Vcard:       proto.String(contact.VCard),
+		}
+	}
+
+	msg := &waProto.Message{ContactMessage: cards[0]}
+	if len(cards) > 1 {
+		msg = &waProto.Message{
+			ContactsArrayMessage: &waProto.ContactsArrayMessage{
+				DisplayName: proto.String(fmt.Sprintf("%d contatos", len(cards))),
+				Contacts:    cards,
+			},
+		}
+	}
+
+	stored := &database.WhatsAppMessage{
+		Content:   contactsText(contacts),
+		MediaType: "contact",
+		Details:   &database.MessageDetails{Contacts: contacts},
+	}
+	return c.sendContent(to, msg, stored, nil)
+}
+
+// sendContent envia uma mensagem já montada e a registra no histórico
+func (c *Client) sendContent(to string, msg *waProto.Message, stored *database.WhatsAppMessage, media []byte) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("cliente não está conectado")
+	}
+
+	recipient, err := types.ParseJID(to)
+	if err != nil {
+		return "", fmt.Errorf("JID inválido: %w", err)
+	}
+
+	resp, err := c.Client.SendMessage(context.Background(), recipient, msg)
+	if err != nil {
+		return "", fmt.Errorf("falha ao enviar mensagem: %w", err)
+	}
+
+	c.saveSentMessage(recipient, resp, stored, media)
+
+	return resp.ID, nil
+}
+
+// locationText é o texto guardado no histórico para uma localização
+func locationText(location *webhook.LocationData) string {
+	parts := []string{}
+	for _, part := range []string{location.Name, location.Address, location.Caption} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("%.6f, %.6f", location.Latitude, location.Longitude)
+	}
+	return strings.Join(parts, " - ")
+}
+
+// contactsText é o texto guardado no histórico para cartões de contato
+func contactsText(contacts []webhook.ContactData) string {
+	names := make([]string, len(contacts))
+	for i, contact := range contacts {
+		names[i] = contact.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// linkPreview é a prévia de um link com a miniatura em JPEG
+type linkPreview struct {
+	webhook.LinkPreviewData
+	Thumbnail []byte
+}
+
+// fetchLinkPreview busca as tags OpenGraph do primeiro link HTTPS do texto.
+// Retorna nil se o texto não tiver links HTTPS ou a página não tiver título.
+func fetchLinkPreview(text string) (*linkPreview, error) {
+	link := strings.TrimRight(linkPattern.FindString(text), ".,;:!?)")
+	if link == "" {
+		return nil, nil
+	}
+
+	resp, err := mediaHTTPClient.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d ao buscar %s", resp.StatusCode, link)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return nil, nil
+	}
+
+	preview := &linkPreview{LinkPreviewData: webhook.LinkPreviewData{URL: link}}
+	imageURL := parseOpenGraph(io.LimitReader(resp.Body, linkPreviewMaxHTMLBytes), &preview.LinkPreviewData)
+	if preview.Title == "" {
+		return nil, nil
+	}
+
+	if imageURL != "" {
+		if resolved, err := resp.Request.URL.Parse(imageURL); err == nil {
+			preview.Thumbnail = fetchPreviewThumbnail(resolved)
+		}
+	}
+
+	return preview, nil
+}
+
+// parseOpenGraph lê o título e a descrição da página (OpenGraph, com
+// <title> e meta description como alternativas) e retorna a URL da imagem
+func parseOpenGraph(body io.Reader, preview *webhook.LinkPreviewData) string {
+	var ogTitle, pageTitle, ogDescription, description, imageURL string
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(body)
+	for done := false; !done; {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "body":
+				done = true
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if imageURL == "" {
+						imageURL = content
+					}
+				}
+			}
+		case html.TextToken:
+			if inTitle && pageTitle == "" {
+				pageTitle = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			switch tokenizer.Token().Data {
+			case "title":
+				inTitle = false
+			case "head":
+				done = true
+			}
+		}
+	}
+
+	preview.Title = firstNonEmpty(ogTitle, pageTitle)
+	preview.Description = firstNonEmpty(ogDescription, description)
+	return imageURL
+}
+
+// fetchPreviewThumbnail baixa a imagem da prévia e gera a miniatura.
+// Falhas apenas deixam a prévia sem imagem.
+func fetchPreviewThumbnail(imageURL *url.URL) []byte {
+	data, _, _, err := fetchHTTPS(imageURL.String(), linkPreviewMaxImageBytes)
+	if err != nil {
+		fmt.Printf("Aviso: erro ao baixar imagem da prévia %s: %v\n", imageURL, err)
+		return nil
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil
+	}
+
+	thumbnail, err := thumbnailJPEG(img)
+	if err != nil {
+		return nil
+	}
+	return thumbnail
+}
+
+// firstNonEmpty retorna o primeiro valor não vazio
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
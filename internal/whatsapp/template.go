@@ -0,0 +1,141 @@
+// internal/whatsapp/template.go
+package whatsapp
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"whatsapp-service/internal/database"
+)
+
+// templateVariable encontra as variáveis {{nome}} de um template
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// TemplateVariables lista, sem repetição e em ordem de aparição, as variáveis de um template
+func TemplateVariables(template string) []string {
+	variables := []string{}
+	seen := make(map[string]bool)
+
+	for _, match := range templateVariable.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			variables = append(variables, match[1])
+		}
+	}
+
+	return variables
+}
+
+// MissingVariablesError indica variáveis do template sem valor
+type MissingVariablesError struct {
+	Variables []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	return fmt.Sprintf("variáveis sem valor: %s", strings.Join(e.Variables, ", "))
+}
+
+// RenderTemplate substitui as variáveis {{nome}} do template pelos valores
+// informados. Todas as variáveis são obrigatórias; as ausentes são retornadas
+// em um *MissingVariablesError.
+func RenderTemplate(template string, variables map[string]string) (string, error) {
+	missing := make(map[string]bool)
+
+	rendered := templateVariable.ReplaceAllStringFunc(template, func(match string) string {
+		name := templateVariable.FindStringSubmatch(match)[1]
+		value, ok := variables[name]
+		if !ok {
+			missing[name] = true
+			return match
+		}
+		return value
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", &MissingVariablesError{Variables: names}
+	}
+
+	return rendered, nil
+}
+
+// SaveMessageTemplate cria ou atualiza um template. Se media não for nil, o
+// arquivo substitui o anexo atual do template.
+func (m *Manager) SaveMessageTemplate(template *database.MessageTemplate, mimetype string, media []byte) error {
+	previousMedia := ""
+	if media != nil {
+		mediaPath, err := saveTemplateMedia(template.TenantID, media)
+		if err != nil {
+			return err
+		}
+		previousMedia = template.MediaPath
+		template.MediaPath = mediaPath
+		template.Mimetype = mimetype
+	}
+
+	var err error
+	if template.ID == 0 {
+		err = m.db.CreateMessageTemplate(template)
+	} else {
+		err = m.db.UpdateMessageTemplate(template)
+	}
+	if err != nil {
+		if media != nil {
+			os.Remove(template.MediaPath)
+		}
+		return err
+	}
+
+	if previousMedia != "" {
+		os.Remove(previousMedia)
+	}
+	return nil
+}
+
+// DeleteMessageTemplate remove o template e o arquivo anexado
+func (m *Manager) DeleteMessageTemplate(template *database.MessageTemplate) error {
+	if err := m.db.DeleteMessageTemplate(template.ID); err != nil {
+		return fmt.Errorf("erro ao excluir template: %w", err)
+	}
+
+	if template.MediaPath != "" {
+		if err := os.Remove(template.MediaPath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Aviso: erro ao remover mídia do template %d: %v\n", template.ID, err)
+		}
+	}
+	return nil
+}
+
+// SendTemplate renderiza o template com as variáveis e coloca a mensagem na
+// fila de envio do dispositivo. Templates com anexo são enviados como mídia
+// com o texto renderizado na legenda.
+func (m *Manager) SendTemplate(deviceID int64, to string, template *database.MessageTemplate, variables map[string]string) (*database.OutboundMessage, error) {
+	content, err := RenderTemplate(template.Content, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.MediaPath == "" {
+		return m.EnqueueTextMessage(deviceID, to, content)
+	}
+
+	// A fila remove o arquivo após o envio, então cada mensagem leva uma cópia do anexo
+	data, err := os.ReadFile(template.MediaPath)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler mídia do template: %w", err)
+	}
+
+	return m.EnqueueMediaMessage(deviceID, to, template.Mimetype, data, content, MediaOptions{})
+}
+
+// saveTemplateMedia guarda o anexo de um template em ./storage/templates
+func saveTemplateMedia(tenantID int64, data []byte) (string, error) {
+	return saveStorageFile("templates", tenantID, data)
+}
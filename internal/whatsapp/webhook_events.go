@@ -0,0 +1,180 @@
+// internal/whatsapp/webhook_events.go
+package whatsapp
+
+import (
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"whatsapp-service/pkg/webhook"
+)
+
+// legacyEventTypes mapeia os nomes antigos (tipo Go do whatsmeow) usados nos
+// filtros de assinaturas existentes para o prefixo equivalente no esquema v1
+var legacyEventTypes = map[string]string{
+	"*events.Message":      "message.",
+	"*events.Receipt":      webhook.EventMessageStatus,
+	"*events.Connected":    webhook.EventDeviceConnected,
+	"*events.Disconnected": webhook.EventDeviceDisconnected,
+	"*events.LoggedOut":    webhook.EventDeviceLoggedOut,
+	"*events.GroupInfo":    "group.",
+	"*events.JoinedGroup":  webhook.EventGroupJoined,
+	"*events.Presence":     webhook.EventPresenceUpdate,
+	"*events.ChatPresence": webhook.EventChatPresence,
+}
+
+// eventTypeMatches verifica se um filtro de assinatura aceita o tipo de evento.
+// Aceita "*", o nome exato, curingas de prefixo ("message.*") e os nomes legados.
+func eventTypeMatches(allowedType string, eventType string) bool {
+	if allowedType == "*" || allowedType == eventType {
+		return true
+	}
+
+	if strings.HasSuffix(allowedType, ".*") {
+		return strings.HasPrefix(eventType, strings.TrimSuffix(allowedType, "*"))
+	}
+
+	if legacy, ok := legacyEventTypes[allowedType]; ok {
+		return legacy == eventType || (strings.HasSuffix(legacy, ".") && strings.HasPrefix(eventType, legacy))
+	}
+
+	return false
+}
+
+// webhookEventData converte um evento do whatsmeow para o tipo e payload do
+// esquema público. Eventos sem representação pública retornam ok = false.
+func (h *EventHandler) webhookEventData(evt interface{}) (string, interface{}, bool) {
+	switch v := evt.(type) {
+	case *events.Message:
+		return h.messageEventData(v)
+	case *events.Receipt:
+		status, ok := receiptStatus(v.Type)
+		if !ok || v.IsFromMe {
+			return "", nil, false
+		}
+		return webhook.EventMessageStatus, h.newStatusData(v, status), true
+	case *events.Connected:
+		return webhook.EventDeviceConnected, webhook.DeviceData{Status: "connected"}, true
+	case *events.Disconnected:
+		return webhook.EventDeviceDisconnected, webhook.DeviceData{Status: "disconnected"}, true
+	case *events.LoggedOut:
+		return webhook.EventDeviceLoggedOut, webhook.DeviceData{Status: "logged_out", Reason: v.Reason.String()}, true
+	default:
+		return "", nil, false
+	}
+}
+
+// messageEventData classifica uma mensagem em conteúdo novo, edição, exclusão
+// ou reação. Mensagens de protocolo internas (sincronização, chaves etc.) e
+// mensagens sem conteúdo próprio não são publicadas.
+func (h *EventHandler) messageEventData(msg *events.Message) (string, interface{}, bool) {
+	if protocol := msg.Message.GetProtocolMessage(); protocol != nil {
+		update := webhook.UpdateData{
+			MessageID: protocol.GetKey().GetID(),
+			Chat:      h.resolveContactID(msg.Info.Chat, msg.Info.RecipientAlt),
+			Sender:    h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt),
+			IsFromMe:  msg.Info.IsFromMe,
+			Timestamp: msg.Info.Timestamp,
+		}
+
+		switch protocol.GetType() {
+		case waProto.ProtocolMessage_REVOKE:
+			return webhook.EventMessageRevoked, update, true
+		case waProto.ProtocolMessage_MESSAGE_EDIT:
+			update.Text = getProtoMessageText(protocol.GetEditedMessage())
+			return webhook.EventMessageEdited, update, true
+		default:
+			return "", nil, false
+		}
+	}
+
+	if msg.Message.GetReactionMessage() != nil {
+		return webhook.EventMessageReaction, h.newReactionData(msg), true
+	}
+
+	if !hasMessageContent(msg.Message) {
+		return "", nil, false
+	}
+
+	return webhook.MessageEventType(msg.Info.IsFromMe), h.newMessageData(msg), true
+}
+
+// newReactionData monta o payload de uma reação recebida
+func (h *EventHandler) newReactionData(msg *events.Message) webhook.ReactionData {
+	reaction := msg.Message.GetReactionMessage()
+	return webhook.ReactionData{
+		MessageID: reaction.GetKey().GetID(),
+		Chat:      h.resolveContactID(msg.Info.Chat, msg.Info.RecipientAlt),
+		Sender:    h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt),
+		IsFromMe:  msg.Info.IsFromMe,
+		Reaction:  reaction.GetText(),
+		Timestamp: msg.Info.Timestamp,
+	}
+}
+
+// newMessageData monta o payload de uma mensagem com remetente e chat resolvidos
+func (h *EventHandler) newMessageData(msg *events.Message) webhook.MessageData {
+	data := webhook.MessageData{
+		MessageID:  msg.Info.ID,
+		Chat:       h.resolveContactID(msg.Info.Chat, msg.Info.RecipientAlt),
+		Sender:     h.resolveContactID(msg.Info.Sender, msg.Info.SenderAlt),
+		SenderName: msg.Info.PushName,
+		IsFromMe:   msg.Info.IsFromMe,
+		IsGroup:    msg.Info.IsGroup,
+		Timestamp:  msg.Info.Timestamp,
+		Type:       getMessageMediaType(msg),
+		Text:       getMessageTextContent(msg),
+	}
+
+	switch {
+	case msg.Message.GetImageMessage() != nil:
+		img := msg.Message.GetImageMessage()
+		data.Text = img.GetCaption()
+		data.Media = &webhook.MediaData{Mimetype: img.GetMimetype(), FileLength: img.GetFileLength()}
+	case msg.Message.GetVideoMessage() != nil:
+		vid := msg.Message.GetVideoMessage()
+		data.Text = vid.GetCaption()
+		data.Media = &webhook.MediaData{Mimetype: vid.GetMimetype(), FileLength: vid.GetFileLength()}
+	case msg.Message.GetAudioMessage() != nil:
+		audio := msg.Message.GetAudioMessage()
+		data.Media = &webhook.MediaData{Mimetype: audio.GetMimetype(), FileLength: audio.GetFileLength()}
+	case msg.Message.GetDocumentMessage() != nil:
+		doc := msg.Message.GetDocumentMessage()
+		data.Text = doc.GetCaption()
+		data.Media = &webhook.MediaData{
+			Mimetype:   doc.GetMimetype(),
+			FileName:   doc.GetFileName(),
+			FileLength: doc.GetFileLength(),
+		}
+	case msg.Message.GetStickerMessage() != nil:
+		sticker := msg.Message.GetStickerMessage()
+		data.Media = &webhook.MediaData{Mimetype: sticker.GetMimetype(), FileLength: sticker.GetFileLength()}
+	}
+
+	if details := getMessageDetails(msg.Message); details != nil {
+		data.Location = details.Location
+		data.Contacts = details.Contacts
+		data.LinkPreview = details.LinkPreview
+		data.Poll = details.Poll
+	}
+
+	return data
+}
+
+// newStatusData monta o payload de uma confirmação de entrega ou leitura
+func (h *EventHandler) newStatusData(receipt *events.Receipt, status string) webhook.StatusData {
+	data := webhook.StatusData{
+		MessageIDs: receipt.MessageIDs,
+		Chat:       h.resolveContactID(receipt.Chat, receipt.SenderAlt),
+		Status:     status,
+		Timestamp:  receipt.Timestamp,
+	}
+
+	if receipt.IsGroup {
+		data.Chat = receipt.Chat.String()
+		data.Recipient = h.resolveContactID(receipt.Sender, receipt.SenderAlt)
+	}
+
+	return data
+}
@@ -0,0 +1,281 @@
+// internal/whatsapp/webhook_processor.go
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"whatsapp-service/internal/database"
+	"whatsapp-service/pkg/webhook"
+)
+
+// Limite de bytes da resposta do receptor que guardamos em webhook_deliveries
+const maxWebhookResponseBody = 64 * 1024
+
+// WebhookProcessorConfig define o comportamento da fila de entregas de webhook
+type WebhookProcessorConfig struct {
+	Workers      int           // Número de entregas simultâneas
+	MaxAttempts  int           // Tentativas antes de mover a entrega para dead-letter (failed)
+	PollInterval time.Duration // Intervalo de verificação da fila quando ociosa
+	BaseDelay    time.Duration // Atraso da primeira retentativa
+	MaxDelay     time.Duration // Teto do backoff exponencial
+	Lease        time.Duration // Tempo que uma entrega reservada fica bloqueada para outras réplicas
+	Timeout      time.Duration // Timeout de cada requisição HTTP
+}
+
+// WebhookProcessor consome a fila persistente webhook_deliveries com um pool
+// de workers. Várias réplicas do serviço podem rodar ao mesmo tempo: a
+// reserva das entregas usa FOR UPDATE SKIP LOCKED no Postgres.
+type WebhookProcessor struct {
+	handler    *EventHandler
+	db         *database.DB
+	httpClient *http.Client
+	config     WebhookProcessorConfig
+
+	jobs chan database.WebhookDelivery
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWebhookProcessor cria um processador aplicando valores padrão às opções não informadas
+func NewWebhookProcessor(handler *EventHandler, config WebhookProcessorConfig) *WebhookProcessor {
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = 5 * time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = time.Hour
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Lease <= 0 {
+		// Uma entrega pode esperar um worker livre e depois a própria requisição
+		config.Lease = 6 * config.Timeout
+	}
+
+	return &WebhookProcessor{
+		handler: handler,
+		db:      handler.DB,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+		config: config,
+		jobs:   make(chan database.WebhookDelivery),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start inicia o dispatcher e os workers
+func (p *WebhookProcessor) Start() {
+	for i := 0; i < p.config.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+
+	p.wg.Add(1)
+	go p.dispatch()
+
+	fmt.Printf("Processador de webhooks iniciado (%d workers, máximo de %d tentativas)\n",
+		p.config.Workers, p.config.MaxAttempts)
+}
+
+// Stop encerra o processador e aguarda as entregas em andamento.
+// Entregas reservadas e não iniciadas voltam para a fila quando o lease expira.
+func (p *WebhookProcessor) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+	fmt.Println("Processador de webhooks encerrado")
+}
+
+// Wake antecipa a próxima leitura da fila (chamado após enfileirar eventos)
+func (p *WebhookProcessor) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch reserva lotes de entregas e distribui entre os workers
+func (p *WebhookProcessor) dispatch() {
+	defer p.wg.Done()
+	defer close(p.jobs)
+
+	ticker := time.NewTicker(p.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		deliveries, err := p.db.ClaimWebhookDeliveries(p.config.Workers, p.config.Lease)
+		if err != nil {
+			fmt.Printf("Erro ao reservar entregas de webhook: %v\n", err)
+		}
+
+		for _, delivery := range deliveries {
+			select {
+			case p.jobs <- delivery:
+			case <-p.stop:
+				return
+			}
+		}
+
+		// Lote cheio: provavelmente há mais entregas prontas
+		if len(deliveries) == p.config.Workers {
+			select {
+			case <-p.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// worker processa entregas até o canal de jobs ser fechado
+func (p *WebhookProcessor) worker() {
+	defer p.wg.Done()
+
+	for delivery := range p.jobs {
+		p.process(delivery)
+	}
+}
+
+// process faz uma tentativa de entrega e registra o resultado na fila
+func (p *WebhookProcessor) process(delivery database.WebhookDelivery) {
+	attempt := delivery.AttemptCount + 1
+
+	config, err := p.db.GetWebhookConfigByID(delivery.WebhookID)
+	if err != nil {
+		// Erro de banco: devolver para a fila sem consumir tentativa
+		fmt.Printf("Erro ao buscar webhook %d para entrega %d: %v\n", delivery.WebhookID, delivery.ID, err)
+		p.reschedule(delivery, delivery.AttemptCount, 0, "", fmt.Sprintf("Erro ao buscar webhook: %v", err))
+		return
+	}
+
+	if config == nil || !config.Enabled {
+		p.db.UpdateWebhookDeliveryStatus(delivery.ID, database.WebhookDeliveryCancelled, 0, "",
+			"Webhook desabilitado ou removido", delivery.AttemptCount, nil)
+		return
+	}
+
+	statusCode, responseBody, err := p.send(config, delivery, attempt)
+
+	switch {
+	case err == nil && statusCode >= 200 && statusCode < 300:
+		p.handler.resetWebhookFailures(config.URL)
+		p.db.UpdateWebhookDeliveryStatus(delivery.ID, database.WebhookDeliverySuccess, statusCode, responseBody, "", attempt, nil)
+		fmt.Printf("Webhook %d entregue com sucesso (entrega %d, tentativa %d)\n", config.ID, delivery.ID, attempt)
+
+	case err != nil:
+		fmt.Printf("Erro ao enviar entrega %d para webhook %d: %v\n", delivery.ID, config.ID, err)
+		p.handler.trackWebhookFailure(delivery.DeviceID, config.URL, 5)
+		p.reschedule(delivery, attempt, 0, "", fmt.Sprintf("Erro ao enviar: %v", err))
+
+	case isRetryableStatus(statusCode):
+		fmt.Printf("Webhook %d retornou status %d (entrega %d)\n", config.ID, statusCode, delivery.ID)
+		p.handler.trackWebhookFailure(delivery.DeviceID, config.URL, 3)
+		p.reschedule(delivery, attempt, statusCode, responseBody, fmt.Sprintf("Erro de servidor: %d", statusCode))
+
+	default:
+		// Erros 4xx não mudam com retentativas: dead-letter imediato
+		fmt.Printf("Webhook %d rejeitou a entrega %d com status %d\n", config.ID, delivery.ID, statusCode)
+		p.handler.trackWebhookFailure(delivery.DeviceID, config.URL, 3)
+		p.db.UpdateWebhookDeliveryStatus(delivery.ID, database.WebhookDeliveryFailed, statusCode, responseBody,
+			fmt.Sprintf("Erro no cliente: %d", statusCode), attempt, nil)
+	}
+}
+
+// send executa a requisição HTTP de uma entrega
+func (p *WebhookProcessor) send(config *database.WebhookConfig, delivery database.WebhookDelivery, attempt int) (int, string, error) {
+	payload := []byte(delivery.Payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", config.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("erro ao criar requisição: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WhatsApp-Service-Webhook/1.0")
+	req.Header.Set("X-Webhook-Delivery-ID", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+
+	// Cada tentativa é assinada com o horário do envio, assim o receptor pode
+	// rejeitar reenvios antigos capturados por terceiros
+	now := time.Now()
+	if secrets := config.SigningSecrets(now); len(secrets) > 0 {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(payload, now, secrets...))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	// Ler corpo da resposta para logging
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+
+	return resp.StatusCode, string(responseBody), nil
+}
+
+// reschedule agenda uma nova tentativa ou move a entrega para dead-letter
+func (p *WebhookProcessor) reschedule(delivery database.WebhookDelivery, attempt int, statusCode int, responseBody string, errorMessage string) {
+	if attempt >= p.config.MaxAttempts {
+		p.db.UpdateWebhookDeliveryStatus(delivery.ID, database.WebhookDeliveryFailed, statusCode, responseBody,
+			fmt.Sprintf("Número máximo de tentativas alcançado (%d): %s", attempt, errorMessage), attempt, nil)
+		return
+	}
+
+	nextRetry := time.Now().Add(p.backoff(attempt))
+	p.db.UpdateWebhookDeliveryStatus(delivery.ID, database.WebhookDeliveryRetrying, statusCode, responseBody,
+		errorMessage, attempt, &nextRetry)
+}
+
+// backoff calcula o atraso exponencial com jitter para a tentativa informada:
+// metade fixa e metade aleatória, limitado a MaxDelay
+func (p *WebhookProcessor) backoff(attempt int) time.Duration {
+	delay := p.config.BaseDelay
+	for i := 1; i < attempt && delay < p.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.config.MaxDelay {
+		delay = p.config.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// isRetryableStatus indica se um status HTTP é temporário
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= 500 ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode == http.StatusRequestTimeout
+}
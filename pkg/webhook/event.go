@@ -0,0 +1,357 @@
+// pkg/webhook/event.go
+
+// Package webhook define o esquema público dos eventos enviados pelo serviço
+// aos webhooks e ao Assistant. Receptores podem importar este pacote para
+// decodificar os eventos com tipos Go em vez de depender das structs do whatsmeow.
+//
+// Todo evento é entregue em um envelope versionado:
+//
+//	{
+//	  "id": "evt_5f2b...",
+//	  "version": "v1",
+//	  "type": "message.received",
+//	  "device_id": 3,
+//	  "tenant_id": 4,
+//	  "timestamp": "2025-01-01T12:00:00Z",
+//	  "data": { ... }
+//	}
+//
+// Tipos de evento e o formato de "data":
+//
+//	message.received           MessageData       mensagem recebida de um contato ou grupo
+//	message.sent               MessageData       mensagem enviada pelo próprio número (inclusive pelo celular)
+//	message.status             StatusData        confirmação de entrega, leitura ou falha de mensagens enviadas
+//	message.edited             UpdateData        mensagem editada pelo autor (Text traz o novo texto)
+//	message.revoked            UpdateData        mensagem apagada para todos
+//	message.reaction           ReactionData      reação adicionada, trocada ou removida
+//	poll.vote                  PollVoteData      voto em uma enquete, com a contagem atualizada
+//	group.joined               GroupEventData    dispositivo adicionado a um grupo ou entrou por convite
+//	group.participant_added    GroupEventData    participantes entraram ou foram adicionados
+//	group.participant_removed  GroupEventData    participantes saíram ou foram removidos
+//	group.participant_promoted GroupEventData    participantes promovidos a admin
+//	group.participant_demoted  GroupEventData    admins rebaixados a participantes
+//	group.subject_changed      GroupEventData    nome do grupo alterado
+//	group.description_changed  GroupEventData    descrição alterada ou removida
+//	group.settings_changed     GroupEventData    modo de envio (announce) ou de edição (locked) alterado
+//	presence.update            PresenceData      contato ficou online ou offline (requer inscrição na presença)
+//	presence.chat              ChatPresenceData  contato digitando, gravando áudio ou parou, em um chat
+//	outbound.sent              OutboundData      mensagem da fila de envio entregue ao WhatsApp
+//	outbound.failed            OutboundData      mensagem da fila de envio descartada após esgotar as tentativas
+//	device.connected           DeviceData        dispositivo conectado ao WhatsApp
+//	device.disconnected        DeviceData        conexão perdida (o dispositivo tentará reconectar)
+//	device.logged_out          DeviceData        sessão encerrada, requer novo QR Code
+//	webhook.test               TestData          evento disparado por POST /api/webhook/:id/test
+//
+// Campos novos podem ser adicionados dentro da mesma versão; remoções ou
+// mudanças de significado geram uma nova versão.
+//
+// As entregas são assinadas com HMAC (veja SignatureHeader); receptores devem
+// validar o corpo com Verify ou ConstructEvent antes de processá-lo.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion é a versão atual do envelope e dos payloads
+const SchemaVersion = "v1"
+
+// Tipos de evento publicados
+const (
+	EventMessageReceived = "message.received"
+	EventMessageSent     = "message.sent"
+	EventMessageStatus   = "message.status"
+	EventMessageEdited   = "message.edited"
+	EventMessageRevoked  = "message.revoked"
+	EventMessageReaction = "message.reaction"
+	EventPollVote        = "poll.vote"
+
+	EventGroupJoined              = "group.joined"
+	EventGroupParticipantAdded    = "group.participant_added"
+	EventGroupParticipantRemoved  = "group.participant_removed"
+	EventGroupParticipantPromoted = "group.participant_promoted"
+	EventGroupParticipantDemoted  = "group.participant_demoted"
+	EventGroupSubjectChanged      = "group.subject_changed"
+	EventGroupDescriptionChanged  = "group.description_changed"
+	EventGroupSettingsChanged     = "group.settings_changed"
+	EventPresenceUpdate           = "presence.update"
+	EventChatPresence             = "presence.chat"
+	EventOutboundSent             = "outbound.sent"
+	EventOutboundFailed           = "outbound.failed"
+	EventDeviceConnected          = "device.connected"
+	EventDeviceDisconnected       = "device.disconnected"
+	EventDeviceLoggedOut          = "device.logged_out"
+	EventWebhookTest              = "webhook.test"
+)
+
+// Event é o envelope comum a todos os eventos
+type Event struct {
+	ID        string      `json:"id"` // Único por evento; reenvios mantêm o mesmo ID
+	Version   string      `json:"version"`
+	Type      string      `json:"type"`
+	DeviceID  int64       `json:"device_id"`
+	TenantID  int64       `json:"tenant_id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// MessageData é o payload de message.received e message.sent.
+// Chat e Sender já vêm com o LID resolvido para o número real quando possível.
+type MessageData struct {
+	MessageID  string     `json:"message_id"`
+	Chat       string     `json:"chat"`
+	Sender     string     `json:"sender"`
+	SenderName string     `json:"sender_name,omitempty"` // Push name do remetente
+	IsFromMe   bool       `json:"is_from_me"`
+	IsGroup    bool       `json:"is_group"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Type       string     `json:"type"`           // text, image, video, audio, document, sticker, location, contact, poll
+	Text       string     `json:"text,omitempty"` // Texto ou legenda da mídia
+	Media      *MediaData `json:"media,omitempty"`
+	Audio      *AudioData `json:"audio,omitempty"` // Apenas nos eventos enviados ao Assistant
+
+	Location    *LocationData    `json:"location,omitempty"`     // Apenas no tipo location
+	Contacts    []ContactData    `json:"contacts,omitempty"`     // Apenas no tipo contact
+	LinkPreview *LinkPreviewData `json:"link_preview,omitempty"` // Texto com prévia de link
+	Poll        *PollData        `json:"poll,omitempty"`         // Apenas no tipo poll
+}
+
+// MediaData descreve a mídia de uma mensagem
+type MediaData struct {
+	URL        string `json:"url,omitempty"` // Caminho da mídia armazenada pelo serviço, se baixada
+	Mimetype   string `json:"mimetype,omitempty"`
+	FileName   string `json:"file_name,omitempty"`
+	FileLength uint64 `json:"file_length,omitempty"`
+}
+
+// LocationData descreve uma localização fixa ou em tempo real
+type LocationData struct {
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	Name           string  `json:"name,omitempty"`
+	Address        string  `json:"address,omitempty"`
+	URL            string  `json:"url,omitempty"`
+	Live           bool    `json:"live,omitempty"`    // Localização em tempo real
+	Caption        string  `json:"caption,omitempty"` // Legenda da localização em tempo real
+	AccuracyMeters uint32  `json:"accuracy_meters,omitempty"`
+}
+
+// ContactData é um cartão de contato. Phones traz os números encontrados no vCard.
+type ContactData struct {
+	Name   string   `json:"name"`
+	Phones []string `json:"phones,omitempty"`
+	VCard  string   `json:"vcard"`
+}
+
+// LinkPreviewData é a prévia exibida para o link de uma mensagem de texto
+type LinkPreviewData struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// PollData descreve uma enquete
+type PollData struct {
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	SelectableCount int      `json:"selectable_count"` // Opções que cada participante pode marcar; 0 = qualquer quantidade
+}
+
+// AudioData contém o áudio convertido para envio ao Assistant
+type AudioData struct {
+	Base64 string `json:"base64"`
+	Format string `json:"format"`
+}
+
+// StatusData é o payload de message.status. Uma confirmação do WhatsApp pode
+// cobrir várias mensagens do mesmo chat de uma vez.
+type StatusData struct {
+	MessageIDs []string  `json:"message_ids"`
+	Chat       string    `json:"chat"`
+	Recipient  string    `json:"recipient,omitempty"` // Participante que confirmou (em grupos, cada um confirma)
+	Status     string    `json:"status"`              // delivered, read, played, failed
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// UpdateData é o payload de message.edited e message.revoked.
+// MessageID é o ID da mensagem original, que foi editada ou apagada.
+type UpdateData struct {
+	MessageID string    `json:"message_id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"` // Quem editou ou apagou (admins podem apagar mensagens de outros)
+	IsFromMe  bool      `json:"is_from_me"`
+	Timestamp time.Time `json:"timestamp"`
+	Text      string    `json:"text,omitempty"` // Novo texto, apenas em message.edited
+}
+
+// ReactionData é o payload de message.reaction
+type ReactionData struct {
+	MessageID string    `json:"message_id"` // Mensagem que recebeu a reação
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"` // Quem reagiu
+	IsFromMe  bool      `json:"is_from_me"`
+	Reaction  string    `json:"reaction"` // Emoji; vazio quando a reação foi removida
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PollVoteData é o payload de poll.vote. Cada voto substitui o anterior do
+// mesmo participante; Options vazio significa que o voto foi retirado.
+type PollVoteData struct {
+	PollID    string          `json:"poll_id"` // ID da mensagem da enquete
+	Chat      string          `json:"chat"`
+	Voter     string          `json:"voter"`
+	IsFromMe  bool            `json:"is_from_me"`
+	Options   []string        `json:"options"` // Opções marcadas pelo participante
+	Tally     []PollTallyData `json:"tally"`   // Contagem de todas as opções após o voto
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// PollTallyData é a quantidade de votos de uma opção da enquete
+type PollTallyData struct {
+	Option string `json:"option"`
+	Votes  int    `json:"votes"`
+}
+
+// GroupEventData é o payload dos eventos group.*. Apenas os campos do tipo
+// do evento são preenchidos. Participantes e Actor vêm com o LID resolvido
+// para o número real quando possível.
+type GroupEventData struct {
+	Group        string    `json:"group"`
+	Actor        string    `json:"actor,omitempty"`        // Quem fez a alteração, quando informado pelo WhatsApp
+	Participants []string  `json:"participants,omitempty"` // group.participant_*
+	Reason       string    `json:"reason,omitempty"`       // "invite" quando a entrada foi por link de convite
+	Subject      string    `json:"subject,omitempty"`      // group.subject_changed e group.joined
+	Description  *string   `json:"description,omitempty"`  // group.description_changed; vazio quando removida
+	Announce     *bool     `json:"announce,omitempty"`     // group.settings_changed: apenas admins enviam mensagens
+	Locked       *bool     `json:"locked,omitempty"`       // group.settings_changed: apenas admins editam o grupo
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// PresenceData é o payload de presence.update. O WhatsApp só envia a presença
+// de contatos em que o dispositivo se inscreveu e enquanto ele está disponível.
+type PresenceData struct {
+	Contact  string     `json:"contact"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"` // Ausente se o contato esconde o visto por último
+}
+
+// ChatPresenceData é o payload de presence.chat
+type ChatPresenceData struct {
+	Chat    string `json:"chat"`
+	Sender  string `json:"sender"`
+	IsGroup bool   `json:"is_group"`
+	State   string `json:"state"` // composing, recording ou paused
+}
+
+// OutboundData é o payload dos eventos outbound.*. QueueID é o ID devolvido
+// pela API ao enfileirar; MessageID é o ID da mensagem no WhatsApp, usado
+// depois nos eventos message.status.
+type OutboundData struct {
+	QueueID   int64     `json:"queue_id"`
+	MessageID string    `json:"message_id,omitempty"` // Apenas em outbound.sent
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"` // sent, failed
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DeviceData é o payload dos eventos device.*
+type DeviceData struct {
+	Status string `json:"status"`           // connected, disconnected, logged_out
+	Reason string `json:"reason,omitempty"` // Motivo informado pelo WhatsApp, quando houver
+}
+
+// TestData é o payload de webhook.test
+type TestData struct {
+	WebhookID int64  `json:"webhook_id"`
+	Message   string `json:"message"`
+}
+
+// NewEvent cria um envelope com ID único e a versão atual do esquema
+func NewEvent(eventType string, deviceID int64, tenantID int64, data interface{}) *Event {
+	return &Event{
+		ID:        newEventID(),
+		Version:   SchemaVersion,
+		Type:      eventType,
+		DeviceID:  deviceID,
+		TenantID:  tenantID,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// MessageEventType retorna o tipo de evento de uma mensagem conforme a direção
+func MessageEventType(isFromMe bool) string {
+	if isFromMe {
+		return EventMessageSent
+	}
+	return EventMessageReceived
+}
+
+// Parse decodifica um evento recebido, preenchendo Data com a struct do tipo.
+// Tipos desconhecidos (de versões mais novas) mantêm Data como json.RawMessage.
+func Parse(body []byte) (*Event, error) {
+	var raw struct {
+		Event
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("evento inválido: %w", err)
+	}
+
+	event := raw.Event
+	var data interface{}
+
+	switch event.Type {
+	case EventMessageReceived, EventMessageSent:
+		data = &MessageData{}
+	case EventMessageStatus:
+		data = &StatusData{}
+	case EventMessageEdited, EventMessageRevoked:
+		data = &UpdateData{}
+	case EventMessageReaction:
+		data = &ReactionData{}
+	case EventPollVote:
+		data = &PollVoteData{}
+	case EventGroupJoined, EventGroupParticipantAdded, EventGroupParticipantRemoved,
+		EventGroupParticipantPromoted, EventGroupParticipantDemoted, EventGroupSubjectChanged,
+		EventGroupDescriptionChanged, EventGroupSettingsChanged:
+		data = &GroupEventData{}
+	case EventPresenceUpdate:
+		data = &PresenceData{}
+	case EventChatPresence:
+		data = &ChatPresenceData{}
+	case EventOutboundSent, EventOutboundFailed:
+		data = &OutboundData{}
+	case EventDeviceConnected, EventDeviceDisconnected, EventDeviceLoggedOut:
+		data = &DeviceData{}
+	case EventWebhookTest:
+		data = &TestData{}
+	default:
+		event.Data = raw.Data
+		return &event, nil
+	}
+
+	if len(raw.Data) > 0 {
+		if err := json.Unmarshal(raw.Data, data); err != nil {
+			return nil, fmt.Errorf("payload inválido para %s: %w", event.Type, err)
+		}
+	}
+	event.Data = data
+
+	return &event, nil
+}
+
+// newEventID gera um identificador aleatório para o evento
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("evt_%d", time.Now().UnixNano())
+	}
+	return "evt_" + hex.EncodeToString(b)
+}
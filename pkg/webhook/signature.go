@@ -0,0 +1,139 @@
+// pkg/webhook/signature.go
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader é o cabeçalho HTTP que carrega a assinatura da entrega, no formato
+//
+//	X-Webhook-Signature: t=1700000000,v1=5257a869...,v1=9a0c31fd...
+//
+// t é o horário do envio em segundos Unix e cada v1 é o HMAC-SHA256 em hexadecimal
+// de "<t>.<corpo>" com um dos segredos ativos da assinatura. Durante uma rotação
+// de segredo a entrega traz um v1 para o segredo novo e outro para o anterior.
+const SignatureHeader = "X-Webhook-Signature"
+
+// DefaultTolerance é a diferença máxima aceita entre t e o relógio do receptor
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidHeader      = errors.New("cabeçalho de assinatura inválido")
+	ErrNoValidSignature   = errors.New("nenhuma assinatura corresponde aos segredos informados")
+	ErrTimestampTolerance = errors.New("timestamp da assinatura fora da tolerância")
+)
+
+// ComputeSignature calcula o HMAC-SHA256 de "<timestamp>.<payload>" em hexadecimal
+func ComputeSignature(payload []byte, timestamp int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign monta o valor do cabeçalho de assinatura para o payload, com um v1 por segredo.
+// Segredos vazios são ignorados.
+func Sign(payload []byte, timestamp time.Time, secrets ...string) string {
+	t := timestamp.Unix()
+
+	parts := []string{"t=" + strconv.FormatInt(t, 10)}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		parts = append(parts, "v1="+ComputeSignature(payload, t, secret))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Verify valida o cabeçalho de assinatura recebido. A entrega é aceita se
+// qualquer v1 corresponder a qualquer um dos segredos informados, o que permite
+// ao receptor trocar de segredo sem janela de indisponibilidade. Uma tolerância
+// zero desativa a verificação do timestamp (não recomendado).
+func Verify(payload []byte, header string, tolerance time.Duration, secrets ...string) error {
+	timestamp, signatures, err := parseHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrTimestampTolerance
+		}
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := []byte(ComputeSignature(payload, timestamp, secret))
+		for _, signature := range signatures {
+			if hmac.Equal(expected, []byte(signature)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrNoValidSignature
+}
+
+// ConstructEvent verifica a assinatura e decodifica o evento em uma única chamada
+func ConstructEvent(payload []byte, header string, tolerance time.Duration, secrets ...string) (*Event, error) {
+	if err := Verify(payload, header, tolerance, secrets...); err != nil {
+		return nil, err
+	}
+	return Parse(payload)
+}
+
+// NewSecret gera um segredo aleatório para uma assinatura de webhook
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("erro ao gerar segredo: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// parseHeader extrai o timestamp e as assinaturas v1 do cabeçalho
+func parseHeader(header string) (int64, []string, error) {
+	var timestamp int64
+	var signatures []string
+	hasTimestamp := false
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return 0, nil, ErrInvalidHeader
+		}
+
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, nil, ErrInvalidHeader
+			}
+			timestamp = t
+			hasTimestamp = true
+		case "v1":
+			signatures = append(signatures, value)
+		}
+		// Esquemas desconhecidos são ignorados para permitir versões futuras
+	}
+
+	if !hasTimestamp || len(signatures) == 0 {
+		return 0, nil, ErrInvalidHeader
+	}
+
+	return timestamp, signatures, nil
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testPayload = []byte(`{"id":"evt_1","version":"v1","type":"webhook.test","device_id":3,"data":{"webhook_id":7,"message":"ok"}}`)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	header := Sign(testPayload, time.Now(), "whsec_a")
+
+	if err := Verify(testPayload, header, DefaultTolerance, "whsec_a"); err != nil {
+		t.Fatalf("assinatura recusada: %v", err)
+	}
+
+	event, err := ConstructEvent(testPayload, header, DefaultTolerance, "whsec_a")
+	if err != nil {
+		t.Fatalf("ConstructEvent: %v", err)
+	}
+	data, ok := event.Data.(*TestData)
+	if event.Type != EventWebhookTest || !ok || data.WebhookID != 7 {
+		t.Errorf("evento = %+v, dados = %+v", event, event.Data)
+	}
+}
+
+func TestVerifyTolerance(t *testing.T) {
+	header := Sign(testPayload, time.Now().Add(-10*time.Minute), "whsec_a")
+
+	if err := Verify(testPayload, header, DefaultTolerance, "whsec_a"); !errors.Is(err, ErrTimestampTolerance) {
+		t.Errorf("assinatura antiga: %v, esperado %v", err, ErrTimestampTolerance)
+	}
+	if _, err := ConstructEvent(testPayload, header, DefaultTolerance, "whsec_a"); !errors.Is(err, ErrTimestampTolerance) {
+		t.Errorf("ConstructEvent com assinatura antiga: %v, esperado %v", err, ErrTimestampTolerance)
+	}
+
+	// Tolerância zero desativa a verificação do timestamp
+	if err := Verify(testPayload, header, 0, "whsec_a"); err != nil {
+		t.Errorf("tolerância zero: %v", err)
+	}
+}
+
+func TestVerifyTamperedPayload(t *testing.T) {
+	header := Sign(testPayload, time.Now(), "whsec_a")
+	tampered := []byte(`{"id":"evt_1","version":"v1","type":"webhook.test","device_id":4,"data":{"webhook_id":7,"message":"ok"}}`)
+
+	if err := Verify(tampered, header, DefaultTolerance, "whsec_a"); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("corpo alterado: %v, esperado %v", err, ErrNoValidSignature)
+	}
+	if err := Verify(testPayload, header, DefaultTolerance, "whsec_b"); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("segredo errado: %v, esperado %v", err, ErrNoValidSignature)
+	}
+}
+
+func TestVerifyRotatedSecret(t *testing.T) {
+	// Durante a rotação a entrega é assinada com o segredo novo e o anterior
+	header := Sign(testPayload, time.Now(), "whsec_new", "whsec_old")
+
+	for _, secret := range []string{"whsec_new", "whsec_old"} {
+		if err := Verify(testPayload, header, DefaultTolerance, secret); err != nil {
+			t.Errorf("segredo %s recusado: %v", secret, err)
+		}
+	}
+
+	// Receptor já trocou de segredo mas ainda aceita o anterior
+	header = Sign(testPayload, time.Now(), "whsec_old")
+	if err := Verify(testPayload, header, DefaultTolerance, "whsec_new", "whsec_old"); err != nil {
+		t.Errorf("segredo anterior recusado pelo receptor: %v", err)
+	}
+}
+
+func TestVerifyMalformedHeader(t *testing.T) {
+	signature := ComputeSignature(testPayload, time.Now().Unix(), "whsec_a")
+
+	for _, header := range []string{
+		"",
+		"lixo",
+		"t=abc,v1=" + signature,
+		"v1=" + signature,
+		"t=1700000000",
+		"t=1700000000,v2=abc",
+	} {
+		if err := Verify(testPayload, header, DefaultTolerance, "whsec_a"); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("%q: %v, esperado %v", header, err, ErrInvalidHeader)
+		}
+	}
+}